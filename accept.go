@@ -0,0 +1,57 @@
+package bmwcardata
+
+import (
+	"context"
+	"fmt"
+	"mime"
+	"net/http"
+)
+
+// DefaultAcceptMediaType is the media type requested from the CarData API by default.
+const DefaultAcceptMediaType = "application/json"
+
+// imageAcceptMediaType is the media type requested by default by GetImage.
+// Errors are still returned as JSON.
+const imageAcceptMediaType = "image/*, application/json;q=0.9"
+
+type acceptKey struct{}
+
+// ContextWithAccept returns a context requesting the given media type, through the Accept header,
+// for the CarData API calls made with it. This allows pinning a versioned media type
+// once BMW introduces one, in addition to the X-Version header.
+// The media type is validated, parameters like application/vnd.bmw.v2+json; charset=utf-8 are supported.
+//
+// The responses are still decoded into the structs of this package, unknown fields being ignored
+// and missing ones left empty. When requesting a media type with a different schema, use
+// ContextWithRawResponse to access the whole response.
+func ContextWithAccept(ctx context.Context, mediaType string) (context.Context, error) {
+	_, _, err := mime.ParseMediaType(mediaType)
+	if err != nil {
+		return nil, fmt.Errorf("invalid media type %q: %w", mediaType, err)
+	}
+	return context.WithValue(ctx, acceptKey{}, mediaType), nil
+}
+
+// acceptFromContext returns the media type requested with ContextWithAccept, if any.
+func acceptFromContext(ctx context.Context) (string, bool) {
+	mediaType, ok := ctx.Value(acceptKey{}).(string)
+	return mediaType, ok
+}
+
+// injectAcceptHeader sets the Accept header requested with ContextWithAccept, or DefaultAcceptMediaType.
+func injectAcceptHeader(ctx context.Context, req *http.Request) error {
+	mediaType, ok := acceptFromContext(ctx)
+	if !ok {
+		mediaType = DefaultAcceptMediaType
+	}
+	req.Header.Set("Accept", mediaType)
+	return nil
+}
+
+// acceptImage requests an image instead of DefaultAcceptMediaType, unless a media type was requested with ContextWithAccept.
+func acceptImage(ctx context.Context, req *http.Request) error {
+	if _, ok := acceptFromContext(ctx); !ok {
+		req.Header.Set("Accept", imageAcceptMediaType)
+	}
+	return nil
+}
@@ -0,0 +1,48 @@
+package bmwcardata
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestContextWithAccept(t *testing.T) {
+	accepted := ""
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		accepted = r.Header.Get("Accept")
+		if strings.HasSuffix(r.URL.Path, "/image") {
+			w.Header().Set("Content-Type", "image/png")
+		}
+		w.Write([]byte(`{"vin":"WBA00000000000000"}`))
+	}))
+	defer server.Close()
+	client, err := NewClient(WithCarDataServer(server.URL), WithAuthenticator(&staticAuthenticator{session: &AuthenticatedSession{AccessToken: "acc"}}))
+	require.NoError(t, err)
+
+	_, err = client.GetBasicData(context.Background(), "WBA00000000000000")
+	require.NoError(t, err)
+	assert.Equal(t, DefaultAcceptMediaType, accepted)
+
+	_, err = client.GetImage(context.Background(), "WBA00000000000000")
+	require.NoError(t, err)
+	assert.Equal(t, imageAcceptMediaType, accepted, "images must be requested by GetImage")
+
+	ctx, err := ContextWithAccept(context.Background(), "application/vnd.bmw.cardata.v2+json; charset=utf-8")
+	require.NoError(t, err)
+	_, err = client.GetBasicData(ctx, "WBA00000000000000")
+	require.NoError(t, err)
+	assert.Equal(t, "application/vnd.bmw.cardata.v2+json; charset=utf-8", accepted)
+	_, err = client.GetImage(ctx, "WBA00000000000000")
+	require.NoError(t, err)
+	assert.Equal(t, "application/vnd.bmw.cardata.v2+json; charset=utf-8", accepted, "the requested media type must take precedence")
+
+	for _, mediaType := range []string{"", "/json", "not a media type"} {
+		_, err := ContextWithAccept(context.Background(), mediaType)
+		assert.Error(t, err, mediaType)
+	}
+}
@@ -2,10 +2,17 @@ package bmwcardata
 
 import (
 	"archive/zip"
+	"encoding/base64"
 	"encoding/json"
 	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
 	"path/filepath"
+	"slices"
 	"strings"
+	"time"
 )
 
 // ZipReader represents a zip file reader
@@ -32,66 +39,400 @@ func (z *ZipReader) Files() []*zip.File {
 	return z.reader.File
 }
 
+type readArchiveOptions struct {
+	lenient bool
+	// sections are the sections to parse, all of them when nil.
+	sections []ArchiveSection
+}
+
+// ArchiveSection is a section of the archive stored in its own file, see WithArchiveSections.
+type ArchiveSection string
+
+const (
+	ArchiveSectionChargingHistory  ArchiveSection = "chargingHistory"
+	ArchiveSectionSmartMaintenance ArchiveSection = "smartMaintenance"
+	ArchiveSectionNavigation       ArchiveSection = "navigation"
+)
+
+// parses reports whether the section must be parsed.
+func (o readArchiveOptions) parses(section ArchiveSection) bool {
+	return o.sections == nil || slices.Contains(o.sections, section)
+}
+
+// ReadArchiveOption configures how ReadArchive parses an archive.
+type ReadArchiveOption func(*readArchiveOptions)
+
+// WithLenientArchive makes ReadArchive skip the sub-files that can't be read or parsed,
+// such as the charging history file, instead of failing.
+// The errors are collected in the Archive Warnings while the other sections are still returned.
+// The KeyList file is always required.
+// Regardless of this option, the smart maintenance and navigation files are best effort:
+// when they can't be parsed, their section is left empty and the error is collected in the Warnings.
+func WithLenientArchive() ReadArchiveOption {
+	return func(o *readArchiveOptions) {
+		o.lenient = true
+	}
+}
+
+// WithArchiveSections makes ReadArchive only decode the files of the requested sections,
+// leaving the other ones empty. This speeds up the processing of large archives when only some sections are needed,
+// like the charging history. The data of the KeyList file, like the VIN or the basic vehicle data, is always read.
+func WithArchiveSections(sections ...ArchiveSection) ReadArchiveOption {
+	return func(o *readArchiveOptions) {
+		o.sections = append([]ArchiveSection{}, sections...)
+	}
+}
+
 // ReadArchive reads an archive from a file downloaded from the BMW CarData portal
 // It parses the zip file and returns a structured representation of the archive
-func ReadArchive(path string) (*Archive, error) {
+func ReadArchive(path string, options ...ReadArchiveOption) (*Archive, error) {
+	opts := readArchiveOptions{}
+	for _, option := range options {
+		option(&opts)
+	}
 	zipReader, err := NewZipReader(path)
 	if err != nil {
 		return nil, err
 	}
 	defer zipReader.Close()
-	archiveContent := customerArchiveContent{}
+	archiveContent, archiveRelPath, err := zipReader.readKeyList()
+	if err != nil {
+		return nil, err
+	}
+	archive := archiveContent.archive()
+	for _, subFile := range []struct {
+		section ArchiveSection
+		name    string
+		target  any
+		// reset empties the section, which may be partially decoded when the file can't be parsed.
+		reset func()
+		// bestEffort sections tolerate parse errors, even when the archive is not read WithLenientArchive.
+		bestEffort bool
+	}{
+		{section: ArchiveSectionChargingHistory, name: archiveContent.ChargingHistoryFileName, target: &archive.ChargingHistory,
+			reset: func() { archive.ChargingHistory = nil }},
+		{section: ArchiveSectionSmartMaintenance, name: archiveContent.SmartMaintenanceFileName, target: &archive.SmartMaintenance,
+			reset: func() { archive.SmartMaintenance = SmartMaintenanceArchive{} }, bestEffort: true},
+		{section: ArchiveSectionNavigation, name: archiveContent.LearningNavigationFileName, target: &archive.AdaptiveNavigation,
+			reset: func() { archive.AdaptiveNavigation = AdaptiveNavigationArchive{} }, bestEffort: true},
+	} {
+		if subFile.name == "" || !opts.parses(subFile.section) {
+			continue
+		}
+		err := zipReader.decodeJSON(filepath.Join(archiveRelPath, subFile.name), subFile.target)
+		if err == nil {
+			continue
+		}
+		if !opts.lenient && !(subFile.bestEffort && errors.Is(err, errArchiveParse)) {
+			return nil, err
+		}
+		subFile.reset()
+		archive.Warnings = append(archive.Warnings, err)
+	}
+	return &archive, nil
+}
+
+// ReadArchiveSections reads the archive like ReadArchive, but only decodes the files of the requested sections,
+// see WithArchiveSections. Use ReadArchive or ReadArchiveForVIN with WithArchiveSections to combine it with other options.
+func ReadArchiveSections(path string, sections ...ArchiveSection) (*Archive, error) {
+	return ReadArchive(path, WithArchiveSections(sections...))
+}
+
+// ErrArchiveVINMismatch is returned by ReadArchiveForVIN when the archive is for another vehicle.
+var ErrArchiveVINMismatch = errors.New("the archive VIN does not match the expected VIN")
+
+// ReadArchiveForVIN reads the archive like ReadArchive, and checks it is for the expected vehicle.
+// Both VINs are normalized before being compared, see NormalizeVIN.
+// It returns an error wrapping ErrArchiveVINMismatch when the archive is for another vehicle,
+// preventing to process the export of the wrong vehicle by mistake.
+func ReadArchiveForVIN(path, expectedVIN string, options ...ReadArchiveOption) (*Archive, error) {
+	expected, err := NormalizeVIN(expectedVIN)
+	if err != nil {
+		return nil, fmt.Errorf("invalid expected VIN: %w", err)
+	}
+	archive, err := ReadArchive(path, options...)
+	if err != nil {
+		return nil, err
+	}
+	if strings.ToUpper(strings.TrimSpace(archive.VIN)) != expected {
+		return nil, fmt.Errorf("%w: expected %s, got %q", ErrArchiveVINMismatch, expected, archive.VIN)
+	}
+	return archive, nil
+}
+
+// readKeyList reads the KeyList XML file, indexing the archive content.
+// It returns the parsed content and the directory of the KeyList file, where the other files are.
+func (z *ZipReader) readKeyList() (*customerArchiveContent, string, error) {
+	archiveContent := &customerArchiveContent{}
 	archiveRelPath := ""
-	for _, file := range zipReader.Files() {
+	for _, file := range z.Files() {
 		if strings.Contains(file.Name, "KeyList") && strings.HasSuffix(file.Name, ".xml") {
 			archiveRelPath = filepath.Dir(file.Name)
 			fd, err := file.Open()
 			if err != nil {
-				return nil, err
+				return nil, "", err
 			}
-			defer fd.Close()
-			err = xml.NewDecoder(fd).Decode(&archiveContent)
+			err = xml.NewDecoder(fd).Decode(archiveContent)
+			fd.Close()
 			if err != nil {
-				return nil, err
+				return nil, "", err
 			}
 		}
 	}
-	archive := Archive{
-		Lang:                archiveContent.Lang,
-		RequestDate:         archiveContent.RequestDate,
-		VIN:                 archiveContent.VIN,
-		UnitOfLength:        archiveContent.UnitOfLength,
-		BasicVehicleData:    archiveContent.BasicVehicleData,
-		CasaContractDetails: archiveContent.CasaContractDetailsDataList,
-		TelematicValues:     archiveContent.TelematicValues,
-		VehicleImage:        archiveContent.VehicleImage,
-	}
-	if archiveContent.ChargingHistoryFileName != "" {
-		fd, err := zipReader.reader.Open(filepath.Join(archiveRelPath, archiveContent.ChargingHistoryFileName))
-		if err != nil {
-			return nil, err
+	return archiveContent, archiveRelPath, nil
+}
+
+// archive returns the archive with the data from the KeyList file, without the data of the other files.
+func (c *customerArchiveContent) archive() Archive {
+	return Archive{
+		Lang:                c.Lang,
+		RequestDate:         c.RequestDate,
+		VIN:                 c.VIN,
+		UnitOfLength:        c.UnitOfLength,
+		BasicVehicleData:    c.BasicVehicleData,
+		CasaContractDetails: c.CasaContractDetailsDataList,
+		TelematicValues:     c.TelematicValues,
+		VehicleImage:        c.VehicleImage,
+	}
+}
+
+// DecodeVehicleImage decodes the base64 encoded vehicle image of the archive.
+// The image is either a data URI, like data:image/png;base64,..., in which case the declared
+// MIME type is used, or bare base64, in which case the content type is sniffed from the data.
+func (a *Archive) DecodeVehicleImage() (*Image, error) {
+	encoded := strings.TrimSpace(a.VehicleImage)
+	if encoded == "" {
+		return nil, errors.New("the archive has no vehicle image")
+	}
+	contentType := ""
+	if rest, ok := strings.CutPrefix(encoded, "data:"); ok {
+		header, data, ok := strings.Cut(rest, ",")
+		if !ok {
+			return nil, errors.New("invalid vehicle image data URI: missing data")
 		}
-		defer fd.Close()
-		err = json.NewDecoder(fd).Decode(&archive.ChargingHistory)
-		if err != nil {
-			return nil, err
+		mediaType, isBase64 := strings.CutSuffix(header, ";base64")
+		if !isBase64 {
+			return nil, fmt.Errorf("invalid vehicle image data URI: unsupported encoding %q", header)
+		}
+		contentType = mediaType
+		encoded = data
+	}
+	// Long base64 values may be wrapped in the XML file.
+	encoded = strings.Join(strings.Fields(encoded), "")
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode the vehicle image: %w", err)
+	}
+	if contentType == "" {
+		contentType = http.DetectContentType(data)
+	}
+	return &Image{Data: data, ContentType: contentType}, nil
+}
+
+// contractStatusActive is the status of the contracts in force.
+const contractStatusActive = "ACTIVE"
+
+// ActiveContracts returns the contracts of the archive that are active and whose contract period covers now.
+// Missing bounds of the contract period are considered open.
+func (a *Archive) ActiveContracts() []CasaContractDetails {
+	return a.activeContractsAt(time.Now())
+}
+
+func (a *Archive) activeContractsAt(now time.Time) []CasaContractDetails {
+	r := []CasaContractDetails{}
+	for _, contract := range a.CasaContractDetails {
+		if !strings.EqualFold(strings.TrimSpace(contract.Status), contractStatusActive) {
+			continue
+		}
+		if !contract.ContractPeriod.covers(now) {
+			continue
 		}
+		r = append(r, contract)
+	}
+	return r
+}
+
+// covers reports whether t is within the period, missing bounds being open.
+func (p ContractPeriod) covers(t time.Time) bool {
+	if !p.Start.IsZero() && t.Before(p.Start.Time) {
+		return false
+	}
+	if !p.End.IsZero() && t.After(p.End.Time) {
+		return false
+	}
+	return true
+}
+
+// ProductName returns a human readable name of the contracted product: its name when the archive
+// provides one, and its offer ID otherwise.
+func (c CasaContractDetails) ProductName() string {
+	switch {
+	case strings.TrimSpace(c.Name) != "":
+		return strings.TrimSpace(c.Name)
+	case c.OfferID.MasterOfferID != "":
+		return c.OfferID.MasterOfferID
+	default:
+		return c.OfferID.GlobalID
 	}
+}
+
+// WriteArchiveJSON writes the archive downloaded from the BMW CarData portal as JSON to w.
+// The output is the same as encoding the Archive returned by ReadArchive, but the charging history
+// is decoded and encoded one session at a time instead of being loaded in memory at once.
+// This allows converting multi-year archives on constrained machines.
+func WriteArchiveJSON(w io.Writer, path string) error {
+	zipReader, err := NewZipReader(path)
+	if err != nil {
+		return err
+	}
+	defer zipReader.Close()
+	archiveContent, archiveRelPath, err := zipReader.readKeyList()
+	if err != nil {
+		return err
+	}
+	archive := archiveContent.archive()
+	// As for ReadArchive, the smart maintenance and navigation files are best effort.
 	if archiveContent.SmartMaintenanceFileName != "" {
-		fd, err := zipReader.reader.Open(filepath.Join(archiveRelPath, archiveContent.SmartMaintenanceFileName))
-		if err != nil {
-			return nil, err
+		err := zipReader.decodeJSON(filepath.Join(archiveRelPath, archiveContent.SmartMaintenanceFileName), &archive.SmartMaintenance)
+		if errors.Is(err, errArchiveParse) {
+			archive.SmartMaintenance = SmartMaintenanceArchive{}
+		} else if err != nil {
+			return err
 		}
-		defer fd.Close()
-		err = json.NewDecoder(fd).Decode(&archive.SmartMaintenance)
 	}
 	if archiveContent.LearningNavigationFileName != "" {
-		fd, err := zipReader.reader.Open(filepath.Join(archiveRelPath, archiveContent.LearningNavigationFileName))
+		err := zipReader.decodeJSON(filepath.Join(archiveRelPath, archiveContent.LearningNavigationFileName), &archive.AdaptiveNavigation)
+		if errors.Is(err, errArchiveParse) {
+			archive.AdaptiveNavigation = AdaptiveNavigationArchive{}
+		} else if err != nil {
+			return err
+		}
+	}
+
+	// Fields are written in the Archive struct order, honouring omitempty.
+	o := &objectWriter{w: w}
+	o.field("vin", archive.VIN, archive.VIN == "")
+	o.field("unitOfLength", archive.UnitOfLength, archive.UnitOfLength == "")
+	o.field("basicVehicleData", archive.BasicVehicleData, false)
+	o.field("casaContractDetails", archive.CasaContractDetails, len(archive.CasaContractDetails) == 0)
+	o.field("telematicValues", archive.TelematicValues, len(archive.TelematicValues) == 0)
+	o.field("vehicleImage", archive.VehicleImage, archive.VehicleImage == "")
+	o.field("lang", archive.Lang, archive.Lang == "")
+	o.field("requestDate", archive.RequestDate, archive.RequestDate == "")
+	o.field("smartMaintenance", archive.SmartMaintenance, false)
+	if archiveContent.ChargingHistoryFileName != "" && o.err == nil {
+		o.err = zipReader.streamChargingHistory(filepath.Join(archiveRelPath, archiveContent.ChargingHistoryFileName), o)
+	}
+	o.field("adaptiveNavigationArchive", archive.AdaptiveNavigation, false)
+	if o.err != nil {
+		return o.err
+	}
+	_, err = io.WriteString(w, "}\n")
+	return err
+}
+
+// streamChargingHistory copies the charging sessions from the JSON array at path
+// to the chargingHistory field, one session at a time.
+func (z *ZipReader) streamChargingHistory(path string, o *objectWriter) error {
+	fd, err := z.reader.Open(path)
+	if err != nil {
+		return err
+	}
+	defer fd.Close()
+	decoder := json.NewDecoder(fd)
+	token, err := decoder.Token()
+	if err != nil {
+		return fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	if token == nil {
+		// null charging history, as decoded by ReadArchive
+		return nil
+	}
+	if delim, ok := token.(json.Delim); !ok || delim != '[' {
+		return fmt.Errorf("failed to parse %s: expected an array, got %v", path, token)
+	}
+	count := 0
+	for decoder.More() {
+		session := ChargingSessionArchive{}
+		err := decoder.Decode(&session)
 		if err != nil {
-			return nil, err
+			return fmt.Errorf("failed to parse %s: %w", path, err)
 		}
-		defer fd.Close()
-		err = json.NewDecoder(fd).Decode(&archive.AdaptiveNavigation)
+		data, err := json.Marshal(session)
+		if err != nil {
+			return err
+		}
+		if count == 0 {
+			err = o.key("chargingHistory")
+			if err == nil {
+				_, err = io.WriteString(o.w, "[")
+			}
+		} else {
+			_, err = io.WriteString(o.w, ",")
+		}
+		if err != nil {
+			return err
+		}
+		_, err = o.w.Write(data)
+		if err != nil {
+			return err
+		}
+		count++
 	}
-	return &archive, nil
+	if _, err := decoder.Token(); err != nil {
+		return fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	if count > 0 {
+		_, err = io.WriteString(o.w, "]")
+	}
+	return err
+}
+
+// objectWriter writes the fields of a JSON object one at a time, remembering the first error.
+type objectWriter struct {
+	w       io.Writer
+	started bool
+	err     error
+}
+
+func (o *objectWriter) key(name string) error {
+	separator := ","
+	if !o.started {
+		separator = "{"
+		o.started = true
+	}
+	_, err := fmt.Fprintf(o.w, "%s%q:", separator, name)
+	return err
+}
+
+func (o *objectWriter) field(name string, value any, omit bool) {
+	if o.err != nil || omit {
+		return
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		o.err = err
+		return
+	}
+	o.err = o.key(name)
+	if o.err == nil {
+		_, o.err = o.w.Write(data)
+	}
+}
+
+// errArchiveParse is wrapped by the errors of the archive files that were found but could not be parsed.
+var errArchiveParse = errors.New("failed to parse")
+
+// decodeJSON decodes the JSON file at path in the archive into target.
+func (z *ZipReader) decodeJSON(path string, target any) error {
+	fd, err := z.reader.Open(path)
+	if err != nil {
+		return err
+	}
+	defer fd.Close()
+	err = json.NewDecoder(fd).Decode(target)
+	if err != nil {
+		return fmt.Errorf("%w %s: %w", errArchiveParse, path, err)
+	}
+	return nil
 }
@@ -0,0 +1,122 @@
+package bmwcardata
+
+import (
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MileageDescriptorID is the descriptor ID of the mileage of the vehicle.
+const MileageDescriptorID = "vehicle.vehicle.travelledDistance"
+
+// Charging statuses streamed for the ChargingStatusDescriptorID signal.
+const (
+	ChargingStatusActive = "CHARGINGACTIVE"
+	ChargingStatusEnded  = "CHARGINGENDED"
+)
+
+// StreamedMessages synthesizes the messages the vehicle would have streamed during the charging session,
+// so that stream consumers can be exercised with historical data, without a live vehicle.
+// It returns a message when the charging starts, with the start state of charge, and a message when it ends,
+// with the displayed state of charge. Both hold the charging status, the plug state and the mileage.
+// No message is synthesized for a missing start or end time.
+func (s ChargingSessionArchive) StreamedMessages(vin string) []StreamedMessage {
+	mileage := map[string]StreamedDataDetails{}
+	if s.Mileage > 0 {
+		unit := ""
+		if u, err := s.MileageLengthUnit(); err == nil {
+			unit = string(u)
+		}
+		mileage[MileageDescriptorID] = StreamedDataDetails{Value: StreamedDataValue{Float: p(float64(s.Mileage))}, Unit: unit}
+	}
+	messages := []StreamedMessage{}
+	for _, event := range []struct {
+		epoch  int64
+		soc    int
+		status string
+	}{
+		{s.StartTime, s.DisplayedStartSoc, ChargingStatusActive},
+		{s.EndTime, s.DisplayedSoc, ChargingStatusEnded},
+	} {
+		at := epochTime(event.epoch)
+		if at.IsZero() {
+			continue
+		}
+		data := map[string]StreamedDataDetails{
+			ChargingSoCDescriptorID:     {Value: StreamedDataValue{Float: p(float64(event.soc))}, Unit: "%"},
+			ChargingStatusDescriptorID:  {Value: StreamedDataValue{String: p(event.status)}},
+			ChargingPluggedDescriptorID: {Value: StreamedDataValue{Bool: p(true)}},
+		}
+		for id, details := range mileage {
+			data[id] = details
+		}
+		messages = append(messages, newArchiveMessage(vin, at.Time, data))
+	}
+	return messages
+}
+
+// StreamedMessages synthesizes the messages the vehicle would have streamed from the data of the archive,
+// ordered by time, so that stream consumers can be exercised with historical data, without a live vehicle.
+// The messages can be passed to the subscription callbacks or to the consumers of SubscribeChan, one at a time.
+// They hold the charging sessions, see ChargingSessionArchive.StreamedMessages, and the telematic values,
+// grouped by value timestamp. Telematic values without timestamp are skipped, as they can't be ordered.
+func (a *Archive) StreamedMessages() []StreamedMessage {
+	messages := []StreamedMessage{}
+	for _, session := range a.ChargingHistory {
+		messages = append(messages, session.StreamedMessages(a.VIN)...)
+	}
+	byTime := map[time.Time]map[string]StreamedDataDetails{}
+	for _, values := range a.TelematicValues {
+		for _, value := range values.TelematicValues {
+			at := value.ValueTimestamp.Time
+			if at.IsZero() {
+				at = value.FetchTimestamp.Time
+			}
+			if at.IsZero() || value.Name == "" {
+				continue
+			}
+			// Messages are timestamped to the second, group the values of the same second.
+			at = at.UTC().Truncate(time.Second)
+			if byTime[at] == nil {
+				byTime[at] = map[string]StreamedDataDetails{}
+			}
+			byTime[at][value.Name] = StreamedDataDetails{Value: parseArchiveValue(value.Value), Unit: value.Unit}
+		}
+	}
+	for at, data := range byTime {
+		messages = append(messages, newArchiveMessage(a.VIN, at, data))
+	}
+	slices.SortStableFunc(messages, func(a, b StreamedMessage) int {
+		// The timestamps are all formatted the same way, in UTC, hence they sort chronologically.
+		return strings.Compare(a.Timestamp, b.Timestamp)
+	})
+	return messages
+}
+
+// newArchiveMessage builds a message as streamed for the vehicle, with the data timestamped at the message time.
+func newArchiveMessage(vin string, at time.Time, data map[string]StreamedDataDetails) StreamedMessage {
+	timestamp := at.UTC().Format(time.RFC3339)
+	for id, details := range data {
+		details.Timestamp = timestamp
+		data[id] = details
+	}
+	return StreamedMessage{VIN: vin, EntityID: vin, Topic: vin, Timestamp: timestamp, Data: data}
+}
+
+// parseArchiveValue types the archive values, stored as strings, the way they are streamed.
+func parseArchiveValue(raw string) StreamedDataValue {
+	if i, err := strconv.ParseInt(raw, 10, 64); err == nil {
+		return StreamedDataValue{Int: &i}
+	}
+	if f, err := strconv.ParseFloat(raw, 64); err == nil {
+		return StreamedDataValue{Float: &f}
+	}
+	switch strings.ToLower(raw) {
+	case "true":
+		return StreamedDataValue{Bool: p(true)}
+	case "false":
+		return StreamedDataValue{Bool: p(false)}
+	}
+	return StreamedDataValue{String: &raw}
+}
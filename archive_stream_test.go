@@ -0,0 +1,56 @@
+package bmwcardata
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestArchiveStreamedMessages(t *testing.T) {
+	start := time.Date(2025, 1, 1, 20, 0, 0, 0, time.UTC)
+	archive := &Archive{
+		VIN: "WBA00000000000000",
+		ChargingHistory: []ChargingSessionArchive{{
+			StartTime:         start.Unix(),
+			EndTime:           start.Add(3 * time.Hour).UnixMilli(),
+			DisplayedStartSoc: 20,
+			DisplayedSoc:      80,
+			Mileage:           12000,
+			MileageUnits:      "KM",
+		}},
+		TelematicValues: []TelematicValues{{TelematicValues: []TelematicValue{
+			{Name: "vehicle.cabin.door.status", Value: "LOCKED", ValueTimestamp: Time{Time: start.Add(time.Hour)}},
+			{Name: "vehicle.vehicle.speed", Value: "0", Unit: "km/h", FetchTimestamp: Time{Time: start.Add(time.Hour + 500*time.Millisecond)}},
+			{Name: "vehicle.isMoving", Value: "false"},
+		}}},
+	}
+
+	messages := archive.StreamedMessages()
+	require.Len(t, messages, 3)
+	timestamps := []string{}
+	for _, message := range messages {
+		assert.Equal(t, "WBA00000000000000", message.VIN)
+		timestamps = append(timestamps, message.Timestamp)
+	}
+	assert.Equal(t, []string{"2025-01-01T20:00:00Z", "2025-01-01T21:00:00Z", "2025-01-01T23:00:00Z"}, timestamps)
+
+	started := ExtractChargingSignals(messages[0])
+	require.NotNil(t, started.SoCPercent)
+	assert.Equal(t, 20.0, *started.SoCPercent)
+	assert.Equal(t, ChargingStatusActive, *started.ChargingState)
+	assert.Equal(t, 12000.0, *messages[0].Data[MileageDescriptorID].Value.Float)
+	assert.Equal(t, "km", messages[0].Data[MileageDescriptorID].Unit)
+
+	assert.Equal(t, "LOCKED", *messages[1].Data["vehicle.cabin.door.status"].Value.String)
+	assert.Equal(t, int64(0), *messages[1].Data["vehicle.vehicle.speed"].Value.Int)
+	assert.Equal(t, "km/h", messages[1].Data["vehicle.vehicle.speed"].Unit)
+
+	ended := ExtractChargingSignals(messages[2])
+	assert.Equal(t, 80.0, *ended.SoCPercent)
+	assert.Equal(t, ChargingStatusEnded, *ended.ChargingState)
+	assert.True(t, *ended.IsPlugged)
+
+	assert.Empty(t, ChargingSessionArchive{}.StreamedMessages("WBA00000000000000"))
+}
@@ -0,0 +1,207 @@
+package bmwcardata
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeTestArchive(t *testing.T, files map[string]string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "archive.zip")
+	fd, err := os.Create(path)
+	require.NoError(t, err)
+	defer fd.Close()
+	w := zip.NewWriter(fd)
+	for name, content := range files {
+		f, err := w.Create(name)
+		require.NoError(t, err)
+		_, err = f.Write([]byte(content))
+		require.NoError(t, err)
+	}
+	require.NoError(t, w.Close())
+	return path
+}
+
+func TestReadArchive_CorruptSubFile(t *testing.T) {
+	path := writeTestArchive(t, map[string]string{
+		"archive/KeyList.xml":         `<customerArchiveContent vin="WBA00000000000000" chargingHistoryFileName="charging.json" smartMaintenanceFileName="maintenance.json"></customerArchiveContent>`,
+		"archive/charging.json":       `[{"displayedSoc": 80, "energyConsumedFromPowerGridKwh": 15.4}, {"displayedSoc": "full"}]`,
+		"archive/maintenance.json":    `{"passengerCar": {}, "errors": "none"}`,
+		"archive/unrelated-file.json": `{}`,
+	})
+
+	_, err := ReadArchive(path)
+	require.Error(t, err, "archives are parsed strictly by default")
+	assert.Contains(t, err.Error(), "charging.json")
+
+	archive, err := ReadArchive(path, WithLenientArchive())
+	require.NoError(t, err)
+	assert.Equal(t, "WBA00000000000000", archive.VIN)
+	assert.Empty(t, archive.ChargingHistory, "the partially decoded sections must be reset")
+	assert.Nil(t, archive.SmartMaintenance.PassengerCar, "the partially decoded sections must be reset")
+	require.Len(t, archive.Warnings, 2)
+	assert.Contains(t, archive.Warnings[0].Error(), "charging.json")
+	assert.Contains(t, archive.Warnings[1].Error(), "maintenance.json")
+}
+
+func TestReadArchive_BestEffortSubFiles(t *testing.T) {
+	path := writeTestArchive(t, map[string]string{
+		"archive/KeyList.xml":      `<customerArchiveContent vin="WBA00000000000000" chargingHistoryFileName="charging.json" smartMaintenanceFileName="maintenance.json" learningNavigationFileName="navigation.json"></customerArchiveContent>`,
+		"archive/charging.json":    `[{"displayedSoc": 80, "energyConsumedFromPowerGridKwh": 15.4}]`,
+		"archive/maintenance.json": `{"passengerCar": {}, "errors": "none"}`,
+		"archive/navigation.json":  `{not-json`,
+	})
+
+	archive, err := ReadArchive(path)
+	require.NoError(t, err, "the smart maintenance and navigation files are best effort")
+	require.Len(t, archive.ChargingHistory, 1)
+	assert.Nil(t, archive.SmartMaintenance.PassengerCar, "the partially decoded sections must be reset")
+	require.Len(t, archive.Warnings, 2)
+
+	buf := bytes.Buffer{}
+	require.NoError(t, WriteArchiveJSON(&buf, path))
+	expected, err := json.Marshal(archive)
+	require.NoError(t, err)
+	assert.JSONEq(t, string(expected), buf.String())
+
+	_, err = ReadArchive(writeTestArchive(t, map[string]string{
+		"archive/KeyList.xml": `<customerArchiveContent vin="WBA00000000000000" smartMaintenanceFileName="missing.json"></customerArchiveContent>`,
+	}))
+	assert.Error(t, err, "missing files must still fail by default")
+}
+
+func TestReadArchiveSections(t *testing.T) {
+	path := writeTestArchive(t, map[string]string{
+		"archive/KeyList.xml":      `<customerArchiveContent vin="WBA00000000000000" chargingHistoryFileName="charging.json" smartMaintenanceFileName="maintenance.json" learningNavigationFileName="navigation.json"></customerArchiveContent>`,
+		"archive/charging.json":    `[{"displayedSoc": 80, "energyConsumedFromPowerGridKwh": 15.4}]`,
+		"archive/maintenance.json": `{not-json`,
+		"archive/navigation.json":  `{not-json`,
+	})
+
+	archive, err := ReadArchiveSections(path, ArchiveSectionChargingHistory)
+	require.NoError(t, err, "the files of the other sections must not be decoded")
+	assert.Equal(t, "WBA00000000000000", archive.VIN)
+	require.Len(t, archive.ChargingHistory, 1)
+	assert.Empty(t, archive.Warnings)
+
+	archive, err = ReadArchiveSections(path)
+	require.NoError(t, err)
+	assert.Equal(t, "WBA00000000000000", archive.VIN, "the KeyList must always be read")
+	assert.Empty(t, archive.ChargingHistory)
+
+	archive, err = ReadArchiveSections(path, ArchiveSectionChargingHistory, ArchiveSectionNavigation)
+	require.NoError(t, err)
+	require.Len(t, archive.Warnings, 1)
+	assert.ErrorContains(t, archive.Warnings[0], "navigation.json")
+
+	archive, err = ReadArchiveForVIN(path, "WBA00000000000000", WithArchiveSections(ArchiveSectionChargingHistory), WithLenientArchive())
+	require.NoError(t, err, "the sections must combine with the other options")
+	require.Len(t, archive.ChargingHistory, 1)
+	assert.Empty(t, archive.Warnings)
+}
+
+func TestReadArchiveForVIN(t *testing.T) {
+	path := writeTestArchive(t, map[string]string{
+		"archive/KeyList.xml": `<customerArchiveContent vin="WBA00000000000000"></customerArchiveContent>`,
+	})
+
+	archive, err := ReadArchiveForVIN(path, " wba00000000000000 ")
+	require.NoError(t, err)
+	assert.Equal(t, "WBA00000000000000", archive.VIN)
+
+	_, err = ReadArchiveForVIN(path, "WBA00000000000001")
+	require.ErrorIs(t, err, ErrArchiveVINMismatch)
+	assert.Contains(t, err.Error(), "WBA00000000000001")
+
+	_, err = ReadArchiveForVIN(path, "not-a-vin")
+	require.Error(t, err)
+	assert.NotErrorIs(t, err, ErrArchiveVINMismatch)
+}
+
+func TestWriteArchiveJSON(t *testing.T) {
+	path := writeTestArchive(t, map[string]string{
+		"archive/KeyList.xml":      `<customerArchiveContent vin="WBA00000000000000" lang="en" chargingHistoryFileName="charging.json" smartMaintenanceFileName="maintenance.json" learningNavigationFileName="navigation.json"></customerArchiveContent>`,
+		"archive/charging.json":    `[{"displayedSoc": 80, "energyConsumedFromPowerGridKwh": 15.4}, {"displayedSoc": 40, "chargingCostInformation": {"currency": "EUR"}}]`,
+		"archive/maintenance.json": `{}`,
+		"archive/navigation.json":  `{}`,
+	})
+
+	archive, err := ReadArchive(path)
+	require.NoError(t, err)
+	expected, err := json.Marshal(archive)
+	require.NoError(t, err)
+
+	buf := bytes.Buffer{}
+	require.NoError(t, WriteArchiveJSON(&buf, path))
+	assert.JSONEq(t, string(expected), buf.String())
+}
+
+func TestWriteArchiveJSON_CorruptChargingHistory(t *testing.T) {
+	path := writeTestArchive(t, map[string]string{
+		"archive/KeyList.xml":   `<customerArchiveContent vin="WBA00000000000000" chargingHistoryFileName="charging.json"></customerArchiveContent>`,
+		"archive/charging.json": `[{"displayedSoc": 80}, {not-json`,
+	})
+
+	err := WriteArchiveJSON(&bytes.Buffer{}, path)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "charging.json")
+}
+
+func TestArchiveDecodeVehicleImage(t *testing.T) {
+	png := []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+	encoded := base64.StdEncoding.EncodeToString(png)
+
+	image, err := (&Archive{VehicleImage: "data:image/webp;base64," + encoded}).DecodeVehicleImage()
+	require.NoError(t, err)
+	assert.Equal(t, png, image.Data)
+	assert.Equal(t, "image/webp", image.ContentType, "the declared MIME type must be used")
+
+	image, err = (&Archive{VehicleImage: encoded[:8] + "\n  " + encoded[8:]}).DecodeVehicleImage()
+	require.NoError(t, err)
+	assert.Equal(t, png, image.Data)
+	assert.Equal(t, "image/png", image.ContentType, "the content type must be sniffed for bare base64")
+
+	_, err = (&Archive{VehicleImage: "data:image/png," + encoded}).DecodeVehicleImage()
+	assert.Error(t, err)
+	_, err = (&Archive{VehicleImage: "not base64!"}).DecodeVehicleImage()
+	assert.Error(t, err)
+	_, err = (&Archive{}).DecodeVehicleImage()
+	assert.Error(t, err)
+}
+
+func TestArchiveActiveContracts(t *testing.T) {
+	now := time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC)
+	period := func(start, end time.Time) ContractPeriod {
+		return ContractPeriod{Start: Time{Time: start}, End: Time{Time: end}}
+	}
+	archive := &Archive{CasaContractDetails: []CasaContractDetails{
+		{Name: "current", Status: "ACTIVE", ContractPeriod: period(now.AddDate(-1, 0, 0), now.AddDate(1, 0, 0))},
+		{Name: "open", Status: "active"},
+		{Name: "open end", Status: "ACTIVE", ContractPeriod: period(now.AddDate(-1, 0, 0), time.Time{})},
+		{Name: "expired", Status: "ACTIVE", ContractPeriod: period(now.AddDate(-2, 0, 0), now.AddDate(-1, 0, 0))},
+		{Name: "future", Status: "ACTIVE", ContractPeriod: period(now.AddDate(0, 1, 0), time.Time{})},
+		{Name: "cancelled", Status: "CANCELLED"},
+	}}
+
+	names := []string{}
+	for _, contract := range archive.activeContractsAt(now) {
+		names = append(names, contract.Name)
+	}
+	assert.Equal(t, []string{"current", "open", "open end"}, names)
+	assert.Empty(t, (&Archive{}).ActiveContracts())
+}
+
+func TestCasaContractDetailsProductName(t *testing.T) {
+	assert.Equal(t, "Remote Services", CasaContractDetails{Name: " Remote Services ", OfferID: OfferID{MasterOfferID: "M"}}.ProductName())
+	assert.Equal(t, "M", CasaContractDetails{OfferID: OfferID{MasterOfferID: "M", GlobalID: "G"}}.ProductName())
+	assert.Equal(t, "G", CasaContractDetails{OfferID: OfferID{GlobalID: "G"}}.ProductName())
+}
@@ -120,8 +120,15 @@ func (t *Time) UnmarshalJSON(data []byte) error {
 		if err != nil {
 			return err
 		}
-		t.Time = time.Unix(parsed, 0)
-		t.format = "unix"
+		// Epochs in milliseconds have at least 13 digits since September 2001,
+		// while epochs in seconds will only reach 13 digits in year 33658.
+		if len(data) >= 13 {
+			t.Time = time.UnixMilli(parsed)
+			t.format = "unix-millis"
+		} else {
+			t.Time = time.Unix(parsed, 0)
+			t.format = "unix"
+		}
 		t.parsed = true
 		return nil
 	}
@@ -139,6 +146,9 @@ func (t Time) MarshalJSON() ([]byte, error) {
 	if t.format == "unix" {
 		return []byte(fmt.Sprintf("%d", t.Time.Unix())), nil
 	}
+	if t.format == "unix-millis" {
+		return []byte(fmt.Sprintf("%d", t.Time.UnixMilli())), nil
+	}
 	if t.format == "" {
 		return []byte(fmt.Sprintf("\"%s\"", t.Time.Format(time.RFC3339))), nil
 	}
@@ -397,6 +407,8 @@ type Archive struct {
 	SmartMaintenance    SmartMaintenanceArchive   `json:"smartMaintenance,omitempty"`
 	ChargingHistory     []ChargingSessionArchive  `json:"chargingHistory,omitempty"`
 	AdaptiveNavigation  AdaptiveNavigationArchive `json:"adaptiveNavigationArchive,omitempty"`
+	// Warnings holds the errors of the sub-files skipped when reading the archive, see WithLenientArchive.
+	Warnings []error `json:"-"`
 }
 
 // Types for parsing the BMW CarData "KeyList" XML (customerArchiveContent)
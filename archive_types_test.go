@@ -0,0 +1,43 @@
+package bmwcardata
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTimeJSONRoundTrip(t *testing.T) {
+	tests := []struct {
+		name   string
+		input  string
+		format string
+	}{
+		{name: "unix seconds", input: `1700000000`, format: "unix"},
+		{name: "unix millis", input: `1700000000123`, format: "unix-millis"},
+		{name: "unix millis with zero milliseconds", input: `1700000000000`, format: "unix-millis"},
+		{name: "millis with offset", input: `"2024-03-10T12:34:56.789+0100"`, format: "2006-01-02T15:04:05.000-0700"},
+		{name: "millis UTC", input: `"2024-03-10T12:34:56.789Z"`, format: "2006-01-02T15:04:05.000Z"},
+		{name: "micros UTC", input: `"2024-03-10T12:34:56.123456Z"`, format: "2006-01-02T15:04:05.999999Z"},
+		{name: "seconds", input: `"2024-03-10T12:34:56"`, format: "2006-01-02T15:04:05"},
+		{name: "date", input: `"2024-03-10"`, format: "2006-01-02"},
+		{name: "german date with zone", input: `"10.03.2024 12:34:56 CET"`, format: "02.01.2006 15:04:05 MST"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parsed := Time{}
+			require.NoError(t, json.Unmarshal([]byte(tt.input), &parsed))
+			assert.Equal(t, tt.format, parsed.format)
+			marshalled, err := json.Marshal(parsed)
+			require.NoError(t, err)
+			assert.Equal(t, tt.input, string(marshalled))
+		})
+	}
+}
+
+func TestTimeJSONUnixMillisPrecision(t *testing.T) {
+	parsed := Time{}
+	require.NoError(t, json.Unmarshal([]byte(`1700000000123`), &parsed))
+	assert.Equal(t, int64(1700000000123), parsed.UnixMilli())
+}
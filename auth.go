@@ -7,8 +7,14 @@ import (
 	"encoding/base64"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	mathrand "math/rand/v2"
 	"net/http"
+	"os"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/google/uuid"
@@ -16,6 +22,10 @@ import (
 	"github.com/tjamet/bmw-cardata/auth"
 )
 
+// ErrInteractiveAuthenticationRequired is returned by non-interactive authenticators
+// when the user needs to go through the device-code flow.
+var ErrInteractiveAuthenticationRequired = errors.New("interactive authentication required")
+
 // AuthClientInterface is an interface that allows to initiate an authentication session,
 // poll for the token, and refresh the token.
 type AuthClientInterface interface {
@@ -30,6 +40,9 @@ type AuthenticatorInterface interface {
 	GetSession(ctx context.Context) (*AuthenticatedSession, error)
 }
 
+// ErrMissingGcid is returned when the session holds no GCID, which identifies the user account.
+var ErrMissingGcid = errors.New("the session has no GCID")
+
 // AuthenticationSession is a session that has been initiated by the BMW auth API
 // It is exclusively used to hold all the relevant information for the authentication flow to complete.
 // It is not persisted and does not allow to authenticate the user to the BMW API.
@@ -60,8 +73,16 @@ type AuthenticatedSession struct {
 	// IdToken The id_token is only returned if the scope openid was in the authenticate call.
 	IdToken      *string `json:"id_token,omitempty"`
 	RefreshToken string  `json:"refresh_token"`
-	Scope        string  `json:"scope"`
-	TokenType    string  `json:"token_type"`
+	// RefreshTokenExpiresAt is the expiry of the refresh token, zero when unknown.
+	// It is provided by the token response, when present, or set from the Authenticator RefreshTokenLifetime.
+	RefreshTokenExpiresAt time.Time `json:"refresh_token_expires_at,omitzero"`
+	Scope                 string    `json:"scope"`
+	TokenType             string    `json:"token_type"`
+}
+
+// hasValidIDToken checks if the session holds an id_token that can still be used
+func (a *AuthenticatedSession) hasValidIDToken() bool {
+	return a != nil && a.IdToken != nil && *a.IdToken != "" && !a.IsExpired()
 }
 
 // IsExpired checks if the session is expired
@@ -72,6 +93,20 @@ func (a *AuthenticatedSession) IsExpired() bool {
 	return time.Now().Add(10 * time.Second).After(a.ExpiresAt)
 }
 
+// ErrRefreshTokenExpired is returned, wrapped with ErrInteractiveAuthenticationRequired, by non-interactive
+// authenticators when the refresh token of the session is known to be expired, see RefreshTokenExpired.
+var ErrRefreshTokenExpired = errors.New("the refresh token expired")
+
+// RefreshTokenExpired checks if the refresh token is expired, in which case the session can't be refreshed
+// and a new authentication flow is needed.
+// It returns false when the refresh token expiry is unknown.
+func (a *AuthenticatedSession) RefreshTokenExpired() bool {
+	if a == nil {
+		return true
+	}
+	return !a.RefreshTokenExpiresAt.IsZero() && !time.Now().Before(a.RefreshTokenExpiresAt)
+}
+
 type AuthenticatorOption func(*Authenticator) error
 
 func WithScopes(scopes []Scope) AuthenticatorOption {
@@ -88,6 +123,75 @@ func WithPromptURI(promptURI func(string, string, string)) AuthenticatorOption {
 	}
 }
 
+// PromptWriter returns a PromptURI implementation writing the authentication instructions to w.
+func PromptWriter(w io.Writer) func(string, string, string) {
+	return func(verificationURI, userCode, verificationURIComplete string) {
+		fmt.Fprintf(w, "Open %s and enter code %s\n", verificationURI, userCode)
+		fmt.Fprintf(w, "Direct link: %s\n", verificationURIComplete)
+	}
+}
+
+// PromptHyperlink returns a PromptURI implementation writing the authentication instructions to w, like PromptWriter,
+// with the URLs rendered as OSC 8 hyperlinks, clickable in the terminals supporting them.
+// Plain URLs are written when the terminal is unlikely to support them: when TERM is unset or dumb, or NO_COLOR is set.
+func PromptHyperlink(w io.Writer) func(string, string, string) {
+	if !supportsHyperlinks(os.Getenv) {
+		return PromptWriter(w)
+	}
+	return func(verificationURI, userCode, verificationURIComplete string) {
+		fmt.Fprintf(w, "Open %s and enter code %s\n", hyperlink(verificationURI), userCode)
+		fmt.Fprintf(w, "Direct link: %s\n", hyperlink(verificationURIComplete))
+	}
+}
+
+// supportsHyperlinks reports whether the terminal described by the environment may render OSC 8 hyperlinks.
+func supportsHyperlinks(getenv func(string) string) bool {
+	if getenv("NO_COLOR") != "" {
+		return false
+	}
+	term := getenv("TERM")
+	return term != "" && term != "dumb"
+}
+
+// hyperlink formats the URL as an OSC 8 hyperlink, labelled with the URL itself
+// so that it remains readable when copied.
+func hyperlink(uri string) string {
+	return "\x1b]8;;" + uri + "\x1b\\" + uri + "\x1b]8;;\x1b\\"
+}
+
+// PromptStdout is a PromptURI implementation writing the authentication instructions to the standard output.
+func PromptStdout(verificationURI, userCode, verificationURIComplete string) {
+	PromptWriter(os.Stdout)(verificationURI, userCode, verificationURIComplete)
+}
+
+// PromptLogger returns a PromptURI implementation logging the authentication instructions with logger.
+// When logger is nil, slog.Default() is used.
+func PromptLogger(logger *slog.Logger) func(string, string, string) {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return func(verificationURI, userCode, verificationURIComplete string) {
+		logger.Info("authentication required, open the verification URI and enter the user code",
+			slog.String("verification_uri", verificationURI),
+			slog.String("user_code", userCode),
+			slog.String("verification_uri_complete", verificationURIComplete),
+		)
+	}
+}
+
+// WithAuthClientOptions is an authenticator option building its AuthClient with the given options,
+// for example to set the auth server or the HTTP transport. By default, the AuthClient is built without options.
+func WithAuthClientOptions(options ...AuthClientOption) AuthenticatorOption {
+	return func(c *Authenticator) error {
+		authClient, err := NewAuthClient(options...)
+		if err != nil {
+			return err
+		}
+		c.AuthClient = authClient
+		return nil
+	}
+}
+
 func WithSessionStore(sessionStore SessionStore) AuthenticatorOption {
 	return func(c *Authenticator) error {
 		c.SessionStore = sessionStore
@@ -95,6 +199,58 @@ func WithSessionStore(sessionStore SessionStore) AuthenticatorOption {
 	}
 }
 
+// WithInteractive is an authenticator option that allows to disable the interactive device-code flow.
+// When not interactive, GetSession fails with ErrInteractiveAuthenticationRequired instead of calling
+// PromptURI when there is no valid stored session and it can't be refreshed.
+// This is useful for servers, where no user can open a browser.
+func WithInteractive(interactive bool) AuthenticatorOption {
+	return func(c *Authenticator) error {
+		c.NonInteractive = !interactive
+		return nil
+	}
+}
+
+// WithTokenRefreshCallback is an authenticator option that calls callback with every session obtained
+// by refreshing the tokens or by a new authentication flow, once it is saved.
+// This allows pushing the new tokens to another service, or logging the token renewals.
+// The callback runs in its own goroutine, so that it never delays the callers waiting for the session,
+// hence it must be safe for concurrent use. Sessions set with SetSession are not reported.
+func WithTokenRefreshCallback(callback func(*AuthenticatedSession)) AuthenticatorOption {
+	return func(c *Authenticator) error {
+		c.OnTokenRefresh = callback
+		return nil
+	}
+}
+
+// WithRefreshTokenLifetime is an authenticator option setting the lifetime of the refresh tokens,
+// used to compute their expiry when the token response does not provide it.
+func WithRefreshTokenLifetime(lifetime time.Duration) AuthenticatorOption {
+	return func(c *Authenticator) error {
+		if lifetime <= 0 {
+			return fmt.Errorf("the refresh token lifetime must be positive, got %s", lifetime)
+		}
+		c.RefreshTokenLifetime = lifetime
+		return nil
+	}
+}
+
+// WithRefreshTokenExpiryWarning is an authenticator option logging a warning with logger when the refresh token
+// of the session returned by GetSession expires within the given duration, so that operators can authenticate
+// again before being prompted. The warning is logged once per refresh token.
+// The refresh token expiry must be known, see WithRefreshTokenLifetime. When logger is nil, slog.Default() is used.
+func WithRefreshTokenExpiryWarning(within time.Duration, logger *slog.Logger) AuthenticatorOption {
+	return func(c *Authenticator) error {
+		if within <= 0 {
+			return fmt.Errorf("the refresh token expiry warning duration must be positive, got %s", within)
+		}
+		if logger == nil {
+			logger = slog.Default()
+		}
+		c.refreshTokenWarning = &refreshTokenWarning{within: within, logger: logger}
+		return nil
+	}
+}
+
 func WithClientID(clientID string) AuthenticatorOption {
 	return func(c *Authenticator) error {
 		c.ClientID = clientID
@@ -110,6 +266,69 @@ type Authenticator struct {
 	ClientID     string
 	Scopes       []Scope
 	PromptURI    func(string, string, string)
+	// NonInteractive disables the device-code flow, see WithInteractive.
+	NonInteractive bool
+	// OnTokenRefresh is called with the sessions obtained by refresh or authentication, see WithTokenRefreshCallback.
+	OnTokenRefresh func(*AuthenticatedSession)
+	// RefreshTokenLifetime is the lifetime of the refresh tokens when the token response does not provide it,
+	// see WithRefreshTokenLifetime. Zero leaves their expiry unknown.
+	RefreshTokenLifetime time.Duration
+
+	// m serializes the session retrieval so that concurrent callers share a single refresh
+	// or authentication flow instead of racing on the SessionStore.
+	// As the authentication flow can last minutes, waiting for it is bounded by the context of each caller.
+	m contextMutex
+	// session is the latest session, retained in memory so that a rotated refresh token
+	// is not lost when there is no SessionStore.
+	session *AuthenticatedSession
+	// refreshTokenWarning warns about the refresh tokens about to expire, see WithRefreshTokenExpiryWarning.
+	refreshTokenWarning *refreshTokenWarning
+}
+
+// contextMutex is a mutual exclusion lock whose waiters give up once their context is done.
+// The zero value is an unlocked mutex.
+type contextMutex struct {
+	once sync.Once
+	ch   chan struct{}
+}
+
+// lock acquires the mutex, or returns the context error if it is done first.
+func (m *contextMutex) lock(ctx context.Context) error {
+	m.once.Do(func() { m.ch = make(chan struct{}, 1) })
+	select {
+	case m.ch <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (m *contextMutex) unlock() {
+	<-m.ch
+}
+
+// refreshTokenWarning logs a warning when the refresh token is about to expire.
+type refreshTokenWarning struct {
+	within time.Duration
+	logger *slog.Logger
+	// warned is the last refresh token warned about, to warn only once per token.
+	warned string
+}
+
+// check logs a warning when the refresh token of the session expires soon, unless already done for this token.
+func (w *refreshTokenWarning) check(session *AuthenticatedSession) {
+	if w == nil || session == nil || session.RefreshTokenExpiresAt.IsZero() || session.RefreshToken == w.warned {
+		return
+	}
+	remaining := time.Until(session.RefreshTokenExpiresAt)
+	if remaining > w.within {
+		return
+	}
+	w.warned = session.RefreshToken
+	w.logger.Warn("the refresh token expires soon, authenticate again to avoid being prompted",
+		slog.Time("expires_at", session.RefreshTokenExpiresAt),
+		slog.Duration("remaining", remaining.Round(time.Second)),
+	)
 }
 
 func NewAuthenticator(options ...AuthenticatorOption) (*Authenticator, error) {
@@ -139,13 +358,28 @@ func NewAuthenticator(options ...AuthenticatorOption) (*Authenticator, error) {
 	if authenticator.Scopes == nil {
 		authenticator.Scopes = []Scope{ScopeOpenID, ScopeCardataAPI, ScopeCardataStreaming, ScopeAuthenticateUser}
 	}
-	if authenticator.PromptURI == nil {
+	if authenticator.PromptURI == nil && !authenticator.NonInteractive {
 		return nil, errors.New("promptURI is required")
 	}
 	return authenticator, nil
 }
 
+// GetSession returns a valid session, refreshing the stored one or starting a new authentication flow when needed.
+// It is safe for concurrent use: concurrent calls wait for the ongoing refresh or authentication flow
+// and use its resulting session, or return the context error if it is done before.
 func (a *Authenticator) GetSession(ctx context.Context) (*AuthenticatedSession, error) {
+	if err := a.m.lock(ctx); err != nil {
+		return nil, err
+	}
+	defer a.m.unlock()
+	session, err := a.getSession(ctx)
+	if err == nil {
+		a.refreshTokenWarning.check(session)
+	}
+	return session, err
+}
+
+func (a *Authenticator) getSession(ctx context.Context) (*AuthenticatedSession, error) {
 	session, err := a.getStoredSession(ctx)
 	if err != nil {
 		return a.NewSession(ctx)
@@ -157,7 +391,7 @@ func (a *Authenticator) GetSession(ctx context.Context) (*AuthenticatedSession,
 		if session.IsExpired() {
 			session, err = a.refreshSession(ctx, session)
 			if err != nil {
-				return a.NewSession(ctx)
+				return a.newSessionAfterRefresh(ctx, err)
 			}
 		}
 		return session, nil
@@ -165,23 +399,126 @@ func (a *Authenticator) GetSession(ctx context.Context) (*AuthenticatedSession,
 	return a.NewSession(ctx)
 }
 
+// newSessionAfterRefresh starts a new authentication flow once the session failed to be refreshed.
+// When not interactive, the returned error wraps the refresh error along with ErrInteractiveAuthenticationRequired,
+// so that a transient refresh failure is not mistaken for the need to authenticate again.
+func (a *Authenticator) newSessionAfterRefresh(ctx context.Context, refreshErr error) (*AuthenticatedSession, error) {
+	session, err := a.NewSession(ctx)
+	if errors.Is(err, ErrInteractiveAuthenticationRequired) {
+		return nil, fmt.Errorf("%w: failed to refresh the session: %w", err, refreshErr)
+	}
+	return session, err
+}
+
+// Gcid returns the GCID of the user account of the current session, fetching or refreshing it as for GetSession.
+// This is the username of the streaming broker and the first level of the <GCID>/<VIN> topics.
+func (a *Authenticator) Gcid(ctx context.Context) (string, error) {
+	return sessionGcid(ctx, a)
+}
+
+// sessionGcid returns the GCID of the current session of the authenticator.
+func sessionGcid(ctx context.Context, authenticator AuthenticatorInterface) (string, error) {
+	session, err := authenticator.GetSession(ctx)
+	if err != nil {
+		return "", err
+	}
+	if session == nil || session.Gcid == "" {
+		return "", ErrMissingGcid
+	}
+	return session.Gcid, nil
+}
+
+// SetSession stores a session obtained out-of-band, e.g. from another service,
+// so that the device-code flow is not needed.
+// GetSession then uses it and refreshes it once expired, as for sessions obtained through NewSession.
+// The session client ID must match the authenticator one to prevent mixing accounts.
+func (a *Authenticator) SetSession(ctx context.Context, session *AuthenticatedSession) error {
+	if session == nil {
+		return errors.New("session must not be nil")
+	}
+	if !strings.EqualFold(session.ClientID.String(), a.ClientID) {
+		return fmt.Errorf("session client ID %s does not match the authenticator client ID %s", session.ClientID, a.ClientID)
+	}
+	if err := a.m.lock(ctx); err != nil {
+		return err
+	}
+	defer a.m.unlock()
+	return a.saveSession(ctx, session)
+}
+
+// RefreshSession forces the refresh of the stored session, even if it is not expired yet.
+// This allows getting fresh tokens, e.g. a fresh id_token to reconnect to the streaming broker.
+// When the session can't be refreshed, a new authentication flow is started, as for GetSession.
+func (a *Authenticator) RefreshSession(ctx context.Context) (*AuthenticatedSession, error) {
+	if err := a.m.lock(ctx); err != nil {
+		return nil, err
+	}
+	defer a.m.unlock()
+	session, err := a.getStoredSession(ctx)
+	if err != nil || session == nil || !strings.EqualFold(session.ClientID.String(), a.ClientID) {
+		return a.NewSession(ctx)
+	}
+	session, err = a.refreshSession(ctx, session)
+	if err != nil {
+		return a.newSessionAfterRefresh(ctx, err)
+	}
+	return session, nil
+}
+
 func (a *Authenticator) refreshSession(ctx context.Context, session *AuthenticatedSession) (*AuthenticatedSession, error) {
-	session, err := a.AuthClient.RefreshToken(ctx, a.ClientID, session.RefreshToken)
+	if session.RefreshTokenExpired() {
+		// Don't send a refresh token known to be rejected.
+		return nil, ErrRefreshTokenExpired
+	}
+	refreshed, err := a.AuthClient.RefreshToken(ctx, a.ClientID, session.RefreshToken)
 	if err != nil {
 		return nil, err
 	}
-	err = a.SessionStore.Save(ctx, session)
+	if refreshed.RefreshToken == "" || refreshed.RefreshToken == session.RefreshToken {
+		// The refresh token was not rotated, keep using the current one, until its expiry.
+		refreshed.RefreshToken = session.RefreshToken
+		if refreshed.RefreshTokenExpiresAt.IsZero() {
+			refreshed.RefreshTokenExpiresAt = session.RefreshTokenExpiresAt
+		}
+	}
+	a.setRefreshTokenExpiry(refreshed)
+	err = a.saveSession(ctx, refreshed)
 	if err != nil {
 		return nil, err
 	}
-	return session, nil
+	a.notifyTokenRefresh(refreshed)
+	return refreshed, nil
+}
+
+// setRefreshTokenExpiry sets the refresh token expiry from RefreshTokenLifetime when it is unknown.
+func (a *Authenticator) setRefreshTokenExpiry(session *AuthenticatedSession) {
+	if session.RefreshTokenExpiresAt.IsZero() && a.RefreshTokenLifetime > 0 {
+		session.RefreshTokenExpiresAt = time.Now().Round(0).Add(a.RefreshTokenLifetime)
+	}
+}
+
+// saveSession retains the session in memory and saves it in the SessionStore, if any.
+func (a *Authenticator) saveSession(ctx context.Context, session *AuthenticatedSession) error {
+	a.session = session
+	if a.SessionStore != nil {
+		return a.SessionStore.Save(ctx, session)
+	}
+	return nil
 }
 
+// notifyTokenRefresh calls the OnTokenRefresh callback, if any, without blocking.
+func (a *Authenticator) notifyTokenRefresh(session *AuthenticatedSession) {
+	if a.OnTokenRefresh != nil {
+		go a.OnTokenRefresh(session)
+	}
+}
+
+// getStoredSession returns the session of the SessionStore, or the one retained in memory without SessionStore.
 func (a *Authenticator) getStoredSession(ctx context.Context) (*AuthenticatedSession, error) {
 	if a.SessionStore != nil {
 		return a.SessionStore.Get(ctx)
 	}
-	return nil, errors.New("session store not set")
+	return a.session, nil
 }
 
 // NewSession implements the whole authentication flow.
@@ -189,37 +526,93 @@ func (a *Authenticator) getStoredSession(ctx context.Context) (*AuthenticatedSes
 // to redirect the user to the authentication page in a browser.
 // As soon as the function returns, the authentication flow will be continued
 // polling for the token.
+// When the authenticator is not interactive, it fails with ErrInteractiveAuthenticationRequired.
 func (c *Authenticator) NewSession(ctx context.Context) (*AuthenticatedSession, error) {
+	if c.NonInteractive {
+		return nil, ErrInteractiveAuthenticationRequired
+	}
 	authSession, err := c.AuthClient.InitiateAuthenticationSession(ctx, c.ClientID, c.Scopes)
 	if err != nil {
 		return nil, err
 	}
 	expiresAt := time.Now().Add(time.Duration(authSession.ExpiresIn) * time.Second)
-	delay := authSession.Interval
-	if delay == 0 {
-		delay = 10
-	}
+	backoff := newPollBackoff(authSession.Interval)
 	c.PromptURI(authSession.VerificationURI, authSession.UserCode, authSession.VerificationURIComplete)
 	for time.Now().Before(expiresAt) {
 		tokenResponse, err := c.AuthClient.PollAuthToken(ctx, authSession)
+		if isSlowDown(err) {
+			backoff.slowDown()
+			err = nil
+		}
 		err = ignoreFlowNotCompleted(err)
 		if err != nil {
 			return nil, err
 		}
 		if tokenResponse != nil {
-			if c.SessionStore != nil {
-				err = c.SessionStore.Save(ctx, tokenResponse)
-				if err != nil {
-					return nil, err
-				}
+			c.setRefreshTokenExpiry(tokenResponse)
+			err = c.saveSession(ctx, tokenResponse)
+			if err != nil {
+				return nil, err
 			}
+			c.notifyTokenRefresh(tokenResponse)
 			return tokenResponse, nil
 		}
-		<-time.After(time.Duration(delay) * time.Second)
+		// Never wait beyond the expiry of the authentication session.
+		delay := min(backoff.next(), time.Until(expiresAt))
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(delay):
+		}
 	}
 	return nil, errors.New("authentication session expired")
 }
 
+const (
+	// defaultPollInterval is the delay between token polls when the server does not provide any.
+	defaultPollInterval = 10 * time.Second
+	// maxPollDelay caps the growth of the delay between token polls.
+	maxPollDelay = time.Minute
+	// slowDownIncrement is the increase of the poll interval requested by a slow_down error, as of RFC 8628.
+	slowDownIncrement = 5 * time.Second
+)
+
+// pollBackoff computes the delays between the polls of the device-code flow.
+// The delay starts at the interval requested by the server and grows exponentially up to maxPollDelay,
+// with a random jitter to avoid clients polling in sync. It never goes below the server interval.
+type pollBackoff struct {
+	interval time.Duration
+	delay    time.Duration
+}
+
+func newPollBackoff(intervalSeconds int) *pollBackoff {
+	interval := time.Duration(intervalSeconds) * time.Second
+	if interval <= 0 {
+		interval = defaultPollInterval
+	}
+	return &pollBackoff{interval: interval, delay: interval}
+}
+
+// next returns the delay before the next poll.
+func (b *pollBackoff) next() time.Duration {
+	delay := b.delay
+	b.delay = max(min(b.delay*3/2, maxPollDelay), b.interval)
+	// The jitter is only ever added, up to 10% of the delay, to never poll faster than the interval.
+	return delay + mathrand.N(delay/10+1)
+}
+
+// slowDown increases the poll interval, as requested by the server with a slow_down error.
+func (b *pollBackoff) slowDown() {
+	b.interval += slowDownIncrement
+	b.delay = max(b.delay, b.interval)
+}
+
+// isSlowDown reports whether the error asks to poll less often.
+func isSlowDown(err error) bool {
+	authErr := &auth.AuthError{}
+	return errors.As(err, &authErr) && authErr.Err == "slow_down"
+}
+
 // AuthClient is a user friendly wrapper to the BMW auth API
 // It mostly relies on openapi generated code for the plumbing
 // and provides simple interfaces and helpers to authenticate
@@ -228,6 +621,9 @@ type AuthClient struct {
 	auth       auth.ClientInterfaceWithRefreshToken
 	AuthServer string
 	Challenger AuthChallenger
+	pool       connectionPool
+
+	httpTransport http.RoundTripper
 }
 
 type AuthClientOption func(*AuthClient) error
@@ -252,9 +648,14 @@ func WithChallenger(challenger AuthChallenger) AuthClientOption {
 }
 
 // WithAuthServer is a client option that allows you to set the auth server.
+// It must be an absolute http(s) URL, trailing slashes are removed.
 func WithAuthServer(authServer string) AuthClientOption {
 	return func(c *AuthClient) error {
-		c.AuthServer = authServer
+		server, err := normalizeServerURL(authServer)
+		if err != nil {
+			return fmt.Errorf("invalid auth server: %w", err)
+		}
+		c.AuthServer = server
 		return nil
 	}
 }
@@ -267,6 +668,7 @@ func NewAuthClient(options ...AuthClientOption) (*AuthClient, error) {
 	authClient := &AuthClient{
 		AuthServer: auth.AuthServer,
 		Challenger: &S256Challenger{},
+		pool:       defaultConnectionPool(),
 	}
 	for _, option := range options {
 		if err := option(authClient); err != nil {
@@ -274,7 +676,12 @@ func NewAuthClient(options ...AuthClientOption) (*AuthClient, error) {
 		}
 	}
 	if authClient.auth == nil {
-		auth, err := auth.NewClient(authClient.AuthServer)
+		transport := authClient.httpTransport
+		if transport == nil {
+			transport = authClient.pool.transport()
+		}
+		// As for the CarData API, a dedicated client is used instead of http.DefaultClient.
+		auth, err := auth.NewClient(authClient.AuthServer, auth.WithHTTPClient(&http.Client{Transport: transport}))
 		if err != nil {
 			return nil, err
 		}
@@ -283,6 +690,17 @@ func NewAuthClient(options ...AuthClientOption) (*AuthClient, error) {
 	return authClient, nil
 }
 
+// formatScopes serializes the scopes as the space-delimited list of case-sensitive scopes
+// expected by the BMW device code endpoint, as of RFC 6749 section 3.3.
+// The form encoding of the request then encodes the spaces as '+'.
+// As the order of the scopes is not significant, they are sorted and deduplicated so that
+// the same scopes always produce the same request, regardless of the configuration order.
+func formatScopes(scopes []Scope) string {
+	set := ScopeSet{}
+	set.Add(scopes...)
+	return set.String()
+}
+
 // InitiateAuthenticationSession is a low level function that initiates the authentication session and returns the session information.
 // It is recommended to use the Authenticate function instead.
 func (c *AuthClient) InitiateAuthenticationSession(ctx context.Context, clientID string, scopes []Scope) (*AuthenticationSession, error) {
@@ -290,14 +708,14 @@ func (c *AuthClient) InitiateAuthenticationSession(ctx context.Context, clientID
 	if err != nil {
 		return nil, err
 	}
-	scopesString := make([]string, len(scopes))
-	for i, scope := range scopes {
-		scopesString[i] = string(scope)
-	}
 	codeVerifier, err := c.Challenger.Verifier()
 	if err != nil {
 		return nil, err
 	}
+	err = validateCodeVerifier(codeVerifier)
+	if err != nil {
+		return nil, err
+	}
 	codeChallenge, err := c.Challenger.Challenge()
 	if err != nil {
 		return nil, err
@@ -307,7 +725,7 @@ func (c *AuthClient) InitiateAuthenticationSession(ctx context.Context, clientID
 		ResponseType:        auth.DeviceCode,
 		CodeChallengeMethod: auth.S256,
 		CodeChallenge:       codeChallenge,
-		Scope:               strings.Join(scopesString, " "),
+		Scope:               formatScopes(scopes),
 	}
 	resp, err := c.auth.PostGcdmOauthDeviceCodeWithFormdataBody(
 		ctx,
@@ -393,7 +811,11 @@ func (c *AuthClient) parseOauthTokenResponse(ctx context.Context, parsedClientID
 	}
 	switch resp.StatusCode {
 	case http.StatusOK:
-		var tokenResponse auth.TokenResponse
+		var tokenResponse struct {
+			auth.TokenResponse
+			// RefreshExpiresIn is the lifetime of the refresh token in seconds, when provided.
+			RefreshExpiresIn int `json:"refresh_expires_in"`
+		}
 		if err := json.NewDecoder(resp.Body).Decode(&tokenResponse); err != nil {
 			return nil, err
 		}
@@ -407,6 +829,9 @@ func (c *AuthClient) parseOauthTokenResponse(ctx context.Context, parsedClientID
 			Scope:        tokenResponse.Scope,
 			TokenType:    tokenResponse.TokenType,
 		}
+		if tokenResponse.RefreshExpiresIn > 0 {
+			session.RefreshTokenExpiresAt = time.Now().Round(0).Add(time.Duration(tokenResponse.RefreshExpiresIn) * time.Second)
+		}
 		return session, nil
 	default:
 		var httpErr auth.AuthError
@@ -439,6 +864,34 @@ type AuthChallenger interface {
 	Method() auth.DeviceCodeFlowPart1CodeChallengeMethod
 }
 
+// ErrInvalidCodeVerifier is returned when the PKCE code verifier generated by the AuthChallenger
+// does not comply with RFC 7636, which BMW would reject with an obscure error.
+var ErrInvalidCodeVerifier = errors.New("invalid PKCE code verifier")
+
+// Bounds of the length of the PKCE code verifiers, as of RFC 7636 section 4.1.
+const (
+	minCodeVerifierLength = 43
+	maxCodeVerifierLength = 128
+)
+
+// validateCodeVerifier checks the code verifier length and characters, as of RFC 7636 section 4.1.
+func validateCodeVerifier(verifier string) error {
+	if len(verifier) < minCodeVerifierLength || len(verifier) > maxCodeVerifierLength {
+		return fmt.Errorf("%w: it must be %d to %d characters long, got %d", ErrInvalidCodeVerifier, minCodeVerifierLength, maxCodeVerifierLength, len(verifier))
+	}
+	for _, r := range verifier {
+		if !isUnreservedCharacter(r) {
+			return fmt.Errorf("%w: character %q is not allowed", ErrInvalidCodeVerifier, r)
+		}
+	}
+	return nil
+}
+
+// isUnreservedCharacter reports whether the character is an unreserved URI character, the only ones allowed in code verifiers.
+func isUnreservedCharacter(r rune) bool {
+	return r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune("-._~", r)
+}
+
 type S256Challenger struct {
 	codeVerifier string
 }
@@ -458,6 +911,7 @@ func (c *S256Challenger) Verifier() (string, error) {
 	if c.codeVerifier != "" {
 		return c.codeVerifier, nil
 	}
+	// 64 random bytes are encoded in 86 characters, within the bounds of RFC 7636.
 	randomBytes := make([]byte, 64)
 	if _, err := rand.Read(randomBytes); err != nil {
 		return "", err
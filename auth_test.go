@@ -6,7 +6,11 @@ import (
 	"encoding/json"
 	"errors"
 	"io"
+	"log/slog"
 	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -59,6 +63,9 @@ func (m *mockAuthClient) PostGcdmOauthRefreshTokenWithFormdataBody(ctx context.C
 	return nil, nil
 }
 
+// testVerifier is a code verifier of the minimal length allowed by RFC 7636.
+const testVerifier = "verifier-0123456789-abcdefghijklmnopqrstuvw"
+
 type mockChallenger struct {
 	challenge string
 	verifier  string
@@ -103,14 +110,56 @@ func TestInitiateAuthenticationSession_Success(t *testing.T) {
 			VerificationUriComplete: "https://verify?code=USER-1234",
 		}), nil
 	}
-	c := &AuthClient{auth: m, Challenger: &mockChallenger{challenge: "challenge", verifier: "verifier"}}
+	c := &AuthClient{auth: m, Challenger: &mockChallenger{challenge: "challenge", verifier: testVerifier}}
 	sess, err := c.InitiateAuthenticationSession(context.Background(), testClientID, []Scope{ScopeOpenID})
 	require.NoError(t, err)
 	require.NotNil(t, sess)
 	assert.Equal(t, "dev-code", sess.DeviceCode)
 	assert.Equal(t, "USER-1234", sess.UserCode)
 	assert.Equal(t, 3, sess.Interval)
-	assert.Equal(t, "verifier", sess.Verifier)
+	assert.Equal(t, testVerifier, sess.Verifier)
+}
+
+func TestInitiateAuthenticationSession_Scopes(t *testing.T) {
+	m := &mockAuthClient{}
+	m.postDeviceCode = func(ctx context.Context, params *authapi.PostGcdmOauthDeviceCodeParams, body authapi.PostGcdmOauthDeviceCodeFormdataRequestBody, reqEditors ...authapi.RequestEditorFn) (*http.Response, error) {
+		assert.Equal(t, "authenticate_user cardata:api:read openid", body.Scope)
+		req, err := authapi.NewPostGcdmOauthDeviceCodeRequestWithFormdataBody("https://example.com", params, body)
+		require.NoError(t, err)
+		raw, err := io.ReadAll(req.Body)
+		require.NoError(t, err)
+		assert.Contains(t, string(raw), "scope=authenticate_user+cardata%3Aapi%3Aread+openid", "spaces must be form encoded")
+		return httpResp(http.StatusOK, authapi.DeviceCodeResponse{DeviceCode: "dev-code"}), nil
+	}
+	c := &AuthClient{auth: m, Challenger: &mockChallenger{challenge: "challenge", verifier: testVerifier}}
+	_, err := c.InitiateAuthenticationSession(context.Background(), testClientID, []Scope{ScopeOpenID, ScopeCardataAPI, " openid ", "", ScopeAuthenticateUser})
+	require.NoError(t, err)
+
+	assert.Equal(t, formatScopes([]Scope{ScopeCardataStreaming, ScopeOpenID}), formatScopes([]Scope{ScopeOpenID, ScopeCardataStreaming}), "the scopes must be serialized regardless of their order")
+}
+
+func TestInitiateAuthenticationSession_InvalidVerifier(t *testing.T) {
+	m := &mockAuthClient{}
+	m.postDeviceCode = func(ctx context.Context, params *authapi.PostGcdmOauthDeviceCodeParams, body authapi.PostGcdmOauthDeviceCodeFormdataRequestBody, reqEditors ...authapi.RequestEditorFn) (*http.Response, error) {
+		t.Error("invalid verifiers must not be sent")
+		return httpResp(http.StatusOK, authapi.DeviceCodeResponse{DeviceCode: "dev-code"}), nil
+	}
+	for _, verifier := range []string{"verifier", strings.Repeat("v", 129), testVerifier[:42] + "+"} {
+		c := &AuthClient{auth: m, Challenger: &mockChallenger{challenge: "challenge", verifier: verifier}}
+		_, err := c.InitiateAuthenticationSession(context.Background(), testClientID, nil)
+		assert.ErrorIs(t, err, ErrInvalidCodeVerifier, verifier)
+	}
+}
+
+func TestS256Challenger(t *testing.T) {
+	challenger := &S256Challenger{}
+	verifier, err := challenger.Verifier()
+	require.NoError(t, err)
+	assert.Len(t, verifier, 86)
+	assert.NoError(t, validateCodeVerifier(verifier))
+	again, err := challenger.Verifier()
+	require.NoError(t, err)
+	assert.Equal(t, verifier, again, "the verifier must be stable for the challenge to match it")
 }
 
 func TestInitiateAuthenticationSession_BadRequest(t *testing.T) {
@@ -118,7 +167,7 @@ func TestInitiateAuthenticationSession_BadRequest(t *testing.T) {
 	m.postDeviceCode = func(ctx context.Context, params *authapi.PostGcdmOauthDeviceCodeParams, body authapi.PostGcdmOauthDeviceCodeFormdataRequestBody, reqEditors ...authapi.RequestEditorFn) (*http.Response, error) {
 		return httpResp(http.StatusBadRequest, authapi.AuthError{Err: "invalid", Description: "bad"}), nil
 	}
-	c := &AuthClient{auth: m, Challenger: &mockChallenger{challenge: "challenge", verifier: "verifier"}}
+	c := &AuthClient{auth: m, Challenger: &mockChallenger{challenge: "challenge", verifier: testVerifier}}
 	_, err := c.InitiateAuthenticationSession(context.Background(), testClientID, nil)
 	require.Error(t, err)
 	assert.ErrorContains(t, err, "invalid")
@@ -445,3 +494,376 @@ func TestIgnoreFloNotCompleted(t *testing.T) {
 	require.NoError(t, ignoreFlowNotCompleted(&authapi.AuthError{StatusCode: http.StatusForbidden, Err: "authorization_pending"}))
 	require.Error(t, ignoreFlowNotCompleted(&authapi.AuthError{StatusCode: http.StatusBadRequest, Err: "bad"}))
 }
+
+func TestPollBackoff(t *testing.T) {
+	b := newPollBackoff(2)
+	interval := 2 * time.Second
+	previous := time.Duration(0)
+	for i := 0; i < 20; i++ {
+		delay := b.next()
+		assert.GreaterOrEqual(t, delay, interval, "the delay must never drop below the server interval")
+		assert.LessOrEqual(t, delay, maxPollDelay+maxPollDelay/10, "the delay must be capped")
+		if i == 3 {
+			assert.Greater(t, delay, 3*time.Second, "the delay must grow")
+		}
+		previous = delay
+	}
+	assert.GreaterOrEqual(t, previous, maxPollDelay)
+
+	b = newPollBackoff(0)
+	assert.GreaterOrEqual(t, b.next(), defaultPollInterval)
+
+	b = newPollBackoff(1)
+	first := b.next()
+	b.slowDown()
+	interval = time.Second + slowDownIncrement
+	for i := 0; i < 5; i++ {
+		delay := b.next()
+		assert.Greater(t, delay, first)
+		assert.GreaterOrEqual(t, delay, interval, "slow_down must increase the interval")
+	}
+
+	assert.True(t, isSlowDown(&authapi.AuthError{StatusCode: http.StatusBadRequest, Err: "slow_down"}))
+	assert.False(t, isSlowDown(&authapi.AuthError{StatusCode: http.StatusForbidden, Err: "authorization_pending"}))
+	assert.False(t, isSlowDown(nil))
+}
+
+func TestPromptWriter(t *testing.T) {
+	buf := &bytes.Buffer{}
+	PromptWriter(buf)("https://example.com", "123456", "https://example.com?code=123456")
+	assert.Equal(t, "Open https://example.com and enter code 123456\nDirect link: https://example.com?code=123456\n", buf.String())
+}
+
+func TestPromptHyperlink(t *testing.T) {
+	t.Setenv("TERM", "xterm-256color")
+	t.Setenv("NO_COLOR", "")
+	buf := &bytes.Buffer{}
+	PromptHyperlink(buf)("https://example.com", "123456", "https://example.com?code=123456")
+	assert.Equal(t, "Open \x1b]8;;https://example.com\x1b\\https://example.com\x1b]8;;\x1b\\ and enter code 123456\n"+
+		"Direct link: \x1b]8;;https://example.com?code=123456\x1b\\https://example.com?code=123456\x1b]8;;\x1b\\\n", buf.String())
+
+	for name, env := range map[string]map[string]string{
+		"dumb terminal": {"TERM": "dumb"},
+		"no terminal":   {"TERM": ""},
+		"no color":      {"TERM": "xterm-256color", "NO_COLOR": "1"},
+	} {
+		assert.False(t, supportsHyperlinks(func(key string) string { return env[key] }), name)
+	}
+	assert.True(t, supportsHyperlinks(func(key string) string { return map[string]string{"TERM": "xterm"}[key] }))
+}
+
+func TestPromptLogger(t *testing.T) {
+	buf := &bytes.Buffer{}
+	PromptLogger(slog.New(slog.NewJSONHandler(buf, nil)))("https://example.com", "123456", "https://example.com?code=123456")
+	record := map[string]any{}
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &record))
+	assert.Equal(t, "https://example.com", record["verification_uri"])
+	assert.Equal(t, "123456", record["user_code"])
+	assert.Equal(t, "https://example.com?code=123456", record["verification_uri_complete"])
+}
+
+func TestAuthenticatorGetSession_NonInteractive(t *testing.T) {
+	refreshErr := errors.New("invalid_grant")
+	m := &mochAuthenticationImplem{}
+	m.refreshTokenFunc = func(ctx context.Context, clientID string, refreshToken string) (*AuthenticatedSession, error) {
+		return nil, refreshErr
+	}
+	authenticator, err := NewAuthenticator(
+		WithClientID(testClientID),
+		WithSessionStore(&InMemorySessionStore{}),
+		WithInteractive(false),
+	)
+	require.NoError(t, err, "non-interactive authenticators do not require a PromptURI")
+	authenticator.AuthClient = m
+
+	_, err = authenticator.GetSession(context.Background())
+	require.ErrorIs(t, err, ErrInteractiveAuthenticationRequired)
+	assert.Equal(t, 0, m.initiateAuthenticationSessionCalls)
+
+	authenticator.SessionStore = &InMemorySessionStore{session: &AuthenticatedSession{
+		ClientID:     uuid.MustParse(testClientID),
+		RefreshToken: "ref",
+		ExpiresAt:    time.Now().Add(-time.Minute),
+	}}
+	_, err = authenticator.GetSession(context.Background())
+	require.ErrorIs(t, err, ErrInteractiveAuthenticationRequired)
+	require.ErrorIs(t, err, refreshErr, "the refresh error must be kept to tell transient failures apart")
+	assert.Equal(t, 1, m.refreshTokenCalls)
+	assert.Equal(t, 0, m.initiateAuthenticationSessionCalls)
+
+	m.refreshTokenFunc = func(ctx context.Context, clientID string, refreshToken string) (*AuthenticatedSession, error) {
+		return &AuthenticatedSession{AccessToken: "acc", ExpiresAt: time.Now().Add(time.Hour)}, nil
+	}
+	session, err := authenticator.GetSession(context.Background())
+	require.NoError(t, err, "refreshable sessions must still be usable")
+	assert.Equal(t, "acc", session.AccessToken)
+}
+
+func TestAuthenticatorSetSession(t *testing.T) {
+	m := &mochAuthenticationImplem{}
+	m.refreshTokenFunc = func(ctx context.Context, clientID string, refreshToken string) (*AuthenticatedSession, error) {
+		assert.Equal(t, "external-ref", refreshToken)
+		return &AuthenticatedSession{AccessToken: "refreshed", ClientID: uuid.MustParse(testClientID), ExpiresAt: time.Now().Add(time.Hour)}, nil
+	}
+	authenticator := &Authenticator{
+		ClientID:     testClientID,
+		AuthClient:   m,
+		SessionStore: &InMemorySessionStore{},
+		PromptURI: func(uri, code, complete string) {
+			t.Fatal("the device-code flow must not be used with a pre-seeded session")
+		},
+	}
+
+	err := authenticator.SetSession(context.Background(), &AuthenticatedSession{AccessToken: "other", ClientID: uuid.MustParse(otherClientID)})
+	assert.Error(t, err, "sessions of another client ID must be rejected")
+	assert.Error(t, authenticator.SetSession(context.Background(), nil))
+
+	require.NoError(t, authenticator.SetSession(context.Background(), &AuthenticatedSession{
+		AccessToken: "external", RefreshToken: "external-ref", ClientID: uuid.MustParse(testClientID), ExpiresAt: time.Now().Add(time.Hour),
+	}))
+	session, err := authenticator.GetSession(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "external", session.AccessToken)
+
+	require.NoError(t, authenticator.SetSession(context.Background(), &AuthenticatedSession{
+		AccessToken: "external", RefreshToken: "external-ref", ClientID: uuid.MustParse(testClientID), ExpiresAt: time.Now().Add(-time.Minute),
+	}))
+	session, err = authenticator.GetSession(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "refreshed", session.AccessToken, "expired pre-seeded sessions must be refreshed")
+}
+
+func TestAuthenticatorGetSession_ConcurrentRefresh(t *testing.T) {
+	refreshes := atomic.Int32{}
+	m := &mochAuthenticationImplem{}
+	m.refreshTokenFunc = func(ctx context.Context, clientID string, refreshToken string) (*AuthenticatedSession, error) {
+		refreshes.Add(1)
+		// Leave room for the concurrent calls to pile up
+		time.Sleep(10 * time.Millisecond)
+		return &AuthenticatedSession{AccessToken: "refreshed", RefreshToken: "new-ref", ClientID: uuid.MustParse(testClientID), ExpiresAt: time.Now().Add(time.Hour)}, nil
+	}
+	authenticator := &Authenticator{
+		ClientID:   testClientID,
+		AuthClient: m,
+		SessionStore: &InMemorySessionStore{session: &AuthenticatedSession{
+			AccessToken: "expired", RefreshToken: "ref", ClientID: uuid.MustParse(testClientID), ExpiresAt: time.Now().Add(-time.Minute),
+		}},
+		PromptURI: func(uri, code, complete string) {},
+	}
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			session, err := authenticator.GetSession(context.Background())
+			assert.NoError(t, err)
+			assert.Equal(t, "refreshed", session.AccessToken)
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int32(1), refreshes.Load(), "concurrent calls must share a single refresh")
+}
+
+func TestAuthenticatorGetSession_WaitBoundedByContext(t *testing.T) {
+	prompted := make(chan struct{})
+	m := &mochAuthenticationImplem{}
+	m.initiateAuthenticationSessionFunc = func(ctx context.Context, clientID string, scopes []Scope) (*AuthenticationSession, error) {
+		return &AuthenticationSession{ExpiresIn: 3600, Interval: 1}, nil
+	}
+	m.pollAuthTokenFunc = func(ctx context.Context, authSession *AuthenticationSession) (*AuthenticatedSession, error) {
+		// The user never completes the flow.
+		return nil, nil
+	}
+	authenticator := &Authenticator{
+		ClientID:     testClientID,
+		AuthClient:   m,
+		SessionStore: &InMemorySessionStore{},
+		PromptURI:    func(uri, code, complete string) { close(prompted) },
+	}
+	flowCtx, cancelFlow := context.WithCancel(context.Background())
+	defer cancelFlow()
+	flowDone := make(chan struct{})
+	go func() {
+		defer close(flowDone)
+		_, _ = authenticator.GetSession(flowCtx)
+	}()
+	<-prompted
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err := authenticator.GetSession(ctx)
+	require.ErrorIs(t, err, context.DeadlineExceeded, "waiting for the ongoing authentication flow must be bounded by the context")
+	require.ErrorIs(t, authenticator.SetSession(ctx, &AuthenticatedSession{ClientID: uuid.MustParse(testClientID)}), context.DeadlineExceeded)
+	_, err = authenticator.RefreshSession(ctx)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	_, err = authenticator.UpgradeScopes(ctx, ScopeCardataStreaming)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+
+	cancelFlow()
+	<-flowDone
+}
+
+func TestAuthenticatorRefreshSession(t *testing.T) {
+	m := &mochAuthenticationImplem{}
+	m.refreshTokenFunc = func(ctx context.Context, clientID string, refreshToken string) (*AuthenticatedSession, error) {
+		return &AuthenticatedSession{AccessToken: "refreshed", IdToken: p("fresh"), ClientID: uuid.MustParse(testClientID), ExpiresAt: time.Now().Add(time.Hour)}, nil
+	}
+	store := &InMemorySessionStore{session: &AuthenticatedSession{
+		AccessToken: "valid", RefreshToken: "ref", ClientID: uuid.MustParse(testClientID), ExpiresAt: time.Now().Add(time.Hour),
+	}}
+	authenticator := &Authenticator{ClientID: testClientID, AuthClient: m, SessionStore: store, PromptURI: func(uri, code, complete string) {}}
+
+	session, err := authenticator.RefreshSession(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "refreshed", session.AccessToken, "the session must be refreshed even if not expired")
+	assert.Equal(t, 1, m.refreshTokenCalls)
+	assert.Equal(t, "refreshed", store.session.AccessToken)
+}
+
+func TestWithTokenRefreshCallback(t *testing.T) {
+	m := &mochAuthenticationImplem{}
+	m.refreshTokenFunc = func(ctx context.Context, clientID string, refreshToken string) (*AuthenticatedSession, error) {
+		return &AuthenticatedSession{AccessToken: "refreshed", ClientID: uuid.MustParse(testClientID), ExpiresAt: time.Now().Add(time.Hour)}, nil
+	}
+	refreshed := make(chan *AuthenticatedSession, 1)
+	authenticator := &Authenticator{ClientID: testClientID, AuthClient: m, SessionStore: &InMemorySessionStore{}, NonInteractive: true}
+	require.NoError(t, WithTokenRefreshCallback(func(session *AuthenticatedSession) {
+		refreshed <- session
+	})(authenticator))
+
+	require.NoError(t, authenticator.SetSession(context.Background(), &AuthenticatedSession{
+		AccessToken: "expired", RefreshToken: "ref", ClientID: uuid.MustParse(testClientID), ExpiresAt: time.Now().Add(-time.Minute),
+	}))
+	select {
+	case <-refreshed:
+		t.Fatal("sessions set with SetSession must not be reported")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	session, err := authenticator.GetSession(context.Background())
+	require.NoError(t, err)
+	select {
+	case s := <-refreshed:
+		assert.Same(t, session, s)
+	case <-time.After(time.Second):
+		t.Fatal("the refreshed session must be reported")
+	}
+}
+
+func TestWithAuthServer(t *testing.T) {
+	for _, server := range []string{"example.com", "ftp://example.com"} {
+		_, err := NewAuthClient(WithAuthServer(server))
+		assert.Error(t, err, server)
+	}
+
+	client, err := NewAuthClient(WithAuthServer("http://localhost:8080/"))
+	require.NoError(t, err)
+	assert.Equal(t, "http://localhost:8080", client.AuthServer)
+}
+
+func TestAuthenticatorRefreshTokenRotation(t *testing.T) {
+	for name, store := range map[string]SessionStore{"without session store": nil, "with session store": &InMemorySessionStore{}} {
+		t.Run(name, func(t *testing.T) {
+			refreshTokens := []string{}
+			m := &mochAuthenticationImplem{}
+			m.refreshTokenFunc = func(ctx context.Context, clientID string, refreshToken string) (*AuthenticatedSession, error) {
+				refreshTokens = append(refreshTokens, refreshToken)
+				rotated := ""
+				if len(refreshTokens) == 1 {
+					rotated = "rotated"
+				}
+				// Sessions expire right away to refresh on each call.
+				return &AuthenticatedSession{AccessToken: "acc", RefreshToken: rotated, ClientID: uuid.MustParse(testClientID), ExpiresAt: time.Now().Add(-time.Minute)}, nil
+			}
+			authenticator := &Authenticator{ClientID: testClientID, AuthClient: m, SessionStore: store, PromptURI: func(uri, code, complete string) {
+				t.Error("promptURI should not be called")
+			}}
+			require.NoError(t, authenticator.SetSession(context.Background(), &AuthenticatedSession{
+				RefreshToken: "initial", ClientID: uuid.MustParse(testClientID), ExpiresAt: time.Now().Add(-time.Minute),
+			}))
+
+			for i := 0; i < 3; i++ {
+				_, err := authenticator.GetSession(context.Background())
+				require.NoError(t, err)
+			}
+			assert.Equal(t, []string{"initial", "rotated", "rotated"}, refreshTokens, "the rotated refresh token must be used, and kept when not rotated again")
+		})
+	}
+}
+
+func TestRefreshToken_RefreshExpiresIn(t *testing.T) {
+	m := &mockAuthClient{}
+	m.postRefresh = func(ctx context.Context, params *authapi.PostGcdmOauthTokenParams, body authapi.PostGcdmOauthRefreshTokenRequest, reqEditors ...authapi.RequestEditorFn) (*http.Response, error) {
+		return httpResp(http.StatusOK, `{"access_token": "acc", "expires_in": 3600, "refresh_token": "ref", "refresh_expires_in": 1209600}`), nil
+	}
+	c := &AuthClient{auth: m}
+	got, err := c.RefreshToken(context.Background(), testClientID, "ref")
+	require.NoError(t, err)
+	assert.WithinDuration(t, time.Now().Add(14*24*time.Hour), got.RefreshTokenExpiresAt, time.Minute)
+	assert.False(t, got.RefreshTokenExpired())
+
+	assert.False(t, (&AuthenticatedSession{}).RefreshTokenExpired(), "unknown expiries must not be reported as expired")
+	assert.True(t, (&AuthenticatedSession{RefreshTokenExpiresAt: time.Now().Add(-time.Second)}).RefreshTokenExpired())
+}
+
+func TestAuthenticatorRefreshTokenExpiry(t *testing.T) {
+	refreshToken := "rotated"
+	m := &mochAuthenticationImplem{}
+	m.refreshTokenFunc = func(ctx context.Context, clientID string, _ string) (*AuthenticatedSession, error) {
+		// Sessions expire right away to refresh on each call.
+		return &AuthenticatedSession{AccessToken: "acc", RefreshToken: refreshToken, ClientID: uuid.MustParse(testClientID), ExpiresAt: time.Now().Add(-time.Minute)}, nil
+	}
+	buf := &bytes.Buffer{}
+	authenticator := &Authenticator{ClientID: testClientID, AuthClient: m, SessionStore: &InMemorySessionStore{}, NonInteractive: true}
+	require.NoError(t, WithRefreshTokenLifetime(time.Hour)(authenticator))
+	require.NoError(t, WithRefreshTokenExpiryWarning(2*time.Hour, slog.New(slog.NewJSONHandler(buf, nil)))(authenticator))
+	require.Error(t, WithRefreshTokenLifetime(0)(authenticator))
+	require.NoError(t, authenticator.SetSession(context.Background(), &AuthenticatedSession{
+		RefreshToken: "initial", ClientID: uuid.MustParse(testClientID), ExpiresAt: time.Now().Add(-time.Minute),
+	}))
+
+	session, err := authenticator.GetSession(context.Background())
+	require.NoError(t, err)
+	expiresAt := session.RefreshTokenExpiresAt
+	assert.WithinDuration(t, time.Now().Add(time.Hour), expiresAt, time.Minute, "the lifetime must apply to new refresh tokens")
+
+	refreshToken = ""
+	session, err = authenticator.GetSession(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, expiresAt, session.RefreshTokenExpiresAt, "the expiry must be kept when the refresh token is not rotated")
+
+	assert.Equal(t, 1, strings.Count(buf.String(), "the refresh token expires soon"), "the warning must be logged once per refresh token")
+}
+
+func TestAuthenticatorGetSession_RefreshTokenExpired(t *testing.T) {
+	m := &mochAuthenticationImplem{}
+	authenticator := &Authenticator{ClientID: testClientID, AuthClient: m, NonInteractive: true, SessionStore: &InMemorySessionStore{session: &AuthenticatedSession{
+		RefreshToken:          "ref",
+		RefreshTokenExpiresAt: time.Now().Add(-time.Minute),
+		ClientID:              uuid.MustParse(testClientID),
+		ExpiresAt:             time.Now().Add(-time.Minute),
+	}}}
+
+	_, err := authenticator.GetSession(context.Background())
+	require.ErrorIs(t, err, ErrInteractiveAuthenticationRequired)
+	require.ErrorIs(t, err, ErrRefreshTokenExpired)
+	_, err = authenticator.RefreshSession(context.Background())
+	require.ErrorIs(t, err, ErrRefreshTokenExpired)
+	assert.Equal(t, 0, m.refreshTokenCalls, "expired refresh tokens must not be sent")
+}
+
+func TestAuthenticatorGcid(t *testing.T) {
+	authenticator := &Authenticator{ClientID: testClientID, SessionStore: &InMemorySessionStore{}, NonInteractive: true}
+	require.NoError(t, authenticator.SetSession(context.Background(), &AuthenticatedSession{
+		AccessToken: "acc", Gcid: "gcid", ClientID: uuid.MustParse(testClientID), ExpiresAt: time.Now().Add(time.Hour),
+	}))
+	gcid, err := authenticator.Gcid(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "gcid", gcid)
+
+	_, err = sessionGcid(context.Background(), &staticAuthenticator{session: &AuthenticatedSession{}})
+	assert.ErrorIs(t, err, ErrMissingGcid)
+	_, err = sessionGcid(context.Background(), &staticAuthenticator{err: errors.New("failed")})
+	assert.EqualError(t, err, "failed")
+}
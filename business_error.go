@@ -0,0 +1,39 @@
+package bmwcardata
+
+import "strings"
+
+// BusinessErrorCode classifies the business errors of the charging sessions, see BusinessError.Classify.
+type BusinessErrorCode string
+
+const (
+	// BusinessErrorUnknown is the code of the hints that are not recognized, the raw hint is then the only information.
+	BusinessErrorUnknown BusinessErrorCode = "unknown"
+	// BusinessErrorPreconditioningNotAvailable reports that the preconditioning information of the session is not available.
+	BusinessErrorPreconditioningNotAvailable BusinessErrorCode = "preconditioning-not-available"
+	// BusinessErrorCostUnavailable reports that the charging cost of the session could not be calculated.
+	BusinessErrorCostUnavailable BusinessErrorCode = "cost-unavailable"
+)
+
+// businessErrorKeywords lists the keywords identifying each code in the hints, checked in order.
+var businessErrorKeywords = []struct {
+	code     BusinessErrorCode
+	keywords []string
+}{
+	{code: BusinessErrorPreconditioningNotAvailable, keywords: []string{"precondition"}},
+	{code: BusinessErrorCostUnavailable, keywords: []string{"cost", "price", "tariff"}},
+}
+
+// Classify returns the code of the business error, so that sessions can be bucketed by error type.
+// BMW does not document the hints, hence they are classified by keywords, case-insensitively,
+// and BusinessErrorUnknown is returned for the other hints, leaving the raw Hint as the only information.
+func (e BusinessError) Classify() BusinessErrorCode {
+	hint := strings.ToLower(e.Hint)
+	for _, candidate := range businessErrorKeywords {
+		for _, keyword := range candidate.keywords {
+			if strings.Contains(hint, keyword) {
+				return candidate.code
+			}
+		}
+	}
+	return BusinessErrorUnknown
+}
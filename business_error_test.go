@@ -0,0 +1,19 @@
+package bmwcardata
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBusinessErrorClassify(t *testing.T) {
+	for hint, expected := range map[string]BusinessErrorCode{
+		"Preconditioning information not available": BusinessErrorPreconditioningNotAvailable,
+		"CHARGING_COST_NOT_AVAILABLE":               BusinessErrorCostUnavailable,
+		"No tariff found for the charging location": BusinessErrorCostUnavailable,
+		"something else":                            BusinessErrorUnknown,
+		"":                                          BusinessErrorUnknown,
+	} {
+		assert.Equal(t, expected, BusinessError{Hint: hint}.Classify(), hint)
+	}
+}
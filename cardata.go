@@ -3,30 +3,96 @@ package bmwcardata
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
+	"mime"
 	"net/http"
+	"reflect"
+	"slices"
+	"strings"
 	"time"
 
 	"github.com/tjamet/bmw-cardata/cardataapi"
 )
 
+type getBasicDataOptions struct {
+	fields []string
+	etag   *string
+}
+
+type GetBasicDataOption func(*getBasicDataOptions)
+
+// WithBasicDataFields restricts the basic data to the given fields, named after their JSON names,
+// like modelName or driveTrain.
+// The CarData API has no field selection, the whole vehicle is still transferred and the
+// other fields are cleared once decoded. This documents the fields the caller relies on.
+func WithBasicDataFields(fields ...string) GetBasicDataOption {
+	return func(options *getBasicDataOptions) {
+		options.fields = append(options.fields, fields...)
+	}
+}
+
+// ErrNotModified is returned by conditional requests when the resource did not change, see WithBasicDataETag.
+var ErrNotModified = errors.New("not modified")
+
+// WithBasicDataETag makes GetBasicData a conditional request, for polling tools to avoid fetching
+// the vehicle data again when it did not change.
+// When *etag is not empty, it is sent as If-None-Match and GetBasicData returns ErrNotModified
+// when the vehicle data did not change. On success, *etag is set to the ETag of the response, empty when there is none.
+func WithBasicDataETag(etag *string) GetBasicDataOption {
+	return func(options *getBasicDataOptions) {
+		options.etag = etag
+	}
+}
+
 // GetBasicData gets the basic data for a given VIN
 // See https://bmw-cardata.bmwgroup.com/customer/public/api-specification#operations-Vehicles-getBasicData
-func (c *Client) GetBasicData(ctx context.Context, vin string) (*cardataapi.VehicleDto, error) {
-	resp, err := c.carDataAPI.GetBasicData(ctx, vin, &cardataapi.GetBasicDataParams{XVersion: "v1"})
+func (c *Client) GetBasicData(ctx context.Context, vin string, options ...GetBasicDataOption) (*cardataapi.VehicleDto, error) {
+	vin, err := c.normalizeVIN(vin)
+	if err != nil {
+		return nil, err
+	}
+	opts := &getBasicDataOptions{}
+	for _, option := range options {
+		option(opts)
+	}
+	for _, field := range opts.fields {
+		if !slices.Contains(vehicleDtoFields, field) {
+			return nil, fmt.Errorf("unknown basic data field %q, expected one of %s", field, strings.Join(vehicleDtoFields, ", "))
+		}
+	}
+	editors := []cardataapi.RequestEditorFn{}
+	if opts.etag != nil && *opts.etag != "" {
+		etag := *opts.etag
+		editors = append(editors, func(ctx context.Context, req *http.Request) error {
+			req.Header.Set("If-None-Match", etag)
+			return nil
+		})
+	}
+	resp, err := c.carDataAPI.GetBasicData(ctx, vin, &cardataapi.GetBasicDataParams{XVersion: "v1"}, editors...)
 	if err != nil {
 		return nil, err
 	}
+	defer resp.Body.Close()
 	switch resp.StatusCode {
+	case http.StatusNotModified:
+		return nil, ErrNotModified
 	case http.StatusOK:
 		data := cardataapi.VehicleDto{}
 		err := json.NewDecoder(resp.Body).Decode(&data)
 		if err != nil {
 			return nil, err
 		}
+		if opts.etag != nil {
+			*opts.etag = resp.Header.Get("ETag")
+		}
+		if len(opts.fields) > 0 {
+			return projectVehicle(&data, opts.fields)
+		}
 		return &data, nil
 	default:
-		data := cardataapi.CarDataError{}
+		data := cardataapi.CarDataError{StatusCode: resp.StatusCode}
 		err := json.NewDecoder(resp.Body).Decode(&data)
 		if err != nil {
 			return nil, err
@@ -35,13 +101,62 @@ func (c *Client) GetBasicData(ctx context.Context, vin string) (*cardataapi.Vehi
 	}
 }
 
+// vehicleDtoFields lists the JSON names of the VehicleDto fields.
+var vehicleDtoFields = func() []string {
+	fields := []string{}
+	t := reflect.TypeFor[cardataapi.VehicleDto]()
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		fields = append(fields, name)
+	}
+	return fields
+}()
+
+// projectVehicle returns a copy of the vehicle with only the given fields set.
+func projectVehicle(vehicle *cardataapi.VehicleDto, fields []string) (*cardataapi.VehicleDto, error) {
+	data, err := json.Marshal(vehicle)
+	if err != nil {
+		return nil, err
+	}
+	all := map[string]json.RawMessage{}
+	err = json.Unmarshal(data, &all)
+	if err != nil {
+		return nil, err
+	}
+	selected := map[string]json.RawMessage{}
+	for _, field := range fields {
+		if value, ok := all[field]; ok {
+			selected[field] = value
+		}
+	}
+	data, err = json.Marshal(selected)
+	if err != nil {
+		return nil, err
+	}
+	projected := cardataapi.VehicleDto{}
+	err = json.Unmarshal(data, &projected)
+	if err != nil {
+		return nil, err
+	}
+	return &projected, nil
+}
+
 // GetMappings lists all the existing mappings (i.e. car VINs) that are available in the BMW CarData API
+// When the client is created WithMappingsCache, the cached mappings are returned until they expire.
 // See https://bmw-cardata.bmwgroup.com/customer/public/api-specification#operations-Vehicles-getMappings
 func (c *Client) GetMappings(ctx context.Context) ([]cardataapi.VehicleMappingDto, error) {
+	if c.mappingsCache != nil {
+		return c.mappingsCache.get(ctx, c.fetchMappings, c.now, false)
+	}
+	return c.fetchMappings(ctx)
+}
+
+func (c *Client) fetchMappings(ctx context.Context) ([]cardataapi.VehicleMappingDto, error) {
 	resp, err := c.carDataAPI.GetMappings(ctx, &cardataapi.GetMappingsParams{XVersion: "v1"})
 	if err != nil {
 		return nil, err
 	}
+	defer resp.Body.Close()
 	switch resp.StatusCode {
 	case http.StatusOK:
 		data := []cardataapi.VehicleMappingDto{}
@@ -51,7 +166,7 @@ func (c *Client) GetMappings(ctx context.Context) ([]cardataapi.VehicleMappingDt
 		}
 		return data, nil
 	default:
-		data := cardataapi.CarDataError{}
+		data := cardataapi.CarDataError{StatusCode: resp.StatusCode}
 		err := json.NewDecoder(resp.Body).Decode(&data)
 		if err != nil {
 			return nil, err
@@ -71,6 +186,10 @@ func WithChargingHistoryNextToken(token string) GetChargingHistoryParamsOption {
 // GetChargingHistory gets the charging history for a given VIN
 // See https://bmw-cardata.bmwgroup.com/customer/public/api-specification#operations-Vehicles-getChargingHistory
 func (c *Client) GetChargingHistory(ctx context.Context, vin string, from, to time.Time, options ...GetChargingHistoryParamsOption) (*cardataapi.ChargingHistoryResponseDto, error) {
+	vin, err := c.normalizeVIN(vin)
+	if err != nil {
+		return nil, err
+	}
 	params := &cardataapi.GetChargingHistoryParams{XVersion: "v1", From: from, To: to}
 	for _, option := range options {
 		option(params)
@@ -89,7 +208,7 @@ func (c *Client) GetChargingHistory(ctx context.Context, vin string, from, to ti
 		}
 		return &data, nil
 	default:
-		data := cardataapi.CarDataError{}
+		data := cardataapi.CarDataError{StatusCode: resp.StatusCode}
 		err := json.NewDecoder(resp.Body).Decode(&data)
 		if err != nil {
 			return nil, err
@@ -103,10 +222,51 @@ type Image struct {
 	ContentType string
 }
 
+// checkContentType checks the image is declared as an image, or looks like one when declared otherwise,
+// in which case the sniffed content type is used.
+func (i *Image) checkContentType() error {
+	if mediaType, _, err := mime.ParseMediaType(i.ContentType); err == nil && strings.HasPrefix(mediaType, "image/") {
+		return nil
+	}
+	sniffed := http.DetectContentType(i.Data)
+	if !strings.HasPrefix(sniffed, "image/") {
+		return fmt.Errorf("%w: declared %q, sniffed %q", ErrUnexpectedImageContentType, i.ContentType, sniffed)
+	}
+	i.ContentType = sniffed
+	return nil
+}
+
+// ErrUnexpectedImageContentType is returned by GetImage when BMW responds successfully with something
+// that is not an image, like the HTML error pages served during outages.
+var ErrUnexpectedImageContentType = errors.New("unexpected content type for the vehicle image")
+
+type getImageOptions struct {
+	lenient bool
+}
+
+type GetImageOption func(*getImageOptions)
+
+// WithLenientImageContentType makes GetImage return the response body whatever its content type.
+// By default, GetImage fails with ErrUnexpectedImageContentType unless the response is declared as,
+// or sniffed as, an image.
+func WithLenientImageContentType() GetImageOption {
+	return func(options *getImageOptions) {
+		options.lenient = true
+	}
+}
+
 // GetImage gets the image for a given VIN
 // See https://bmw-cardata.bmwgroup.com/customer/public/api-specification#operations-Vehicles-getImage
-func (c *Client) GetImage(ctx context.Context, vin string) (*Image, error) {
-	resp, err := c.carDataAPI.GetImage(ctx, vin, &cardataapi.GetImageParams{XVersion: "v1"})
+func (c *Client) GetImage(ctx context.Context, vin string, options ...GetImageOption) (*Image, error) {
+	vin, err := c.normalizeVIN(vin)
+	if err != nil {
+		return nil, err
+	}
+	opts := &getImageOptions{}
+	for _, option := range options {
+		option(opts)
+	}
+	resp, err := c.carDataAPI.GetImage(ctx, vin, &cardataapi.GetImageParams{XVersion: "v1"}, acceptImage)
 	if err != nil {
 		return nil, err
 	}
@@ -117,9 +277,16 @@ func (c *Client) GetImage(ctx context.Context, vin string) (*Image, error) {
 		if err != nil {
 			return nil, err
 		}
-		return &Image{Data: data, ContentType: resp.Header.Get("Content-Type")}, nil
+		image := &Image{Data: data, ContentType: resp.Header.Get("Content-Type")}
+		if !opts.lenient {
+			err = image.checkContentType()
+			if err != nil {
+				return nil, err
+			}
+		}
+		return image, nil
 	default:
-		data := cardataapi.CarDataError{}
+		data := cardataapi.CarDataError{StatusCode: resp.StatusCode}
 		err := json.NewDecoder(resp.Body).Decode(&data)
 		if err != nil {
 			return nil, err
@@ -139,6 +306,10 @@ func WithLocationBasedChargingSettingsNextToken(token string) GetLocationBasedCh
 // GetLocationBasedChargingSettings gets the location based charging settings for a given VIN
 // See https://bmw-cardata.bmwgroup.com/customer/public/api-specification#operations-Vehicles-getLocationBasedChargingSettings
 func (c *Client) GetLocationBasedChargingSettings(ctx context.Context, vin string, options ...GetLocationBasedChargingSettingsParamsOption) (*cardataapi.LocationBasedChargingSettingsDto, error) {
+	vin, err := c.normalizeVIN(vin)
+	if err != nil {
+		return nil, err
+	}
 	params := &cardataapi.GetLocationBasedChargingSettingsParams{XVersion: "v1"}
 	for _, option := range options {
 		option(params)
@@ -157,7 +328,7 @@ func (c *Client) GetLocationBasedChargingSettings(ctx context.Context, vin strin
 		}
 		return &data, nil
 	default:
-		data := cardataapi.CarDataError{}
+		data := cardataapi.CarDataError{StatusCode: resp.StatusCode}
 		err := json.NewDecoder(resp.Body).Decode(&data)
 		if err != nil {
 			return nil, err
@@ -167,8 +338,14 @@ func (c *Client) GetLocationBasedChargingSettings(ctx context.Context, vin strin
 }
 
 // GetSmartMaintenanceTyreDiagnosis gets the smart maintenance tyre diagnosis for a given VIN
+// The API specification defines no parameter other than the API version: the mounted and unmounted tyre sets
+// are always both returned, use TyreSet.Season to tell the winter and summer sets apart.
 // See https://bmw-cardata.bmwgroup.com/customer/public/api-specification#operations-Vehicles-getSmartMaintenanceTyreDiagnosis
 func (c *Client) GetSmartMaintenanceTyreDiagnosis(ctx context.Context, vin string) (*cardataapi.SmartMaintenanceTyreDiagnosisDto, error) {
+	vin, err := c.normalizeVIN(vin)
+	if err != nil {
+		return nil, err
+	}
 	resp, err := c.carDataAPI.GetSmartMaintenanceTyreDiagnosis(ctx, vin, &cardataapi.GetSmartMaintenanceTyreDiagnosisParams{XVersion: "v1"})
 	if err != nil {
 		return nil, err
@@ -183,7 +360,7 @@ func (c *Client) GetSmartMaintenanceTyreDiagnosis(ctx context.Context, vin strin
 		}
 		return &data, nil
 	default:
-		data := cardataapi.CarDataError{}
+		data := cardataapi.CarDataError{StatusCode: resp.StatusCode}
 		err := json.NewDecoder(resp.Body).Decode(&data)
 		if err != nil {
 			return nil, err
@@ -195,6 +372,10 @@ func (c *Client) GetSmartMaintenanceTyreDiagnosis(ctx context.Context, vin strin
 // GetTelematicData gets the telematic data for a given VIN and container ID
 // See https://bmw-cardata.bmwgroup.com/customer/public/api-specification#operations-Vehicles-getTelematicData
 func (c *Client) GetTelematicData(ctx context.Context, vin, containerID string) (*cardataapi.ExVeTelematicDataResponseDto, error) {
+	vin, err := c.normalizeVIN(vin)
+	if err != nil {
+		return nil, err
+	}
 	resp, err := c.carDataAPI.GetTelematicData(ctx, vin, &cardataapi.GetTelematicDataParams{XVersion: "v1", ContainerId: containerID})
 	if err != nil {
 		return nil, err
@@ -209,7 +390,7 @@ func (c *Client) GetTelematicData(ctx context.Context, vin, containerID string)
 		}
 		return &data, nil
 	default:
-		data := cardataapi.CarDataError{}
+		data := cardataapi.CarDataError{StatusCode: resp.StatusCode}
 		err := json.NewDecoder(resp.Body).Decode(&data)
 		if err != nil {
 			return nil, err
@@ -217,3 +398,52 @@ func (c *Client) GetTelematicData(ctx context.Context, vin, containerID string)
 		return nil, &data
 	}
 }
+
+// DescribedTelematicData is a telematic data entry enriched with the metadata of its descriptor.
+// Name, Category and Description are empty when the descriptor is not part of the catalogue.
+type DescribedTelematicData struct {
+	ID          string
+	Name        string
+	Description string
+	Category    string
+	// Unit is the unit returned by BMW, or the descriptor unit when not returned.
+	Unit      string
+	Timestamp *string
+	Value     *string
+}
+
+// DescribeTelematicData enriches the telematic data with the descriptor metadata from the catalogue.
+// The result is keyed by descriptor ID. Entries for unknown descriptors are kept with empty metadata.
+func DescribeTelematicData(data *cardataapi.ExVeTelematicDataResponseDto) map[string]DescribedTelematicData {
+	described := map[string]DescribedTelematicData{}
+	if data == nil || data.TelematicData == nil {
+		return described
+	}
+	for id, entry := range *data.TelematicData {
+		descriptor, _ := DescriptorByID(id)
+		value := DescribedTelematicData{
+			ID:          id,
+			Name:        descriptor.Name,
+			Description: descriptor.Description,
+			Category:    descriptor.Category,
+			Unit:        descriptor.Unit,
+			Timestamp:   entry.Timestamp,
+			Value:       entry.Value,
+		}
+		if entry.Unit != nil && *entry.Unit != "" {
+			value.Unit = *entry.Unit
+		}
+		described[id] = value
+	}
+	return described
+}
+
+// GetDescribedTelematicData gets the telematic data for a given VIN and container ID
+// and enriches it with the descriptor metadata, see DescribeTelematicData.
+func (c *Client) GetDescribedTelematicData(ctx context.Context, vin, containerID string) (map[string]DescribedTelematicData, error) {
+	data, err := c.GetTelematicData(ctx, vin, containerID)
+	if err != nil {
+		return nil, err
+	}
+	return DescribeTelematicData(data), nil
+}
@@ -161,6 +161,70 @@ func TestGetBasicData_Success(t *testing.T) {
 	}
 }
 
+func TestGetBasicData_Fields(t *testing.T) {
+	ctx := context.Background()
+	calls := 0
+	mock := &mockCardataClient{
+		GetBasicDataFunc: func(ctx context.Context, vin string, params *cardataapi.GetBasicDataParams, _ ...cardataapi.RequestEditorFn) (*http.Response, error) {
+			calls++
+			return jsonResponse(http.StatusOK, cardataapi.VehicleDto{Vin: p("VIN123"), ModelName: p("X7"), NumberOfDoors: p(int32(5)), HasNavi: p(true)}, nil), nil
+		},
+	}
+	c := &Client{carDataAPI: mock}
+	data, err := c.GetBasicData(ctx, "VIN123", WithBasicDataFields("modelName", "numberOfDoors"))
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	if data.ModelName == nil || *data.ModelName != "X7" || data.NumberOfDoors == nil || *data.NumberOfDoors != 5 {
+		t.Fatalf("expected the requested fields to be set, got %#v", data)
+	}
+	if data.Vin != nil || data.HasNavi != nil {
+		t.Fatalf("expected the other fields to be cleared, got %#v", data)
+	}
+
+	_, err = c.GetBasicData(ctx, "VIN123", WithBasicDataFields("modelname"))
+	if err == nil {
+		t.Fatal("expected an error for unknown fields")
+	}
+	if calls != 1 {
+		t.Fatalf("expected unknown fields to be rejected before calling BMW, got %d calls", calls)
+	}
+}
+
+func TestGetBasicData_ETag(t *testing.T) {
+	ctx := context.Background()
+	mock := &mockCardataClient{
+		GetBasicDataFunc: func(ctx context.Context, vin string, params *cardataapi.GetBasicDataParams, reqEditors ...cardataapi.RequestEditorFn) (*http.Response, error) {
+			req, _ := http.NewRequestWithContext(ctx, http.MethodGet, "https://example.com", nil)
+			for _, editor := range reqEditors {
+				if err := editor(ctx, req); err != nil {
+					t.Fatalf("unexpected err: %v", err)
+				}
+			}
+			if req.Header.Get("If-None-Match") == `"v1"` {
+				return bytesResponse(http.StatusNotModified, nil, nil), nil
+			}
+			return jsonResponse(http.StatusOK, cardataapi.VehicleDto{Vin: p("VIN")}, map[string]string{"ETag": `"v1"`}), nil
+		},
+	}
+	c := &Client{carDataAPI: mock}
+	etag := ""
+	vehicle, err := c.GetBasicData(ctx, "VIN", WithBasicDataETag(&etag))
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	if vehicle == nil || etag != `"v1"` {
+		t.Fatalf("expected the vehicle and its ETag, got %#v and %q", vehicle, etag)
+	}
+	_, err = c.GetBasicData(ctx, "VIN", WithBasicDataETag(&etag))
+	if !errors.Is(err, ErrNotModified) {
+		t.Fatalf("expected ErrNotModified, got %v", err)
+	}
+	if _, err := c.GetBasicData(ctx, "VIN"); err != nil {
+		t.Fatalf("requests without ETag must not be conditional, got %v", err)
+	}
+}
+
 func TestGetBasicData_Error(t *testing.T) {
 	ctx := context.Background()
 	mock := &mockCardataClient{
@@ -314,6 +378,42 @@ func TestGetImage_Success(t *testing.T) {
 	}
 }
 
+func TestGetImage_UnexpectedContentType(t *testing.T) {
+	ctx := context.Background()
+	png := []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+	responses := []*http.Response{
+		bytesResponse(http.StatusOK, []byte("<html><body>Service unavailable</body></html>"), map[string]string{"Content-Type": "text/html"}),
+		bytesResponse(http.StatusOK, png, map[string]string{"Content-Type": "application/octet-stream"}),
+		bytesResponse(http.StatusOK, []byte("<html></html>"), map[string]string{"Content-Type": "text/html"}),
+	}
+	mock := &mockCardataClient{
+		GetImageFunc: func(ctx context.Context, vin string, params *cardataapi.GetImageParams, _ ...cardataapi.RequestEditorFn) (*http.Response, error) {
+			resp := responses[0]
+			responses = responses[1:]
+			return resp, nil
+		},
+	}
+	c := &Client{carDataAPI: mock}
+	_, err := c.GetImage(ctx, "VIN")
+	if !errors.Is(err, ErrUnexpectedImageContentType) {
+		t.Fatalf("expected ErrUnexpectedImageContentType for HTML pages, got %v", err)
+	}
+	img, err := c.GetImage(ctx, "VIN")
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	if img.ContentType != "image/png" {
+		t.Fatalf("expected the sniffed content type, got %q", img.ContentType)
+	}
+	img, err = c.GetImage(ctx, "VIN", WithLenientImageContentType())
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	if img.ContentType != "text/html" || string(img.Data) != "<html></html>" {
+		t.Fatalf("unexpected image: %#v", img)
+	}
+}
+
 func TestGetImage_ErrorCarData(t *testing.T) {
 	ctx := context.Background()
 	mock := &mockCardataClient{
@@ -463,6 +563,34 @@ func TestGetTelematicData_Error(t *testing.T) {
 	}
 }
 
+func TestGetDescribedTelematicData(t *testing.T) {
+	ctx := context.Background()
+	mock := &mockCardataClient{
+		GetTelematicDataFunc: func(ctx context.Context, vin string, params *cardataapi.GetTelematicDataParams, _ ...cardataapi.RequestEditorFn) (*http.Response, error) {
+			return jsonResponse(http.StatusOK, cardataapi.ExVeTelematicDataResponseDto{TelematicData: &map[string]cardataapi.TelematicDataEntryDto{
+				"vehicle.body.chargingPort.combinedStatus": {Value: p("CONNECTED")},
+				"vehicle.unknown":                          {Value: p("42"), Unit: p("km")},
+			}}, nil), nil
+		},
+	}
+	c := &Client{carDataAPI: mock}
+	described, err := c.GetDescribedTelematicData(ctx, "VIN", "CID")
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	known := described["vehicle.body.chargingPort.combinedStatus"]
+	if known.Name != "Charging port connection status" || known.Category != "BEV_PHEV_DATA" || *known.Value != "CONNECTED" {
+		t.Fatalf("unexpected described data %#v", known)
+	}
+	unknown, ok := described["vehicle.unknown"]
+	if !ok {
+		t.Fatal("expected unknown descriptors to be kept")
+	}
+	if unknown.Name != "" || unknown.Unit != "km" || *unknown.Value != "42" {
+		t.Fatalf("unexpected described data %#v", unknown)
+	}
+}
+
 func TestListContainers_Success(t *testing.T) {
 	ctx := context.Background()
 	mock := &mockCardataClient{
@@ -603,7 +731,7 @@ func TestDeleteContainer_Success(t *testing.T) {
 			if containerId != "CID" {
 				t.Fatalf("expected containerId CID, got %s", containerId)
 			}
-			return jsonResponse(http.StatusOK, cardataapi.DeleteContainerResponse{}, nil), nil
+			return jsonResponse(http.StatusNoContent, cardataapi.DeleteContainerResponse{}, nil), nil
 		},
 	}
 	c := &Client{carDataAPI: mock}
@@ -654,13 +782,12 @@ func TestUnderlyingClientErrorPropagation(t *testing.T) {
 
 func ExampleClient_GetBasicData() {
 	client := Must(NewClient(
+		WithHTTPTransport(fakeServerTransport),
 		WithAuthenticator(
 			Must(NewAuthenticator(
 				WithClientID(clientID),
-				WithPromptURI(func(verificationURI, userCode, verificationURIComplete string) {
-					fmt.Printf("Open %s and enter code %s\n", verificationURI, userCode)
-					fmt.Printf("Direct link: %s\n", verificationURIComplete)
-				}),
+				WithPromptURI(PromptStdout),
+				WithAuthClientOptions(WithAuthHTTPTransport(fakeServerTransport)),
 			)),
 		)),
 	)
@@ -679,13 +806,12 @@ func ExampleClient_GetBasicData() {
 
 func ExampleClient_GetMappings() {
 	client := Must(NewClient(
+		WithHTTPTransport(fakeServerTransport),
 		WithAuthenticator(
 			Must(NewAuthenticator(
 				WithClientID(clientID),
-				WithPromptURI(func(verificationURI, userCode, verificationURIComplete string) {
-					fmt.Printf("Open %s and enter code %s\n", verificationURI, userCode)
-					fmt.Printf("Direct link: %s\n", verificationURIComplete)
-				}),
+				WithPromptURI(PromptStdout),
+				WithAuthClientOptions(WithAuthHTTPTransport(fakeServerTransport)),
 			)),
 		)),
 	)
@@ -703,13 +829,12 @@ func ExampleClient_GetMappings() {
 
 func ExampleClient_GetChargingHistory() {
 	client := Must(NewClient(
+		WithHTTPTransport(fakeServerTransport),
 		WithAuthenticator(
 			Must(NewAuthenticator(
 				WithClientID(clientID),
-				WithPromptURI(func(verificationURI, userCode, verificationURIComplete string) {
-					fmt.Printf("Open %s and enter code %s\n", verificationURI, userCode)
-					fmt.Printf("Direct link: %s\n", verificationURIComplete)
-				}),
+				WithPromptURI(PromptStdout),
+				WithAuthClientOptions(WithAuthHTTPTransport(fakeServerTransport)),
 			)),
 		)),
 	)
@@ -9,6 +9,9 @@ type CarDataError struct {
 	ExveErrorMsg *string `json:"exveErrorMsg,omitempty"`
 	ExveErrorRef *string `json:"exveErrorRef,omitempty"`
 	ExveNote     *string `json:"exveNote,omitempty"`
+
+	// StatusCode is the HTTP status of the response the error was decoded from.
+	StatusCode int `json:"-"`
 }
 
 func (e *CarDataError) Error() string {
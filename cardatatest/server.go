@@ -0,0 +1,512 @@
+// Package cardatatest provides a fake BMW CarData server to integration-test
+// code built on top of github.com/tjamet/bmw-cardata.
+//
+// The server implements the device code authentication flow as well as the CarData
+// API endpoints. It serves canned responses that can be customised through the exported
+// fields of Server, and allows injecting errors and latency to exercise failure paths.
+//
+//	server := cardatatest.NewServer()
+//	defer server.Close()
+//	authClient := bmwcardata.Must(bmwcardata.NewAuthClient(bmwcardata.WithAuthServer(server.URL)))
+//	client := bmwcardata.Must(bmwcardata.NewClient(bmwcardata.WithCarDataServer(server.URL), ...))
+package cardatatest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/tjamet/bmw-cardata/auth"
+	"github.com/tjamet/bmw-cardata/cardataapi"
+)
+
+const (
+	// DefaultVIN is the VIN of the primary vehicle served by default.
+	DefaultVIN = "WBA00000000000000"
+	// SecondaryVIN is the VIN of the secondary vehicle served by default.
+	SecondaryVIN = "WBA00000000000001"
+)
+
+// Operation identifies an endpoint of the fake server.
+type Operation string
+
+const (
+	OperationDeviceCode                       Operation = "deviceCode"
+	OperationToken                            Operation = "token"
+	OperationListContainers                   Operation = "listContainers"
+	OperationCreateContainer                  Operation = "createContainer"
+	OperationDeleteContainer                  Operation = "deleteContainer"
+	OperationGetContainerDetails              Operation = "getContainerDetails"
+	OperationGetMappings                      Operation = "getMappings"
+	OperationGetBasicData                     Operation = "getBasicData"
+	OperationGetChargingHistory               Operation = "getChargingHistory"
+	OperationGetImage                         Operation = "getImage"
+	OperationGetLocationBasedChargingSettings Operation = "getLocationBasedChargingSettings"
+	OperationGetSmartMaintenanceTyreDiagnosis Operation = "getSmartMaintenanceTyreDiagnosis"
+	OperationGetTelematicData                 Operation = "getTelematicData"
+)
+
+// Image is a canned vehicle image.
+type Image struct {
+	Data        []byte
+	ContentType string
+}
+
+type failure struct {
+	statusCode int
+	body       any
+}
+
+type grant struct {
+	scope string
+}
+
+// Server is a fake BMW CarData server, serving both the authentication
+// and the CarData API endpoints.
+//
+// Exported fields can be modified to customise the responses.
+// They must not be modified concurrently with requests being served.
+type Server struct {
+	*httptest.Server
+
+	// ClientID, when set, is the only client ID accepted by the authentication endpoints.
+	ClientID string
+	// UserCode, VerificationURI and VerificationURIComplete are returned when initiating
+	// the device code flow.
+	UserCode                string
+	VerificationURI         string
+	VerificationURIComplete string
+	// Interval is the polling interval, in seconds, returned when initiating the device code flow.
+	Interval int
+	// ExpiresIn is the device code validity, in seconds.
+	ExpiresIn int
+	// PendingPolls is the number of token polls answered with authorization_pending
+	// before the token is issued, simulating the user taking time to log in.
+	PendingPolls int
+	// Gcid is the user account identifier returned with the tokens.
+	Gcid string
+	// TokenExpiresIn is the access token validity, in seconds.
+	TokenExpiresIn int
+	// RejectRefresh makes every refresh token grant fail with invalid_grant.
+	RejectRefresh bool
+
+	Mappings                      []cardataapi.VehicleMappingDto
+	BasicData                     map[string]cardataapi.VehicleDto
+	ChargingHistory               map[string]cardataapi.ChargingHistoryResponseDto
+	Images                        map[string]Image
+	LocationBasedChargingSettings map[string]cardataapi.LocationBasedChargingSettingsDto
+	TyreDiagnosis                 map[string]cardataapi.SmartMaintenanceTyreDiagnosisDto
+	// TelematicData is keyed by VIN, the container ID is ignored.
+	TelematicData map[string]cardataapi.ExVeTelematicDataResponseDto
+	Containers    map[string]cardataapi.ContainerDetailsDto
+	// NewContainerID generates the ID of the containers created through the API.
+	NewContainerID func() string
+
+	m             sync.Mutex
+	latency       time.Duration
+	failures      map[Operation]failure
+	calls         map[Operation]int
+	deviceCodes   map[string]grant
+	polls         map[string]int
+	accessTokens  map[string]bool
+	refreshTokens map[string]grant
+	counter       int
+}
+
+// NewServer starts a new fake server with canned data for DefaultVIN and SecondaryVIN.
+// The caller must call Close when done.
+func NewServer() *Server {
+	brand := cardataapi.BMW
+	primary := cardataapi.PRIMARY
+	secondary := cardataapi.SECONDARY
+	energy := 15.4
+	s := &Server{
+		UserCode:                "123456",
+		VerificationURI:         "https://example.com",
+		VerificationURIComplete: "https://example.com?code=123456",
+		Interval:                1,
+		ExpiresIn:               600,
+		Gcid:                    uuid.New().String(),
+		TokenExpiresIn:          3600,
+
+		Mappings: []cardataapi.VehicleMappingDto{
+			{Vin: p(DefaultVIN), MappingType: &primary},
+			{Vin: p(SecondaryVIN), MappingType: &secondary},
+		},
+		BasicData: map[string]cardataapi.VehicleDto{
+			DefaultVIN:   {Vin: p(DefaultVIN), Brand: &brand, ModelName: p("X7"), DriveTrain: p("BEV")},
+			SecondaryVIN: {Vin: p(SecondaryVIN), Brand: &brand, ModelName: p("i4"), DriveTrain: p("BEV")},
+		},
+		ChargingHistory: map[string]cardataapi.ChargingHistoryResponseDto{
+			DefaultVIN: {Data: []cardataapi.ChargingSessionDto{
+				{
+					StartTime:                      1735725600,
+					EndTime:                        1735736400,
+					DisplayedStartSoc:              20,
+					DisplayedSoc:                   80,
+					EnergyConsumedFromPowerGridKwh: &energy,
+					TimeZone:                       "Europe/Berlin",
+					MileageUnits:                   cardataapi.MileageUnitsKM,
+					TotalChargingDurationSec:       10800,
+				},
+			}},
+		},
+		Images: map[string]Image{
+			DefaultVIN: {Data: []byte("\x89PNG\r\n\x1a\n"), ContentType: "image/png"},
+		},
+		LocationBasedChargingSettings: map[string]cardataapi.LocationBasedChargingSettingsDto{},
+		TyreDiagnosis:                 map[string]cardataapi.SmartMaintenanceTyreDiagnosisDto{},
+		TelematicData:                 map[string]cardataapi.ExVeTelematicDataResponseDto{},
+		Containers:                    map[string]cardataapi.ContainerDetailsDto{},
+		NewContainerID:                func() string { return uuid.New().String() },
+
+		failures:      map[Operation]failure{},
+		calls:         map[Operation]int{},
+		deviceCodes:   map[string]grant{},
+		polls:         map[string]int{},
+		accessTokens:  map[string]bool{},
+		refreshTokens: map[string]grant{},
+	}
+	s.Server = httptest.NewServer(s.handler())
+	return s
+}
+
+// SetLatency delays every response by the given duration.
+// The delay is interrupted when the request is cancelled.
+func (s *Server) SetLatency(latency time.Duration) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.latency = latency
+}
+
+// SetError makes every subsequent call to the operation fail with the given status code.
+// When body is nil, an error payload matching the operation is generated.
+func (s *Server) SetError(operation Operation, statusCode int, body any) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.failures[operation] = failure{statusCode: statusCode, body: body}
+}
+
+// ClearErrors removes all the errors injected with SetError.
+func (s *Server) ClearErrors() {
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.failures = map[Operation]failure{}
+}
+
+// Calls returns the number of requests received for the given operation.
+func (s *Server) Calls(operation Operation) int {
+	s.m.Lock()
+	defer s.m.Unlock()
+	return s.calls[operation]
+}
+
+// Transport returns an http.RoundTripper routing the requests targeting the default BMW
+// authentication and CarData servers to the fake server.
+// This allows testing code that does not allow to override the server URLs,
+// for example by passing it to bmwcardata.WithHTTPTransport and bmwcardata.WithAuthHTTPTransport.
+// Other requests are sent through base, or http.DefaultTransport when base is nil.
+func (s *Server) Transport(base http.RoundTripper) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	target, _ := url.Parse(s.URL)
+	hosts := map[string]bool{}
+	for _, server := range []string{auth.AuthServer, cardataapi.CarDataAPIServer} {
+		u, _ := url.Parse(server)
+		hosts[u.Host] = true
+	}
+	return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		if !hosts[req.URL.Host] {
+			return base.RoundTrip(req)
+		}
+		req = req.Clone(req.Context())
+		req.URL.Scheme = target.Scheme
+		req.URL.Host = target.Host
+		req.Host = target.Host
+		return s.Client().Transport.RoundTrip(req)
+	})
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func (s *Server) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /gcdm/oauth/device/code", s.handle(OperationDeviceCode, false, s.deviceCode))
+	mux.HandleFunc("POST /gcdm/oauth/token", s.handle(OperationToken, false, s.token))
+	mux.HandleFunc("GET /customers/containers", s.handle(OperationListContainers, true, s.listContainers))
+	mux.HandleFunc("POST /customers/containers", s.handle(OperationCreateContainer, true, s.createContainer))
+	mux.HandleFunc("DELETE /customers/containers/{containerId}", s.handle(OperationDeleteContainer, true, s.deleteContainer))
+	mux.HandleFunc("GET /customers/containers/{containerId}", s.handle(OperationGetContainerDetails, true, s.getContainerDetails))
+	mux.HandleFunc("GET /customers/vehicles/mappings", s.handle(OperationGetMappings, true, s.getMappings))
+	mux.HandleFunc("GET /customers/vehicles/{vin}/basicData", s.handle(OperationGetBasicData, true, byVIN(&s.m, func() map[string]cardataapi.VehicleDto { return s.BasicData })))
+	mux.HandleFunc("GET /customers/vehicles/{vin}/chargingHistory", s.handle(OperationGetChargingHistory, true, byVIN(&s.m, func() map[string]cardataapi.ChargingHistoryResponseDto { return s.ChargingHistory })))
+	mux.HandleFunc("GET /customers/vehicles/{vin}/image", s.handle(OperationGetImage, true, s.getImage))
+	mux.HandleFunc("GET /customers/vehicles/{vin}/locationBasedChargingSettings", s.handle(OperationGetLocationBasedChargingSettings, true, byVIN(&s.m, func() map[string]cardataapi.LocationBasedChargingSettingsDto { return s.LocationBasedChargingSettings })))
+	mux.HandleFunc("GET /customers/vehicles/{vin}/smartMaintenanceTyreDiagnosis", s.handle(OperationGetSmartMaintenanceTyreDiagnosis, true, byVIN(&s.m, func() map[string]cardataapi.SmartMaintenanceTyreDiagnosisDto { return s.TyreDiagnosis })))
+	mux.HandleFunc("GET /customers/vehicles/{vin}/telematicData", s.handle(OperationGetTelematicData, true, byVIN(&s.m, func() map[string]cardataapi.ExVeTelematicDataResponseDto { return s.TelematicData })))
+	return mux
+}
+
+func (s *Server) handle(operation Operation, authenticated bool, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s.m.Lock()
+		s.calls[operation]++
+		latency := s.latency
+		f, failing := s.failures[operation]
+		s.m.Unlock()
+
+		if latency > 0 {
+			select {
+			case <-time.After(latency):
+			case <-r.Context().Done():
+				return
+			}
+		}
+		if failing {
+			body := f.body
+			if body == nil {
+				if authenticated {
+					body = carDataError(fmt.Sprintf("injected error on %s", operation))
+				} else {
+					body = auth.AuthError{Err: "server_error", Description: fmt.Sprintf("injected error on %s", operation)}
+				}
+			}
+			writeJSON(w, f.statusCode, body)
+			return
+		}
+		if authenticated && !s.isAuthorized(r) {
+			writeJSON(w, http.StatusUnauthorized, carDataError("invalid or missing access token"))
+			return
+		}
+		handler(w, r)
+	}
+}
+
+func (s *Server) isAuthorized(r *http.Request) bool {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		return false
+	}
+	s.m.Lock()
+	defer s.m.Unlock()
+	return s.accessTokens[token]
+}
+
+func (s *Server) checkClientID(w http.ResponseWriter, r *http.Request) bool {
+	if s.ClientID != "" && !strings.EqualFold(r.PostForm.Get("client_id"), s.ClientID) {
+		writeJSON(w, http.StatusUnauthorized, auth.AuthError{Err: "invalid_client", Description: "unknown client_id"})
+		return false
+	}
+	return true
+}
+
+func (s *Server) deviceCode(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		writeJSON(w, http.StatusBadRequest, auth.AuthError{Err: "invalid_request", Description: err.Error()})
+		return
+	}
+	if !s.checkClientID(w, r) {
+		return
+	}
+	if r.PostForm.Get("code_challenge") == "" {
+		writeJSON(w, http.StatusBadRequest, auth.AuthError{Err: "invalid_request", Description: "code_challenge is required"})
+		return
+	}
+	s.m.Lock()
+	s.counter++
+	deviceCode := fmt.Sprintf("device-code-%d", s.counter)
+	s.deviceCodes[deviceCode] = grant{scope: r.PostForm.Get("scope")}
+	interval := s.Interval
+	s.m.Unlock()
+	writeJSON(w, http.StatusOK, auth.DeviceCodeResponse{
+		DeviceCode:              deviceCode,
+		ExpiresIn:               s.ExpiresIn,
+		Interval:                &interval,
+		UserCode:                s.UserCode,
+		VerificationUri:         s.VerificationURI,
+		VerificationUriComplete: s.VerificationURIComplete,
+	})
+}
+
+func (s *Server) token(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		writeJSON(w, http.StatusBadRequest, auth.AuthError{Err: "invalid_request", Description: err.Error()})
+		return
+	}
+	if !s.checkClientID(w, r) {
+		return
+	}
+	s.m.Lock()
+	defer s.m.Unlock()
+	switch r.PostForm.Get("grant_type") {
+	case auth.DeviceCodeGrantType:
+		deviceCode := r.PostForm.Get("device_code")
+		g, ok := s.deviceCodes[deviceCode]
+		if !ok {
+			writeJSON(w, http.StatusBadRequest, auth.AuthError{Err: "invalid_grant", Description: "unknown device_code"})
+			return
+		}
+		if s.polls[deviceCode] < s.PendingPolls {
+			s.polls[deviceCode]++
+			writeJSON(w, http.StatusForbidden, auth.AuthError{Err: "authorization_pending", Description: "the user has not yet completed the authentication"})
+			return
+		}
+		delete(s.deviceCodes, deviceCode)
+		delete(s.polls, deviceCode)
+		writeJSON(w, http.StatusOK, s.issueTokens(g))
+	case auth.RefreshTokenGrantType:
+		refreshToken := r.PostForm.Get("refresh_token")
+		g, ok := s.refreshTokens[refreshToken]
+		if !ok || s.RejectRefresh {
+			writeJSON(w, http.StatusBadRequest, auth.AuthError{Err: "invalid_grant", Description: "invalid refresh_token"})
+			return
+		}
+		delete(s.refreshTokens, refreshToken)
+		writeJSON(w, http.StatusOK, s.issueTokens(g))
+	default:
+		writeJSON(w, http.StatusBadRequest, auth.AuthError{Err: "unsupported_grant_type", Description: "unsupported grant_type"})
+	}
+}
+
+// issueTokens must be called with s.m held.
+func (s *Server) issueTokens(g grant) auth.TokenResponse {
+	s.counter++
+	response := auth.TokenResponse{
+		AccessToken:  fmt.Sprintf("access-token-%d", s.counter),
+		RefreshToken: fmt.Sprintf("refresh-token-%d", s.counter),
+		ExpiresIn:    s.TokenExpiresIn,
+		Gcid:         s.Gcid,
+		Scope:        g.scope,
+		TokenType:    "Bearer",
+	}
+	for _, scope := range strings.Fields(g.scope) {
+		if scope == "openid" {
+			response.IdToken = p(fmt.Sprintf("id-token-%d", s.counter))
+		}
+	}
+	s.accessTokens[response.AccessToken] = true
+	s.refreshTokens[response.RefreshToken] = g
+	return response
+}
+
+func (s *Server) getMappings(w http.ResponseWriter, r *http.Request) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	writeJSON(w, http.StatusOK, s.Mappings)
+}
+
+func (s *Server) getImage(w http.ResponseWriter, r *http.Request) {
+	s.m.Lock()
+	image, ok := s.Images[r.PathValue("vin")]
+	s.m.Unlock()
+	if !ok {
+		writeJSON(w, http.StatusNotFound, carDataError("vehicle not found"))
+		return
+	}
+	w.Header().Set("Content-Type", image.ContentType)
+	w.WriteHeader(http.StatusOK)
+	w.Write(image.Data)
+}
+
+func (s *Server) listContainers(w http.ResponseWriter, r *http.Request) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	containers := []cardataapi.ContainerDto{}
+	for _, container := range s.Containers {
+		state := cardataapi.ContainerDtoState("")
+		if container.State != nil {
+			state = cardataapi.ContainerDtoState(*container.State)
+		}
+		containers = append(containers, cardataapi.ContainerDto{
+			ContainerId: container.ContainerId,
+			Created:     container.Created,
+			Name:        container.Name,
+			Purpose:     container.Purpose,
+			State:       &state,
+		})
+	}
+	writeJSON(w, http.StatusOK, cardataapi.ContainerListDto{Containers: &containers})
+}
+
+func (s *Server) createContainer(w http.ResponseWriter, r *http.Request) {
+	request := cardataapi.CreateContainerRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		writeJSON(w, http.StatusBadRequest, carDataError(err.Error()))
+		return
+	}
+	s.m.Lock()
+	defer s.m.Unlock()
+	containerID := s.NewContainerID()
+	created := time.Now().UTC()
+	state := cardataapi.ContainerDetailsDtoStateACTIVE
+	s.Containers[containerID] = cardataapi.ContainerDetailsDto{
+		ContainerId:          &containerID,
+		Created:              &created,
+		Name:                 request.Name,
+		Purpose:              request.Purpose,
+		State:                &state,
+		TechnicalDescriptors: request.TechnicalDescriptors,
+	}
+	writeJSON(w, http.StatusCreated, s.Containers[containerID])
+}
+
+func (s *Server) getContainerDetails(w http.ResponseWriter, r *http.Request) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	container, ok := s.Containers[r.PathValue("containerId")]
+	if !ok {
+		writeJSON(w, http.StatusNotFound, carDataError("container not found"))
+		return
+	}
+	writeJSON(w, http.StatusOK, container)
+}
+
+func (s *Server) deleteContainer(w http.ResponseWriter, r *http.Request) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	containerID := r.PathValue("containerId")
+	if _, ok := s.Containers[containerID]; !ok {
+		writeJSON(w, http.StatusNotFound, carDataError("container not found"))
+		return
+	}
+	delete(s.Containers, containerID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func byVIN[T any](m *sync.Mutex, data func() map[string]T) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		m.Lock()
+		value, ok := data()[r.PathValue("vin")]
+		m.Unlock()
+		if !ok {
+			writeJSON(w, http.StatusNotFound, carDataError("vehicle not found"))
+			return
+		}
+		writeJSON(w, http.StatusOK, value)
+	}
+}
+
+func carDataError(message string) cardataapi.CarDataError {
+	return cardataapi.CarDataError{ExveErrorId: p("CU-000"), ExveErrorMsg: &message}
+}
+
+func writeJSON(w http.ResponseWriter, statusCode int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(body)
+}
+
+func p[T any](v T) *T {
+	return &v
+}
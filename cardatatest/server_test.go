@@ -0,0 +1,137 @@
+package cardatatest_test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	bmwcardata "github.com/tjamet/bmw-cardata"
+	"github.com/tjamet/bmw-cardata/cardataapi"
+	"github.com/tjamet/bmw-cardata/cardatatest"
+)
+
+func newClient(t *testing.T, server *cardatatest.Server) *bmwcardata.Client {
+	t.Helper()
+	authClient, err := bmwcardata.NewAuthClient(bmwcardata.WithAuthServer(server.URL))
+	require.NoError(t, err)
+	authenticator, err := bmwcardata.NewAuthenticator(
+		bmwcardata.WithClientID(server.ClientID),
+		bmwcardata.WithSessionStore(&bmwcardata.InMemorySessionStore{}),
+		bmwcardata.WithPromptURI(func(uri, code, complete string) {}),
+	)
+	require.NoError(t, err)
+	authenticator.AuthClient = authClient
+	client, err := bmwcardata.NewClient(
+		bmwcardata.WithCarDataServer(server.URL),
+		bmwcardata.WithAuthenticator(authenticator),
+	)
+	require.NoError(t, err)
+	return client
+}
+
+func TestServer(t *testing.T) {
+	server := cardatatest.NewServer()
+	defer server.Close()
+	server.ClientID = uuid.New().String()
+	server.PendingPolls = 1
+	client := newClient(t, server)
+	ctx := context.Background()
+
+	vehicle, err := client.GetBasicData(ctx, cardatatest.DefaultVIN)
+	require.NoError(t, err)
+	assert.Equal(t, "X7", *vehicle.ModelName)
+	assert.Equal(t, 1, server.Calls(cardatatest.OperationDeviceCode))
+	assert.Equal(t, 2, server.Calls(cardatatest.OperationToken))
+
+	mappings, err := client.GetMappings(ctx)
+	require.NoError(t, err)
+	assert.Len(t, mappings, 2)
+
+	history, err := client.GetChargingHistory(ctx, cardatatest.DefaultVIN, time.Now().Add(-time.Hour), time.Now())
+	require.NoError(t, err)
+	require.Len(t, history.Data, 1)
+	assert.Equal(t, 15.4, *history.Data[0].EnergyConsumedFromPowerGridKwh)
+
+	image, err := client.GetImage(ctx, cardatatest.DefaultVIN)
+	require.NoError(t, err)
+	assert.Equal(t, "image/png", image.ContentType)
+
+	_, err = client.GetBasicData(ctx, "WBA99999999999999")
+	carDataErr := &cardataapi.CarDataError{}
+	require.ErrorAs(t, err, &carDataErr)
+
+	containerID := "container"
+	server.Containers[containerID] = cardataapi.ContainerDetailsDto{ContainerId: &containerID}
+	containers, err := client.ListContainers(ctx)
+	require.NoError(t, err)
+	require.Len(t, *containers.Containers, 1)
+	assert.Equal(t, containerID, *(*containers.Containers)[0].ContainerId)
+	server.NewContainerID = func() string { return "created" }
+	created, err := client.CreateContainer(ctx, "name", "purpose", []bmwcardata.Descriptor{{ID: "vehicle.cabin.door.status"}})
+	require.NoError(t, err)
+	assert.Equal(t, "created", *created.JSON201.ContainerId)
+	assert.Equal(t, 1, server.Calls(cardatatest.OperationCreateContainer))
+	_, err = client.DeleteContainer(ctx, containerID)
+	require.NoError(t, err)
+	_, err = client.GetContainerDetails(ctx, containerID)
+	require.ErrorAs(t, err, &carDataErr)
+}
+
+func TestServer_InjectErrors(t *testing.T) {
+	server := cardatatest.NewServer()
+	defer server.Close()
+	server.ClientID = uuid.New().String()
+	client := newClient(t, server)
+	ctx := context.Background()
+
+	server.SetError(cardatatest.OperationGetMappings, http.StatusServiceUnavailable, nil)
+	_, err := client.GetMappings(ctx)
+	carDataErr := &cardataapi.CarDataError{}
+	require.ErrorAs(t, err, &carDataErr)
+	assert.Contains(t, err.Error(), "injected error")
+
+	server.ClearErrors()
+	_, err = client.GetMappings(ctx)
+	require.NoError(t, err)
+}
+
+func TestServer_InjectLatency(t *testing.T) {
+	server := cardatatest.NewServer()
+	defer server.Close()
+	server.ClientID = uuid.New().String()
+	client := newClient(t, server)
+
+	_, err := client.GetMappings(context.Background())
+	require.NoError(t, err)
+
+	server.SetLatency(time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = client.GetMappings(ctx)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestServer_RejectsUnknownClientID(t *testing.T) {
+	server := cardatatest.NewServer()
+	defer server.Close()
+	server.ClientID = uuid.New().String()
+	authClient, err := bmwcardata.NewAuthClient(bmwcardata.WithAuthServer(server.URL))
+	require.NoError(t, err)
+	_, err = authClient.InitiateAuthenticationSession(context.Background(), uuid.New().String(), []bmwcardata.Scope{bmwcardata.ScopeOpenID})
+	require.Error(t, err)
+}
+
+func TestServer_Transport(t *testing.T) {
+	server := cardatatest.NewServer()
+	defer server.Close()
+	httpClient := &http.Client{Transport: server.Transport(nil)}
+	resp, err := httpClient.Get(cardataapi.CarDataAPIServer + "/customers/vehicles/mappings")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	assert.Equal(t, 1, server.Calls(cardatatest.OperationGetMappings))
+}
@@ -0,0 +1,200 @@
+package bmwcardata
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Descriptor IDs of the charging related signals, as listed in the descriptor catalogue.
+const (
+	ChargingSoCDescriptorID           = "vehicle.drivetrain.batteryManagement.header"
+	ChargingTargetSoCDescriptorID     = "vehicle.powertrain.electric.battery.stateOfCharge.target"
+	ChargingStatusDescriptorID        = "vehicle.drivetrain.electricEngine.charging.status"
+	ChargingHVStatusDescriptorID      = "vehicle.drivetrain.electricEngine.charging.hvStatus"
+	ChargingPluggedDescriptorID       = "vehicle.powertrain.tractionBattery.charging.port.anyPosition.isPlugged"
+	ChargingTimeRemainingDescriptorID = "vehicle.drivetrain.electricEngine.charging.timeRemaining"
+	ChargingElectricRangeDescriptorID = "vehicle.drivetrain.electricEngine.kombiRemainingElectricRange"
+)
+
+// ChargingSignals holds the charging related signals of a streamed message.
+// Signals absent from the message, or with a value that can't be parsed, are nil.
+type ChargingSignals struct {
+	// SoCPercent is the state of charge of the high-voltage battery, in percent.
+	SoCPercent *float64
+	// TargetSoCPercent is the state of charge the battery is charged to, in percent.
+	TargetSoCPercent *float64
+	// ChargingState is the charging status, like CHARGINGACTIVE or NOCHARGING.
+	ChargingState *string
+	// HVStatus is the status of the high-voltage charging system.
+	HVStatus *string
+	// IsPlugged reports whether a charging cable is plugged in any charging port.
+	IsPlugged *bool
+	// TimeRemainingMinutes is the estimated remaining charging time, in minutes.
+	TimeRemainingMinutes *float64
+	// ElectricRange is the remaining electric range, in the unit of the vehicle (km or mi).
+	ElectricRange *float64
+}
+
+// ExtractChargingSignals extracts the charging related signals from a streamed message.
+func ExtractChargingSignals(message StreamedMessage) ChargingSignals {
+	return ChargingSignals{
+		SoCPercent:           message.Data[ChargingSoCDescriptorID].Value.float(),
+		TargetSoCPercent:     message.Data[ChargingTargetSoCDescriptorID].Value.float(),
+		ChargingState:        message.Data[ChargingStatusDescriptorID].Value.string(),
+		HVStatus:             message.Data[ChargingHVStatusDescriptorID].Value.string(),
+		IsPlugged:            message.Data[ChargingPluggedDescriptorID].Value.bool(),
+		TimeRemainingMinutes: message.Data[ChargingTimeRemainingDescriptorID].Value.float(),
+		ElectricRange:        message.Data[ChargingElectricRangeDescriptorID].Value.float(),
+	}
+}
+
+// DefaultTimeToTargetWindow is the default duration over which EstimateTimeToTarget computes the charging rate.
+const DefaultTimeToTargetWindow = 15 * time.Minute
+
+// EstimateTimeToTarget estimates when the battery reaches a target state of charge,
+// extrapolating the charging rate observed over a rolling window of successive samples.
+// It is safe for concurrent use, so that it can be fed from subscription callbacks, see Observe.
+type EstimateTimeToTarget struct {
+	// TargetPercent is the state of charge to reach, in percent.
+	TargetPercent float64
+	// Window is the duration over which the charging rate is computed, DefaultTimeToTargetWindow when 0.
+	// Gaps longer than the window between two samples are considered idle periods and restart the estimation.
+	Window time.Duration
+
+	m       sync.Mutex
+	samples []socSample
+}
+
+type socSample struct {
+	at      time.Time
+	percent float64
+}
+
+// NewEstimateTimeToTarget creates an estimator for the given target state of charge, in percent.
+func NewEstimateTimeToTarget(targetPercent float64) *EstimateTimeToTarget {
+	return &EstimateTimeToTarget{TargetPercent: targetPercent}
+}
+
+func (e *EstimateTimeToTarget) window() time.Duration {
+	if e.Window <= 0 {
+		return DefaultTimeToTargetWindow
+	}
+	return e.Window
+}
+
+// AddSample records the state of charge, in percent, at the given time.
+// Samples older than the latest one are ignored. A decreasing state of charge, or a gap longer
+// than the window, means the battery stopped charging and restarts the estimation.
+func (e *EstimateTimeToTarget) AddSample(at time.Time, percent float64) {
+	e.m.Lock()
+	defer e.m.Unlock()
+	if len(e.samples) > 0 {
+		last := e.samples[len(e.samples)-1]
+		if !at.After(last.at) {
+			return
+		}
+		if percent < last.percent || at.Sub(last.at) > e.window() {
+			e.samples = e.samples[:0]
+		}
+	}
+	e.samples = append(e.samples, socSample{at: at, percent: percent})
+	// Keep a single sample older than the window to compute the rate over the whole window.
+	start := at.Add(-e.window())
+	for len(e.samples) > 2 && !e.samples[1].at.After(start) {
+		e.samples = e.samples[1:]
+	}
+}
+
+// Observe records the state of charge of a streamed message, if any.
+// The sample time is the timestamp of the state of charge, or of the message when missing.
+func (e *EstimateTimeToTarget) Observe(message StreamedMessage) {
+	soc := ExtractChargingSignals(message).SoCPercent
+	if soc == nil {
+		return
+	}
+	timestamp := message.Data[ChargingSoCDescriptorID].Timestamp
+	if timestamp == "" {
+		timestamp = message.Timestamp
+	}
+	at, err := time.Parse(time.RFC3339, timestamp)
+	if err != nil {
+		return
+	}
+	e.AddSample(at, *soc)
+}
+
+// Estimate returns the estimated time at which the target state of charge is reached.
+// It returns false when there is not enough data: less than two samples since the battery
+// started charging, or no increase of the state of charge over the window.
+// When the target is already reached, the time of the latest sample is returned.
+func (e *EstimateTimeToTarget) Estimate() (time.Time, bool) {
+	e.m.Lock()
+	defer e.m.Unlock()
+	if len(e.samples) == 0 {
+		return time.Time{}, false
+	}
+	last := e.samples[len(e.samples)-1]
+	if last.percent >= e.TargetPercent {
+		return last.at, true
+	}
+	if len(e.samples) < 2 {
+		return time.Time{}, false
+	}
+	first := e.samples[0]
+	elapsed := last.at.Sub(first.at)
+	gained := last.percent - first.percent
+	if gained <= 0 || elapsed <= 0 {
+		return time.Time{}, false
+	}
+	remaining := time.Duration((e.TargetPercent - last.percent) / gained * float64(elapsed))
+	return last.at.Add(remaining), true
+}
+
+// float returns the numeric value, parsing it when it is streamed as a string.
+func (v StreamedDataValue) float() *float64 {
+	switch {
+	case v.Float != nil:
+		return v.Float
+	case v.Int != nil:
+		return p(float64(*v.Int))
+	case v.String != nil:
+		f, err := strconv.ParseFloat(strings.TrimSpace(*v.String), 64)
+		if err != nil {
+			return nil
+		}
+		return &f
+	}
+	return nil
+}
+
+// string returns the string value, formatting it when it is not streamed as a string.
+func (v StreamedDataValue) string() *string {
+	switch {
+	case v.String != nil:
+		return v.String
+	case v.Bool != nil:
+		return p(strconv.FormatBool(*v.Bool))
+	case v.Int != nil:
+		return p(strconv.FormatInt(*v.Int, 10))
+	case v.Float != nil:
+		return p(strconv.FormatFloat(*v.Float, 'f', -1, 64))
+	}
+	return nil
+}
+
+// bool returns the boolean value, parsing it when it is streamed as a string.
+func (v StreamedDataValue) bool() *bool {
+	switch {
+	case v.Bool != nil:
+		return v.Bool
+	case v.String != nil:
+		b, err := strconv.ParseBool(strings.TrimSpace(*v.String))
+		if err != nil {
+			return nil
+		}
+		return &b
+	}
+	return nil
+}
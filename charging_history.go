@@ -0,0 +1,191 @@
+package bmwcardata
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/tjamet/bmw-cardata/cardataapi"
+)
+
+// ChargingSessionsFromAPI converts the charging history returned by GetChargingHistory to the archive types,
+// so that the charging sessions are handled the same way regardless of their source, for example
+// with WriteChargingHistoryGeoJSON.
+func ChargingSessionsFromAPI(resp *cardataapi.ChargingHistoryResponseDto) []ChargingSessionArchive {
+	if resp == nil {
+		return nil
+	}
+	sessions := make([]ChargingSessionArchive, 0, len(resp.Data))
+	for _, dto := range resp.Data {
+		sessions = append(sessions, chargingSessionFromDto(dto))
+	}
+	return sessions
+}
+
+func chargingSessionFromDto(dto cardataapi.ChargingSessionDto) ChargingSessionArchive {
+	session := ChargingSessionArchive{
+		DisplayedSoc:                   int(dto.DisplayedSoc),
+		DisplayedStartSoc:              int(dto.DisplayedStartSoc),
+		EndTime:                        dto.EndTime,
+		EnergyConsumedFromPowerGridKwh: value(dto.EnergyConsumedFromPowerGridKwh),
+		IsPreconditioningActivated:     dto.IsPreconditioningActivated,
+		Mileage:                        int64(dto.Mileage),
+		MileageUnits:                   string(dto.MileageUnits),
+		StartTime:                      dto.StartTime,
+		TimeZone:                       dto.TimeZone,
+		TotalChargingDurationSec:       int64(dto.TotalChargingDurationSec),
+	}
+	for _, block := range value(dto.ChargingBlocks) {
+		session.ChargingBlocks = append(session.ChargingBlocks, ChargingBlock{
+			AverageChargingPowerW: value(block.AveragePowerGridKw),
+			StartTime:             epochTime(block.StartTime),
+			EndTime:               epochTime(block.EndTime),
+		})
+	}
+	if cost := dto.ChargingCostInformation; cost != nil {
+		session.ChargingCostInformation = &ChargingCostInformation{
+			CalculatedChargingCost: cost.CalculatedChargingCost,
+			CalculatedSavings:      cost.CalculatedSavings,
+			Currency:               cost.Currency,
+		}
+	}
+	if location := dto.ChargingLocation; location != nil {
+		session.ChargingLocation = &ChargingLocation{
+			FormattedAddress:    location.FormattedAddress,
+			MapMatchedLatitude:  float64(value(location.MapMatchedLatitude)),
+			MapMatchedLongitude: float64(value(location.MapMatchedLongitude)),
+			Municipality:        location.Municipality,
+			StreetAddress:       location.StreetAddress,
+		}
+	}
+	if point := dto.PublicChargingPoint; point != nil {
+		session.PublicChargingPoint = &PublicChargingPoint{}
+		for _, match := range point.PotentialChargingPointMatches {
+			session.PublicChargingPoint.PotentialChargingPointMatches = append(session.PublicChargingPoint.PotentialChargingPointMatches, PotentialChargingPointMatch{
+				City:          value(match.City),
+				PostalCode:    value(match.PostalCode),
+				ProviderName:  value(match.ProviderName),
+				StreetAddress: value(match.StreetAddress),
+			})
+		}
+	}
+	for _, businessError := range value(dto.BusinessErrors) {
+		converted := BusinessError{Hint: value(businessError.Hint)}
+		if businessError.CreationTime != nil {
+			converted.CreationTime = Time{Time: *businessError.CreationTime, parsed: true}
+		}
+		session.BusinessErrors = append(session.BusinessErrors, converted)
+	}
+	return session
+}
+
+// epochTime converts an epoch, in seconds or milliseconds, to a Time serialized the same way.
+// A zero epoch is considered unset.
+func epochTime(epoch int64) Time {
+	t := Time{}
+	if epoch <= 0 {
+		return t
+	}
+	// The archive stores epochs as JSON numbers, reuse its format detection.
+	_ = t.UnmarshalJSON([]byte(strconv.FormatInt(epoch, 10)))
+	return t
+}
+
+// StartTimeAsTime returns the start time of the charging session in its time zone, or UTC when the time zone is unknown.
+// It returns the zero time when the start time is unset.
+func (s ChargingSessionArchive) StartTimeAsTime() time.Time {
+	return s.inTimeZone(s.StartTime)
+}
+
+// EndTimeAsTime returns the end time of the charging session in its time zone, or UTC when the time zone is unknown.
+// It returns the zero time when the end time is unset.
+func (s ChargingSessionArchive) EndTimeAsTime() time.Time {
+	return s.inTimeZone(s.EndTime)
+}
+
+// Duration returns the duration of the charging session, from its start to its end time.
+// When they are unset or inconsistent, TotalChargingDurationSec is returned instead.
+// Note that TotalChargingDurationSec excludes the charging pauses, hence it is usually shorter.
+func (s ChargingSessionArchive) Duration() time.Duration {
+	total := time.Duration(s.TotalChargingDurationSec) * time.Second
+	start, end := epochTime(s.StartTime).Time, epochTime(s.EndTime).Time
+	if start.IsZero() || end.IsZero() || end.Before(start) {
+		return total
+	}
+	return end.Sub(start)
+}
+
+// inTimeZone converts the epoch to a time in the time zone of the session.
+func (s ChargingSessionArchive) inTimeZone(epoch int64) time.Time {
+	t := epochTime(epoch).Time
+	if t.IsZero() {
+		return t
+	}
+	return t.In(loadTimeZone(s.TimeZone))
+}
+
+// timeZones caches the locations of the valid charging session time zones, by name.
+// Invalid names are not cached, so that the cache is bounded by the time zone database.
+var timeZones sync.Map
+
+// loadTimeZone returns the location of the time zone, or UTC when it is empty or invalid.
+func loadTimeZone(name string) *time.Location {
+	if name == "" {
+		return time.UTC
+	}
+	if location, ok := timeZones.Load(name); ok {
+		return location.(*time.Location)
+	}
+	location, err := time.LoadLocation(name)
+	if err != nil {
+		return time.UTC
+	}
+	timeZones.Store(name, location)
+	return location
+}
+
+// ChargingHistoryIterator iterates over the charging history of a vehicle one session at a time,
+// fetching the pages with GetChargingHistory on demand, see IterateChargingHistory.
+// It holds no resources between calls, hence it does not need to be closed and can be dropped at any time.
+// It is not safe for concurrent use.
+type ChargingHistoryIterator struct {
+	client   *Client
+	vin      string
+	from, to time.Time
+
+	page      []cardataapi.ChargingSessionDto
+	nextToken *string
+	started   bool
+}
+
+// IterateChargingHistory returns an iterator over the charging sessions of the vehicle between from and to.
+// Unlike GetChargingHistory, it follows the next tokens while iterating, without loading the whole history in memory.
+func (c *Client) IterateChargingHistory(vin string, from, to time.Time) *ChargingHistoryIterator {
+	return &ChargingHistoryIterator{client: c, vin: vin, from: from, to: to}
+}
+
+// Next returns the next charging session, fetching the next page when needed.
+// It returns false once all the sessions were returned.
+// When fetching a page fails, the error is returned and calling Next again retries it.
+func (it *ChargingHistoryIterator) Next(ctx context.Context) (cardataapi.ChargingSessionDto, bool, error) {
+	for len(it.page) == 0 {
+		if it.started && (it.nextToken == nil || *it.nextToken == "") {
+			return cardataapi.ChargingSessionDto{}, false, nil
+		}
+		options := []GetChargingHistoryParamsOption{}
+		if it.nextToken != nil {
+			options = append(options, WithChargingHistoryNextToken(*it.nextToken))
+		}
+		resp, err := it.client.GetChargingHistory(ctx, it.vin, it.from, it.to, options...)
+		if err != nil {
+			return cardataapi.ChargingSessionDto{}, false, err
+		}
+		it.started = true
+		it.page = resp.Data
+		it.nextToken = resp.NextToken
+	}
+	session := it.page[0]
+	it.page = it.page[1:]
+	return session, true, nil
+}
@@ -0,0 +1,142 @@
+package bmwcardata
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tjamet/bmw-cardata/cardataapi"
+)
+
+func TestChargingSessionsFromAPI(t *testing.T) {
+	created := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	resp := &cardataapi.ChargingHistoryResponseDto{Data: []cardataapi.ChargingSessionDto{
+		{
+			DisplayedStartSoc:              20,
+			DisplayedSoc:                   80,
+			StartTime:                      1735732800,
+			EndTime:                        1735740000,
+			EnergyConsumedFromPowerGridKwh: p(45.5),
+			Mileage:                        12000,
+			MileageUnits:                   "KM",
+			TimeZone:                       "Europe/Berlin",
+			TotalChargingDurationSec:       7200,
+			ChargingBlocks: &[]cardataapi.ChargingBlockDto{
+				{StartTime: 1735732800000, EndTime: 1735740000000, AveragePowerGridKw: p(11.0)},
+			},
+			ChargingCostInformation: &cardataapi.ChargingCostInformationDto{CalculatedChargingCost: 12.5, Currency: "EUR"},
+			ChargingLocation: &cardataapi.ChargingLocationDto{
+				FormattedAddress:   "Petuelring 130, München",
+				MapMatchedLatitude: p(float32(48.5)),
+			},
+			PublicChargingPoint: &cardataapi.PublicChargingPointDto{PotentialChargingPointMatches: []cardataapi.PublicChargingPointMatchesDto{
+				{ProviderName: p("provider")},
+			}},
+			BusinessErrors: &[]cardataapi.BusinessErrorDto{{CreationTime: &created, Hint: p("hint")}},
+		},
+		{DisplayedSoc: 50},
+	}}
+
+	sessions := ChargingSessionsFromAPI(resp)
+	require.Len(t, sessions, 2)
+	session := sessions[0]
+	assert.Equal(t, 20, session.DisplayedStartSoc)
+	assert.Equal(t, 80, session.DisplayedSoc)
+	assert.Equal(t, int64(1735732800), session.StartTime)
+	assert.Equal(t, int64(1735740000), session.EndTime)
+	assert.Equal(t, 45.5, session.EnergyConsumedFromPowerGridKwh)
+	assert.Equal(t, int64(12000), session.Mileage)
+	assert.Equal(t, "KM", session.MileageUnits)
+	assert.Equal(t, int64(7200), session.TotalChargingDurationSec)
+	require.Len(t, session.ChargingBlocks, 1)
+	assert.True(t, session.ChargingBlocks[0].StartTime.Equal(time.UnixMilli(1735732800000)))
+	assert.Equal(t, 11.0, session.ChargingBlocks[0].AverageChargingPowerW)
+	require.NotNil(t, session.ChargingCostInformation)
+	assert.Equal(t, "EUR", session.ChargingCostInformation.Currency)
+	require.NotNil(t, session.ChargingLocation)
+	assert.Equal(t, 48.5, session.ChargingLocation.MapMatchedLatitude)
+	require.NotNil(t, session.PublicChargingPoint)
+	assert.Equal(t, "provider", session.PublicChargingPoint.PotentialChargingPointMatches[0].ProviderName)
+	require.Len(t, session.BusinessErrors, 1)
+	assert.True(t, session.BusinessErrors[0].CreationTime.Equal(created))
+
+	// Times must be serialized as in the archives.
+	data, err := json.Marshal(session.ChargingBlocks[0])
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"averagePowerGridKw": 11, "startTime": 1735732800000, "endTime": 1735740000000}`, string(data))
+
+	assert.Nil(t, sessions[1].ChargingLocation)
+	assert.Nil(t, sessions[1].ChargingBlocks)
+	assert.Nil(t, ChargingSessionsFromAPI(nil))
+}
+
+func TestChargingSessionArchiveTimes(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	require.NoError(t, err)
+	start := time.Date(2025, 1, 1, 20, 0, 0, 0, berlin)
+	session := ChargingSessionArchive{StartTime: start.Unix(), EndTime: start.Add(time.Hour).UnixMilli(), TimeZone: "Europe/Berlin"}
+	assert.True(t, start.Equal(session.StartTimeAsTime()))
+	assert.Equal(t, berlin, session.StartTimeAsTime().Location())
+	assert.Equal(t, 21, session.EndTimeAsTime().Hour(), "epochs in milliseconds must be supported")
+
+	session.TimeZone = "Not/AZone"
+	assert.Equal(t, time.UTC, session.StartTimeAsTime().Location())
+	assert.Equal(t, 19, session.StartTimeAsTime().Hour())
+	_, cached := timeZones.Load("Not/AZone")
+	assert.False(t, cached, "invalid time zones must not be cached")
+	assert.True(t, ChargingSessionArchive{TimeZone: "Europe/Berlin"}.EndTimeAsTime().IsZero())
+}
+
+func TestChargingSessionArchiveDuration(t *testing.T) {
+	start := time.Date(2025, 1, 1, 20, 0, 0, 0, time.UTC)
+	session := ChargingSessionArchive{StartTime: start.Unix(), EndTime: start.Add(2 * time.Hour).Unix(), TotalChargingDurationSec: 3600}
+	assert.Equal(t, 2*time.Hour, session.Duration(), "the duration must include the charging pauses")
+	assert.Equal(t, time.Hour, ChargingSessionArchive{StartTime: start.Unix(), TotalChargingDurationSec: 3600}.Duration())
+	assert.Equal(t, time.Duration(0), ChargingSessionArchive{}.Duration())
+}
+
+func TestChargingHistoryIterator(t *testing.T) {
+	pages := map[string]cardataapi.ChargingHistoryResponseDto{
+		"":       {Data: []cardataapi.ChargingSessionDto{{StartTime: 1}, {StartTime: 2}}, NextToken: p("page-2")},
+		"page-2": {Data: []cardataapi.ChargingSessionDto{}, NextToken: p("page-3")},
+		"page-3": {Data: []cardataapi.ChargingSessionDto{{StartTime: 3}}},
+	}
+	failures := 1
+	requested := []string{}
+	c := &Client{carDataAPI: &mockCardataClient{
+		GetChargingHistoryFunc: func(ctx context.Context, vin string, params *cardataapi.GetChargingHistoryParams, _ ...cardataapi.RequestEditorFn) (*http.Response, error) {
+			token := value(params.NextToken)
+			requested = append(requested, token)
+			if token == "page-3" && failures > 0 {
+				failures--
+				return jsonResponse(http.StatusServiceUnavailable, cardataapi.CarDataError{}, nil), nil
+			}
+			return jsonResponse(http.StatusOK, pages[token], nil), nil
+		},
+	}}
+
+	it := c.IterateChargingHistory("VIN123", time.Now().AddDate(0, -1, 0), time.Now())
+	startTimes := []int64{}
+	for {
+		session, ok, err := it.Next(context.Background())
+		if err != nil {
+			assert.True(t, IsRetryable(err))
+			continue
+		}
+		if !ok {
+			break
+		}
+		startTimes = append(startTimes, session.StartTime)
+	}
+	assert.Equal(t, []int64{1, 2, 3}, startTimes)
+	assert.Equal(t, []string{"", "page-2", "page-3", "page-3"}, requested, "pages must be fetched on demand, failed ones retried")
+
+	_, ok, err := it.Next(context.Background())
+	require.NoError(t, err)
+	assert.False(t, ok, "an exhausted iterator must not fetch again")
+	assert.Len(t, requested, 4)
+}
@@ -0,0 +1,56 @@
+package bmwcardata
+
+import (
+	"strings"
+	"time"
+)
+
+// ChargingSummary aggregates the charging sessions of a period, see SummarizeChargingSessions.
+type ChargingSummary struct {
+	// Sessions is the number of charging sessions started within the period.
+	Sessions int
+	// EnergyConsumedFromPowerGridKwh is the total energy taken from the power grid, in kWh.
+	EnergyConsumedFromPowerGridKwh float64
+	// CostByCurrency is the total calculated charging cost, per currency code.
+	// Sessions without cost information are not accounted for.
+	CostByCurrency map[string]float64
+	// AverageSoCGain is the average state of charge gained per session, in percent,
+	// over the sessions reporting their final state of charge.
+	AverageSoCGain float64
+}
+
+// SummarizeChargingSessions computes the energy and cost totals of the charging sessions started
+// within [from, to), whether they come from an archive or from ChargingSessionsFromAPI.
+// Sessions are attributed to the period as a whole by their start time, even when they end after to,
+// so that consecutive periods never count a session twice. A zero from or to leaves the period open.
+// Sessions with no start time are ignored, and sessions with no energy reported count as 0 kWh.
+func SummarizeChargingSessions(sessions []ChargingSessionArchive, from, to time.Time) ChargingSummary {
+	summary := ChargingSummary{CostByCurrency: map[string]float64{}}
+	socSessions, socGain := 0, 0
+	for _, session := range sessions {
+		start := epochTime(session.StartTime)
+		if start.IsZero() {
+			continue
+		}
+		if !from.IsZero() && start.Before(from) {
+			continue
+		}
+		if !to.IsZero() && !start.Before(to) {
+			continue
+		}
+		summary.Sessions++
+		summary.EnergyConsumedFromPowerGridKwh += session.EnergyConsumedFromPowerGridKwh
+		if cost := session.ChargingCostInformation; cost != nil {
+			currency := strings.ToUpper(strings.TrimSpace(cost.Currency))
+			summary.CostByCurrency[currency] += cost.CalculatedChargingCost
+		}
+		if session.DisplayedSoc > 0 {
+			socSessions++
+			socGain += session.DisplayedSoc - session.DisplayedStartSoc
+		}
+	}
+	if socSessions > 0 {
+		summary.AverageSoCGain = float64(socGain) / float64(socSessions)
+	}
+	return summary
+}
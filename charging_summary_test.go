@@ -0,0 +1,38 @@
+package bmwcardata
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSummarizeChargingSessions(t *testing.T) {
+	from := time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC)
+	to := from.AddDate(0, 1, 0)
+	sessions := []ChargingSessionArchive{
+		// Started before the period, ending within it.
+		{StartTime: from.Add(-time.Hour).Unix(), EndTime: from.Add(time.Hour).Unix(), EnergyConsumedFromPowerGridKwh: 100},
+		{StartTime: from.Unix(), EnergyConsumedFromPowerGridKwh: 10.5, DisplayedStartSoc: 20, DisplayedSoc: 80,
+			ChargingCostInformation: &ChargingCostInformation{CalculatedChargingCost: 3, Currency: "EUR"}},
+		// Epochs in milliseconds, ending after the period.
+		{StartTime: to.Add(-time.Hour).UnixMilli(), EndTime: to.Add(time.Hour).UnixMilli(), EnergyConsumedFromPowerGridKwh: 4.5, DisplayedStartSoc: 50, DisplayedSoc: 70,
+			ChargingCostInformation: &ChargingCostInformation{CalculatedChargingCost: 1.5, Currency: "eur"}},
+		// No energy nor final state of charge reported.
+		{StartTime: from.AddDate(0, 0, 10).Unix(), ChargingCostInformation: &ChargingCostInformation{CalculatedChargingCost: 2, Currency: "GBP"}},
+		{StartTime: to.Unix(), EnergyConsumedFromPowerGridKwh: 100},
+		{EnergyConsumedFromPowerGridKwh: 100},
+	}
+
+	summary := SummarizeChargingSessions(sessions, from, to)
+	assert.Equal(t, 3, summary.Sessions)
+	assert.InDelta(t, 15.0, summary.EnergyConsumedFromPowerGridKwh, 1e-9)
+	assert.Equal(t, map[string]float64{"EUR": 4.5, "GBP": 2}, summary.CostByCurrency)
+	assert.Equal(t, 40.0, summary.AverageSoCGain)
+
+	summary = SummarizeChargingSessions(sessions, time.Time{}, time.Time{})
+	assert.Equal(t, 5, summary.Sessions, "zero bounds must leave the period open")
+
+	summary = SummarizeChargingSessions(nil, from, to)
+	assert.Equal(t, ChargingSummary{CostByCurrency: map[string]float64{}}, summary)
+}
@@ -0,0 +1,120 @@
+package bmwcardata
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChargingDescriptorIDs(t *testing.T) {
+	for _, id := range []string{
+		ChargingSoCDescriptorID,
+		ChargingTargetSoCDescriptorID,
+		ChargingStatusDescriptorID,
+		ChargingHVStatusDescriptorID,
+		ChargingPluggedDescriptorID,
+		ChargingTimeRemainingDescriptorID,
+		ChargingElectricRangeDescriptorID,
+	} {
+		_, ok := DescriptorByID(id)
+		assert.True(t, ok, "%s must be part of the descriptor catalogue", id)
+	}
+}
+
+func TestExtractChargingSignals(t *testing.T) {
+	message := StreamedMessage{}
+	require.NoError(t, json.Unmarshal([]byte(`{
+		"vin": "WBA00000000000000",
+		"data": {
+			"vehicle.drivetrain.batteryManagement.header": {"value": 81.5, "unit": "percent"},
+			"vehicle.powertrain.electric.battery.stateOfCharge.target": {"value": "80"},
+			"vehicle.drivetrain.electricEngine.charging.status": {"value": "CHARGINGACTIVE"},
+			"vehicle.powertrain.tractionBattery.charging.port.anyPosition.isPlugged": {"value": true},
+			"vehicle.drivetrain.electricEngine.charging.timeRemaining": {"value": "not a number"}
+		}
+	}`), &message))
+
+	signals := ExtractChargingSignals(message)
+	require.NotNil(t, signals.SoCPercent)
+	assert.Equal(t, 81.5, *signals.SoCPercent)
+	require.NotNil(t, signals.TargetSoCPercent)
+	assert.Equal(t, 80.0, *signals.TargetSoCPercent, "numbers streamed as strings must be parsed")
+	require.NotNil(t, signals.ChargingState)
+	assert.Equal(t, "CHARGINGACTIVE", *signals.ChargingState)
+	require.NotNil(t, signals.IsPlugged)
+	assert.True(t, *signals.IsPlugged)
+	assert.Nil(t, signals.TimeRemainingMinutes, "unparsable values must be nil")
+	assert.Nil(t, signals.HVStatus, "absent signals must be nil")
+	assert.Nil(t, signals.ElectricRange)
+
+	assert.Equal(t, ChargingSignals{}, ExtractChargingSignals(StreamedMessage{}))
+}
+
+func TestEstimateTimeToTarget(t *testing.T) {
+	start := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	e := NewEstimateTimeToTarget(80)
+
+	_, ok := e.Estimate()
+	assert.False(t, ok, "no estimate without samples")
+	e.AddSample(start, 50)
+	_, ok = e.Estimate()
+	assert.False(t, ok, "no estimate with a single sample")
+
+	e.AddSample(start.Add(10*time.Minute), 60)
+	estimate, ok := e.Estimate()
+	require.True(t, ok)
+	assert.Equal(t, start.Add(30*time.Minute), estimate, "10%% in 10 minutes, 20%% remaining")
+
+	e.AddSample(start.Add(5*time.Minute), 10)
+	estimate, ok = e.Estimate()
+	require.True(t, ok)
+	assert.Equal(t, start.Add(30*time.Minute), estimate, "out of order samples must be ignored")
+
+	e.AddSample(start.Add(20*time.Minute), 55)
+	_, ok = e.Estimate()
+	assert.False(t, ok, "a decreasing state of charge must restart the estimation")
+
+	e.AddSample(start.Add(2*time.Hour), 56)
+	_, ok = e.Estimate()
+	assert.False(t, ok, "idle periods must restart the estimation")
+
+	e.AddSample(start.Add(2*time.Hour+10*time.Minute), 56)
+	_, ok = e.Estimate()
+	assert.False(t, ok, "no estimate when the state of charge does not increase")
+
+	e.AddSample(start.Add(2*time.Hour+20*time.Minute), 81)
+	estimate, ok = e.Estimate()
+	require.True(t, ok)
+	assert.Equal(t, start.Add(2*time.Hour+20*time.Minute), estimate, "reached targets must be reported right away")
+}
+
+func TestEstimateTimeToTarget_RollingWindow(t *testing.T) {
+	start := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	e := &EstimateTimeToTarget{TargetPercent: 100, Window: 10 * time.Minute}
+	// Fast charging first, then slower charging over the last 10 minutes.
+	e.AddSample(start, 10)
+	e.AddSample(start.Add(5*time.Minute), 30)
+	e.AddSample(start.Add(10*time.Minute), 50)
+	e.AddSample(start.Add(15*time.Minute), 55)
+	e.AddSample(start.Add(20*time.Minute), 60)
+	estimate, ok := e.Estimate()
+	require.True(t, ok)
+	assert.Equal(t, start.Add(60*time.Minute), estimate, "the rate must only be computed over the window")
+}
+
+func TestEstimateTimeToTarget_Observe(t *testing.T) {
+	e := NewEstimateTimeToTarget(80)
+	e.Observe(StreamedMessage{Timestamp: "2025-01-01T12:00:00Z", Data: map[string]StreamedDataDetails{
+		ChargingSoCDescriptorID: {Value: StreamedDataValue{Float: p(50.0)}},
+	}})
+	e.Observe(StreamedMessage{Data: map[string]StreamedDataDetails{
+		ChargingSoCDescriptorID: {Timestamp: "2025-01-01T12:10:00Z", Value: StreamedDataValue{Float: p(60.0)}},
+	}})
+	e.Observe(StreamedMessage{Timestamp: "2025-01-01T12:12:00Z"})
+	estimate, ok := e.Estimate()
+	require.True(t, ok)
+	assert.Equal(t, time.Date(2025, 1, 1, 12, 30, 0, 0, time.UTC), estimate)
+}
@@ -0,0 +1,156 @@
+package bmwcardata
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/tjamet/bmw-cardata/cardataapi"
+)
+
+// ErrCircuitOpen is returned by the CarData API calls short-circuited by the circuit breaker, see WithCircuitBreaker.
+var ErrCircuitOpen = errors.New("the CarData API circuit breaker is open, too many requests failed")
+
+// CircuitState is the state of the circuit breaker of a client, see WithCircuitBreaker.
+type CircuitState string
+
+const (
+	// CircuitClosed lets all the requests through.
+	CircuitClosed CircuitState = "closed"
+	// CircuitOpen fails all the requests with ErrCircuitOpen until the cooldown elapses.
+	CircuitOpen CircuitState = "open"
+	// CircuitHalfOpen lets a single probe request through, closing the circuit when it succeeds.
+	CircuitHalfOpen CircuitState = "half-open"
+)
+
+// WithCircuitBreaker is a client option sharing a failure budget across all the CarData API calls of the client,
+// to avoid amplifying the load while the API is down.
+// Once failures requests failed in a row within window, the circuit opens: the calls fail right away with
+// ErrCircuitOpen, without reaching BMW, for the cooldown duration. A single probe request is then let through,
+// closing the circuit when it succeeds or opening it again otherwise.
+// Requests fail when they can't be sent or when BMW responds with an error worth retrying, see IsRetryable.
+func WithCircuitBreaker(failures int, window, cooldown time.Duration) ClientOption {
+	return func(c *Client) error {
+		c.useHTTPOption("WithCircuitBreaker")
+		if failures <= 0 {
+			return fmt.Errorf("the circuit breaker failures must be positive, got %d", failures)
+		}
+		if window <= 0 || cooldown <= 0 {
+			return fmt.Errorf("the circuit breaker window and cooldown must be positive, got %s and %s", window, cooldown)
+		}
+		c.breaker = &circuitBreaker{failures: failures, window: window, cooldown: cooldown}
+		return nil
+	}
+}
+
+// CircuitState returns the state of the circuit breaker, CircuitClosed when the client has none.
+func (c *Client) CircuitState() CircuitState {
+	if c.breaker == nil {
+		return CircuitClosed
+	}
+	c.breaker.m.Lock()
+	defer c.breaker.m.Unlock()
+	return c.breaker.currentState()
+}
+
+type circuitBreaker struct {
+	m        sync.Mutex
+	clock    Clock
+	failures int
+	window   time.Duration
+	cooldown time.Duration
+
+	state        CircuitState
+	consecutive  int
+	firstFailure time.Time
+	openedAt     time.Time
+	probing      bool
+}
+
+// currentState returns the state, the cooldown of an open circuit moving it to half-open.
+// It must be called with the lock held.
+func (b *circuitBreaker) currentState() CircuitState {
+	if b.state == "" {
+		return CircuitClosed
+	}
+	if b.state == CircuitOpen && b.clock.Now().Sub(b.openedAt) >= b.cooldown {
+		return CircuitHalfOpen
+	}
+	return b.state
+}
+
+// allow reports whether a request can be sent.
+func (b *circuitBreaker) allow() bool {
+	b.m.Lock()
+	defer b.m.Unlock()
+	b.state = b.currentState()
+	switch b.state {
+	case CircuitOpen:
+		return false
+	case CircuitHalfOpen:
+		if b.probing {
+			return false
+		}
+		b.probing = true
+	}
+	return true
+}
+
+// record records the outcome of an allowed request.
+func (b *circuitBreaker) record(failed bool) {
+	b.m.Lock()
+	defer b.m.Unlock()
+	b.probing = false
+	now := b.clock.Now()
+	switch {
+	case !failed:
+		b.state = CircuitClosed
+		b.consecutive = 0
+	case b.state == CircuitHalfOpen:
+		b.state = CircuitOpen
+		b.openedAt = now
+	default:
+		if b.consecutive == 0 || now.Sub(b.firstFailure) > b.window {
+			b.consecutive = 0
+			b.firstFailure = now
+		}
+		b.consecutive++
+		if b.consecutive >= b.failures {
+			b.state = CircuitOpen
+			b.openedAt = now
+			b.consecutive = 0
+		}
+	}
+}
+
+// release releases an allowed request whose outcome says nothing about the API, like a cancelled one.
+func (b *circuitBreaker) release() {
+	b.m.Lock()
+	defer b.m.Unlock()
+	b.probing = false
+}
+
+// circuitBreakerDoer short-circuits the requests while the circuit is open.
+type circuitBreakerDoer struct {
+	doer    cardataapi.HttpRequestDoer
+	breaker *circuitBreaker
+}
+
+func (d *circuitBreakerDoer) Do(req *http.Request) (*http.Response, error) {
+	if !d.breaker.allow() {
+		return nil, ErrCircuitOpen
+	}
+	resp, err := d.doer.Do(req)
+	switch {
+	case err != nil && req.Context().Err() != nil:
+		// Cancelled by the caller.
+		d.breaker.release()
+	case err != nil:
+		d.breaker.record(true)
+	default:
+		d.breaker.record(IsRetryable(&cardataapi.CarDataError{StatusCode: resp.StatusCode}))
+	}
+	return resp, err
+}
@@ -0,0 +1,83 @@
+package bmwcardata
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWithCircuitBreaker(t *testing.T) {
+	_, err := NewClient(WithCircuitBreaker(0, time.Minute, time.Minute))
+	require.Error(t, err)
+	_, err = NewClient(WithCircuitBreaker(3, 0, time.Minute))
+	require.Error(t, err)
+
+	status := atomic.Int32{}
+	status.Store(http.StatusServiceUnavailable)
+	requests := atomic.Int32{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.WriteHeader(int(status.Load()))
+		w.Write([]byte(`[]`))
+	}))
+	defer server.Close()
+	clock := newFakeClock()
+	client, err := NewClient(
+		WithCarDataServer(server.URL),
+		WithAuthenticator(&staticAuthenticator{session: &AuthenticatedSession{AccessToken: "acc"}}),
+		WithClock(clock),
+		WithCircuitBreaker(3, time.Minute, 30*time.Second),
+	)
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, CircuitClosed, client.CircuitState())
+		_, err = client.GetMappings(ctx)
+		require.Error(t, err)
+		assert.NotErrorIs(t, err, ErrCircuitOpen)
+	}
+	assert.Equal(t, CircuitOpen, client.CircuitState())
+	_, err = client.GetMappings(ctx)
+	require.ErrorIs(t, err, ErrCircuitOpen)
+	assert.Equal(t, int32(3), requests.Load(), "requests must be short-circuited while the circuit is open")
+
+	clock.Advance(30 * time.Second)
+	assert.Equal(t, CircuitHalfOpen, client.CircuitState())
+	_, err = client.GetMappings(ctx)
+	require.Error(t, err)
+	assert.Equal(t, CircuitOpen, client.CircuitState(), "a failed probe must open the circuit again")
+
+	clock.Advance(30 * time.Second)
+	status.Store(http.StatusOK)
+	_, err = client.GetMappings(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, CircuitClosed, client.CircuitState(), "a successful probe must close the circuit")
+	assert.Equal(t, int32(5), requests.Load())
+}
+
+func TestCircuitBreakerWindow(t *testing.T) {
+	clock := newFakeClock()
+	b := &circuitBreaker{clock: clock, failures: 2, window: time.Minute, cooldown: time.Minute}
+	require.True(t, b.allow())
+	b.record(true)
+	clock.Advance(2 * time.Minute)
+	require.True(t, b.allow())
+	b.record(true)
+	assert.Equal(t, CircuitClosed, b.currentState(), "failures older than the window must not count")
+	require.True(t, b.allow())
+	b.record(true)
+	assert.Equal(t, CircuitOpen, b.currentState())
+
+	clock.Advance(time.Minute)
+	require.True(t, b.allow(), "a probe must be let through once the cooldown elapsed")
+	assert.False(t, b.allow(), "a single probe must be let through")
+	b.release()
+	assert.True(t, b.allow(), "a released probe must let another one through")
+}
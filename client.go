@@ -3,12 +3,17 @@ package bmwcardata
 import (
 	"context"
 	"errors"
+	"fmt"
 	"net/http"
 	"net/url"
-	"sync"
+	"slices"
+	"strings"
 	"sync/atomic"
+	"time"
 
+	"github.com/google/uuid"
 	"github.com/tjamet/bmw-cardata/cardataapi"
+	"golang.org/x/text/language"
 )
 
 type Scope string
@@ -24,32 +29,99 @@ const (
 	ClientID = "go-bmw-cardata"
 )
 
+// StreamingScopes are the scopes required to stream telematic data.
+// The openid scope is required for BMW to issue the id_token used to authenticate
+// against the MQTT broker.
+var StreamingScopes = []Scope{ScopeOpenID, ScopeCardataStreaming}
+
 type Client struct {
 	Authenticator AuthenticatorInterface
 	CarDataServer string
 	StreamingURL  *url.URL
 
-	carDataAPI cardataapi.ClientInterface
-	streaming  atomic.Pointer[streamingManager]
+	carDataAPI     cardataapi.ClientInterface
+	requestEditors []cardataapi.RequestEditorFn
+	middlewares    []func(http.RoundTripper) http.RoundTripper
+	streaming      atomic.Pointer[streamingManager]
+
+	autoStartEventStream bool
+	messageHandler       func(message StreamedMessage)
+	strictVIN            bool
+	defaultVIN           string
+
+	streamInsecureSkipVerify bool
+
+	perRequestTimeout    time.Duration
+	callbackDrainTimeout time.Duration
+	maxReconnects        int
+	subscribeTimeout     time.Duration
+	connectTimeout       time.Duration
+	keepAlive            time.Duration
+	livenessTimeout      time.Duration
 
-	m             sync.Mutex
-	subscriptions map[string]map[string]func(message StreamedMessage)
+	// streamWriterMarshalOptions customise the messages written by StreamToWriter.
+	streamWriterMarshalOptions []StreamedMarshalOption
+	// streamWriterFlushInterval is the interval at which StreamToWriter flushes, see WithStreamWriterFlushInterval.
+	streamWriterFlushInterval time.Duration
+	// containerConsistencyDelay is the delay between two checks that a freshly created container is available.
+	containerConsistencyDelay time.Duration
+
+	streamErrors  chan error
+	dedup         *messageDeduplicator
+	history       *messageHistory
+	watchdog      *containerWatchdog
+	clock         Clock
+	pool          connectionPool
+	httpTransport http.RoundTripper
+	// httpOptions are the names of the options used to configure the HTTP client built by NewClient.
+	httpOptions   []string
+	mqttClientID  string
+	mappingsCache *mappingsCache
+	idGenerator   func() string
+	breaker       *circuitBreaker
+
+	subscriptions subscriptionRegistry
 }
 
 type ClientOption func(*Client) error
 
 // WithCarDataServer is a client option that allows you to set the car data server.
 // This is the base URL for the car data API.
+// It must be an absolute http(s) URL, trailing slashes are removed.
 func WithCarDataServer(carDataServer string) ClientOption {
 	return func(c *Client) error {
-		c.CarDataServer = carDataServer
+		server, err := normalizeServerURL(carDataServer)
+		if err != nil {
+			return fmt.Errorf("invalid car data server: %w", err)
+		}
+		c.CarDataServer = server
 		return nil
 	}
 }
 
+// normalizeServerURL checks that server is an absolute http(s) URL
+// and returns it without trailing slashes.
+func normalizeServerURL(server string) (string, error) {
+	u, err := url.Parse(server)
+	if err != nil {
+		return "", err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return "", fmt.Errorf("%q must be an absolute http or https URL", server)
+	}
+	if u.Host == "" {
+		return "", fmt.Errorf("%q has no host", server)
+	}
+	return strings.TrimRight(server, "/"), nil
+}
+
 // WithCarDataAPI is a client option that allows you to set the car data API client.
 // In this case you will need to inject the authentication headers manually.
 // Authentication is done through a `Authorization: Bearer <access_token>` header.
+// As the provided client replaces the HTTP client NewClient builds, NewClient returns an error when it is combined
+// with an option configuring the latter: WithLanguage, WithRequestEditor, WithRequestMiddleware, WithPerRequestTimeout,
+// WithCircuitBreaker, WithHTTPTransport or the connection pool options.
+// Neither are the contexts returned by ContextWithAccept and ContextWithRawResponse honoured.
 func WithCarDataAPI(carDataAPI cardataapi.ClientInterface) ClientOption {
 	return func(c *Client) error {
 		c.carDataAPI = carDataAPI
@@ -57,6 +129,89 @@ func WithCarDataAPI(carDataAPI cardataapi.ClientInterface) ClientOption {
 	}
 }
 
+// useHTTPOption records that an option configuring the HTTP client built by NewClient is used,
+// to reject its combination with WithCarDataAPI.
+func (c *Client) useHTTPOption(name string) {
+	if !slices.Contains(c.httpOptions, name) {
+		c.httpOptions = append(c.httpOptions, name)
+	}
+}
+
+// WithLanguage is a client option that sets the Accept-Language header on CarData requests
+// to get localized human-readable labels (charging location, tyre labels, etc.).
+// The tag must be a valid BCP-47 language tag, e.g. "en-GB".
+// When empty, no Accept-Language header is sent.
+func WithLanguage(tag string) ClientOption {
+	return func(c *Client) error {
+		c.useHTTPOption("WithLanguage")
+		if tag == "" {
+			return nil
+		}
+		parsed, err := language.Parse(tag)
+		if err != nil {
+			return fmt.Errorf("invalid language tag %q: %w", tag, err)
+		}
+		lang := parsed.String()
+		c.requestEditors = append(c.requestEditors, func(ctx context.Context, req *http.Request) error {
+			req.Header.Set("Accept-Language", lang)
+			return nil
+		})
+		return nil
+	}
+}
+
+// WithMQTTClientID is a client option setting the client ID used to connect to the MQTT broker.
+// The broker disconnects a client when another one connects with the same ID (session takeover),
+// hence distinct instances streaming at the same time must use distinct IDs.
+// By default, each client uses ClientID followed by a random suffix, which changes on restart, see WithIDGenerator.
+// Setting a stable ID allows resuming the broker session, and the messages it retained, after a restart.
+func WithMQTTClientID(id string) ClientOption {
+	return func(c *Client) error {
+		if id == "" {
+			return errors.New("the MQTT client ID must not be empty")
+		}
+		c.mqttClientID = id
+		return nil
+	}
+}
+
+// WithIDGenerator is a client option setting the generator of the subscription IDs.
+// Unless set with WithMQTTClientID, the MQTT client ID is also ClientID followed by a generated ID.
+// This allows using deterministic IDs in tests, or IDs following another scheme.
+// Generated IDs must be unique for the lifetime of the client.
+// By default, random UUIDs are used.
+func WithIDGenerator(generator func() string) ClientOption {
+	return func(c *Client) error {
+		if generator == nil {
+			return errors.New("the ID generator must not be nil")
+		}
+		c.idGenerator = generator
+		return nil
+	}
+}
+
+// newID returns a new ID with the ID generator, a random UUID by default.
+func (c *Client) newID() string {
+	if c.idGenerator != nil {
+		return c.idGenerator()
+	}
+	return uuid.NewString()
+}
+
+// WithRequestEditor is a client option that edits every CarData API request before it is sent.
+// Editors run in registration order, after the authentication, Accept and Accept-Language headers are set,
+// so they can override them and access the session with SessionFromContext(req.Context()).
+func WithRequestEditor(editor cardataapi.RequestEditorFn) ClientOption {
+	return func(c *Client) error {
+		c.useHTTPOption("WithRequestEditor")
+		if editor == nil {
+			return errors.New("the request editor must not be nil")
+		}
+		c.requestEditors = append(c.requestEditors, editor)
+		return nil
+	}
+}
+
 // WithSessionManager is a client option that allows you to set the session manager.
 // By default, an in-memory session manager is used.
 func WithAuthenticator(authenticator AuthenticatorInterface) ClientOption {
@@ -75,6 +230,120 @@ func WithStreamingURL(streamingURL *url.URL) ClientOption {
 	}
 }
 
+// WithEventStreamAutoStart is a client option that starts the event stream on the first call to Subscribe
+// when it is not already running.
+// By default, StartEventStream must be called explicitly before subscribing.
+func WithEventStreamAutoStart() ClientOption {
+	return func(c *Client) error {
+		c.autoStartEventStream = true
+		return nil
+	}
+}
+
+// WithMessageHandler is a client option that sets a handler invoked for every message received
+// from the event stream, in addition to the callbacks of the matching subscriptions.
+// When set, the event stream receives the messages of all the VINs, without having to subscribe to AllVINs.
+func WithMessageHandler(handler func(message StreamedMessage)) ClientOption {
+	return func(c *Client) error {
+		c.messageHandler = handler
+		return nil
+	}
+}
+
+// WithStreamInsecureSkipVerify is a client option that disables the verification of the
+// streaming broker TLS certificate.
+//
+// WARNING: this is only meant to test against a local broker using a self-signed certificate.
+// It exposes the streamed data and the id_token to any man-in-the-middle and must never be
+// enabled in production. A warning is logged each time the event stream is started with it.
+func WithStreamInsecureSkipVerify(insecureSkipVerify bool) ClientOption {
+	return func(c *Client) error {
+		c.streamInsecureSkipVerify = insecureSkipVerify
+		return nil
+	}
+}
+
+// WithCallbackDrainTimeout is a client option that makes StopEventStream wait, for at most timeout,
+// for the subscription and message handler callbacks that are still running to return.
+// This allows callbacks to complete their work, like writing a message, on shutdown.
+// No callback is started once StopEventStream waits for them. ErrCallbacksStillRunning is returned on timeout.
+// By default, StopEventStream does not wait for the callbacks.
+func WithCallbackDrainTimeout(timeout time.Duration) ClientOption {
+	return func(c *Client) error {
+		c.callbackDrainTimeout = timeout
+		return nil
+	}
+}
+
+// WithMaxReconnects is a client option that stops the event stream after n consecutive failed
+// connection attempts to the streaming broker. ErrTooManyReconnects is then reported on StreamErrors
+// and the channel returned by Done is closed.
+// The count is reset each time the connection is established.
+// By default, or when n is 0, the connection is retried forever.
+func WithMaxReconnects(n int) ClientOption {
+	return func(c *Client) error {
+		if n < 0 {
+			return fmt.Errorf("the maximum number of reconnects must not be negative, got %d", n)
+		}
+		c.maxReconnects = n
+		return nil
+	}
+}
+
+// WithStreamSubscribeTimeout is a client option bounding the time to wait for the broker to acknowledge
+// the subscriptions to, and unsubscriptions from, the VIN topics.
+// This makes a broker accepting the connection but stalling on subscriptions observable: the timeouts
+// are returned by Subscribe and Unsubscribe, or reported on StreamErrors when subscribing on (re)connection.
+// By default, DefaultStreamSubscribeTimeout is used.
+func WithStreamSubscribeTimeout(timeout time.Duration) ClientOption {
+	return func(c *Client) error {
+		if timeout <= 0 {
+			return fmt.Errorf("the subscribe timeout must be positive, got %s", timeout)
+		}
+		c.subscribeTimeout = timeout
+		return nil
+	}
+}
+
+// WithStreamConnectTimeout is a client option bounding the time StartEventStream waits for the initial connection
+// to the streaming broker. When the broker can't be reached in time, the event stream is stopped and StartEventStream
+// returns an error wrapping ErrStreamConnectTimeout, so that it can be started again later.
+// By default, StartEventStream waits until the connection is established or the process is interrupted.
+// The reconnections, once connected, are not bounded by this timeout, see WithMaxReconnects.
+func WithStreamConnectTimeout(timeout time.Duration) ClientOption {
+	return func(c *Client) error {
+		if timeout <= 0 {
+			return fmt.Errorf("the connect timeout must be positive, got %s", timeout)
+		}
+		c.connectTimeout = timeout
+		return nil
+	}
+}
+
+// WithRequestMiddleware is a client option that wraps the transport of the CarData API requests,
+// for cross-cutting concerns like tracing headers, request IDs or signing.
+// Middlewares compose in registration order: the first registered one handles the requests first,
+// and the responses last.
+func WithRequestMiddleware(middleware func(http.RoundTripper) http.RoundTripper) ClientOption {
+	return func(c *Client) error {
+		c.useHTTPOption("WithRequestMiddleware")
+		if middleware == nil {
+			return errors.New("middleware must not be nil")
+		}
+		c.middlewares = append(c.middlewares, middleware)
+		return nil
+	}
+}
+
+// WithStrictVIN is a client option that validates VINs with NormalizeVIN before sending them to BMW.
+// By default, VINs are only trimmed and upper-cased.
+func WithStrictVIN() ClientOption {
+	return func(c *Client) error {
+		c.strictVIN = true
+		return nil
+	}
+}
+
 // NewClient creates a new client with the given options.
 // It will use the default auth server and car data server if not provided.
 // It will use a S256Challenger by default.
@@ -82,12 +351,30 @@ func NewClient(options ...ClientOption) (*Client, error) {
 	client := &Client{
 		CarDataServer: cardataapi.CarDataAPIServer,
 		StreamingURL:  streamingURL,
+		streamErrors:  make(chan error, streamErrorsBuffer),
+		clock:         systemClock{},
+		pool:          defaultConnectionPool(),
+
+		containerConsistencyDelay: defaultContainerConsistencyDelay,
 	}
 	for _, option := range options {
 		if err := option(client); err != nil {
 			return nil, err
 		}
 	}
+	if client.carDataAPI != nil && len(client.httpOptions) > 0 {
+		return nil, fmt.Errorf("%s can't be combined with WithCarDataAPI, which replaces the HTTP client they configure", strings.Join(client.httpOptions, ", "))
+	}
+	if err := client.validateLiveness(); err != nil {
+		return nil, err
+	}
+	if client.mqttClientID == "" {
+		suffix := uuid.NewString()[:8]
+		if client.idGenerator != nil {
+			suffix = client.idGenerator()
+		}
+		client.mqttClientID = ClientID + "-" + suffix
+	}
 	if client.CarDataServer == cardataapi.CarDataAPIServer && client.Authenticator == nil {
 		authenticator, err := NewAuthenticator()
 		if err != nil {
@@ -96,10 +383,32 @@ func NewClient(options ...ClientOption) (*Client, error) {
 		client.Authenticator = authenticator
 	}
 	if client.carDataAPI == nil {
-		carDataAPI, err := cardataapi.NewClientWithResponses(
-			client.CarDataServer,
+		transport := client.httpTransport
+		if transport == nil {
+			transport = client.pool.transport()
+		}
+		// Wrap in reverse order, so that the first registered middleware handles the requests first.
+		for i := len(client.middlewares) - 1; i >= 0; i-- {
+			transport = client.middlewares[i](transport)
+		}
+		// A dedicated client is used so that http.DefaultClient, shared with other libraries, is never used nor modified.
+		var doer cardataapi.HttpRequestDoer = &http.Client{Transport: transport}
+		if client.perRequestTimeout > 0 {
+			doer = &timeoutDoer{doer: doer, timeout: client.perRequestTimeout}
+		}
+		if client.breaker != nil {
+			client.breaker.clock = client.clock
+			doer = &circuitBreakerDoer{doer: doer, breaker: client.breaker}
+		}
+		apiOptions := []cardataapi.ClientOption{
+			cardataapi.WithHTTPClient(&rawResponseRecorder{doer: doer}),
 			cardataapi.WithRequestEditorFn(client.injectAuthenticationHeaders),
-		)
+			cardataapi.WithRequestEditorFn(injectAcceptHeader),
+		}
+		for _, editor := range client.requestEditors {
+			apiOptions = append(apiOptions, cardataapi.WithRequestEditorFn(editor))
+		}
+		carDataAPI, err := cardataapi.NewClientWithResponses(client.CarDataServer, apiOptions...)
 		if err != nil {
 			return nil, err
 		}
@@ -108,6 +417,28 @@ func NewClient(options ...ClientOption) (*Client, error) {
 	return client, nil
 }
 
+// NewClientForStreaming creates a new client with the given options and ensures
+// it is able to stream telematic data.
+// When the authenticator is an *Authenticator, it fails fast if it is not configured
+// to request all the StreamingScopes, instead of failing later on with a missing id_token.
+func NewClientForStreaming(options ...ClientOption) (*Client, error) {
+	client, err := NewClient(options...)
+	if err != nil {
+		return nil, err
+	}
+	if client.Authenticator == nil {
+		return nil, errors.New("an authenticator is required for streaming")
+	}
+	if authenticator, ok := client.Authenticator.(*Authenticator); ok {
+		for _, scope := range StreamingScopes {
+			if !slices.Contains(authenticator.Scopes, scope) {
+				return nil, fmt.Errorf("streaming requires the %s scope, the authenticator is configured with %v", scope, authenticator.Scopes)
+			}
+		}
+	}
+	return client, nil
+}
+
 func (c *Client) injectAuthenticationHeaders(ctx context.Context, req *http.Request) error {
 	session, err := c.Authenticator.GetSession(ctx)
 	if err != nil {
@@ -116,10 +447,42 @@ func (c *Client) injectAuthenticationHeaders(ctx context.Context, req *http.Requ
 	if session == nil {
 		return errors.New("session not found")
 	}
+	err = session.requireScopes(ScopeCardataAPI)
+	if err != nil {
+		return err
+	}
 	req.Header.Set("Authorization", "Bearer "+session.AccessToken)
+	*req = *req.WithContext(context.WithValue(req.Context(), sessionKey{}, session))
 	return nil
 }
 
+type sessionKey struct{}
+
+// SessionFromContext returns the session used to authenticate a CarData API request,
+// given the request context. It allows request editors registered with WithRequestEditor,
+// and middlewares registered with WithRequestMiddleware, to access the GCID or the scopes
+// of the session without resolving it, and possibly refreshing it, again.
+// Use it with req.Context(), the context argument of the request editors is the one of the call.
+func SessionFromContext(ctx context.Context) (*AuthenticatedSession, bool) {
+	session, ok := ctx.Value(sessionKey{}).(*AuthenticatedSession)
+	return session, ok
+}
+
+// Ping checks the credentials and the connectivity to the CarData API with a lightweight authenticated call.
+// It returns nil on success, an error wrapping the authentication error when no session can be obtained,
+// or the API error otherwise.
+// The mappings cache, see WithMappingsCache, is bypassed so that the API is always reached.
+func (c *Client) Ping(ctx context.Context) error {
+	if c.Authenticator != nil {
+		_, err := c.Authenticator.GetSession(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to authenticate: %w", err)
+		}
+	}
+	_, err := c.fetchMappings(ctx)
+	return err
+}
+
 func Must[T any](t T, err error) T {
 	if err != nil {
 		panic(err)
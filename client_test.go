@@ -0,0 +1,246 @@
+package bmwcardata
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tjamet/bmw-cardata/cardataapi"
+)
+
+func TestNewClientForStreaming(t *testing.T) {
+	t.Run("accepts the default scopes", func(t *testing.T) {
+		authenticator := Must(NewAuthenticator(
+			WithClientID(testClientID),
+			WithPromptURI(func(string, string, string) {}),
+			WithSessionStore(&InMemorySessionStore{}),
+		))
+		client, err := NewClientForStreaming(WithAuthenticator(authenticator))
+		require.NoError(t, err)
+		assert.Same(t, authenticator, client.Authenticator)
+	})
+
+	t.Run("rejects read-only scopes", func(t *testing.T) {
+		authenticator := Must(NewAuthenticator(
+			WithClientID(testClientID),
+			WithPromptURI(func(string, string, string) {}),
+			WithSessionStore(&InMemorySessionStore{}),
+			WithScopes([]Scope{ScopeOpenID, ScopeCardataAPI}),
+		))
+		_, err := NewClientForStreaming(WithAuthenticator(authenticator))
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), string(ScopeCardataStreaming))
+	})
+
+	t.Run("rejects missing openid scope", func(t *testing.T) {
+		authenticator := Must(NewAuthenticator(
+			WithClientID(testClientID),
+			WithPromptURI(func(string, string, string) {}),
+			WithSessionStore(&InMemorySessionStore{}),
+			WithScopes([]Scope{ScopeCardataStreaming}),
+		))
+		_, err := NewClientForStreaming(WithAuthenticator(authenticator))
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), string(ScopeOpenID))
+	})
+}
+
+type staticAuthenticator struct {
+	session *AuthenticatedSession
+	err     error
+}
+
+func (a *staticAuthenticator) GetSession(ctx context.Context) (*AuthenticatedSession, error) {
+	return a.session, a.err
+}
+
+func TestWithLanguage(t *testing.T) {
+	headers := make(chan http.Header, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		headers <- r.Header.Clone()
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`[]`))
+	}))
+	defer server.Close()
+	authenticator := &staticAuthenticator{session: &AuthenticatedSession{AccessToken: "acc"}}
+
+	t.Run("sets the Accept-Language header", func(t *testing.T) {
+		client, err := NewClient(WithCarDataServer(server.URL), WithAuthenticator(authenticator), WithLanguage("en-gb"))
+		require.NoError(t, err)
+		_, err = client.GetMappings(context.Background())
+		require.NoError(t, err)
+		got := <-headers
+		assert.Equal(t, "en-GB", got.Get("Accept-Language"))
+		assert.Equal(t, "Bearer acc", got.Get("Authorization"))
+	})
+
+	t.Run("omits the header when unset", func(t *testing.T) {
+		client, err := NewClient(WithCarDataServer(server.URL), WithAuthenticator(authenticator), WithLanguage(""))
+		require.NoError(t, err)
+		_, err = client.GetMappings(context.Background())
+		require.NoError(t, err)
+		got := <-headers
+		assert.Empty(t, got.Values("Accept-Language"))
+	})
+
+	t.Run("rejects invalid tags", func(t *testing.T) {
+		_, err := NewClient(WithCarDataServer(server.URL), WithAuthenticator(authenticator), WithLanguage("not a language"))
+		require.Error(t, err)
+	})
+}
+
+func TestPing(t *testing.T) {
+	calls := 0
+	mock := &mockCardataClient{
+		GetMappingsFunc: func(ctx context.Context, params *cardataapi.GetMappingsParams, _ ...cardataapi.RequestEditorFn) (*http.Response, error) {
+			calls++
+			return jsonResponse(http.StatusOK, []cardataapi.VehicleMappingDto{}, nil), nil
+		},
+	}
+
+	t.Run("succeeds when authenticated", func(t *testing.T) {
+		client := &Client{carDataAPI: mock, Authenticator: &staticAuthenticator{session: &AuthenticatedSession{AccessToken: "acc"}}}
+		require.NoError(t, client.Ping(context.Background()))
+		assert.Equal(t, 1, calls)
+	})
+
+	t.Run("bypasses the mappings cache", func(t *testing.T) {
+		calls = 0
+		client := &Client{carDataAPI: mock, mappingsCache: &mappingsCache{ttl: time.Hour}}
+		for i := 0; i < 2; i++ {
+			require.NoError(t, client.Ping(context.Background()))
+		}
+		assert.Equal(t, 2, calls, "each ping must reach the API")
+	})
+
+	t.Run("returns authentication errors without calling the API", func(t *testing.T) {
+		calls = 0
+		client := &Client{carDataAPI: mock, Authenticator: &staticAuthenticator{err: ErrInteractiveAuthenticationRequired}}
+		err := client.Ping(context.Background())
+		require.ErrorIs(t, err, ErrInteractiveAuthenticationRequired)
+		assert.Equal(t, 0, calls)
+	})
+
+	t.Run("returns API errors", func(t *testing.T) {
+		client := &Client{carDataAPI: &mockCardataClient{
+			GetMappingsFunc: func(ctx context.Context, params *cardataapi.GetMappingsParams, _ ...cardataapi.RequestEditorFn) (*http.Response, error) {
+				return jsonResponse(http.StatusUnauthorized, cardataapi.CarDataError{}, nil), nil
+			},
+		}}
+		err := client.Ping(context.Background())
+		carDataErr := &cardataapi.CarDataError{}
+		require.ErrorAs(t, err, &carDataErr)
+	})
+}
+
+func TestWithCarDataAPI_HTTPOptions(t *testing.T) {
+	for name, option := range map[string]ClientOption{
+		"WithLanguage":          WithLanguage("en-GB"),
+		"WithRequestEditor":     WithRequestEditor(func(context.Context, *http.Request) error { return nil }),
+		"WithRequestMiddleware": WithRequestMiddleware(func(next http.RoundTripper) http.RoundTripper { return next }),
+		"WithPerRequestTimeout": WithPerRequestTimeout(time.Second),
+		"WithCircuitBreaker":    WithCircuitBreaker(3, time.Minute, time.Minute),
+		"WithHTTPTransport":     WithHTTPTransport(http.DefaultTransport),
+		"WithMaxIdleConns":      WithMaxIdleConns(3),
+	} {
+		_, err := NewClient(WithCarDataAPI(&mockCardataClient{}), WithAuthenticator(&staticAuthenticator{}), option)
+		assert.ErrorContains(t, err, name, "options configuring the replaced HTTP client must be rejected")
+	}
+}
+
+func TestWithCarDataServer(t *testing.T) {
+	authenticator := &staticAuthenticator{session: &AuthenticatedSession{AccessToken: "acc"}}
+	for _, server := range []string{"example.com", "ftp://example.com", "https://", ""} {
+		_, err := NewClient(WithCarDataServer(server), WithAuthenticator(authenticator))
+		assert.Error(t, err, server)
+	}
+
+	client, err := NewClient(WithCarDataServer("https://example.com/api/"), WithAuthenticator(authenticator))
+	require.NoError(t, err)
+	assert.Equal(t, "https://example.com/api", client.CarDataServer)
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestWithRequestMiddleware(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, []string{"first", "second"}, r.Header.Values("X-Middleware"))
+		assert.Equal(t, "Bearer acc", r.Header.Get("Authorization"), "middlewares must see the authenticated requests")
+		w.Write([]byte(`[]`))
+	}))
+	defer server.Close()
+	order := []string{}
+	middleware := func(name string) func(http.RoundTripper) http.RoundTripper {
+		return func(next http.RoundTripper) http.RoundTripper {
+			return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+				order = append(order, name)
+				// RoundTrippers must not modify the request they receive.
+				req = req.Clone(req.Context())
+				req.Header.Add("X-Middleware", name)
+				return next.RoundTrip(req)
+			})
+		}
+	}
+
+	_, err := NewClient(WithRequestMiddleware(nil))
+	require.Error(t, err)
+
+	client, err := NewClient(
+		WithCarDataServer(server.URL),
+		WithAuthenticator(&staticAuthenticator{session: &AuthenticatedSession{AccessToken: "acc"}}),
+		WithRequestMiddleware(middleware("first")),
+		WithRequestMiddleware(middleware("second")),
+	)
+	require.NoError(t, err)
+	_, err = client.GetMappings(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []string{"first", "second"}, order)
+}
+
+func TestSessionFromContext(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "gcid", r.Header.Get("X-Gcid"))
+		w.Write([]byte(`[]`))
+	}))
+	defer server.Close()
+	authenticator := &countingAuthenticator{session: &AuthenticatedSession{AccessToken: "acc", Gcid: "gcid"}}
+
+	_, err := NewClient(WithRequestEditor(nil))
+	require.Error(t, err)
+
+	_, ok := SessionFromContext(context.Background())
+	assert.False(t, ok)
+
+	client, err := NewClient(
+		WithCarDataServer(server.URL),
+		WithAuthenticator(authenticator),
+		WithRequestEditor(func(ctx context.Context, req *http.Request) error {
+			session, ok := SessionFromContext(req.Context())
+			require.True(t, ok, "the session must be available to request editors")
+			req.Header.Set("X-Gcid", session.Gcid)
+			return nil
+		}),
+	)
+	require.NoError(t, err)
+	_, err = client.GetMappings(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 1, authenticator.calls, "the session must be resolved once per request")
+}
+
+type countingAuthenticator struct {
+	session *AuthenticatedSession
+	calls   int
+}
+
+func (a *countingAuthenticator) GetSession(ctx context.Context) (*AuthenticatedSession, error) {
+	a.calls++
+	return a.session, nil
+}
@@ -0,0 +1,48 @@
+package bmwcardata
+
+import (
+	"errors"
+	"time"
+)
+
+// Clock provides the time to the event stream.
+// It allows tests to control the time, see WithClock.
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+// systemClock is the Clock of the time package.
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (systemClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+// getClock returns the client clock, the system one by default.
+func (c *Client) getClock() Clock {
+	if c.clock == nil {
+		return systemClock{}
+	}
+	return c.clock
+}
+
+// WithClock is a client option setting the clock of the event stream, used to detect silent streams
+// with WithContainerWatchdog and to time out the callbacks with WithCallbackDrainTimeout.
+// It also times the cooldown of the circuit breaker, see WithCircuitBreaker, and the checks that a container
+// created by ReadTelematicOnce, or recreated by the watchdog, is available.
+// It defaults to the system clock and is mostly meant to test timings without waiting.
+// The reconnection backoff is timed by the MQTT client and does not use it.
+func WithClock(clock Clock) ClientOption {
+	return func(c *Client) error {
+		if clock == nil {
+			return errors.New("the clock must not be nil")
+		}
+		c.clock = clock
+		return nil
+	}
+}
@@ -0,0 +1,83 @@
+package bmwcardata
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// fakeClock is a Clock only moving forward when advanced.
+type fakeClock struct {
+	m      sync.Mutex
+	now    time.Time
+	timers []fakeTimer
+}
+
+type fakeTimer struct {
+	at time.Time
+	c  chan time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.m.Lock()
+	defer c.m.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.m.Lock()
+	defer c.m.Unlock()
+	ch := make(chan time.Time, 1)
+	if d <= 0 {
+		ch <- c.now
+		return ch
+	}
+	c.timers = append(c.timers, fakeTimer{at: c.now.Add(d), c: ch})
+	return ch
+}
+
+// Advance moves the clock forward and fires the expired timers.
+func (c *fakeClock) Advance(d time.Duration) {
+	c.m.Lock()
+	defer c.m.Unlock()
+	c.now = c.now.Add(d)
+	pending := c.timers[:0]
+	for _, timer := range c.timers {
+		if timer.at.After(c.now) {
+			pending = append(pending, timer)
+			continue
+		}
+		timer.c <- c.now
+	}
+	c.timers = pending
+}
+
+// waitTimers waits until n timers are pending.
+func (c *fakeClock) waitTimers(t *testing.T, n int) {
+	t.Helper()
+	require.Eventually(t, func() bool {
+		c.m.Lock()
+		defer c.m.Unlock()
+		return len(c.timers) == n
+	}, time.Second, time.Millisecond)
+}
+
+func TestWithClock(t *testing.T) {
+	_, err := NewClient(WithCarDataAPI(&mockCardataClient{}), WithAuthenticator(&staticAuthenticator{}), WithClock(nil))
+	require.Error(t, err)
+
+	c, err := NewClient(WithCarDataAPI(&mockCardataClient{}), WithAuthenticator(&staticAuthenticator{}))
+	require.NoError(t, err)
+	require.Equal(t, systemClock{}, c.clock, "the system clock must be used by default")
+
+	clock := newFakeClock()
+	c, err = NewClient(WithCarDataAPI(&mockCardataClient{}), WithAuthenticator(&staticAuthenticator{}), WithClock(clock))
+	require.NoError(t, err)
+	require.Same(t, clock, c.clock)
+}
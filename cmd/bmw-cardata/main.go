@@ -6,7 +6,14 @@ import (
 	"flag"
 	"fmt"
 	"log"
+	"mime"
 	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
 	"time"
 
 	bmwcardata "github.com/tjamet/bmw-cardata"
@@ -20,6 +27,110 @@ func dumpOutput(data any, err error) error {
 	return e.Encode(data)
 }
 
+// vinsFlag collects VINs from a repeated and/or comma-separated -vin flag.
+type vinsFlag []string
+
+func (v *vinsFlag) String() string {
+	return strings.Join(*v, ",")
+}
+
+func (v *vinsFlag) Set(value string) error {
+	for _, vin := range strings.Split(value, ",") {
+		vin = strings.TrimSpace(vin)
+		if vin != "" {
+			*v = append(*v, vin)
+		}
+	}
+	return nil
+}
+
+// single returns the only VIN provided, for commands operating on a single vehicle.
+func (v vinsFlag) single() (string, error) {
+	if len(v) > 1 {
+		return "", fmt.Errorf("this command accepts a single VIN, got %d", len(v))
+	}
+	if len(v) == 0 {
+		return "", nil
+	}
+	return v[0], nil
+}
+
+// streamPretty prints the messages streamed for the given VINs in a human readable form, until the stream ends.
+func streamPretty(ctx context.Context, client *bmwcardata.Client, vins []string) error {
+	err := client.StartEventStream()
+	if err != nil {
+		return err
+	}
+	defer client.StopEventStream()
+	m := sync.Mutex{}
+	for _, vin := range vins {
+		_, err := client.Subscribe(ctx, vin, func(message bmwcardata.StreamedMessage) {
+			formatted := bmwcardata.FormatStreamedMessage(message)
+			if formatted == "" {
+				return
+			}
+			m.Lock()
+			defer m.Unlock()
+			fmt.Println(formatted)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	select {
+	case <-ctx.Done():
+	case <-client.Done():
+	}
+	return nil
+}
+
+// extractVehicleImage decodes the vehicle image of the archive and writes it to path,
+// adding the extension matching the image type when path has none.
+func extractVehicleImage(archivePath, path string) error {
+	// The vehicle image is part of the KeyList, no other section is needed.
+	archive, err := bmwcardata.ReadArchiveSections(archivePath)
+	if err != nil {
+		return err
+	}
+	image, err := archive.DecodeVehicleImage()
+	if err != nil {
+		return err
+	}
+	if filepath.Ext(path) == "" {
+		path += imageExtension(image.ContentType)
+	}
+	err = os.WriteFile(path, image.Data, 0o644)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Vehicle image written to %s\n", path)
+	return nil
+}
+
+// imageExtension returns the file extension of the image content type, or an empty string when unknown.
+func imageExtension(contentType string) string {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return ""
+	}
+	// mime.ExtensionsByType depends on the system MIME database, prefer the usual extensions.
+	switch mediaType {
+	case "image/png":
+		return ".png"
+	case "image/jpeg":
+		return ".jpg"
+	case "image/webp":
+		return ".webp"
+	case "image/gif":
+		return ".gif"
+	}
+	extensions, err := mime.ExtensionsByType(mediaType)
+	if err != nil || len(extensions) == 0 {
+		return ""
+	}
+	return extensions[0]
+}
+
 func main() {
 	defaultSessionPath, err := bmwcardata.DefaultSessionPath()
 	if err != nil {
@@ -32,7 +143,10 @@ func main() {
 
 	sessionPath := flag.String("session-path", defaultSessionPath, "Path to the session file")
 	clientID := flag.String("client-id", "", "Client ID")
-	vin := flag.String("vin", "", "VIN")
+	vins := vinsFlag{}
+	flag.Var(&vins, "vin", "VIN, can be repeated or comma-separated for stream-telematic-data")
+	allVINs := flag.Bool("all-vins", false, "Stream data for all the VINs mapped to the account (stream-telematic-data only)")
+	pretty := flag.Bool("pretty", false, "Print the streamed signals with their descriptor names instead of JSON (stream-telematic-data only)")
 
 	from := flag.String("from", defaultFrom, "From date (YYYY-MM-DD)")
 	to := flag.String("to", defaultTo, "To date (YYYY-MM-DD)")
@@ -42,16 +156,20 @@ func main() {
 	containerID := flag.String("container-id", "", "Container ID")
 
 	archivePath := flag.String("archive-path", "", "Archive path")
+	extractImage := flag.String("extract-image", "", "Write the vehicle image of the archive to this file instead of dumping the archive, the extension matching the image type is added when missing (read-archive only)")
+
+	brand := flag.String("brand", "", "Only list descriptors available for this brand (e.g. BMW)")
+	vehicleType := flag.String("vehicle-type", "", "Only list descriptors available for this vehicle type (ICE, PHEV, BEV, MHEV)")
+	category := flag.String("category", "", "Only list descriptors of this category")
+	streamable := flag.String("streamable", "", "Only list streamable (true) or non-streamable (false) descriptors")
+	nameContains := flag.String("name-contains", "", "Only list descriptors whose name contains this string (case insensitive)")
 
 	newClient := func() *bmwcardata.Client {
 		client, err := bmwcardata.NewClient(
 			bmwcardata.WithAuthenticator(bmwcardata.Must(bmwcardata.NewAuthenticator(
 				bmwcardata.WithSessionStore(&bmwcardata.FileSessionStore{Path: *sessionPath}),
 				bmwcardata.WithClientID(*clientID),
-				bmwcardata.WithPromptURI(func(uri, code, complete string) {
-					fmt.Println("Open the following URL in your browser:")
-					fmt.Println(complete)
-				}),
+				bmwcardata.WithPromptURI(bmwcardata.PromptHyperlink(os.Stdout)),
 			))),
 		)
 		if err != nil {
@@ -65,9 +183,17 @@ func main() {
 			return dumpOutput(newClient().GetMappings(ctx))
 		},
 		"get-basic-data": func(ctx context.Context) error {
-			return dumpOutput(newClient().GetBasicData(ctx, *vin))
+			vin, err := vins.single()
+			if err != nil {
+				return err
+			}
+			return dumpOutput(newClient().GetBasicData(ctx, vin))
 		},
 		"get-charging-history": func(ctx context.Context) error {
+			vin, err := vins.single()
+			if err != nil {
+				return err
+			}
 			from, err := time.Parse("2006-01-02", *from)
 			if err != nil {
 				return err
@@ -80,20 +206,32 @@ func main() {
 			if *nextToken != "" {
 				options = append(options, bmwcardata.WithChargingHistoryNextToken(*nextToken))
 			}
-			return dumpOutput(newClient().GetChargingHistory(ctx, *vin, from, to, options...))
+			return dumpOutput(newClient().GetChargingHistory(ctx, vin, from, to, options...))
 		},
 		"get-image": func(ctx context.Context) error {
-			return dumpOutput(newClient().GetImage(ctx, *vin))
+			vin, err := vins.single()
+			if err != nil {
+				return err
+			}
+			return dumpOutput(newClient().GetImage(ctx, vin))
 		},
 		"get-location-based-charging-settings": func(ctx context.Context) error {
+			vin, err := vins.single()
+			if err != nil {
+				return err
+			}
 			options := []bmwcardata.GetLocationBasedChargingSettingsParamsOption{}
 			if *nextToken != "" {
 				options = append(options, bmwcardata.WithLocationBasedChargingSettingsNextToken(*nextToken))
 			}
-			return dumpOutput(newClient().GetLocationBasedChargingSettings(ctx, *vin, options...))
+			return dumpOutput(newClient().GetLocationBasedChargingSettings(ctx, vin, options...))
 		},
 		"get-smart-maintenance-tyre-diagnosis": func(ctx context.Context) error {
-			return dumpOutput(newClient().GetSmartMaintenanceTyreDiagnosis(ctx, *vin))
+			vin, err := vins.single()
+			if err != nil {
+				return err
+			}
+			return dumpOutput(newClient().GetSmartMaintenanceTyreDiagnosis(ctx, vin))
 		},
 		"list-containers": func(ctx context.Context) error {
 			return dumpOutput(newClient().ListContainers(ctx))
@@ -105,27 +243,70 @@ func main() {
 			return dumpOutput(newClient().DeleteContainer(ctx, *containerID))
 		},
 		"get-telematic-data": func(ctx context.Context) error {
-			return dumpOutput(newClient().GetTelematicData(ctx, *vin, *containerID))
-		},
-		"read-archive": func(ctx context.Context) error {
-			return dumpOutput(bmwcardata.ReadArchive(*archivePath))
-		},
-		"stream-telematic-data": func(ctx context.Context) error {
-			client := newClient()
-			err := client.StartEventStream()
+			vin, err := vins.single()
 			if err != nil {
 				return err
 			}
-			defer client.StopEventStream()
-			e := json.NewEncoder(os.Stdout)
-			client.Subscribe(ctx, *vin, func(message bmwcardata.StreamedMessage) {
-				err := e.Encode(message)
+			return dumpOutput(newClient().GetTelematicData(ctx, vin, *containerID))
+		},
+		"list-descriptors": func(ctx context.Context) error {
+			matchers := []bmwcardata.DescriptorMatcher{}
+			if *brand != "" {
+				matchers = append(matchers, bmwcardata.MatchBrand(bmwcardata.Brand(*brand)))
+			}
+			if *vehicleType != "" {
+				matchers = append(matchers, bmwcardata.MatchVehicleType(bmwcardata.VehicleType(*vehicleType)))
+			}
+			if *category != "" {
+				matchers = append(matchers, bmwcardata.MatchCategory(*category))
+			}
+			if *streamable != "" {
+				value, err := strconv.ParseBool(*streamable)
 				if err != nil {
-					log.Fatal(err)
+					return fmt.Errorf("invalid -streamable value %q: %w", *streamable, err)
 				}
+				matchers = append(matchers, bmwcardata.MatchStreamable(value))
+			}
+			if *nameContains != "" {
+				needle := strings.ToLower(*nameContains)
+				matchers = append(matchers, bmwcardata.DescriptorMatcherFunc(func(descriptor bmwcardata.Descriptor) bool {
+					return strings.Contains(strings.ToLower(descriptor.Name), needle)
+				}))
+			}
+			descriptors := bmwcardata.FindDescriptors(bmwcardata.MatchAll(matchers...))
+			slices.SortFunc(descriptors, func(a, b bmwcardata.Descriptor) int {
+				return strings.Compare(a.ID, b.ID)
 			})
-			<-client.Done()
-			return nil
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w, "ID\tNAME\tUNIT\tCATEGORY")
+			for _, descriptor := range descriptors {
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", descriptor.ID, descriptor.Name, descriptor.Unit, descriptor.Category)
+			}
+			return w.Flush()
+		},
+		"read-archive": func(ctx context.Context) error {
+			if *extractImage != "" {
+				return extractVehicleImage(*archivePath, *extractImage)
+			}
+			// Stream the output as archives with a long charging history can be large.
+			return bmwcardata.WriteArchiveJSON(os.Stdout, *archivePath)
+		},
+		"stream-telematic-data": func(ctx context.Context) error {
+			subscribed := slices.Clone(vins)
+			if *allVINs && len(subscribed) > 0 {
+				return fmt.Errorf("-vin and -all-vins are mutually exclusive")
+			}
+			if *allVINs {
+				subscribed = []string{bmwcardata.AllVINs}
+			}
+			if len(subscribed) == 0 {
+				return fmt.Errorf("at least one -vin or -all-vins is required")
+			}
+			if *pretty {
+				return streamPretty(ctx, newClient(), subscribed)
+			}
+			// Each message carries its VIN so that the multiplexed output can be told apart.
+			return newClient().StreamToWriter(ctx, os.Stdout, subscribed...)
 		},
 	}
 
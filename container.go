@@ -3,9 +3,15 @@ package bmwcardata
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
 	"net/http"
 	"slices"
+	"strings"
+	"time"
 
+	"github.com/google/uuid"
 	"github.com/tjamet/bmw-cardata/cardataapi"
 )
 
@@ -41,6 +47,24 @@ func MatchVehicleType(vehicleType VehicleType) DescriptorMatcher {
 	})
 }
 
+// MatchVehicleTypes matches descriptors available for any of the provided vehicle types.
+func MatchVehicleTypes(vehicleTypes ...VehicleType) DescriptorMatcher {
+	return DescriptorMatcherFunc(func(container Descriptor) bool {
+		return slices.ContainsFunc(container.VehicleTypes, func(v VehicleType) bool {
+			return slices.Contains(vehicleTypes, v)
+		})
+	})
+}
+
+// MatchBrands matches descriptors available for any of the provided brands.
+func MatchBrands(brands ...Brand) DescriptorMatcher {
+	return DescriptorMatcherFunc(func(container Descriptor) bool {
+		return slices.ContainsFunc(container.Brand, func(b Brand) bool {
+			return slices.Contains(brands, b)
+		})
+	})
+}
+
 func MatchCategory(category string) DescriptorMatcher {
 	return DescriptorMatcherFunc(func(container Descriptor) bool {
 		return container.Category == category
@@ -104,6 +128,54 @@ func FindDescriptors(matcher DescriptorMatcher) []Descriptor {
 	return r
 }
 
+// DescriptorByID returns the descriptor of the catalogue with the given ID.
+func DescriptorByID(id string) (Descriptor, bool) {
+	descriptor, ok := allDescriptors[id]
+	return descriptor, ok
+}
+
+// ContainerDescriptors resolves the technical descriptors of a container against the catalogue,
+// see GetContainerDetails. Descriptors missing from the catalogue only have their ID set.
+func ContainerDescriptors(details *cardataapi.ContainerDetailsDto) []Descriptor {
+	if details == nil || details.TechnicalDescriptors == nil {
+		return []Descriptor{}
+	}
+	descriptors := make([]Descriptor, len(*details.TechnicalDescriptors))
+	for i, id := range *details.TechnicalDescriptors {
+		descriptor, ok := DescriptorByID(id)
+		if !ok {
+			descriptor = Descriptor{ID: id}
+		}
+		descriptors[i] = descriptor
+	}
+	return descriptors
+}
+
+// DiffDescriptors compares the descriptors of an existing container with the desired ones, by ID and
+// regardless of their order, to reconcile a container definition.
+// It returns the desired descriptors missing from current, and the current descriptors that are not desired,
+// each in the order of its input, without duplicates.
+func DiffDescriptors(current, desired []Descriptor) (toAdd, toRemove []Descriptor) {
+	return missingDescriptors(desired, current), missingDescriptors(current, desired)
+}
+
+// missingDescriptors returns the descriptors of from whose ID is not in other.
+func missingDescriptors(from, other []Descriptor) []Descriptor {
+	seen := map[string]bool{}
+	for _, descriptor := range other {
+		seen[descriptor.ID] = true
+	}
+	r := []Descriptor{}
+	for _, descriptor := range from {
+		if seen[descriptor.ID] {
+			continue
+		}
+		seen[descriptor.ID] = true
+		r = append(r, descriptor)
+	}
+	return r
+}
+
 // ListContainers lists all the containers that are available in the BMW CarData API
 // See https://bmw-cardata.bmwgroup.com/customer/public/api-specification#operations-Containers-listContainers
 func (c *Client) ListContainers(ctx context.Context) (*cardataapi.ContainerListDto, error) {
@@ -122,7 +194,7 @@ func (c *Client) ListContainers(ctx context.Context) (*cardataapi.ContainerListD
 		}
 		return &data, nil
 	default:
-		data := cardataapi.CarDataError{}
+		data := cardataapi.CarDataError{StatusCode: resp.StatusCode}
 		err := json.NewDecoder(resp.Body).Decode(&data)
 		if err != nil {
 			return nil, err
@@ -131,6 +203,42 @@ func (c *Client) ListContainers(ctx context.Context) (*cardataapi.ContainerListD
 	}
 }
 
+// ContainerMatcher selects containers listed by ListContainers, see FindContainers.
+type ContainerMatcher func(container cardataapi.ContainerDto) bool
+
+// MatchContainerName matches the containers with the given name.
+func MatchContainerName(name string) ContainerMatcher {
+	return func(container cardataapi.ContainerDto) bool {
+		return container.Name != nil && *container.Name == name
+	}
+}
+
+// MatchContainerPurpose matches the containers with the given purpose.
+func MatchContainerPurpose(purpose string) ContainerMatcher {
+	return func(container cardataapi.ContainerDto) bool {
+		return container.Purpose != nil && *container.Purpose == purpose
+	}
+}
+
+// FindContainers lists the containers and returns the ones selected by the matcher.
+// The filtering is done client-side as the CarData API does not support it.
+func (c *Client) FindContainers(ctx context.Context, matcher ContainerMatcher) ([]cardataapi.ContainerDto, error) {
+	list, err := c.ListContainers(ctx)
+	if err != nil {
+		return nil, err
+	}
+	r := []cardataapi.ContainerDto{}
+	if list.Containers == nil {
+		return r, nil
+	}
+	for _, container := range *list.Containers {
+		if matcher(container) {
+			r = append(r, container)
+		}
+	}
+	return r, nil
+}
+
 // GetContainerDetails gets the details for a given container ID
 // It allows to retrieve all the technical data included in a container.
 // See https://bmw-cardata.bmwgroup.com/customer/public/api-specification#operations-Containers-getContainerDetails
@@ -149,7 +257,7 @@ func (c *Client) GetContainerDetails(ctx context.Context, containerID string) (*
 		}
 		return &data, nil
 	default:
-		data := cardataapi.CarDataError{}
+		data := cardataapi.CarDataError{StatusCode: resp.StatusCode}
 		err := json.NewDecoder(resp.Body).Decode(&data)
 		if err != nil {
 			return nil, err
@@ -158,9 +266,47 @@ func (c *Client) GetContainerDetails(ctx context.Context, containerID string) (*
 	}
 }
 
+// ErrContainerNotStreamable is returned by ValidateStreamingContainer when the container can't feed the event stream.
+var ErrContainerNotStreamable = errors.New("the container is not usable for streaming")
+
+// ValidateStreamingContainer checks the container can feed the event stream, before StartEventStream:
+// it must be active, and all its technical descriptors must be streamable according to the catalogue.
+// A container of non-streamable descriptors is accepted by the API, but yields no stream data.
+// The returned error wraps ErrContainerNotStreamable and lists the non-streamable descriptors.
+// Descriptors missing from the catalogue are not reported, as the catalogue may lag behind the API.
+func (c *Client) ValidateStreamingContainer(ctx context.Context, containerID string) error {
+	details, err := c.GetContainerDetails(ctx, containerID)
+	if err != nil {
+		return err
+	}
+	if details.State != nil && *details.State != cardataapi.ContainerDetailsDtoStateACTIVE {
+		return fmt.Errorf("%w: container %s is in state %s", ErrContainerNotStreamable, containerID, *details.State)
+	}
+	descriptors := ContainerDescriptors(details)
+	if len(descriptors) == 0 {
+		return fmt.Errorf("%w: container %s has no technical descriptor", ErrContainerNotStreamable, containerID)
+	}
+	notStreamable := []string{}
+	for _, descriptor := range descriptors {
+		if _, known := DescriptorByID(descriptor.ID); known && !descriptor.Streamable {
+			notStreamable = append(notStreamable, descriptor.ID)
+		}
+	}
+	if len(notStreamable) > 0 {
+		return fmt.Errorf("%w: container %s has %d non-streamable descriptors: %s", ErrContainerNotStreamable, containerID, len(notStreamable), strings.Join(notStreamable, ", "))
+	}
+	return nil
+}
+
 // CreateContainer creates a new container to pack many technical descriptors.
+// The purpose is a free text description of the container. It must not be empty nor start or end
+// with white spaces, otherwise an error is returned without calling the API.
 // See https://bmw-cardata.bmwgroup.com/customer/public/api-specification#operations-Containers-createContainer
 func (c *Client) CreateContainer(ctx context.Context, name, purpose string, containers []Descriptor) (*cardataapi.CreateContainerResponse, error) {
+	err := validateContainerPurpose(purpose)
+	if err != nil {
+		return nil, err
+	}
 	opts := &cardataapi.CreateContainerJSONRequestBody{}
 	opts.Name = &name
 	opts.Purpose = &purpose
@@ -178,15 +324,15 @@ func (c *Client) CreateContainer(ctx context.Context, name, purpose string, cont
 	}
 	defer resp.Body.Close()
 	switch resp.StatusCode {
-	case http.StatusOK:
-		data := cardataapi.CreateContainerResponse{}
+	case http.StatusOK, http.StatusCreated:
+		data := cardataapi.ContainerDetailsDto{}
 		err := json.NewDecoder(resp.Body).Decode(&data)
 		if err != nil {
 			return nil, err
 		}
-		return &data, nil
+		return &cardataapi.CreateContainerResponse{HTTPResponse: resp, JSON201: &data}, nil
 	default:
-		data := cardataapi.CarDataError{}
+		data := cardataapi.CarDataError{StatusCode: resp.StatusCode}
 		err := json.NewDecoder(resp.Body).Decode(&data)
 		if err != nil {
 			return nil, err
@@ -195,6 +341,19 @@ func (c *Client) CreateContainer(ctx context.Context, name, purpose string, cont
 	}
 }
 
+// validateContainerPurpose rejects purposes BMW would reject with an obscure 400, or store with a typo.
+// The CarData API specification declares the purpose as a free string, without a set of allowed values,
+// hence only its shape is checked.
+func validateContainerPurpose(purpose string) error {
+	if purpose == "" {
+		return errors.New("the container purpose must not be empty")
+	}
+	if strings.TrimSpace(purpose) != purpose {
+		return fmt.Errorf("the container purpose %q must not start or end with spaces", purpose)
+	}
+	return nil
+}
+
 // DeleteContainer deletes a container
 // See https://bmw-cardata.bmwgroup.com/customer/public/api-specification#operations-Containers-deleteContainer
 // BUG(tjamet): DeleteContainer is not working. It always returns a 400 error and needs to be investigated and fixed.
@@ -207,9 +366,9 @@ func (c *Client) DeleteContainer(ctx context.Context, containerID string) (*card
 	switch resp.StatusCode {
 	case http.StatusNoContent:
 		// No body on deletion success
-		return &cardataapi.DeleteContainerResponse{}, nil
+		return &cardataapi.DeleteContainerResponse{HTTPResponse: resp}, nil
 	default:
-		data := cardataapi.CarDataError{}
+		data := cardataapi.CarDataError{StatusCode: resp.StatusCode}
 		err := json.NewDecoder(resp.Body).Decode(&data)
 		if err != nil {
 			return nil, err
@@ -217,3 +376,152 @@ func (c *Client) DeleteContainer(ctx context.Context, containerID string) (*card
 		return nil, &data
 	}
 }
+
+// DeleteContainers deletes the containers selected by the matcher, typically to clean up
+// the containers created by tests. Failing deletions don't stop the others: it returns the IDs
+// of the deleted containers along with the joined errors of the failed ones.
+func (c *Client) DeleteContainers(ctx context.Context, matcher ContainerMatcher) ([]string, error) {
+	containers, err := c.FindContainers(ctx, matcher)
+	if err != nil {
+		return nil, err
+	}
+	deleted := []string{}
+	errs := []error{}
+	for _, container := range containers {
+		if container.ContainerId == nil {
+			continue
+		}
+		_, err := c.DeleteContainer(ctx, *container.ContainerId)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to delete container %s: %w", *container.ContainerId, err))
+			continue
+		}
+		deleted = append(deleted, *container.ContainerId)
+	}
+	return deleted, errors.Join(errs...)
+}
+
+// defaultContainerConsistencyDelay is the default delay between two checks that a freshly created container is available.
+const defaultContainerConsistencyDelay = time.Second
+
+// WithContainerConsistencyDelay is a client option setting the delay between two checks that a container
+// created by ReadTelematicOnce, or recreated by the container watchdog, is available.
+// A zero delay checks again immediately. It defaults to one second.
+func WithContainerConsistencyDelay(delay time.Duration) ClientOption {
+	return func(c *Client) error {
+		if delay < 0 {
+			return fmt.Errorf("the container consistency delay must not be negative, got %s", delay)
+		}
+		c.containerConsistencyDelay = delay
+		return nil
+	}
+}
+
+// containerConsistencyAttempts is the maximum number of checks that a freshly created container is available.
+const containerConsistencyAttempts = 10
+
+// ContainerCleanupError reports that a temporary container could not be deleted once used.
+// The container is left on the account, it can be deleted later on with DeleteContainer or DeleteContainers.
+type ContainerCleanupError struct {
+	ContainerID string
+	Err         error
+}
+
+func (e *ContainerCleanupError) Error() string {
+	return fmt.Sprintf("failed to delete container %s: %s", e.ContainerID, e.Err)
+}
+
+func (e *ContainerCleanupError) Unwrap() error {
+	return e.Err
+}
+
+type readTelematicOnceOptions struct {
+	onCleanupError func(*ContainerCleanupError)
+}
+
+// ReadTelematicOnceOption is an option of ReadTelematicOnce.
+type ReadTelematicOnceOption func(*readTelematicOnceOptions)
+
+// WithCleanupErrorHandler sets the function called when the temporary container of ReadTelematicOnce
+// can't be deleted while the telematic data was read. By default, the failure is logged with slog.
+func WithCleanupErrorHandler(handler func(*ContainerCleanupError)) ReadTelematicOnceOption {
+	return func(o *readTelematicOnceOptions) {
+		o.onCleanupError = handler
+	}
+}
+
+// ReadTelematicOnce reads the telematic data for the given descriptors using a temporary container.
+// It creates the container, waits for it to be available, reads the telematic data and deletes the container.
+// The container is deleted even when reading the data fails.
+// Failing to delete the container does not fail the read: the data is returned along with a nil error,
+// and the failure is reported to the WithCleanupErrorHandler handler. When the read fails as well,
+// the returned error also wraps the *ContainerCleanupError.
+func (c *Client) ReadTelematicOnce(ctx context.Context, vin string, descriptors []Descriptor, options ...ReadTelematicOnceOption) (_ *cardataapi.ExVeTelematicDataResponseDto, err error) {
+	o := readTelematicOnceOptions{onCleanupError: logCleanupError}
+	for _, option := range options {
+		option(&o)
+	}
+	// Validate the VIN before creating a container that would be useless.
+	vin, err = c.normalizeVIN(vin)
+	if err != nil {
+		return nil, err
+	}
+	created, err := c.CreateContainer(ctx, "bmw-cardata-"+uuid.New().String(), "one-shot telematic data read", descriptors)
+	if err != nil {
+		return nil, err
+	}
+	if created.JSON201 == nil || created.JSON201.ContainerId == nil {
+		return nil, fmt.Errorf("the created container has no ID")
+	}
+	containerID := *created.JSON201.ContainerId
+	defer func() {
+		// Clean up even if the context was cancelled to avoid leaking containers.
+		_, deleteErr := c.DeleteContainer(context.WithoutCancel(ctx), containerID)
+		if deleteErr == nil {
+			return
+		}
+		cleanupErr := &ContainerCleanupError{ContainerID: containerID, Err: deleteErr}
+		if err != nil {
+			err = errors.Join(err, cleanupErr)
+			return
+		}
+		if o.onCleanupError != nil {
+			o.onCleanupError(cleanupErr)
+		}
+	}()
+	err = c.waitForContainer(ctx, c.getClock(), containerID)
+	if err != nil {
+		return nil, err
+	}
+	return c.GetTelematicData(ctx, vin, containerID)
+}
+
+// logCleanupError is the default handler of the failures to delete a temporary container.
+func logCleanupError(err *ContainerCleanupError) {
+	slog.Warn("failed to delete the temporary container", slog.String("container_id", err.ContainerID), slog.Any("error", err.Err))
+}
+
+// waitForContainer waits until the container details are available and the container is active.
+// The delay between two checks is timed by clock.
+func (c *Client) waitForContainer(ctx context.Context, clock Clock, containerID string) error {
+	var err error
+	for attempt := 0; attempt < containerConsistencyAttempts; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-clock.After(c.containerConsistencyDelay):
+			}
+		}
+		var details *cardataapi.ContainerDetailsDto
+		details, err = c.GetContainerDetails(ctx, containerID)
+		if err != nil {
+			continue
+		}
+		if details.State == nil || *details.State == cardataapi.ContainerDetailsDtoStateACTIVE {
+			return nil
+		}
+		err = fmt.Errorf("container %s is in state %s", containerID, *details.State)
+	}
+	return fmt.Errorf("container %s is not available: %w", containerID, err)
+}
@@ -2,10 +2,15 @@ package bmwcardata
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
+	"reflect"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/tjamet/bmw-cardata/cardataapi"
 )
@@ -124,6 +129,131 @@ func TestDeleteContainer_DecodeFailureOnErrorBody(t *testing.T) {
 	}
 }
 
+// One-shot telematic reads
+
+func TestReadTelematicOnce(t *testing.T) {
+	ctx := context.Background()
+	deleted := ""
+	detailsCalls := 0
+	mock := &mockCardataClient{
+		CreateContainerFunc: func(ctx context.Context, body cardataapi.CreateContainerJSONRequestBody, _ ...cardataapi.RequestEditorFn) (*http.Response, error) {
+			if len(*body.TechnicalDescriptors) != 1 || (*body.TechnicalDescriptors)[0] != "vehicle.cabin.door.status" {
+				t.Fatalf("unexpected descriptors %v", *body.TechnicalDescriptors)
+			}
+			return jsonResponse(http.StatusCreated, cardataapi.ContainerDetailsDto{ContainerId: p("CID")}, nil), nil
+		},
+		GetContainerDetailsFunc: func(ctx context.Context, containerId string, params *cardataapi.GetContainerDetailsParams, _ ...cardataapi.RequestEditorFn) (*http.Response, error) {
+			detailsCalls++
+			if detailsCalls == 1 {
+				return jsonResponse(http.StatusNotFound, cardataapi.CarDataError{}, nil), nil
+			}
+			return jsonResponse(http.StatusOK, cardataapi.ContainerDetailsDto{ContainerId: p("CID"), State: p(cardataapi.ContainerDetailsDtoStateACTIVE)}, nil), nil
+		},
+		GetTelematicDataFunc: func(ctx context.Context, vin string, params *cardataapi.GetTelematicDataParams, _ ...cardataapi.RequestEditorFn) (*http.Response, error) {
+			if params.ContainerId != "CID" {
+				t.Fatalf("expected container CID, got %s", params.ContainerId)
+			}
+			return jsonResponse(http.StatusOK, cardataapi.ExVeTelematicDataResponseDto{}, nil), nil
+		},
+		DeleteContainerFunc: func(ctx context.Context, containerId string, _ ...cardataapi.RequestEditorFn) (*http.Response, error) {
+			deleted = containerId
+			return bytesResponse(http.StatusNoContent, nil, nil), nil
+		},
+	}
+	c := &Client{carDataAPI: mock}
+	data, err := c.ReadTelematicOnce(ctx, "VIN", []Descriptor{{ID: "vehicle.cabin.door.status"}})
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	if data == nil {
+		t.Fatal("expected data, got nil")
+	}
+	if detailsCalls != 2 {
+		t.Fatalf("expected to wait for the container to be available, got %d details calls", detailsCalls)
+	}
+	if deleted != "CID" {
+		t.Fatalf("expected container CID to be deleted, got %q", deleted)
+	}
+}
+
+func TestReadTelematicOnce_CleansUpOnError(t *testing.T) {
+	ctx := context.Background()
+	deleted := ""
+	mock := &mockCardataClient{
+		CreateContainerFunc: func(ctx context.Context, body cardataapi.CreateContainerJSONRequestBody, _ ...cardataapi.RequestEditorFn) (*http.Response, error) {
+			return jsonResponse(http.StatusCreated, cardataapi.ContainerDetailsDto{ContainerId: p("CID")}, nil), nil
+		},
+		GetContainerDetailsFunc: func(ctx context.Context, containerId string, params *cardataapi.GetContainerDetailsParams, _ ...cardataapi.RequestEditorFn) (*http.Response, error) {
+			return jsonResponse(http.StatusOK, cardataapi.ContainerDetailsDto{ContainerId: p("CID")}, nil), nil
+		},
+		GetTelematicDataFunc: func(ctx context.Context, vin string, params *cardataapi.GetTelematicDataParams, _ ...cardataapi.RequestEditorFn) (*http.Response, error) {
+			return jsonResponse(http.StatusForbidden, cardataapi.CarDataError{}, nil), nil
+		},
+		DeleteContainerFunc: func(ctx context.Context, containerId string, _ ...cardataapi.RequestEditorFn) (*http.Response, error) {
+			deleted = containerId
+			return bytesResponse(http.StatusNoContent, nil, nil), nil
+		},
+	}
+	c := &Client{carDataAPI: mock}
+	_, err := c.ReadTelematicOnce(ctx, "VIN", []Descriptor{{ID: "vehicle.cabin.door.status"}})
+	if _, ok := err.(*cardataapi.CarDataError); !ok {
+		t.Fatalf("expected CarDataError, got %T", err)
+	}
+	if deleted != "CID" {
+		t.Fatalf("expected container CID to be deleted, got %q", deleted)
+	}
+}
+
+func TestReadTelematicOnce_CleanupFailure(t *testing.T) {
+	ctx := context.Background()
+	readErr := error(nil)
+	mock := &mockCardataClient{
+		CreateContainerFunc: func(ctx context.Context, body cardataapi.CreateContainerJSONRequestBody, _ ...cardataapi.RequestEditorFn) (*http.Response, error) {
+			return jsonResponse(http.StatusCreated, cardataapi.ContainerDetailsDto{ContainerId: p("CID")}, nil), nil
+		},
+		GetContainerDetailsFunc: func(ctx context.Context, containerId string, params *cardataapi.GetContainerDetailsParams, _ ...cardataapi.RequestEditorFn) (*http.Response, error) {
+			return jsonResponse(http.StatusOK, cardataapi.ContainerDetailsDto{ContainerId: p("CID")}, nil), nil
+		},
+		GetTelematicDataFunc: func(ctx context.Context, vin string, params *cardataapi.GetTelematicDataParams, _ ...cardataapi.RequestEditorFn) (*http.Response, error) {
+			if readErr != nil {
+				return nil, readErr
+			}
+			return jsonResponse(http.StatusOK, cardataapi.ExVeTelematicDataResponseDto{}, nil), nil
+		},
+		DeleteContainerFunc: func(ctx context.Context, containerId string, _ ...cardataapi.RequestEditorFn) (*http.Response, error) {
+			return jsonResponse(http.StatusBadRequest, cardataapi.CarDataError{}, nil), nil
+		},
+	}
+	c := &Client{carDataAPI: mock}
+	var reported *ContainerCleanupError
+	handler := WithCleanupErrorHandler(func(err *ContainerCleanupError) { reported = err })
+	data, err := c.ReadTelematicOnce(ctx, "VIN", []Descriptor{{ID: "vehicle.cabin.door.status"}}, handler)
+	if err != nil {
+		t.Fatalf("the cleanup failure must not fail the read, got %v", err)
+	}
+	if data == nil {
+		t.Fatal("expected data, got nil")
+	}
+	if reported == nil || reported.ContainerID != "CID" {
+		t.Fatalf("expected the cleanup failure of container CID to be reported, got %v", reported)
+	}
+	carDataErr := &cardataapi.CarDataError{}
+	if !errors.As(reported, &carDataErr) {
+		t.Fatalf("expected the cleanup failure to wrap the deletion error, got %v", reported)
+	}
+
+	reported = nil
+	readErr = errors.New("read failed")
+	_, err = c.ReadTelematicOnce(ctx, "VIN", []Descriptor{{ID: "vehicle.cabin.door.status"}}, handler)
+	cleanupErr := &ContainerCleanupError{}
+	if !errors.Is(err, readErr) || !errors.As(err, &cleanupErr) {
+		t.Fatalf("expected both the read and the cleanup errors, got %v", err)
+	}
+	if reported != nil {
+		t.Fatal("the cleanup failure must only be returned when the read fails")
+	}
+}
+
 // Matcher helpers and descriptor filtering
 
 func TestDescriptorMatchers_Basic(t *testing.T) {
@@ -196,6 +326,33 @@ func TestDescriptorMatchers_Combinators(t *testing.T) {
 	}
 }
 
+func TestDescriptorMatchers_AnyOf(t *testing.T) {
+	// MatchVehicleTypes and MatchBrands are shorthands for MatchAny compositions
+	anyVehicleType := MatchAny(MatchVehicleType(VehicleTypeBEV), MatchVehicleType(VehicleTypePHEV))
+	anyBrand := MatchAny(MatchBrand(BrandBMW), MatchBrand(Brand("MINI")))
+	for _, d := range []Descriptor{
+		{VehicleTypes: []VehicleType{VehicleTypeBEV}, Brand: []Brand{BrandBMW}},
+		{VehicleTypes: []VehicleType{VehicleTypeICE, VehicleTypePHEV}, Brand: []Brand{"MINI"}},
+		{VehicleTypes: []VehicleType{VehicleTypeICE}, Brand: []Brand{"ROLLS-ROYCE"}},
+		{},
+	} {
+		if MatchVehicleTypes(VehicleTypeBEV, VehicleTypePHEV).Match(d) != anyVehicleType.Match(d) {
+			t.Fatalf("MatchVehicleTypes differs from the MatchAny composition for %#v", d)
+		}
+		if MatchBrands(BrandBMW, "MINI").Match(d) != anyBrand.Match(d) {
+			t.Fatalf("MatchBrands differs from the MatchAny composition for %#v", d)
+		}
+	}
+
+	// The generated catalogue must give the same results as well
+	if len(FindDescriptors(MatchVehicleTypes(VehicleTypeBEV, VehicleTypePHEV))) != len(FindDescriptors(anyVehicleType)) {
+		t.Fatal("MatchVehicleTypes differs from the MatchAny composition on the catalogue")
+	}
+	if MatchVehicleTypes().Match(Descriptor{VehicleTypes: []VehicleType{VehicleTypeBEV}}) {
+		t.Fatal("MatchVehicleTypes with no vehicle types should not match")
+	}
+}
+
 func TestFindDescriptors(t *testing.T) {
 	// Always-true matcher should return at least one descriptor from the generated catalogue
 	results := FindDescriptors(DescriptorMatcherFunc(func(container Descriptor) bool { return true }))
@@ -210,15 +367,27 @@ func TestFindDescriptors(t *testing.T) {
 	}
 }
 
+func TestDescriptorByID(t *testing.T) {
+	descriptor, ok := DescriptorByID("vehicle.body.chargingPort.combinedStatus")
+	if !ok {
+		t.Fatal("expected descriptor to be found")
+	}
+	if descriptor.Name != "Charging port connection status" {
+		t.Fatalf("unexpected descriptor name %q", descriptor.Name)
+	}
+	if _, ok := DescriptorByID("unknown"); ok {
+		t.Fatal("expected unknown descriptor not to be found")
+	}
+}
+
 func ExampleClient_ListContainers() {
 	client := Must(NewClient(
+		WithHTTPTransport(fakeServerTransport),
 		WithAuthenticator(
 			Must(NewAuthenticator(
 				WithClientID(clientID),
-				WithPromptURI(func(verificationURI, userCode, verificationURIComplete string) {
-					fmt.Printf("Open %s and enter code %s\n", verificationURI, userCode)
-					fmt.Printf("Direct link: %s\n", verificationURIComplete)
-				}),
+				WithPromptURI(PromptStdout),
+				WithAuthClientOptions(WithAuthHTTPTransport(fakeServerTransport)),
 			)),
 		)),
 	)
@@ -237,13 +406,12 @@ func ExampleClient_ListContainers() {
 
 func ExampleClient_CreateContainer() {
 	client := Must(NewClient(
+		WithHTTPTransport(fakeServerTransport),
 		WithAuthenticator(
 			Must(NewAuthenticator(
 				WithClientID(clientID),
-				WithPromptURI(func(verificationURI, userCode, verificationURIComplete string) {
-					fmt.Printf("Open %s and enter code %s\n", verificationURI, userCode)
-					fmt.Printf("Direct link: %s\n", verificationURIComplete)
-				}),
+				WithPromptURI(PromptStdout),
+				WithAuthClientOptions(WithAuthHTTPTransport(fakeServerTransport)),
 			)),
 		)),
 	)
@@ -269,3 +437,234 @@ func ExampleClient_CreateContainer() {
 	// Direct link: https://example.com?code=123456
 	// Containers: 123456
 }
+
+func TestFindContainers(t *testing.T) {
+	ctx := context.Background()
+	mock := &mockCardataClient{
+		ListContainersFunc: func(ctx context.Context, params *cardataapi.ListContainersParams, _ ...cardataapi.RequestEditorFn) (*http.Response, error) {
+			return jsonResponse(http.StatusOK, cardataapi.ContainerListDto{Containers: &[]cardataapi.ContainerDto{
+				{ContainerId: p("1"), Name: p("streaming"), Purpose: p("dashboard")},
+				{ContainerId: p("2"), Name: p("one-shot"), Purpose: p("dashboard")},
+				{ContainerId: p("3")},
+			}}, nil), nil
+		},
+	}
+	c := &Client{carDataAPI: mock}
+
+	found, err := c.FindContainers(ctx, MatchContainerName("streaming"))
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	if len(found) != 1 || *found[0].ContainerId != "1" {
+		t.Fatalf("expected container 1, got %v", found)
+	}
+
+	found, err = c.FindContainers(ctx, MatchContainerPurpose("dashboard"))
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	if len(found) != 2 {
+		t.Fatalf("expected 2 containers, got %d", len(found))
+	}
+
+	found, err = c.FindContainers(ctx, MatchContainerName("missing"))
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	if len(found) != 0 {
+		t.Fatalf("expected no container, got %v", found)
+	}
+}
+
+func TestDeleteContainers(t *testing.T) {
+	ctx := context.Background()
+	attempted := []string{}
+	mock := &mockCardataClient{
+		ListContainersFunc: func(ctx context.Context, params *cardataapi.ListContainersParams, _ ...cardataapi.RequestEditorFn) (*http.Response, error) {
+			return jsonResponse(http.StatusOK, cardataapi.ContainerListDto{Containers: &[]cardataapi.ContainerDto{
+				{ContainerId: p("1"), Name: p("test")},
+				{ContainerId: p("2"), Name: p("test")},
+				{ContainerId: p("3"), Name: p("test")},
+				{ContainerId: p("4"), Name: p("production")},
+			}}, nil), nil
+		},
+		DeleteContainerFunc: func(ctx context.Context, containerId string, _ ...cardataapi.RequestEditorFn) (*http.Response, error) {
+			attempted = append(attempted, containerId)
+			if containerId == "2" {
+				return jsonResponse(http.StatusBadRequest, cardataapi.CarDataError{ExveErrorMsg: p("bad request")}, nil), nil
+			}
+			return bytesResponse(http.StatusNoContent, nil, nil), nil
+		},
+	}
+	c := &Client{carDataAPI: mock}
+
+	deleted, err := c.DeleteContainers(ctx, MatchContainerName("test"))
+	if err == nil || !strings.Contains(err.Error(), "failed to delete container 2") {
+		t.Fatalf("expected the failed deletion to be reported, got %v", err)
+	}
+	if !reflect.DeepEqual(attempted, []string{"1", "2", "3"}) {
+		t.Fatalf("expected every matching container to be deleted, got %v", attempted)
+	}
+	if !reflect.DeepEqual(deleted, []string{"1", "3"}) {
+		t.Fatalf("expected containers 1 and 3 to be deleted, got %v", deleted)
+	}
+}
+
+func TestCreateContainer_InvalidPurpose(t *testing.T) {
+	ctx := context.Background()
+	mock := &mockCardataClient{
+		CreateContainerFunc: func(ctx context.Context, body cardataapi.CreateContainerJSONRequestBody, _ ...cardataapi.RequestEditorFn) (*http.Response, error) {
+			t.Fatal("invalid purposes must not be sent to BMW")
+			return nil, nil
+		},
+	}
+	c := &Client{carDataAPI: mock}
+	for _, purpose := range []string{"", "streaming ", " streaming", "\tstreaming"} {
+		_, err := c.CreateContainer(ctx, "name", purpose, nil)
+		if err == nil {
+			t.Fatalf("expected an error for purpose %q", purpose)
+		}
+	}
+}
+
+func TestContainerDescriptors(t *testing.T) {
+	descriptors := ContainerDescriptors(&cardataapi.ContainerDetailsDto{
+		TechnicalDescriptors: &[]string{"vehicle.cabin.door.status", "vehicle.unknown"},
+	})
+	if len(descriptors) != 2 {
+		t.Fatalf("expected 2 descriptors, got %d", len(descriptors))
+	}
+	if descriptors[0].ID != "vehicle.cabin.door.status" || descriptors[0].Name == "" {
+		t.Fatalf("expected the catalogue descriptor, got %+v", descriptors[0])
+	}
+	if descriptors[1].ID != "vehicle.unknown" || descriptors[1].Name != "" {
+		t.Fatalf("expected a descriptor with only the ID, got %+v", descriptors[1])
+	}
+	if len(ContainerDescriptors(&cardataapi.ContainerDetailsDto{})) != 0 {
+		t.Fatal("expected no descriptor without technical descriptors")
+	}
+}
+
+func TestValidateStreamingContainer(t *testing.T) {
+	ctx := context.Background()
+	active, deleted := cardataapi.ContainerDetailsDtoStateACTIVE, cardataapi.ContainerDetailsDtoStateDELETED
+	details := map[string]cardataapi.ContainerDetailsDto{
+		"streamable":     {State: &active, TechnicalDescriptors: &[]string{"vehicle.cabin.door.status", "vehicle.unknown"}},
+		"not-streamable": {State: &active, TechnicalDescriptors: &[]string{"vehicle.cabin.door.status", "vehicle.privacySettings.dataCollection.regulations.obfcm"}},
+		"deleted":        {State: &deleted, TechnicalDescriptors: &[]string{"vehicle.cabin.door.status"}},
+		"empty":          {State: &active},
+	}
+	mock := &mockCardataClient{
+		GetContainerDetailsFunc: func(ctx context.Context, containerId string, params *cardataapi.GetContainerDetailsParams, _ ...cardataapi.RequestEditorFn) (*http.Response, error) {
+			if d, ok := details[containerId]; ok {
+				return jsonResponse(http.StatusOK, d, nil), nil
+			}
+			msg := "not found"
+			return jsonResponse(http.StatusNotFound, cardataapi.CarDataError{ExveErrorMsg: &msg}, nil), nil
+		},
+	}
+	c := &Client{carDataAPI: mock}
+
+	if err := c.ValidateStreamingContainer(ctx, "streamable"); err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	err := c.ValidateStreamingContainer(ctx, "not-streamable")
+	if !errors.Is(err, ErrContainerNotStreamable) {
+		t.Fatalf("expected ErrContainerNotStreamable, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "vehicle.privacySettings.dataCollection.regulations.obfcm") || strings.Contains(err.Error(), "vehicle.cabin.door.status") {
+		t.Fatalf("expected only the non-streamable descriptor to be listed, got %v", err)
+	}
+	for _, containerID := range []string{"deleted", "empty"} {
+		if err := c.ValidateStreamingContainer(ctx, containerID); !errors.Is(err, ErrContainerNotStreamable) {
+			t.Fatalf("expected ErrContainerNotStreamable for the %s container, got %v", containerID, err)
+		}
+	}
+	err = c.ValidateStreamingContainer(ctx, "missing")
+	if _, ok := err.(*cardataapi.CarDataError); !ok {
+		t.Fatalf("expected CarDataError, got %T", err)
+	}
+}
+
+func TestDiffDescriptors(t *testing.T) {
+	ids := func(descriptors []Descriptor) []string {
+		r := []string{}
+		for _, descriptor := range descriptors {
+			r = append(r, descriptor.ID)
+		}
+		return r
+	}
+	current := []Descriptor{{ID: "a"}, {ID: "b"}, {ID: "c"}}
+	desired := []Descriptor{{ID: "d"}, {ID: "c", Name: "other"}, {ID: "a"}, {ID: "d"}}
+
+	toAdd, toRemove := DiffDescriptors(current, desired)
+	if got := ids(toAdd); !reflect.DeepEqual(got, []string{"d"}) {
+		t.Fatalf("expected to add [d], got %v", got)
+	}
+	if got := ids(toRemove); !reflect.DeepEqual(got, []string{"b"}) {
+		t.Fatalf("expected to remove [b], got %v", got)
+	}
+
+	toAdd, toRemove = DiffDescriptors(current, []Descriptor{{ID: "c"}, {ID: "b"}, {ID: "a"}})
+	if len(toAdd) != 0 || len(toRemove) != 0 {
+		t.Fatalf("expected no difference regardless of the order, got %v and %v", ids(toAdd), ids(toRemove))
+	}
+
+	toAdd, toRemove = DiffDescriptors(nil, current)
+	if got := ids(toAdd); !reflect.DeepEqual(got, []string{"a", "b", "c"}) || len(toRemove) != 0 {
+		t.Fatalf("expected to add all the descriptors, got %v and %v", got, ids(toRemove))
+	}
+}
+
+func TestWithContainerConsistencyDelay(t *testing.T) {
+	_, err := NewClient(WithCarDataAPI(&mockCardataClient{}), WithAuthenticator(&staticAuthenticator{}), WithContainerConsistencyDelay(-1))
+	if err == nil {
+		t.Fatal("expected an error on negative delays, got nil")
+	}
+	c, err := NewClient(WithCarDataAPI(&mockCardataClient{}), WithAuthenticator(&staticAuthenticator{}))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if c.containerConsistencyDelay != defaultContainerConsistencyDelay {
+		t.Fatalf("expected the default delay, got %s", c.containerConsistencyDelay)
+	}
+	c, err = NewClient(WithCarDataAPI(&mockCardataClient{}), WithAuthenticator(&staticAuthenticator{}), WithContainerConsistencyDelay(0))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if c.containerConsistencyDelay != 0 {
+		t.Fatalf("expected no delay, got %s", c.containerConsistencyDelay)
+	}
+}
+
+func TestWaitForContainer_Clock(t *testing.T) {
+	var m sync.Mutex
+	calls := 0
+	mock := &mockCardataClient{
+		GetContainerDetailsFunc: func(ctx context.Context, containerId string, params *cardataapi.GetContainerDetailsParams, _ ...cardataapi.RequestEditorFn) (*http.Response, error) {
+			m.Lock()
+			defer m.Unlock()
+			calls++
+			if calls == 1 {
+				return jsonResponse(http.StatusNotFound, cardataapi.CarDataError{}, nil), nil
+			}
+			return jsonResponse(http.StatusOK, cardataapi.ContainerDetailsDto{ContainerId: p(containerId)}, nil), nil
+		},
+	}
+	clock := newFakeClock()
+	c := &Client{carDataAPI: mock, containerConsistencyDelay: time.Minute}
+	done := make(chan error)
+	go func() {
+		done <- c.waitForContainer(context.Background(), clock, "CID")
+	}()
+	clock.waitTimers(t, 1)
+	select {
+	case err := <-done:
+		t.Fatalf("the container must be checked again once the delay elapsed, returned %v", err)
+	default:
+	}
+	clock.Advance(time.Minute)
+	if err := <-done; err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,65 @@
+package bmwcardata
+
+import (
+	"container/list"
+	"fmt"
+	"sync"
+)
+
+// WithDedup is a client option that drops the streamed messages already received, before invoking
+// the callbacks. MQTT QoS 1 delivers messages at least once, hence duplicates may be received,
+// typically after reconnecting.
+// Messages are identified by their VIN, topic and timestamp, the size most recent ones are remembered.
+// Messages without timestamp are never dropped.
+func WithDedup(size int) ClientOption {
+	return func(c *Client) error {
+		if size <= 0 {
+			return fmt.Errorf("the deduplication size must be positive, got %d", size)
+		}
+		c.dedup = newMessageDeduplicator(size)
+		return nil
+	}
+}
+
+// messageDeduplicator is a bounded LRU set of the keys of the received messages.
+type messageDeduplicator struct {
+	m     sync.Mutex
+	size  int
+	order *list.List
+	keys  map[messageKey]*list.Element
+}
+
+type messageKey struct {
+	vin       string
+	topic     string
+	timestamp string
+}
+
+func newMessageDeduplicator(size int) *messageDeduplicator {
+	return &messageDeduplicator{
+		size:  size,
+		order: list.New(),
+		keys:  map[messageKey]*list.Element{},
+	}
+}
+
+// duplicate records the message and reports whether it was already received.
+func (d *messageDeduplicator) duplicate(message StreamedMessage) bool {
+	if message.Timestamp == "" {
+		return false
+	}
+	key := messageKey{vin: message.VIN, topic: message.Topic, timestamp: message.Timestamp}
+	d.m.Lock()
+	defer d.m.Unlock()
+	if element, ok := d.keys[key]; ok {
+		d.order.MoveToFront(element)
+		return true
+	}
+	d.keys[key] = d.order.PushFront(key)
+	if d.order.Len() > d.size {
+		oldest := d.order.Back()
+		d.order.Remove(oldest)
+		delete(d.keys, oldest.Value.(messageKey))
+	}
+	return false
+}
@@ -0,0 +1,51 @@
+package bmwcardata
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/eclipse/paho.golang/paho"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWithDedup(t *testing.T) {
+	_, err := NewClient(WithCarDataAPI(&mockCardataClient{}), WithAuthenticator(&staticAuthenticator{}), WithDedup(0))
+	require.Error(t, err)
+
+	c, err := NewClient(WithCarDataAPI(&mockCardataClient{}), WithAuthenticator(&staticAuthenticator{}), WithDedup(10))
+	require.NoError(t, err)
+	m := &streamingManager{subscriptions: &c.subscriptions, dedup: c.dedup}
+	c.streaming.Store(m)
+	received := atomic.Int32{}
+	_, err = c.Subscribe(context.Background(), "VIN123", func(message StreamedMessage) { received.Add(1) })
+	require.NoError(t, err)
+
+	payload := []byte(`{"vin":"VIN123","topic":"gcid/VIN123","timestamp":"2025-01-01T12:00:00Z"}`)
+	for i := 0; i < 2; i++ {
+		_, err = m.handlePahoPublishReceived(paho.PublishReceived{Packet: &paho.Publish{Payload: payload}})
+		require.NoError(t, err)
+	}
+	require.Eventually(t, func() bool { return received.Load() == 1 }, time.Second, time.Millisecond)
+	time.Sleep(10 * time.Millisecond)
+	assert.Equal(t, int32(1), received.Load(), "duplicated messages must be dropped")
+}
+
+func TestMessageDeduplicator(t *testing.T) {
+	d := newMessageDeduplicator(2)
+	first := StreamedMessage{VIN: "VIN123", Timestamp: "1"}
+	second := StreamedMessage{VIN: "VIN123", Timestamp: "2"}
+	third := StreamedMessage{VIN: "VIN123", Timestamp: "3"}
+
+	assert.False(t, d.duplicate(first))
+	assert.False(t, d.duplicate(second))
+	assert.True(t, d.duplicate(first), "recently received messages are duplicates")
+	assert.False(t, d.duplicate(third), "the least recently received message must be evicted")
+	assert.False(t, d.duplicate(second), "evicted messages are not duplicates anymore")
+	assert.False(t, d.duplicate(StreamedMessage{VIN: "OTHER", Timestamp: "3"}), "the VIN is part of the key")
+
+	assert.False(t, d.duplicate(StreamedMessage{VIN: "VIN123"}))
+	assert.False(t, d.duplicate(StreamedMessage{VIN: "VIN123"}), "messages without timestamp must not be dropped")
+}
@@ -0,0 +1,41 @@
+package bmwcardata
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/tjamet/bmw-cardata/auth"
+	"github.com/tjamet/bmw-cardata/cardataapi"
+)
+
+// StatusCode returns the HTTP status of the response an error was returned for, whether it comes
+// from the authentication API, as an *auth.AuthError, or from the CarData API, as a *cardataapi.CarDataError.
+// It returns false when err is not, and does not wrap, one of them.
+func StatusCode(err error) (int, bool) {
+	var authErr *auth.AuthError
+	if errors.As(err, &authErr) && authErr.StatusCode != 0 {
+		return authErr.StatusCode, true
+	}
+	var carDataErr *cardataapi.CarDataError
+	if errors.As(err, &carDataErr) && carDataErr.StatusCode != 0 {
+		return carDataErr.StatusCode, true
+	}
+	return 0, false
+}
+
+// IsRetryable reports whether the request that failed with err may succeed when sent again,
+// for errors of both the authentication and CarData APIs: on request timeouts, rate limiting
+// and server errors. Other errors, like invalid requests or missing permissions, are not retryable.
+func IsRetryable(err error) bool {
+	status, ok := StatusCode(err)
+	if !ok {
+		return false
+	}
+	switch {
+	case status == http.StatusRequestTimeout, status == http.StatusTooManyRequests:
+		return true
+	case status >= http.StatusInternalServerError && status != http.StatusNotImplemented:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,64 @@
+package bmwcardata
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tjamet/bmw-cardata/auth"
+	"github.com/tjamet/bmw-cardata/cardataapi"
+)
+
+func TestStatusCode(t *testing.T) {
+	status, ok := StatusCode(fmt.Errorf("refresh failed: %w", &auth.AuthError{StatusCode: http.StatusBadRequest, Err: "invalid_grant"}))
+	assert.True(t, ok)
+	assert.Equal(t, http.StatusBadRequest, status)
+
+	status, ok = StatusCode(fmt.Errorf("wrapped: %w", &cardataapi.CarDataError{StatusCode: http.StatusForbidden}))
+	assert.True(t, ok)
+	assert.Equal(t, http.StatusForbidden, status)
+
+	_, ok = StatusCode(context.DeadlineExceeded)
+	assert.False(t, ok)
+	_, ok = StatusCode(nil)
+	assert.False(t, ok)
+}
+
+func TestIsRetryable(t *testing.T) {
+	for status, retryable := range map[int]bool{
+		http.StatusBadRequest:          false,
+		http.StatusUnauthorized:        false,
+		http.StatusForbidden:           false,
+		http.StatusNotFound:            false,
+		http.StatusRequestTimeout:      true,
+		http.StatusTooManyRequests:     true,
+		http.StatusInternalServerError: true,
+		http.StatusNotImplemented:      false,
+		http.StatusServiceUnavailable:  true,
+	} {
+		assert.Equal(t, retryable, IsRetryable(&auth.AuthError{StatusCode: status}), "auth error %d", status)
+		assert.Equal(t, retryable, IsRetryable(&cardataapi.CarDataError{StatusCode: status}), "CarData error %d", status)
+	}
+	assert.False(t, IsRetryable(context.Canceled))
+}
+
+func TestCarDataErrorStatusCode(t *testing.T) {
+	c, err := NewClient(
+		WithAuthenticator(&staticAuthenticator{session: &AuthenticatedSession{AccessToken: "token"}}),
+		WithCarDataAPI(&mockCardataClient{
+			GetBasicDataFunc: func(ctx context.Context, vin string, params *cardataapi.GetBasicDataParams, reqEditors ...cardataapi.RequestEditorFn) (*http.Response, error) {
+				return jsonResponse(http.StatusTooManyRequests, cardataapi.CarDataError{ExveErrorMsg: p("quota exceeded")}, nil), nil
+			},
+		}),
+	)
+	require.NoError(t, err)
+	_, err = c.GetBasicData(context.Background(), "WBA00000000000000")
+	require.Error(t, err)
+	status, ok := StatusCode(err)
+	assert.True(t, ok)
+	assert.Equal(t, http.StatusTooManyRequests, status)
+	assert.True(t, IsRetryable(err))
+}
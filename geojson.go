@@ -0,0 +1,109 @@
+package bmwcardata
+
+import (
+	"encoding/json"
+	"io"
+	"slices"
+)
+
+// geoJSONFeatureCollection is a minimal GeoJSON (RFC 7946) feature collection
+type geoJSONFeatureCollection struct {
+	Type     string           `json:"type"`
+	Features []geoJSONFeature `json:"features"`
+}
+
+type geoJSONFeature struct {
+	Type       string          `json:"type"`
+	Geometry   geoJSONGeometry `json:"geometry"`
+	Properties map[string]any  `json:"properties"`
+}
+
+// geoJSONGeometry holds either a Point ([lng, lat]) or a LineString ([][lng, lat]) coordinates
+type geoJSONGeometry struct {
+	Type        string `json:"type"`
+	Coordinates any    `json:"coordinates"`
+}
+
+func newGeoJSONPoint(latitude, longitude float64, properties map[string]any) geoJSONFeature {
+	return geoJSONFeature{
+		Type:       "Feature",
+		Geometry:   geoJSONGeometry{Type: "Point", Coordinates: []float64{longitude, latitude}},
+		Properties: properties,
+	}
+}
+
+func writeGeoJSON(w io.Writer, features []geoJSONFeature) error {
+	return json.NewEncoder(w).Encode(geoJSONFeatureCollection{Type: "FeatureCollection", Features: features})
+}
+
+// WriteChargingHistoryGeoJSON writes the charging sessions as a GeoJSON FeatureCollection,
+// with a Point per charging location, to visualize where the vehicle charges.
+// Sessions without charging location coordinates are skipped.
+func WriteChargingHistoryGeoJSON(w io.Writer, sessions []ChargingSessionArchive) error {
+	features := []geoJSONFeature{}
+	for _, session := range sessions {
+		location := session.ChargingLocation
+		if location == nil || (location.MapMatchedLatitude == 0 && location.MapMatchedLongitude == 0) {
+			continue
+		}
+		properties := map[string]any{
+			"startTime":                      session.StartTime,
+			"endTime":                        session.EndTime,
+			"timeZone":                       session.TimeZone,
+			"energyConsumedFromPowerGridKwh": session.EnergyConsumedFromPowerGridKwh,
+			"address":                        location.FormattedAddress,
+		}
+		if session.ChargingCostInformation != nil {
+			properties["cost"] = session.ChargingCostInformation.CalculatedChargingCost
+			properties["currency"] = session.ChargingCostInformation.Currency
+		}
+		features = append(features, newGeoJSONPoint(location.MapMatchedLatitude, location.MapMatchedLongitude, properties))
+	}
+	return writeGeoJSON(w, features)
+}
+
+// WriteNavigationGeoJSON writes the adaptive navigation data as a GeoJSON FeatureCollection,
+// with a LineString per learned route, following its segments in order, and a Point per learned place center.
+// Locations without coordinates are ignored, as well as routes with less than two locations.
+func WriteNavigationGeoJSON(w io.Writer, nav AdaptiveNavigationArchive) error {
+	features := []geoJSONFeature{}
+	for _, route := range nav.Routes {
+		segments := slices.Clone(route.Route.Segments)
+		slices.SortStableFunc(segments, func(a, b Segment) int {
+			return a.SegmentID - b.SegmentID
+		})
+		coordinates := [][]float64{}
+		for _, segment := range segments {
+			for _, location := range segment.Locations {
+				if location.Location.Latitude == 0 && location.Location.Longitude == 0 {
+					continue
+				}
+				coordinates = append(coordinates, []float64{location.Location.Longitude, location.Location.Latitude})
+			}
+		}
+		if len(coordinates) < 2 {
+			continue
+		}
+		features = append(features, geoJSONFeature{
+			Type:     "Feature",
+			Geometry: geoJSONGeometry{Type: "LineString", Coordinates: coordinates},
+			Properties: map[string]any{
+				"id":            route.Route.ID,
+				"originId":      route.Route.OriginID,
+				"destinationId": route.Route.DestinationID,
+			},
+		})
+	}
+	for _, place := range nav.Places {
+		center := place.Place.Center
+		if center.Latitude == 0 && center.Longitude == 0 {
+			continue
+		}
+		features = append(features, newGeoJSONPoint(center.Latitude, center.Longitude, map[string]any{
+			"id":     place.Place.ID,
+			"label":  place.Place.LearnedLabel.Label,
+			"radius": place.Place.Radius,
+		}))
+	}
+	return writeGeoJSON(w, features)
+}
@@ -0,0 +1,85 @@
+package bmwcardata
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteChargingHistoryGeoJSON(t *testing.T) {
+	buf := &bytes.Buffer{}
+	err := WriteChargingHistoryGeoJSON(buf, []ChargingSessionArchive{
+		{
+			StartTime:                      1700000000,
+			EnergyConsumedFromPowerGridKwh: 15.4,
+			ChargingLocation:               &ChargingLocation{MapMatchedLatitude: 48.17, MapMatchedLongitude: 11.55},
+			ChargingCostInformation:        &ChargingCostInformation{CalculatedChargingCost: 4.2, Currency: "EUR"},
+		},
+		{StartTime: 1700000001},
+		{StartTime: 1700000002, ChargingLocation: &ChargingLocation{FormattedAddress: "unknown"}},
+	})
+	require.NoError(t, err)
+	assert.JSONEq(t, `{
+		"type": "FeatureCollection",
+		"features": [{
+			"type": "Feature",
+			"geometry": {"type": "Point", "coordinates": [11.55, 48.17]},
+			"properties": {
+				"startTime": 1700000000,
+				"endTime": 0,
+				"timeZone": "",
+				"energyConsumedFromPowerGridKwh": 15.4,
+				"address": "",
+				"cost": 4.2,
+				"currency": "EUR"
+			}
+		}]
+	}`, buf.String())
+}
+
+func TestWriteChargingHistoryGeoJSON_Empty(t *testing.T) {
+	buf := &bytes.Buffer{}
+	require.NoError(t, WriteChargingHistoryGeoJSON(buf, nil))
+	collection := map[string]any{}
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &collection))
+	assert.Equal(t, []any{}, collection["features"], "features must be an empty array rather than null")
+}
+
+func TestWriteNavigationGeoJSON(t *testing.T) {
+	buf := &bytes.Buffer{}
+	err := WriteNavigationGeoJSON(buf, AdaptiveNavigationArchive{
+		Routes: []NavigationRoutes{
+			{Route: Route{ID: "route", OriginID: "home", DestinationID: "work", Segments: []Segment{
+				{SegmentID: 2, Locations: []Location{{Location: Coordinates{Latitude: 3, Longitude: 30}}}},
+				{SegmentID: 1, Locations: []Location{
+					{Location: Coordinates{Latitude: 1, Longitude: 10}},
+					{},
+					{Location: Coordinates{Latitude: 2, Longitude: 20}},
+				}},
+				{SegmentID: 3},
+			}}},
+			{Route: Route{ID: "empty"}},
+			{Route: Route{ID: "single", Segments: []Segment{{Locations: []Location{{Location: Coordinates{Latitude: 1, Longitude: 1}}}}}}},
+		},
+		Places: []NavigationPlaces{
+			{Place: Place{ID: "home", Center: Coordinates{Latitude: 48.17, Longitude: 11.55}, LearnedLabel: Label{Label: "HOME"}, Radius: 50}},
+			{Place: Place{ID: "unknown"}},
+		},
+	})
+	require.NoError(t, err)
+	assert.JSONEq(t, `{
+		"type": "FeatureCollection",
+		"features": [{
+			"type": "Feature",
+			"geometry": {"type": "LineString", "coordinates": [[10, 1], [20, 2], [30, 3]]},
+			"properties": {"id": "route", "originId": "home", "destinationId": "work"}
+		}, {
+			"type": "Feature",
+			"geometry": {"type": "Point", "coordinates": [11.55, 48.17]},
+			"properties": {"id": "home", "label": "HOME", "radius": 50}
+		}]
+	}`, buf.String())
+}
@@ -10,6 +10,7 @@ require (
 	github.com/oapi-codegen/runtime v1.1.2
 	github.com/stretchr/testify v1.11.1
 	golang.org/x/net v0.43.0
+	golang.org/x/text v0.28.0
 )
 
 require (
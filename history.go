@@ -0,0 +1,78 @@
+package bmwcardata
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// WithMessageHistory is a client option that retains the perVIN most recent streamed messages of each VIN,
+// to be queried with RecentMessages. This provides a cheap view of the latest readings, for dashboards
+// or debugging, without a database.
+// Messages are retained once deduplicated, see WithDedup, whether or not a subscription handles them.
+// Memory is bounded by perVIN messages for each of the streamed VINs.
+func WithMessageHistory(perVIN int) ClientOption {
+	return func(c *Client) error {
+		if perVIN <= 0 {
+			return fmt.Errorf("the message history size must be positive, got %d", perVIN)
+		}
+		c.history = newMessageHistory(perVIN)
+		return nil
+	}
+}
+
+// RecentMessages returns the most recent streamed messages of the VIN, from the oldest to the newest.
+// It returns nil when the client was not created with WithMessageHistory or no message was received for the VIN.
+func (c *Client) RecentMessages(vin string) []StreamedMessage {
+	if c.history == nil {
+		return nil
+	}
+	return c.history.recent(strings.ToUpper(strings.TrimSpace(vin)))
+}
+
+// messageHistory is a ring buffer of the latest messages of each VIN.
+type messageHistory struct {
+	m      sync.Mutex
+	perVIN int
+	rings  map[string]*messageRing
+}
+
+type messageRing struct {
+	messages []StreamedMessage
+	// next is the index at which the next message is written once the ring is full.
+	next int
+}
+
+func newMessageHistory(perVIN int) *messageHistory {
+	return &messageHistory{perVIN: perVIN, rings: map[string]*messageRing{}}
+}
+
+// add retains the message, evicting the oldest one of its VIN when full.
+func (h *messageHistory) add(message StreamedMessage) {
+	vin := strings.ToUpper(strings.TrimSpace(message.VIN))
+	h.m.Lock()
+	defer h.m.Unlock()
+	ring, ok := h.rings[vin]
+	if !ok {
+		ring = &messageRing{}
+		h.rings[vin] = ring
+	}
+	if len(ring.messages) < h.perVIN {
+		ring.messages = append(ring.messages, message)
+		return
+	}
+	ring.messages[ring.next] = message
+	ring.next = (ring.next + 1) % h.perVIN
+}
+
+func (h *messageHistory) recent(vin string) []StreamedMessage {
+	h.m.Lock()
+	defer h.m.Unlock()
+	ring, ok := h.rings[vin]
+	if !ok {
+		return nil
+	}
+	r := make([]StreamedMessage, 0, len(ring.messages))
+	r = append(r, ring.messages[ring.next:]...)
+	return append(r, ring.messages[:ring.next]...)
+}
@@ -0,0 +1,61 @@
+package bmwcardata
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/eclipse/paho.golang/paho"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWithMessageHistory(t *testing.T) {
+	_, err := NewClient(WithCarDataAPI(&mockCardataClient{}), WithAuthenticator(&staticAuthenticator{}), WithMessageHistory(0))
+	require.Error(t, err)
+
+	c, err := NewClient(WithCarDataAPI(&mockCardataClient{}), WithAuthenticator(&staticAuthenticator{}))
+	require.NoError(t, err)
+	assert.Nil(t, c.RecentMessages("VIN123"), "no history is retained by default")
+
+	c, err = NewClient(WithCarDataAPI(&mockCardataClient{}), WithAuthenticator(&staticAuthenticator{}), WithMessageHistory(2))
+	require.NoError(t, err)
+	m := &streamingManager{subscriptions: &c.subscriptions, history: c.history}
+	for i := 1; i <= 3; i++ {
+		payload := fmt.Appendf(nil, `{"vin":"VIN123","timestamp":"%d"}`, i)
+		_, err = m.handlePahoPublishReceived(paho.PublishReceived{Packet: &paho.Publish{Payload: payload}})
+		require.NoError(t, err)
+	}
+	_, err = m.handlePahoPublishReceived(paho.PublishReceived{Packet: &paho.Publish{Payload: []byte(`{"vin":"OTHER","timestamp":"4"}`)}})
+	require.NoError(t, err)
+
+	timestamps := []string{}
+	for _, message := range c.RecentMessages(" vin123 ") {
+		timestamps = append(timestamps, message.Timestamp)
+	}
+	assert.Equal(t, []string{"2", "3"}, timestamps, "messages without subscription must be retained, oldest first")
+	assert.Len(t, c.RecentMessages("OTHER"), 1)
+	assert.Nil(t, c.RecentMessages("UNKNOWN"))
+}
+
+func TestMessageHistory(t *testing.T) {
+	h := newMessageHistory(3)
+	wg := sync.WaitGroup{}
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			h.add(StreamedMessage{VIN: "VIN123", Timestamp: fmt.Sprint(i)})
+			h.recent("VIN123")
+		}()
+	}
+	wg.Wait()
+	assert.Len(t, h.recent("VIN123"), 3, "the history must be bounded")
+
+	for i := 0; i < 5; i++ {
+		h.add(StreamedMessage{VIN: "VIN123", Timestamp: fmt.Sprint(i)})
+	}
+	recent := h.recent("VIN123")
+	recent[0].Timestamp = "modified"
+	assert.Equal(t, []StreamedMessage{{VIN: "VIN123", Timestamp: "2"}, {VIN: "VIN123", Timestamp: "3"}, {VIN: "VIN123", Timestamp: "4"}}, h.recent("VIN123"))
+}
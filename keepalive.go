@@ -0,0 +1,137 @@
+package bmwcardata
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"github.com/eclipse/paho.golang/paho"
+)
+
+// DefaultKeepAlive is the default MQTT keep-alive of the event stream, see WithKeepAlive.
+const DefaultKeepAlive = 20 * time.Second
+
+// ErrStreamNotLive is reported on StreamErrors when nothing was received from the streaming broker
+// within the liveness timeout, see WithStreamLivenessTimeout. The stream then reconnects.
+var ErrStreamNotLive = errors.New("nothing received from the streaming broker")
+
+// WithKeepAlive is a client option setting the MQTT keep-alive of the event stream, DefaultKeepAlive by default.
+// The broker is pinged when nothing was exchanged for this duration, and the connection is closed and re-established
+// when it does not answer before the next ping. The duration is rounded down to the second.
+// Networks with aggressive NAT timeouts, like mobile or residential ones which may drop idle mappings
+// after 30 seconds, silently leave the connection half-open: there, a keep-alive of 10 to 15 seconds is recommended,
+// along with WithStreamLivenessTimeout.
+func WithKeepAlive(keepAlive time.Duration) ClientOption {
+	return func(c *Client) error {
+		if keepAlive < time.Second || keepAlive > math.MaxUint16*time.Second {
+			return fmt.Errorf("the keep-alive must be between 1s and %s, got %s", math.MaxUint16*time.Second, keepAlive)
+		}
+		c.keepAlive = keepAlive
+		return nil
+	}
+}
+
+// WithStreamLivenessTimeout is a client option reconnecting the event stream when nothing, neither a message
+// nor a ping response, is received from the broker for the timeout, which reveals a half-open connection
+// without waiting for the TCP timeouts.
+// As the broker answers the keep-alive pings, the timeout must be longer than the keep-alive: 3 keep-alive periods,
+// 45 seconds with a keep-alive of 15 seconds, is recommended for mobile or residential networks.
+// The reconnections are reported on StreamErrors with ErrStreamNotLive.
+// Unlike WithContainerWatchdog, this does not detect a container that stopped delivering messages over a live connection.
+func WithStreamLivenessTimeout(timeout time.Duration) ClientOption {
+	return func(c *Client) error {
+		if timeout <= 0 {
+			return fmt.Errorf("the liveness timeout must be positive, got %s", timeout)
+		}
+		c.livenessTimeout = timeout
+		return nil
+	}
+}
+
+// validateLiveness checks the liveness timeout leaves time for the keep-alive pings to be answered.
+func (c *Client) validateLiveness() error {
+	keepAlive := c.keepAlive
+	if keepAlive == 0 {
+		keepAlive = DefaultKeepAlive
+	}
+	if c.livenessTimeout > 0 && c.livenessTimeout <= keepAlive {
+		return fmt.Errorf("the liveness timeout %s must be longer than the keep-alive %s", c.livenessTimeout, keepAlive)
+	}
+	return nil
+}
+
+// keepAliveSeconds returns the MQTT keep-alive of the stream, in seconds.
+func (m *streamingManager) keepAliveSeconds() uint16 {
+	if m.keepAlive == 0 {
+		return uint16(DefaultKeepAlive / time.Second)
+	}
+	return uint16(m.keepAlive / time.Second)
+}
+
+// pinger returns the paho pinger of the stream, nil to use the paho default one.
+func (m *streamingManager) pinger() paho.Pinger {
+	if m.livenessTimeout <= 0 {
+		return nil
+	}
+	return &livenessPinger{DefaultPinger: paho.NewDefaultPinger(), timeout: m.livenessTimeout, clock: m.getClock()}
+}
+
+// livenessPinger is a paho pinger closing the connection when nothing is received from the broker within the timeout.
+type livenessPinger struct {
+	*paho.DefaultPinger
+	timeout time.Duration
+	clock   Clock
+	// received is the time of the last packet received, in nanoseconds since epoch.
+	received atomic.Int64
+}
+
+func (p *livenessPinger) PacketReceived() {
+	p.received.Store(p.clock.Now().UnixNano())
+	p.DefaultPinger.PacketReceived()
+}
+
+// Run runs the keep-alive pings until the connection is closed, or nothing is received within the timeout,
+// in which case the returned error makes paho close the connection, and autopaho re-establish it.
+func (p *livenessPinger) Run(ctx context.Context, conn net.Conn, keepAlive uint16) error {
+	// Run is called once the connection is acknowledged.
+	p.received.Store(p.clock.Now().UnixNano())
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var errs chan error
+	if keepAlive > 0 {
+		errs = make(chan error, 1)
+		go func() {
+			errs <- p.DefaultPinger.Run(ctx, conn, keepAlive)
+		}()
+	}
+	for {
+		last := time.Unix(0, p.received.Load())
+		select {
+		case <-ctx.Done():
+			if errs != nil {
+				<-errs
+			}
+			return nil
+		case err := <-errs:
+			if err != nil {
+				return err
+			}
+			// The pinger only stops without error with the connection.
+			return nil
+		case <-p.clock.After(last.Add(p.timeout).Sub(p.clock.Now())):
+			silence := p.clock.Now().Sub(time.Unix(0, p.received.Load()))
+			if silence < p.timeout {
+				continue
+			}
+			cancel()
+			if errs != nil {
+				<-errs
+			}
+			return fmt.Errorf("%w for %s", ErrStreamNotLive, silence)
+		}
+	}
+}
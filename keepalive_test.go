@@ -0,0 +1,83 @@
+package bmwcardata
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWithKeepAlive(t *testing.T) {
+	c, err := NewClient(WithCarDataAPI(&mockCardataClient{}), WithAuthenticator(&staticAuthenticator{}))
+	require.NoError(t, err)
+	m := &streamingManager{keepAlive: c.keepAlive, livenessTimeout: c.livenessTimeout}
+	assert.Equal(t, uint16(20), m.autopahoConfig().KeepAlive)
+	assert.Nil(t, m.autopahoConfig().PingHandler, "the paho pinger must be used without liveness timeout")
+
+	c, err = NewClient(
+		WithCarDataAPI(&mockCardataClient{}),
+		WithAuthenticator(&staticAuthenticator{}),
+		WithKeepAlive(15*time.Second),
+		WithStreamLivenessTimeout(45*time.Second),
+	)
+	require.NoError(t, err)
+	m = &streamingManager{keepAlive: c.keepAlive, livenessTimeout: c.livenessTimeout}
+	assert.Equal(t, uint16(15), m.autopahoConfig().KeepAlive)
+	assert.IsType(t, &livenessPinger{}, m.autopahoConfig().PingHandler)
+
+	_, err = NewClient(WithKeepAlive(time.Millisecond))
+	assert.Error(t, err)
+	_, err = NewClient(WithStreamLivenessTimeout(0))
+	assert.Error(t, err)
+	_, err = NewClient(WithCarDataAPI(&mockCardataClient{}), WithAuthenticator(&staticAuthenticator{}), WithStreamLivenessTimeout(10*time.Second))
+	assert.Error(t, err, "the liveness timeout must be longer than the default keep-alive")
+}
+
+func TestLivenessPinger(t *testing.T) {
+	clock := newFakeClock()
+	m := &streamingManager{livenessTimeout: time.Minute, clock: clock}
+	pinger := m.pinger().(*livenessPinger)
+	conn, peer := net.Pipe()
+	defer conn.Close()
+	defer peer.Close()
+
+	errs := make(chan error, 1)
+	go func() {
+		// Disable the keep-alive pings to only exercise the liveness check.
+		errs <- pinger.Run(context.Background(), conn, 0)
+	}()
+	clock.waitTimers(t, 1)
+	clock.Advance(40 * time.Second)
+	pinger.PacketReceived()
+	clock.Advance(30 * time.Second)
+	select {
+	case err := <-errs:
+		t.Fatalf("the connection must be kept alive by the received packets, got %v", err)
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	clock.waitTimers(t, 1)
+	clock.Advance(30 * time.Second)
+	select {
+	case err := <-errs:
+		assert.ErrorIs(t, err, ErrStreamNotLive)
+	case <-time.After(time.Second):
+		t.Fatal("the pinger must stop when nothing is received within the timeout")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		errs <- pinger.Run(ctx, conn, 0)
+	}()
+	clock.waitTimers(t, 1)
+	cancel()
+	select {
+	case err := <-errs:
+		assert.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("the pinger must stop with the connection")
+	}
+}
@@ -0,0 +1,47 @@
+package bmwcardata
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/tjamet/bmw-cardata/cardataapi"
+	"github.com/tjamet/bmw-cardata/cardatatest"
+)
+
+// clientID is the client ID used by the examples.
+var clientID = uuid.New().String()
+
+// fakeServerTransport routes the requests targeting the BMW servers to the fake server started by TestMain.
+var fakeServerTransport http.RoundTripper
+
+// TestMain starts a fake server so the examples can run without network access nor BMW account.
+func TestMain(m *testing.M) {
+	os.Exit(runWithFakeServer(m))
+}
+
+func runWithFakeServer(m *testing.M) int {
+	home, err := os.MkdirTemp("", "bmw-cardata-test")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer os.RemoveAll(home)
+	// The examples rely on the default session store, keep it away from the user's session.
+	os.Setenv(SessionPathEnv, filepath.Join(home, "session.json"))
+
+	server := cardatatest.NewServer()
+	defer server.Close()
+	server.ClientID = clientID
+	// Every example is expected to go through the whole device code flow.
+	server.TokenExpiresIn = 0
+	server.RejectRefresh = true
+	server.Containers["existing"] = cardataapi.ContainerDetailsDto{ContainerId: p("existing"), Name: p("existing")}
+	server.NewContainerID = func() string { return "123456" }
+
+	fakeServerTransport = server.Transport(nil)
+	return m.Run()
+}
@@ -0,0 +1,88 @@
+package bmwcardata
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/tjamet/bmw-cardata/cardataapi"
+)
+
+// WithMappingsCache is a client option caching the mappings returned by GetMappings, and hence ListVINs,
+// for ttl. The mappings rarely change, the cache avoids fetching them again when many components need
+// the VINs. Use RefreshMappings or InvalidateMappings when they are known to have changed.
+func WithMappingsCache(ttl time.Duration) ClientOption {
+	return func(c *Client) error {
+		if ttl <= 0 {
+			return fmt.Errorf("the mappings cache TTL must be positive, got %s", ttl)
+		}
+		c.mappingsCache = &mappingsCache{ttl: ttl}
+		return nil
+	}
+}
+
+// ListVINs lists the VINs mapped to the account, see GetMappings.
+func (c *Client) ListVINs(ctx context.Context) ([]string, error) {
+	mappings, err := c.GetMappings(ctx)
+	if err != nil {
+		return nil, err
+	}
+	vins := []string{}
+	for _, mapping := range mappings {
+		if mapping.Vin != nil {
+			vins = append(vins, *mapping.Vin)
+		}
+	}
+	return vins, nil
+}
+
+// RefreshMappings fetches the mappings, updating the cache enabled WithMappingsCache.
+func (c *Client) RefreshMappings(ctx context.Context) ([]cardataapi.VehicleMappingDto, error) {
+	if c.mappingsCache != nil {
+		return c.mappingsCache.get(ctx, c.fetchMappings, c.now, true)
+	}
+	return c.fetchMappings(ctx)
+}
+
+// InvalidateMappings drops the mappings cached WithMappingsCache, they are fetched again on the next call.
+func (c *Client) InvalidateMappings() {
+	if c.mappingsCache != nil {
+		c.mappingsCache.invalidate()
+	}
+}
+
+// mappingsCache holds the mappings until they expire, according to the client clock.
+// Concurrent callers wait for a single fetch instead of all hitting the API.
+type mappingsCache struct {
+	m         sync.Mutex
+	ttl       time.Duration
+	mappings  []cardataapi.VehicleMappingDto
+	expiresAt time.Time
+}
+
+func (c *mappingsCache) get(ctx context.Context, fetch func(context.Context) ([]cardataapi.VehicleMappingDto, error), now func() time.Time, refresh bool) ([]cardataapi.VehicleMappingDto, error) {
+	c.m.Lock()
+	defer c.m.Unlock()
+	if refresh || c.mappings == nil || !now().Before(c.expiresAt) {
+		mappings, err := fetch(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if mappings == nil {
+			// A nil slice marks the mappings to be fetched, cache the absence of mappings as well.
+			mappings = []cardataapi.VehicleMappingDto{}
+		}
+		c.mappings = mappings
+		c.expiresAt = now().Add(c.ttl)
+	}
+	// Callers must not be able to alter the cached mappings.
+	return slices.Clone(c.mappings), nil
+}
+
+func (c *mappingsCache) invalidate() {
+	c.m.Lock()
+	defer c.m.Unlock()
+	c.mappings = nil
+}
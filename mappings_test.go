@@ -0,0 +1,114 @@
+package bmwcardata
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tjamet/bmw-cardata/cardataapi"
+)
+
+func TestWithMappingsCache(t *testing.T) {
+	ctx := context.Background()
+	var m sync.Mutex
+	calls := 0
+	mock := &mockCardataClient{
+		GetMappingsFunc: func(ctx context.Context, params *cardataapi.GetMappingsParams, _ ...cardataapi.RequestEditorFn) (*http.Response, error) {
+			m.Lock()
+			defer m.Unlock()
+			calls++
+			return jsonResponse(http.StatusOK, []cardataapi.VehicleMappingDto{{Vin: p("VIN1")}, {}, {Vin: p("VIN2")}}, nil), nil
+		},
+	}
+	getCalls := func() int {
+		m.Lock()
+		defer m.Unlock()
+		return calls
+	}
+
+	_, err := NewClient(WithCarDataAPI(mock), WithAuthenticator(&staticAuthenticator{}), WithMappingsCache(0))
+	require.Error(t, err)
+
+	clock := newFakeClock()
+	c, err := NewClient(WithCarDataAPI(mock), WithAuthenticator(&staticAuthenticator{}), WithMappingsCache(time.Hour), WithClock(clock))
+	require.NoError(t, err)
+	wg := sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			vins, err := c.ListVINs(ctx)
+			assert.NoError(t, err)
+			assert.Equal(t, []string{"VIN1", "VIN2"}, vins)
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, 1, getCalls(), "the mappings must be fetched once")
+
+	mappings, err := c.GetMappings(ctx)
+	require.NoError(t, err)
+	mappings[0].Vin = p("ALTERED")
+	vins, err := c.ListVINs(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"VIN1", "VIN2"}, vins, "the cache must not be altered by callers")
+	assert.Equal(t, 1, getCalls())
+
+	_, err = c.RefreshMappings(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 2, getCalls(), "refreshing must fetch the mappings")
+
+	c.InvalidateMappings()
+	_, err = c.GetMappings(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 3, getCalls(), "invalidated mappings must be fetched again")
+
+	clock.Advance(time.Hour - time.Second)
+	_, err = c.GetMappings(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 3, getCalls(), "the mappings must be cached until they expire")
+
+	clock.Advance(time.Second)
+	_, err = c.GetMappings(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 4, getCalls(), "expired mappings must be fetched again")
+}
+
+func TestWithMappingsCache_NoMappings(t *testing.T) {
+	calls := 0
+	mock := &mockCardataClient{
+		GetMappingsFunc: func(ctx context.Context, params *cardataapi.GetMappingsParams, _ ...cardataapi.RequestEditorFn) (*http.Response, error) {
+			calls++
+			return bytesResponse(http.StatusOK, []byte("null"), nil), nil
+		},
+	}
+	c, err := NewClient(WithCarDataAPI(mock), WithAuthenticator(&staticAuthenticator{}), WithMappingsCache(time.Hour), WithClock(newFakeClock()))
+	require.NoError(t, err)
+	for i := 0; i < 2; i++ {
+		vins, err := c.ListVINs(context.Background())
+		require.NoError(t, err)
+		assert.Empty(t, vins)
+	}
+	assert.Equal(t, 1, calls, "the absence of mappings must be cached")
+}
+
+func TestGetMappings_WithoutCache(t *testing.T) {
+	calls := 0
+	mock := &mockCardataClient{
+		GetMappingsFunc: func(ctx context.Context, params *cardataapi.GetMappingsParams, _ ...cardataapi.RequestEditorFn) (*http.Response, error) {
+			calls++
+			return jsonResponse(http.StatusOK, []cardataapi.VehicleMappingDto{{Vin: p("VIN1")}}, nil), nil
+		},
+	}
+	c := &Client{carDataAPI: mock}
+	for i := 0; i < 2; i++ {
+		vins, err := c.ListVINs(context.Background())
+		require.NoError(t, err)
+		assert.Equal(t, []string{"VIN1"}, vins)
+	}
+	c.InvalidateMappings()
+	assert.Equal(t, 2, calls, "the mappings must not be cached by default")
+}
@@ -0,0 +1,144 @@
+package bmwcardata
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Default connection pool settings of the CarData and auth HTTP clients.
+// As each client only talks to a single BMW host, all the idle connections are kept for it,
+// instead of the 2 kept per host by http.DefaultTransport, avoiding connection churn for bulk jobs.
+const (
+	DefaultMaxIdleConns        = 32
+	DefaultMaxIdleConnsPerHost = 32
+	DefaultIdleConnTimeout     = 90 * time.Second
+)
+
+// connectionPool holds the connection pool settings of an HTTP transport.
+type connectionPool struct {
+	maxIdleConns        int
+	maxIdleConnsPerHost int
+	idleConnTimeout     time.Duration
+}
+
+func defaultConnectionPool() connectionPool {
+	return connectionPool{
+		maxIdleConns:        DefaultMaxIdleConns,
+		maxIdleConnsPerHost: DefaultMaxIdleConnsPerHost,
+		idleConnTimeout:     DefaultIdleConnTimeout,
+	}
+}
+
+// transport returns a copy of http.DefaultTransport, as set when the client is built, with the pool settings.
+// When http.DefaultTransport was replaced by another kind of http.RoundTripper, for example to go through
+// a tracing or recording transport, it is used as is and the pool settings can't be applied.
+func (p connectionPool) transport() http.RoundTripper {
+	base, ok := http.DefaultTransport.(*http.Transport)
+	if !ok {
+		return http.DefaultTransport
+	}
+	transport := base.Clone()
+	transport.MaxIdleConns = p.maxIdleConns
+	transport.MaxIdleConnsPerHost = p.maxIdleConnsPerHost
+	transport.IdleConnTimeout = p.idleConnTimeout
+	return transport
+}
+
+func validatePoolSize(name string, n int) error {
+	if n < 0 {
+		return fmt.Errorf("%s must not be negative, got %d", name, n)
+	}
+	return nil
+}
+
+// WithHTTPTransport is a client option setting the transport of the requests to the CarData API,
+// a dedicated one with the connection pool settings by default.
+// The connection pool options are not applied to it, the explicitly provided transport wins.
+func WithHTTPTransport(transport http.RoundTripper) ClientOption {
+	return func(c *Client) error {
+		c.useHTTPOption("WithHTTPTransport")
+		if transport == nil {
+			return errors.New("the HTTP transport must not be nil")
+		}
+		c.httpTransport = transport
+		return nil
+	}
+}
+
+// WithMaxIdleConns is a client option setting the maximum number of idle connections to the CarData API,
+// DefaultMaxIdleConns by default. 0 means no limit, as for http.Transport.
+func WithMaxIdleConns(n int) ClientOption {
+	return func(c *Client) error {
+		c.useHTTPOption("WithMaxIdleConns")
+		c.pool.maxIdleConns = n
+		return validatePoolSize("max idle connections", n)
+	}
+}
+
+// WithMaxIdleConnsPerHost is a client option setting the maximum number of idle connections kept
+// to the CarData API host, DefaultMaxIdleConnsPerHost by default. 0 means http.DefaultMaxIdleConnsPerHost.
+func WithMaxIdleConnsPerHost(n int) ClientOption {
+	return func(c *Client) error {
+		c.useHTTPOption("WithMaxIdleConnsPerHost")
+		c.pool.maxIdleConnsPerHost = n
+		return validatePoolSize("max idle connections per host", n)
+	}
+}
+
+// WithIdleConnTimeout is a client option setting how long idle connections to the CarData API are kept,
+// DefaultIdleConnTimeout by default. 0 means no limit.
+func WithIdleConnTimeout(d time.Duration) ClientOption {
+	return func(c *Client) error {
+		c.useHTTPOption("WithIdleConnTimeout")
+		if d < 0 {
+			return fmt.Errorf("the idle connection timeout must not be negative, got %s", d)
+		}
+		c.pool.idleConnTimeout = d
+		return nil
+	}
+}
+
+// WithAuthHTTPTransport is an auth client option setting the transport of the requests to the auth API,
+// see WithHTTPTransport. It is not applied when using WithAuthClient.
+func WithAuthHTTPTransport(transport http.RoundTripper) AuthClientOption {
+	return func(c *AuthClient) error {
+		if transport == nil {
+			return errors.New("the HTTP transport must not be nil")
+		}
+		c.httpTransport = transport
+		return nil
+	}
+}
+
+// WithAuthMaxIdleConns is an auth client option setting the maximum number of idle connections to the auth API,
+// see WithMaxIdleConns. It is not applied when using WithAuthClient, the explicitly provided client wins.
+func WithAuthMaxIdleConns(n int) AuthClientOption {
+	return func(c *AuthClient) error {
+		c.pool.maxIdleConns = n
+		return validatePoolSize("max idle connections", n)
+	}
+}
+
+// WithAuthMaxIdleConnsPerHost is an auth client option setting the maximum number of idle connections
+// kept to the auth API host, see WithMaxIdleConnsPerHost.
+// It is not applied when using WithAuthClient, the explicitly provided client wins.
+func WithAuthMaxIdleConnsPerHost(n int) AuthClientOption {
+	return func(c *AuthClient) error {
+		c.pool.maxIdleConnsPerHost = n
+		return validatePoolSize("max idle connections per host", n)
+	}
+}
+
+// WithAuthIdleConnTimeout is an auth client option setting how long idle connections to the auth API are kept,
+// see WithIdleConnTimeout. It is not applied when using WithAuthClient, the explicitly provided client wins.
+func WithAuthIdleConnTimeout(d time.Duration) AuthClientOption {
+	return func(c *AuthClient) error {
+		if d < 0 {
+			return fmt.Errorf("the idle connection timeout must not be negative, got %s", d)
+		}
+		c.pool.idleConnTimeout = d
+		return nil
+	}
+}
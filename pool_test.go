@@ -0,0 +1,119 @@
+package bmwcardata
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	authapi "github.com/tjamet/bmw-cardata/auth"
+	"github.com/tjamet/bmw-cardata/cardataapi"
+)
+
+func TestConnectionPool(t *testing.T) {
+	c, err := NewClient(WithCarDataAPI(&mockCardataClient{}), WithAuthenticator(&staticAuthenticator{}))
+	require.NoError(t, err)
+	assert.Equal(t, defaultConnectionPool(), c.pool)
+
+	c, err = NewClient(
+		WithCarDataServer("http://cardata.invalid"),
+		WithAuthenticator(&staticAuthenticator{}),
+		WithMaxIdleConns(10),
+		WithMaxIdleConnsPerHost(5),
+		WithIdleConnTimeout(time.Minute),
+	)
+	require.NoError(t, err)
+	transport, ok := c.pool.transport().(*http.Transport)
+	require.True(t, ok)
+	assert.Equal(t, 10, transport.MaxIdleConns)
+	assert.Equal(t, 5, transport.MaxIdleConnsPerHost)
+	assert.Equal(t, time.Minute, transport.IdleConnTimeout)
+	defaultTransport, ok := http.DefaultTransport.(*http.Transport)
+	require.True(t, ok)
+	assert.NotSame(t, defaultTransport, transport)
+	assert.NotEqual(t, 5, defaultTransport.MaxIdleConnsPerHost, "the default transport must not be modified")
+
+	_, err = NewClient(WithMaxIdleConns(-1))
+	require.Error(t, err)
+	_, err = NewClient(WithMaxIdleConnsPerHost(-1))
+	require.Error(t, err)
+	_, err = NewClient(WithIdleConnTimeout(-time.Second))
+	require.Error(t, err)
+
+	authClient, err := NewAuthClient(WithAuthMaxIdleConns(3), WithAuthMaxIdleConnsPerHost(2), WithAuthIdleConnTimeout(time.Second))
+	require.NoError(t, err)
+	assert.Equal(t, connectionPool{maxIdleConns: 3, maxIdleConnsPerHost: 2, idleConnTimeout: time.Second}, authClient.pool)
+	_, err = NewAuthClient(WithAuthMaxIdleConns(-1))
+	require.Error(t, err)
+}
+
+func TestDedicatedHTTPClients(t *testing.T) {
+	c, err := NewClient(WithCarDataServer("http://cardata.invalid"), WithAuthenticator(&staticAuthenticator{}))
+	require.NoError(t, err)
+	api, ok := c.carDataAPI.(*cardataapi.ClientWithResponses).ClientInterface.(*cardataapi.Client)
+	require.True(t, ok)
+	httpClient, ok := api.Client.(*rawResponseRecorder).doer.(*http.Client)
+	require.True(t, ok)
+	assert.NotSame(t, http.DefaultClient, httpClient)
+
+	authClient, err := NewAuthClient()
+	require.NoError(t, err)
+	authAPI, ok := authClient.auth.(*authapi.Client)
+	require.True(t, ok)
+	authHTTPClient, ok := authAPI.Client.(*http.Client)
+	require.True(t, ok)
+	assert.NotSame(t, http.DefaultClient, authHTTPClient)
+	assert.NotSame(t, httpClient, authHTTPClient)
+}
+
+func TestConnectionPool_ReplacedDefaultTransport(t *testing.T) {
+	original := http.DefaultTransport
+	defer func() { http.DefaultTransport = original }()
+	calls := 0
+	http.DefaultTransport = roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		calls++
+		return nil, errors.New("not implemented")
+	})
+
+	c, err := NewClient(WithCarDataServer("http://cardata.invalid"), WithAuthenticator(&staticAuthenticator{session: &AuthenticatedSession{AccessToken: "acc"}}))
+	require.NoError(t, err)
+	_, err = c.GetMappings(context.Background())
+	require.Error(t, err)
+	assert.Equal(t, 1, calls, "a replaced default transport must be used as is")
+}
+
+func TestWithHTTPTransport(t *testing.T) {
+	calls := 0
+	transport := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		calls++
+		return nil, errors.New("not implemented")
+	})
+	req, err := http.NewRequest(http.MethodGet, "http://example.com", nil)
+	require.NoError(t, err)
+
+	c, err := NewClient(WithCarDataServer("http://cardata.invalid"), WithAuthenticator(&staticAuthenticator{}), WithHTTPTransport(transport))
+	require.NoError(t, err)
+	api, ok := c.carDataAPI.(*cardataapi.ClientWithResponses).ClientInterface.(*cardataapi.Client)
+	require.True(t, ok)
+	httpClient, ok := api.Client.(*rawResponseRecorder).doer.(*http.Client)
+	require.True(t, ok)
+	_, _ = httpClient.Transport.RoundTrip(req)
+	assert.Equal(t, 1, calls)
+
+	authClient, err := NewAuthClient(WithAuthHTTPTransport(transport))
+	require.NoError(t, err)
+	authAPI, ok := authClient.auth.(*authapi.Client)
+	require.True(t, ok)
+	authHTTPClient, ok := authAPI.Client.(*http.Client)
+	require.True(t, ok)
+	_, _ = authHTTPClient.Transport.RoundTrip(req)
+	assert.Equal(t, 2, calls)
+
+	_, err = NewClient(WithHTTPTransport(nil))
+	require.Error(t, err)
+	_, err = NewAuthClient(WithAuthHTTPTransport(nil))
+	require.Error(t, err)
+}
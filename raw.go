@@ -0,0 +1,61 @@
+package bmwcardata
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+
+	"github.com/tjamet/bmw-cardata/cardataapi"
+)
+
+// RawResponse holds the raw HTTP response of a CarData API call.
+// It allows inspecting fields BMW returns but the decoded structs do not model.
+type RawResponse struct {
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+}
+
+type rawResponseKey struct{}
+
+// ContextWithRawResponse returns a context capturing the raw response of the CarData API calls made with it.
+// The returned RawResponse is filled once the call returns. When several calls are made with the
+// same context, it holds the response of the last one, hence the context must not be shared across
+// concurrent calls.
+func ContextWithRawResponse(ctx context.Context) (context.Context, *RawResponse) {
+	raw := &RawResponse{}
+	return context.WithValue(ctx, rawResponseKey{}, raw), raw
+}
+
+// withoutRawResponse returns a context whose calls don't capture their raw response, for the calls made
+// concurrently on behalf of the caller, whose RawResponse can only hold one response.
+func withoutRawResponse(ctx context.Context) context.Context {
+	return context.WithValue(ctx, rawResponseKey{}, (*RawResponse)(nil))
+}
+
+// rawResponseRecorder captures the response bodies for requests made with a ContextWithRawResponse context.
+type rawResponseRecorder struct {
+	doer cardataapi.HttpRequestDoer
+}
+
+func (r *rawResponseRecorder) Do(req *http.Request) (*http.Response, error) {
+	resp, err := r.doer.Do(req)
+	if err != nil {
+		return resp, err
+	}
+	raw, ok := req.Context().Value(rawResponseKey{}).(*RawResponse)
+	if !ok || raw == nil {
+		return resp, nil
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	raw.StatusCode = resp.StatusCode
+	raw.Header = resp.Header.Clone()
+	raw.Body = body
+	return resp, nil
+}
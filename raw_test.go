@@ -0,0 +1,32 @@
+package bmwcardata
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestContextWithRawResponse(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"vin":"WBA00000000000000","modelName":"X7","notModeled":true}`))
+	}))
+	defer server.Close()
+	client, err := NewClient(WithCarDataServer(server.URL), WithAuthenticator(&staticAuthenticator{session: &AuthenticatedSession{AccessToken: "acc"}}))
+	require.NoError(t, err)
+
+	ctx, raw := ContextWithRawResponse(context.Background())
+	vehicle, err := client.GetBasicData(ctx, "WBA00000000000000")
+	require.NoError(t, err)
+	assert.Equal(t, "X7", *vehicle.ModelName, "the response must still be decoded")
+	assert.Equal(t, http.StatusOK, raw.StatusCode)
+	assert.Equal(t, "application/json", raw.Header.Get("Content-Type"))
+	assert.JSONEq(t, `{"vin":"WBA00000000000000","modelName":"X7","notModeled":true}`, string(raw.Body))
+
+	_, err = client.GetBasicData(context.Background(), "WBA00000000000000")
+	require.NoError(t, err, "responses must be decoded without raw response capture")
+}
@@ -0,0 +1,170 @@
+package bmwcardata
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+)
+
+// ErrMissingScope is returned when the session was not granted a scope required by an operation,
+// like ScopeCardataStreaming to stream telematic data, instead of the opaque 403 returned by BMW.
+var ErrMissingScope = errors.New("missing scope")
+
+// ErrUnknownScope is returned by UpgradeScopes for scopes not supported by the BMW auth API.
+var ErrUnknownScope = errors.New("unknown scope")
+
+// knownScopes are the scopes supported by the BMW auth API.
+var knownScopes = ScopeSet{ScopeAuthenticateUser: {}, ScopeOpenID: {}, ScopeCardataAPI: {}, ScopeCardataStreaming: {}}
+
+// ScopeSet is a set of scopes, as granted to a session or requested to authenticate.
+// The zero value is an empty set ready to use.
+type ScopeSet map[Scope]struct{}
+
+// ScopeSetFromSpaceSeparated parses the space-delimited list of scopes of the token responses, as of RFC 6749 section 3.3.
+func ScopeSetFromSpaceSeparated(s string) ScopeSet {
+	set := ScopeSet{}
+	for _, scope := range strings.Fields(s) {
+		set.Add(Scope(scope))
+	}
+	return set
+}
+
+// Has reports whether the set contains the scope.
+func (s ScopeSet) Has(scope Scope) bool {
+	_, ok := s[scope]
+	return ok
+}
+
+// Add adds the scopes to the set, ignoring empty ones and surrounding spaces.
+func (s *ScopeSet) Add(scopes ...Scope) {
+	for _, scope := range scopes {
+		scope = Scope(strings.TrimSpace(string(scope)))
+		if scope == "" {
+			continue
+		}
+		if *s == nil {
+			*s = ScopeSet{}
+		}
+		(*s)[scope] = struct{}{}
+	}
+}
+
+// Merge adds the scopes of other to the set.
+func (s *ScopeSet) Merge(other ScopeSet) {
+	for scope := range other {
+		s.Add(scope)
+	}
+}
+
+// Scopes returns the sorted scopes of the set.
+func (s ScopeSet) Scopes() []Scope {
+	return slices.Sorted(maps.Keys(s))
+}
+
+// String returns the sorted space-delimited list of the scopes, as expected by the BMW auth API.
+func (s ScopeSet) String() string {
+	r := []string{}
+	for _, scope := range s.Scopes() {
+		r = append(r, string(scope))
+	}
+	return strings.Join(r, " ")
+}
+
+// Scopes returns the scopes granted to the session.
+func (a *AuthenticatedSession) Scopes() ScopeSet {
+	if a == nil {
+		return ScopeSet{}
+	}
+	return ScopeSetFromSpaceSeparated(a.Scope)
+}
+
+// HasScope reports whether the session was granted the scope.
+func (a *AuthenticatedSession) HasScope(scope Scope) bool {
+	return a.Scopes().Has(scope)
+}
+
+// requireScopes checks the session was granted all the scopes.
+// Sessions without scope information, like sessions set from another service, are assumed to have them all
+// and are left for BMW to reject.
+func (a *AuthenticatedSession) requireScopes(scopes ...Scope) error {
+	granted := a.Scopes()
+	if len(granted) == 0 {
+		return nil
+	}
+	for _, scope := range scopes {
+		if !granted.Has(scope) {
+			return fmt.Errorf("%w %s, the session was granted %q", ErrMissingScope, scope, a.Scope)
+		}
+	}
+	return nil
+}
+
+// RequireScopes checks the current session was granted all the scopes, without calling the CarData API.
+// It returns an error wrapping ErrMissingScope otherwise.
+// The CarData API calls and the event stream already check the scope they require, ScopeCardataAPI
+// and ScopeCardataStreaming respectively, RequireScopes allows checking them all upfront.
+func (c *Client) RequireScopes(ctx context.Context, scopes ...Scope) error {
+	if c.Authenticator == nil {
+		return errors.New("an authenticator is required to check the scopes")
+	}
+	session, err := c.Authenticator.GetSession(ctx)
+	if err != nil {
+		return err
+	}
+	return session.requireScopes(scopes...)
+}
+
+// UpgradeScopes authenticates again to be granted the additional scopes on top of the current ones,
+// for instance ScopeCardataStreaming for a user who initially authorized only the CarData API.
+// It starts a new device-code flow requesting the union of the authenticator scopes, the scopes granted
+// to the current session and the additional ones, and replaces the stored session once the user authorized it.
+// The authenticator then keeps requesting these scopes for later authentications.
+// No flow is started when the current session was already granted the additional scopes.
+// The additional scopes must be known, the returned error wraps ErrUnknownScope otherwise.
+func (a *Authenticator) UpgradeScopes(ctx context.Context, additional ...Scope) (*AuthenticatedSession, error) {
+	if len(additional) == 0 {
+		return nil, errors.New("at least one scope is required")
+	}
+	for _, scope := range additional {
+		if !knownScopes.Has(scope) {
+			return nil, fmt.Errorf("%w %q", ErrUnknownScope, scope)
+		}
+	}
+	if err := a.m.lock(ctx); err != nil {
+		return nil, err
+	}
+	defer a.m.unlock()
+	scopes := ScopeSet{}
+	scopes.Add(a.Scopes...)
+	session, err := a.getStoredSession(ctx)
+	if err == nil && session != nil && strings.EqualFold(session.ClientID.String(), a.ClientID) {
+		granted := session.Scopes()
+		if len(granted) > 0 && hasAllScopes(granted, additional) {
+			return a.getSession(ctx)
+		}
+		scopes.Merge(granted)
+	}
+	scopes.Add(additional...)
+
+	previous := a.Scopes
+	a.Scopes = scopes.Scopes()
+	session, err = a.NewSession(ctx)
+	if err != nil {
+		a.Scopes = previous
+		return nil, err
+	}
+	return session, nil
+}
+
+// hasAllScopes reports whether the set contains all the scopes.
+func hasAllScopes(set ScopeSet, scopes []Scope) bool {
+	for _, scope := range scopes {
+		if !set.Has(scope) {
+			return false
+		}
+	}
+	return true
+}
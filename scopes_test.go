@@ -0,0 +1,117 @@
+package bmwcardata
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuthenticatedSessionHasScope(t *testing.T) {
+	session := &AuthenticatedSession{Scope: "openid cardata:streaming:read"}
+	assert.True(t, session.HasScope(ScopeCardataStreaming))
+	assert.False(t, session.HasScope(ScopeCardataAPI))
+	assert.False(t, (*AuthenticatedSession)(nil).HasScope(ScopeOpenID))
+
+	assert.NoError(t, (&AuthenticatedSession{}).requireScopes(ScopeCardataAPI), "sessions without scope information must not be rejected")
+}
+
+func TestScopeSet(t *testing.T) {
+	set := ScopeSetFromSpaceSeparated("  openid\tcardata:api:read openid ")
+	assert.True(t, set.Has(ScopeOpenID))
+	assert.True(t, set.Has(ScopeCardataAPI))
+	assert.False(t, set.Has(ScopeCardataStreaming))
+
+	var other ScopeSet
+	other.Add(ScopeCardataStreaming, " ", ScopeOpenID)
+	set.Merge(other)
+	assert.Equal(t, []Scope{ScopeCardataAPI, ScopeCardataStreaming, ScopeOpenID}, set.Scopes())
+	assert.Equal(t, "cardata:api:read cardata:streaming:read openid", set.String())
+	assert.Empty(t, ScopeSetFromSpaceSeparated(""))
+}
+
+func TestMissingScopeForCarDataAPI(t *testing.T) {
+	client, err := NewClient(
+		WithCarDataServer("http://cardata.invalid"),
+		WithAuthenticator(&staticAuthenticator{session: &AuthenticatedSession{AccessToken: "acc", Scope: "openid cardata:streaming:read"}}),
+		WithRequestMiddleware(func(next http.RoundTripper) http.RoundTripper {
+			return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+				t.Error("the request must not be sent without the API scope")
+				return next.RoundTrip(req)
+			})
+		}),
+	)
+	require.NoError(t, err)
+	_, err = client.GetBasicData(context.Background(), "WBA00000000000000")
+	require.ErrorIs(t, err, ErrMissingScope)
+	assert.Contains(t, err.Error(), "missing scope cardata:api:read")
+
+	assert.NoError(t, client.RequireScopes(context.Background(), ScopeCardataStreaming))
+	assert.ErrorIs(t, client.RequireScopes(context.Background(), ScopeOpenID, ScopeCardataAPI), ErrMissingScope)
+}
+
+func TestMissingScopeForStreaming(t *testing.T) {
+	m := &streamingManager{
+		Authenticator: &staticAuthenticator{session: &AuthenticatedSession{AccessToken: "acc", Scope: "openid cardata:api:read", IdToken: p("id")}},
+		ctx:           context.Background(),
+	}
+	_, err := m.getStreamingSession()
+	require.ErrorIs(t, err, ErrMissingScope)
+	assert.Contains(t, err.Error(), "missing scope cardata:streaming:read")
+}
+
+func TestAuthenticatorUpgradeScopes(t *testing.T) {
+	m := &mochAuthenticationImplem{}
+	m.initiateAuthenticationSessionFunc = func(ctx context.Context, clientID string, scopes []Scope) (*AuthenticationSession, error) {
+		assert.Equal(t, []Scope{ScopeCardataAPI, ScopeCardataStreaming, ScopeOpenID}, scopes, "the current and additional scopes must be requested")
+		return &AuthenticationSession{DeviceCode: "dev", ExpiresIn: 2, Interval: 1}, nil
+	}
+	m.pollAuthTokenFunc = func(ctx context.Context, authSession *AuthenticationSession) (*AuthenticatedSession, error) {
+		return &AuthenticatedSession{
+			AccessToken: "upgraded", Scope: "openid cardata:api:read cardata:streaming:read", ClientID: uuid.MustParse(testClientID), ExpiresAt: time.Now().Add(time.Hour),
+		}, nil
+	}
+	prompts := 0
+	store := &InMemorySessionStore{session: &AuthenticatedSession{
+		AccessToken: "read-only", Scope: "openid cardata:api:read", ClientID: uuid.MustParse(testClientID), ExpiresAt: time.Now().Add(time.Hour),
+	}}
+	authenticator := &Authenticator{
+		ClientID:     testClientID,
+		AuthClient:   m,
+		SessionStore: store,
+		Scopes:       []Scope{ScopeOpenID},
+		PromptURI:    func(uri, code, complete string) { prompts++ },
+	}
+
+	_, err := authenticator.UpgradeScopes(context.Background(), ScopeCardataStreaming, "cardata:write")
+	require.ErrorIs(t, err, ErrUnknownScope)
+	assert.Equal(t, 0, prompts)
+
+	session, err := authenticator.UpgradeScopes(context.Background(), ScopeCardataStreaming)
+	require.NoError(t, err)
+	assert.Equal(t, "upgraded", session.AccessToken)
+	assert.Equal(t, "upgraded", store.session.AccessToken, "the stored session must be replaced")
+	assert.Equal(t, 1, prompts)
+	assert.Equal(t, []Scope{ScopeCardataAPI, ScopeCardataStreaming, ScopeOpenID}, authenticator.Scopes)
+
+	session, err = authenticator.UpgradeScopes(context.Background(), ScopeCardataStreaming)
+	require.NoError(t, err)
+	assert.Equal(t, "upgraded", session.AccessToken)
+	assert.Equal(t, 1, prompts, "no flow must be started when the scopes are already granted")
+}
+
+func TestAuthenticatorUpgradeScopes_Failure(t *testing.T) {
+	authenticator := &Authenticator{
+		ClientID:       testClientID,
+		SessionStore:   &InMemorySessionStore{},
+		Scopes:         []Scope{ScopeOpenID},
+		NonInteractive: true,
+	}
+	_, err := authenticator.UpgradeScopes(context.Background(), ScopeCardataStreaming)
+	require.ErrorIs(t, err, ErrInteractiveAuthenticationRequired)
+	assert.Equal(t, []Scope{ScopeOpenID}, authenticator.Scopes, "the scopes must be left unchanged when the upgrade fails")
+}
@@ -3,8 +3,12 @@ package bmwcardata
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
 	"os"
 	"path/filepath"
+	"runtime"
 )
 
 // SessionStore is an interface that allows to store, persist and retrieve authenticated sessions.
@@ -33,56 +37,210 @@ func (s *InMemorySessionStore) Save(ctx context.Context, session *AuthenticatedS
 }
 
 // FileSessionStore is a session store that persists the session to a file.
+// The directory of the file is created with 0700 permissions when missing,
+// and the file is replaced atomically, with 0600 permissions.
 type FileSessionStore struct {
 	Path    string
 	session *AuthenticatedSession
+	// legacyPath is read when there is no file at Path, see NewFileSessionStore.
+	legacyPath string
 }
 
+// SessionPathEnv is the environment variable overriding the DefaultSessionPath.
+const SessionPathEnv = "BMW_CARDATA_SESSION_PATH"
+
+// DefaultSessionPath returns the path where sessions are stored by default.
+// In order of precedence, it is:
+//   - the BMW_CARDATA_SESSION_PATH environment variable, when set
+//   - bmw-cardata/session.json under $XDG_DATA_HOME, when set to an absolute path (except on Windows)
+//   - bmw-cardata/session.json under %AppData% on Windows
+//   - ~/.local/share/bmw-cardata/session.json otherwise
 func DefaultSessionPath() (string, error) {
+	if path := os.Getenv(SessionPathEnv); path != "" {
+		return path, nil
+	}
+	dir, err := defaultDataDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to determine the session directory, set %s to choose the session path: %w", SessionPathEnv, err)
+	}
+	return filepath.Join(dir, "bmw-cardata", "session.json"), nil
+}
+
+func defaultDataDir() (string, error) {
+	if runtime.GOOS == "windows" {
+		return os.UserConfigDir()
+	}
+	// The XDG specification requires relative paths to be ignored
+	if dir := os.Getenv("XDG_DATA_HOME"); filepath.IsAbs(dir) {
+		return dir, nil
+	}
 	homedir, err := os.UserHomeDir()
 	if err != nil {
 		return "", err
 	}
-	return filepath.Join(homedir, ".local", "share", "bmw-cardata", "session.json"), nil
+	return filepath.Join(homedir, ".local", "share"), nil
 }
 
+// NewFileSessionStore returns a session store persisting the session at path, DefaultSessionPath when empty.
+// Sessions used to be stored under ~/.local/share/bmw-cardata/session.json regardless of the platform and of
+// $XDG_DATA_HOME. When the default path is used and holds no session yet, the session is read from this legacy
+// path instead, and copied to the default path, so that upgrading does not require to authenticate again.
 func NewFileSessionStore(path string) (*FileSessionStore, error) {
-	if path == "" {
-		p, err := DefaultSessionPath()
-		if err != nil {
-			return nil, err
+	if path != "" {
+		return &FileSessionStore{Path: path}, nil
+	}
+	path, err := DefaultSessionPath()
+	if err != nil {
+		return nil, err
+	}
+	store := &FileSessionStore{Path: path}
+	if os.Getenv(SessionPathEnv) == "" {
+		if legacyPath, err := legacySessionPath(); err == nil && legacyPath != path {
+			store.legacyPath = legacyPath
 		}
-		path = p
 	}
-	return &FileSessionStore{Path: path}, nil
+	return store, nil
 }
 
+// legacySessionPath returns the default session path of the versions not following the platform conventions.
+func legacySessionPath() (string, error) {
+	homedir, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(homedir, ".local", "share", "bmw-cardata", "session.json"), nil
+}
+
+// sessionSchemaVersion is the version of the session files written by FileSessionStore.
+// It must be increased, with a migration from the previous version, when the format of the sessions changes.
+const sessionSchemaVersion = 1
+
+// ErrUnsupportedSessionSchema is returned by FileSessionStore when the session file was written
+// by a newer version of the library, rather than misreading its fields.
+var ErrUnsupportedSessionSchema = errors.New("unsupported session file schema version")
+
+// sessionMigrations upgrade the session files, indexed by the version they upgrade from.
+// The files without version, written before the sessions were versioned, are version 0.
+var sessionMigrations = []func(fields map[string]json.RawMessage) error{
+	// Version 1 only adds the schema version, and the optional expiry of the refresh token.
+	0: func(fields map[string]json.RawMessage) error { return nil },
+}
+
+// persistedSession is the format of the session files.
+type persistedSession struct {
+	SchemaVersion int `json:"schema_version"`
+	*AuthenticatedSession
+}
+
+// Get returns the session of the file, cached after the first read.
+// Session files written by previous versions of the library are migrated to Path.
 func (s *FileSessionStore) Get(ctx context.Context) (*AuthenticatedSession, error) {
 	if s.session != nil {
 		return s.session, nil
 	}
-	data, err := os.ReadFile(s.Path)
+	path := s.Path
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) && s.legacyPath != "" {
+		path = s.legacyPath
+		data, err = os.ReadFile(path)
+	}
 	if err != nil {
 		return nil, err
 	}
-	var session AuthenticatedSession
+	session, migrated, err := decodeSession(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the session file %s: %w", path, err)
+	}
+	// Sessions read from the legacy path are copied to the current one.
+	migrated = migrated || path != s.Path
+	if migrated {
+		// The session remains usable when the file can't be written, it is migrated again on the next read.
+		_ = s.Save(ctx, session)
+	}
+	s.session = session
+	return session, nil
+}
+
+// decodeSession decodes a session file, migrating it to the current schema version when needed.
+func decodeSession(data []byte) (*AuthenticatedSession, bool, error) {
+	fields := map[string]json.RawMessage{}
+	err := json.Unmarshal(data, &fields)
+	if err != nil {
+		return nil, false, err
+	}
+	version := 0
+	if raw, ok := fields["schema_version"]; ok {
+		err = json.Unmarshal(raw, &version)
+		if err != nil {
+			return nil, false, fmt.Errorf("invalid schema version: %w", err)
+		}
+	}
+	if version < 0 || version > sessionSchemaVersion {
+		return nil, false, fmt.Errorf("%w %d, the latest supported version is %d", ErrUnsupportedSessionSchema, version, sessionSchemaVersion)
+	}
+	migrated := version < sessionSchemaVersion
+	for ; version < sessionSchemaVersion; version++ {
+		err = sessionMigrations[version](fields)
+		if err != nil {
+			return nil, false, fmt.Errorf("failed to migrate the session from schema version %d: %w", version, err)
+		}
+	}
+	data, err = json.Marshal(fields)
+	if err != nil {
+		return nil, false, err
+	}
+	session := persistedSession{AuthenticatedSession: &AuthenticatedSession{}}
 	err = json.Unmarshal(data, &session)
 	if err != nil {
-		return nil, err
+		return nil, false, err
 	}
-	s.session = &session
-	return &session, nil
+	return session.AuthenticatedSession, migrated, nil
 }
 
 func (s *FileSessionStore) Save(ctx context.Context, session *AuthenticatedSession) error {
 	s.session = session
-	data, err := json.Marshal(session)
+	data, err := json.Marshal(persistedSession{SchemaVersion: sessionSchemaVersion, AuthenticatedSession: session})
+	if err != nil {
+		return err
+	}
+	dir := filepath.Dir(s.Path)
+	err = os.MkdirAll(dir, 0700)
+	if err != nil {
+		return fmt.Errorf("failed to create the session directory %s: %w", dir, err)
+	}
+	return writeFileAtomic(s.Path, data, 0600)
+}
+
+// writeFileAtomic writes data to a temporary file next to path, syncs it, then renames it to path.
+// Readers either see the previous content or the new one, never a partially written file,
+// even when the process dies mid-write.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	// Removing the temporary file fails once it was renamed, which is expected.
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(data)
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+	err = tmp.Chmod(perm)
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+	// Flush the content to disk before the rename, otherwise a crash could
+	// persist the rename but not the content.
+	err = tmp.Sync()
 	if err != nil {
+		tmp.Close()
 		return err
 	}
-	err = os.MkdirAll(filepath.Dir(s.Path), 0700)
+	err = tmp.Close()
 	if err != nil {
 		return err
 	}
-	return os.WriteFile(s.Path, data, 0600)
+	return os.Rename(tmp.Name(), path)
 }
@@ -2,7 +2,10 @@ package bmwcardata
 
 import (
 	"context"
+	"io/fs"
+	"os"
 	"path/filepath"
+	"runtime"
 	"testing"
 	"time"
 
@@ -60,3 +63,166 @@ func TestFileSessionManager_SaveGet_ReadsFromDiskAndCaches(t *testing.T) {
 	assert.Equal(t, stored.AccessToken, cached.AccessToken)
 	assert.Equal(t, stored.RefreshToken, cached.RefreshToken)
 }
+
+func TestDefaultSessionPath(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+
+	t.Run("environment variable override", func(t *testing.T) {
+		t.Setenv(SessionPathEnv, "/custom/session.json")
+		t.Setenv("XDG_DATA_HOME", "/xdg")
+		path, err := DefaultSessionPath()
+		require.NoError(t, err)
+		assert.Equal(t, "/custom/session.json", path)
+	})
+
+	if runtime.GOOS == "windows" {
+		return
+	}
+
+	t.Run("XDG data home", func(t *testing.T) {
+		t.Setenv(SessionPathEnv, "")
+		t.Setenv("XDG_DATA_HOME", "/xdg")
+		path, err := DefaultSessionPath()
+		require.NoError(t, err)
+		assert.Equal(t, filepath.Join("/xdg", "bmw-cardata", "session.json"), path)
+	})
+
+	t.Run("relative XDG data home is ignored", func(t *testing.T) {
+		t.Setenv(SessionPathEnv, "")
+		t.Setenv("XDG_DATA_HOME", "relative")
+		path, err := DefaultSessionPath()
+		require.NoError(t, err)
+		assert.Equal(t, filepath.Join(home, ".local", "share", "bmw-cardata", "session.json"), path)
+	})
+
+	t.Run("home fallback", func(t *testing.T) {
+		t.Setenv(SessionPathEnv, "")
+		t.Setenv("XDG_DATA_HOME", "")
+		path, err := DefaultSessionPath()
+		require.NoError(t, err)
+		assert.Equal(t, filepath.Join(home, ".local", "share", "bmw-cardata", "session.json"), path)
+	})
+
+	t.Run("no home directory", func(t *testing.T) {
+		t.Setenv(SessionPathEnv, "")
+		t.Setenv("XDG_DATA_HOME", "")
+		t.Setenv("HOME", "")
+		_, err := DefaultSessionPath()
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), SessionPathEnv)
+	})
+}
+
+func TestNewFileSessionStore_LegacyPath(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("HOME does not set the home directory on Windows")
+	}
+	ctx := context.Background()
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv(SessionPathEnv, "")
+	t.Setenv("XDG_DATA_HOME", filepath.Join(home, "xdg"))
+	legacy := &FileSessionStore{Path: filepath.Join(home, ".local", "share", "bmw-cardata", "session.json")}
+	require.NoError(t, legacy.Save(ctx, &AuthenticatedSession{AccessToken: "legacy"}))
+
+	store, err := NewFileSessionStore("")
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(home, "xdg", "bmw-cardata", "session.json"), store.Path)
+	got, err := store.Get(ctx)
+	require.NoError(t, err, "the session of the legacy path must be read when there is none at the default path")
+	assert.Equal(t, "legacy", got.AccessToken)
+	got, err = (&FileSessionStore{Path: store.Path}).Get(ctx)
+	require.NoError(t, err, "the legacy session must be copied to the default path")
+	assert.Equal(t, "legacy", got.AccessToken)
+
+	require.NoError(t, store.Save(ctx, &AuthenticatedSession{AccessToken: "current"}))
+	store, err = NewFileSessionStore("")
+	require.NoError(t, err)
+	got, err = store.Get(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "current", got.AccessToken, "the default path must take precedence over the legacy one")
+
+	store, err = NewFileSessionStore(filepath.Join(home, "explicit.json"))
+	require.NoError(t, err)
+	_, err = store.Get(ctx)
+	require.ErrorIs(t, err, fs.ErrNotExist, "explicit paths must not fall back to the legacy one")
+}
+
+func TestFileSessionStore_SaveCreatesDirectory(t *testing.T) {
+	ctx := context.Background()
+	dir := filepath.Join(t.TempDir(), "missing", "bmw-cardata")
+	store := &FileSessionStore{Path: filepath.Join(dir, "session.json")}
+	require.NoError(t, store.Save(ctx, &AuthenticatedSession{AccessToken: "tok"}))
+
+	got, err := (&FileSessionStore{Path: store.Path}).Get(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "tok", got.AccessToken)
+	if runtime.GOOS != "windows" {
+		info, err := os.Stat(dir)
+		require.NoError(t, err)
+		assert.Equal(t, os.FileMode(0700), info.Mode().Perm())
+		info, err = os.Stat(store.Path)
+		require.NoError(t, err)
+		assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+	}
+}
+
+func TestFileSessionStore_SaveReplacesAtomically(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	store := &FileSessionStore{Path: filepath.Join(dir, "session.json")}
+	require.NoError(t, store.Save(ctx, &AuthenticatedSession{AccessToken: "a-much-longer-first-token"}))
+	require.NoError(t, store.Save(ctx, &AuthenticatedSession{AccessToken: "tok"}))
+
+	got, err := (&FileSessionStore{Path: store.Path}).Get(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "tok", got.AccessToken)
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Len(t, entries, 1, "no temporary file must be left behind")
+	assert.Equal(t, "session.json", entries[0].Name())
+}
+
+func TestFileSessionStore_InterruptedSave(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	store := &FileSessionStore{Path: filepath.Join(dir, "session.json")}
+	require.NoError(t, store.Save(ctx, &AuthenticatedSession{AccessToken: "original"}))
+
+	// Simulate a process dying after writing the temporary file, before renaming it.
+	require.NoError(t, os.WriteFile(filepath.Join(dir, ".session.json.tmp-interrupted"), []byte(`{"access_token":"trunc`), 0600))
+
+	got, err := (&FileSessionStore{Path: store.Path}).Get(ctx)
+	require.NoError(t, err, "the session file must be intact")
+	assert.Equal(t, "original", got.AccessToken)
+
+	require.NoError(t, (&FileSessionStore{Path: store.Path}).Save(ctx, &AuthenticatedSession{AccessToken: "next"}))
+	got, err = (&FileSessionStore{Path: store.Path}).Get(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "next", got.AccessToken, "leftover temporary files must not prevent saving")
+}
+
+func TestFileSessionStore_Migration(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "session.json")
+	v0 := `{"ClientID":"` + testClientID + `","access_token":"acc","expires_at":"2025-01-01T12:00:00Z","gcid":"gcid","refresh_token":"ref","scope":"openid","token_type":"Bearer"}`
+	require.NoError(t, os.WriteFile(path, []byte(v0), 0600))
+
+	session, err := (&FileSessionStore{Path: path}).Get(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, testClientID, session.ClientID.String())
+	assert.Equal(t, "acc", session.AccessToken)
+	assert.Equal(t, "ref", session.RefreshToken)
+	assert.Equal(t, time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC), session.ExpiresAt.UTC())
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), `"schema_version":1`, "the file must be migrated in place")
+	migrated, err := (&FileSessionStore{Path: path}).Get(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, session, migrated)
+
+	require.NoError(t, os.WriteFile(path, []byte(`{"schema_version":2,"access_token":"acc"}`), 0600))
+	_, err = (&FileSessionStore{Path: path}).Get(context.Background())
+	assert.ErrorIs(t, err, ErrUnsupportedSessionSchema)
+}
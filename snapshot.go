@@ -0,0 +1,162 @@
+package bmwcardata
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/tjamet/bmw-cardata/cardataapi"
+)
+
+// DefaultSnapshotChargingHistoryWindow is how far back GetVehicleSnapshot looks for the latest charging session,
+// see WithSnapshotChargingHistoryWindow.
+const DefaultSnapshotChargingHistoryWindow = 30 * 24 * time.Hour
+
+// SnapshotSection identifies the sections of a Snapshot, as keys of its errors.
+type SnapshotSection string
+
+const (
+	SnapshotBasicData       SnapshotSection = "basicData"
+	SnapshotChargingSession SnapshotSection = "chargingSession"
+	SnapshotTyreDiagnosis   SnapshotSection = "tyreDiagnosis"
+	SnapshotImage           SnapshotSection = "image"
+)
+
+// Snapshot holds the data of a vehicle fetched at once by GetVehicleSnapshot.
+// A section which failed to be fetched is left nil, and its error is recorded in Errors.
+type Snapshot struct {
+	VIN       string
+	FetchedAt time.Time
+
+	BasicData *cardataapi.VehicleDto
+	// LatestChargingSession is the charging session started last within the charging history window,
+	// nil when the vehicle was not charged within the window.
+	LatestChargingSession *cardataapi.ChargingSessionDto
+	TyreDiagnosis         *cardataapi.SmartMaintenanceTyreDiagnosisDto
+	// Image is only fetched with WithSnapshotImage.
+	Image *Image
+
+	// Errors holds the error of each section which failed to be fetched.
+	Errors map[SnapshotSection]error
+}
+
+// Err returns the errors of the failed sections joined together, nil when all the sections were fetched.
+func (s *Snapshot) Err() error {
+	sections := make([]string, 0, len(s.Errors))
+	for section := range s.Errors {
+		sections = append(sections, string(section))
+	}
+	sort.Strings(sections)
+	errs := make([]error, 0, len(sections))
+	for _, section := range sections {
+		errs = append(errs, fmt.Errorf("%s: %w", section, s.Errors[SnapshotSection(section)]))
+	}
+	return errors.Join(errs...)
+}
+
+type vehicleSnapshotOptions struct {
+	chargingHistoryWindow time.Duration
+	image                 bool
+	imageOptions          []GetImageOption
+}
+
+// GetVehicleSnapshotOption is an option of GetVehicleSnapshot.
+type GetVehicleSnapshotOption func(*vehicleSnapshotOptions)
+
+// WithSnapshotImage includes the image of the vehicle in the snapshot, fetched with the given options.
+// It is not fetched by default, as it is much larger than the other sections and rarely changes.
+func WithSnapshotImage(options ...GetImageOption) GetVehicleSnapshotOption {
+	return func(o *vehicleSnapshotOptions) {
+		o.image = true
+		o.imageOptions = options
+	}
+}
+
+// WithSnapshotChargingHistoryWindow sets how far back the latest charging session is looked for,
+// DefaultSnapshotChargingHistoryWindow by default.
+func WithSnapshotChargingHistoryWindow(window time.Duration) GetVehicleSnapshotOption {
+	return func(o *vehicleSnapshotOptions) {
+		o.chargingHistoryWindow = window
+	}
+}
+
+// GetVehicleSnapshot concurrently fetches the basic data, the latest charging session, the tyre diagnosis
+// and, with WithSnapshotImage, the image of a vehicle, in a single snapshot.
+// A section failing to be fetched does not fail the others: the snapshot holds the sections which were fetched,
+// and the errors of the others, see Snapshot.Errors.
+// An error is only returned, along with the partial snapshot, when the VIN is invalid or the context is done.
+// As the sections are fetched concurrently, their raw responses are not recorded in a RawResponse
+// of the context, see ContextWithRawResponse, which is left untouched.
+func (c *Client) GetVehicleSnapshot(ctx context.Context, vin string, options ...GetVehicleSnapshotOption) (*Snapshot, error) {
+	vin, err := c.normalizeVIN(vin)
+	if err != nil {
+		return nil, err
+	}
+	sectionCtx := withoutRawResponse(ctx)
+	o := vehicleSnapshotOptions{chargingHistoryWindow: DefaultSnapshotChargingHistoryWindow}
+	for _, option := range options {
+		option(&o)
+	}
+	snapshot := &Snapshot{VIN: vin, FetchedAt: c.now(), Errors: map[SnapshotSection]error{}}
+
+	mu := sync.Mutex{}
+	wg := sync.WaitGroup{}
+	fetch := func(section SnapshotSection, f func() error) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := f(); err != nil {
+				mu.Lock()
+				snapshot.Errors[section] = err
+				mu.Unlock()
+			}
+		}()
+	}
+	// Each section sets its own field, only the errors are shared.
+	fetch(SnapshotBasicData, func() (err error) {
+		snapshot.BasicData, err = c.GetBasicData(sectionCtx, vin)
+		return err
+	})
+	fetch(SnapshotChargingSession, func() (err error) {
+		snapshot.LatestChargingSession, err = c.latestChargingSession(sectionCtx, vin, snapshot.FetchedAt.Add(-o.chargingHistoryWindow), snapshot.FetchedAt)
+		return err
+	})
+	fetch(SnapshotTyreDiagnosis, func() (err error) {
+		snapshot.TyreDiagnosis, err = c.GetSmartMaintenanceTyreDiagnosis(sectionCtx, vin)
+		return err
+	})
+	if o.image {
+		fetch(SnapshotImage, func() (err error) {
+			snapshot.Image, err = c.GetImage(sectionCtx, vin, o.imageOptions...)
+			return err
+		})
+	}
+	wg.Wait()
+	return snapshot, ctx.Err()
+}
+
+// latestChargingSession returns the charging session started last between from and to, nil if there is none.
+func (c *Client) latestChargingSession(ctx context.Context, vin string, from, to time.Time) (*cardataapi.ChargingSessionDto, error) {
+	var latest *cardataapi.ChargingSessionDto
+	it := c.IterateChargingHistory(vin, from, to)
+	for {
+		session, ok, err := it.Next(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return latest, nil
+		}
+		if latest == nil || session.StartTime > latest.StartTime {
+			latest = &session
+		}
+	}
+}
+
+// now returns the current time of the client clock, the system one by default.
+func (c *Client) now() time.Time {
+	return c.getClock().Now()
+}
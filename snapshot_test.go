@@ -0,0 +1,129 @@
+package bmwcardata
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tjamet/bmw-cardata/cardataapi"
+)
+
+func TestGetVehicleSnapshot(t *testing.T) {
+	clock := newFakeClock()
+	now := clock.Now()
+	mock := &mockCardataClient{
+		GetBasicDataFunc: func(ctx context.Context, vin string, params *cardataapi.GetBasicDataParams, _ ...cardataapi.RequestEditorFn) (*http.Response, error) {
+			return jsonResponse(http.StatusOK, cardataapi.VehicleDto{Brand: p(cardataapi.BMW)}, nil), nil
+		},
+		GetChargingHistoryFunc: func(ctx context.Context, vin string, params *cardataapi.GetChargingHistoryParams, _ ...cardataapi.RequestEditorFn) (*http.Response, error) {
+			assert.Equal(t, now.Add(-7*24*time.Hour), params.From)
+			assert.Equal(t, now, params.To)
+			return jsonResponse(http.StatusOK, cardataapi.ChargingHistoryResponseDto{Data: []cardataapi.ChargingSessionDto{
+				{StartTime: 100, DisplayedSoc: 80},
+				{StartTime: 300, DisplayedSoc: 90},
+				{StartTime: 200, DisplayedSoc: 70},
+			}}, nil), nil
+		},
+		GetSmartMaintenanceTyreDiagnosisFunc: func(ctx context.Context, vin string, params *cardataapi.GetSmartMaintenanceTyreDiagnosisParams, _ ...cardataapi.RequestEditorFn) (*http.Response, error) {
+			return nil, errors.New("connection reset")
+		},
+		GetImageFunc: func(ctx context.Context, vin string, params *cardataapi.GetImageParams, _ ...cardataapi.RequestEditorFn) (*http.Response, error) {
+			t.Error("the image must only be fetched with WithSnapshotImage")
+			return nil, errors.New("unexpected call")
+		},
+	}
+	c := &Client{carDataAPI: mock, clock: clock}
+
+	snapshot, err := c.GetVehicleSnapshot(context.Background(), " wba00000000000000 ", WithSnapshotChargingHistoryWindow(7*24*time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, "WBA00000000000000", snapshot.VIN)
+	assert.Equal(t, now, snapshot.FetchedAt)
+	require.NotNil(t, snapshot.BasicData)
+	assert.Equal(t, cardataapi.BMW, *snapshot.BasicData.Brand)
+	require.NotNil(t, snapshot.LatestChargingSession)
+	assert.Equal(t, int32(90), snapshot.LatestChargingSession.DisplayedSoc)
+	assert.Nil(t, snapshot.TyreDiagnosis, "a failed section must not fail the others")
+	assert.Nil(t, snapshot.Image)
+	require.Len(t, snapshot.Errors, 1)
+	assert.ErrorContains(t, snapshot.Errors[SnapshotTyreDiagnosis], "connection reset")
+	assert.ErrorContains(t, snapshot.Err(), "tyreDiagnosis: connection reset")
+}
+
+func TestGetVehicleSnapshot_Image(t *testing.T) {
+	mock := &mockCardataClient{
+		GetBasicDataFunc: func(ctx context.Context, vin string, params *cardataapi.GetBasicDataParams, _ ...cardataapi.RequestEditorFn) (*http.Response, error) {
+			return jsonResponse(http.StatusOK, cardataapi.VehicleDto{}, nil), nil
+		},
+		GetChargingHistoryFunc: func(ctx context.Context, vin string, params *cardataapi.GetChargingHistoryParams, _ ...cardataapi.RequestEditorFn) (*http.Response, error) {
+			return jsonResponse(http.StatusOK, cardataapi.ChargingHistoryResponseDto{}, nil), nil
+		},
+		GetSmartMaintenanceTyreDiagnosisFunc: func(ctx context.Context, vin string, params *cardataapi.GetSmartMaintenanceTyreDiagnosisParams, _ ...cardataapi.RequestEditorFn) (*http.Response, error) {
+			return jsonResponse(http.StatusOK, cardataapi.SmartMaintenanceTyreDiagnosisDto{}, nil), nil
+		},
+		GetImageFunc: func(ctx context.Context, vin string, params *cardataapi.GetImageParams, _ ...cardataapi.RequestEditorFn) (*http.Response, error) {
+			return bytesResponse(http.StatusOK, []byte{1, 2, 3}, map[string]string{"Content-Type": "image/png"}), nil
+		},
+	}
+	c := &Client{carDataAPI: mock}
+
+	snapshot, err := c.GetVehicleSnapshot(context.Background(), "WBA00000000000000", WithSnapshotImage())
+	require.NoError(t, err)
+	assert.Empty(t, snapshot.Errors)
+	assert.NoError(t, snapshot.Err())
+	assert.Nil(t, snapshot.LatestChargingSession, "no session was started within the window")
+	assert.NotNil(t, snapshot.TyreDiagnosis)
+	require.NotNil(t, snapshot.Image)
+	assert.Equal(t, "image/png", snapshot.Image.ContentType)
+}
+
+func TestGetVehicleSnapshot_Cancelled(t *testing.T) {
+	failOnCancel := func(ctx context.Context) (*http.Response, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	mock := &mockCardataClient{
+		GetBasicDataFunc: func(ctx context.Context, vin string, params *cardataapi.GetBasicDataParams, _ ...cardataapi.RequestEditorFn) (*http.Response, error) {
+			return failOnCancel(ctx)
+		},
+		GetChargingHistoryFunc: func(ctx context.Context, vin string, params *cardataapi.GetChargingHistoryParams, _ ...cardataapi.RequestEditorFn) (*http.Response, error) {
+			return failOnCancel(ctx)
+		},
+		GetSmartMaintenanceTyreDiagnosisFunc: func(ctx context.Context, vin string, params *cardataapi.GetSmartMaintenanceTyreDiagnosisParams, _ ...cardataapi.RequestEditorFn) (*http.Response, error) {
+			return failOnCancel(ctx)
+		},
+	}
+	c := &Client{carDataAPI: mock}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	snapshot, err := c.GetVehicleSnapshot(ctx, "WBA00000000000000")
+	require.ErrorIs(t, err, context.Canceled)
+	require.NotNil(t, snapshot)
+	assert.Len(t, snapshot.Errors, 3)
+	assert.ErrorIs(t, snapshot.Errors[SnapshotBasicData], context.Canceled)
+}
+
+func TestGetVehicleSnapshot_RawResponse(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{}`))
+	}))
+	defer server.Close()
+	client, err := NewClient(WithCarDataServer(server.URL), WithAuthenticator(&staticAuthenticator{session: &AuthenticatedSession{AccessToken: "acc"}}))
+	require.NoError(t, err)
+
+	ctx, raw := ContextWithRawResponse(context.Background())
+	snapshot, err := client.GetVehicleSnapshot(ctx, "WBA00000000000000")
+	require.NoError(t, err)
+	assert.Empty(t, snapshot.Errors)
+	assert.Equal(t, RawResponse{}, *raw, "the concurrent section calls must not record their raw response")
+
+	_, err = client.GetBasicData(ctx, "WBA00000000000000")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, raw.StatusCode, "the context must still record the other calls")
+}
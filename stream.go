@@ -4,16 +4,19 @@ import (
 	"context"
 	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"log/slog"
 	"net/url"
 	"os"
 	"os/signal"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/eclipse/paho.golang/autopaho"
 	"github.com/eclipse/paho.golang/paho"
-	"github.com/google/uuid"
 )
 
 const (
@@ -59,6 +62,34 @@ func (c MQTTError) Error() string {
 	return fmt.Sprintf("%d (%s): %s", int(c), ReasonCode(c).Name(), ReasonCode(c).Description())
 }
 
+// ReasonCode returns the MQTT reason code of the error.
+func (c MQTTError) ReasonCode() ReasonCode {
+	return ReasonCode(c)
+}
+
+// Name returns the name of the MQTT reason code, like "Not authorized".
+func (c MQTTError) Name() string {
+	return ReasonCode(c).Name()
+}
+
+// Description returns the description of the MQTT reason code.
+func (c MQTTError) Description() string {
+	return ReasonCode(c).Description()
+}
+
+// MQTT reason codes the streaming broker may reject connections or disconnect with.
+// They are returned by StartEventStream and reported on StreamErrors as MQTTError,
+// which can be checked with errors.Is(err, MQTTError(ReasonCodeNotAuthorized)), or retrieved with errors.As.
+const (
+	ReasonCodeBadUserNameOrPassword  ReasonCode = 0x86
+	ReasonCodeNotAuthorized          ReasonCode = 0x87
+	ReasonCodeServerUnavailable      ReasonCode = 0x88
+	ReasonCodeServerBusy             ReasonCode = 0x89
+	ReasonCodeBanned                 ReasonCode = 0x8A
+	ReasonCodeQuotaExceeded          ReasonCode = 0x97
+	ReasonCodeConnectionRateExceeded ReasonCode = 0x9F
+)
+
 type MQTTReasonCode struct {
 	ReasonCode ReasonCode
 	Name       string
@@ -76,6 +107,45 @@ var (
 	streamingURL = Must(url.Parse(StreamingEndpoint))
 )
 
+// ErrMissingIDToken is returned when the session has no valid id_token to authenticate against
+// the streaming broker, even after a refresh.
+var ErrMissingIDToken = errors.New("no valid id_token to authenticate against the streaming broker, make sure the openid scope is requested")
+
+// ErrEventStreamNotStarted is returned when subscribing before the event stream is started.
+var ErrEventStreamNotStarted = errors.New("the event stream is not started, call StartEventStream first or use WithEventStreamAutoStart")
+
+// ErrInvalidSubscriptionVIN is returned when subscribing to a VIN that can't be used as an MQTT topic level.
+var ErrInvalidSubscriptionVIN = errors.New("invalid subscription VIN")
+
+// ErrCallbacksStillRunning is returned by StopEventStream when subscription callbacks are still running
+// after the timeout set with WithCallbackDrainTimeout.
+var ErrCallbacksStillRunning = errors.New("subscription callbacks are still running after the drain timeout")
+
+// ErrTooManyReconnects is reported on StreamErrors when the event stream is stopped after
+// the number of consecutive connection failures set with WithMaxReconnects.
+// The event stream can then be started again with StartEventStream.
+var ErrTooManyReconnects = errors.New("too many failed connections to the streaming broker")
+
+// ErrStreamConnectTimeout is returned by StartEventStream when the streaming broker can't be connected to
+// within the timeout set with WithStreamConnectTimeout.
+var ErrStreamConnectTimeout = errors.New("timed out connecting to the streaming broker")
+
+// DefaultStreamSubscribeTimeout is the default time to wait for the broker to acknowledge
+// a subscription, see WithStreamSubscribeTimeout.
+const DefaultStreamSubscribeTimeout = 30 * time.Second
+
+// streamErrorsBuffer is the number of errors StreamErrors holds before dropping new ones.
+const streamErrorsBuffer = 16
+
+// StreamErrors returns a channel receiving the errors of the event stream, like failed connection attempts.
+// Errors are dropped when the channel is full, reading it is optional.
+// The channel is shared across event stream restarts and never closed, use Done to know when the stream ends.
+func (c *Client) StreamErrors() <-chan error {
+	return c.streamErrors
+}
+
+// StreamedMessage is a message of the event stream, holding telematic data of the vehicle.
+// EntityID identifies the entity publishing the message, see SubscribeEntity.
 type StreamedMessage struct {
 	VIN       string                         `json:"vin"`
 	EntityID  string                         `json:"entityId"`
@@ -132,13 +202,38 @@ type StreamedDataDetails struct {
 }
 
 type streamingManager struct {
-	Authenticator     AuthenticatorInterface
-	connectionManager *autopaho.ConnectionManager
-	subscriptions     map[string]map[string]func(message StreamedMessage)
-	m                 sync.Mutex
-	streamingURL      *url.URL
-	stop              context.CancelFunc
-	ctx               context.Context
+	Authenticator      AuthenticatorInterface
+	connectionManager  *autopaho.ConnectionManager
+	subscriptions      *subscriptionRegistry
+	messageHandler     func(message StreamedMessage)
+	insecureSkipVerify bool
+	// callbacks tracks the running callback goroutines, see WithCallbackDrainTimeout.
+	callbacks sync.WaitGroup
+	// draining is set once the manager waits for the callbacks, no callback is started afterwards.
+	draining bool
+	// maxReconnects is the number of consecutive connection failures after which the stream is stopped.
+	maxReconnects   int
+	connectFailures int
+	// subscribeTimeout bounds the subscribe and unsubscribe calls, see WithStreamSubscribeTimeout.
+	subscribeTimeout time.Duration
+	// connectTimeout bounds the initial connection, see WithStreamConnectTimeout.
+	connectTimeout time.Duration
+	// cancelConnect aborts the wait for the initial connection, with the cause returned by StartEventStream.
+	cancelConnect context.CancelCauseFunc
+	// keepAlive and livenessTimeout detect the broken connections, see WithKeepAlive and WithStreamLivenessTimeout.
+	keepAlive       time.Duration
+	livenessTimeout time.Duration
+	streamErrors    chan error
+	dedup           *messageDeduplicator
+	history         *messageHistory
+	clock           Clock
+	mqttClientID    string
+	// activity is the time of the last received message, in nanoseconds since epoch, see WithContainerWatchdog.
+	activity     atomic.Int64
+	m            sync.Mutex
+	streamingURL *url.URL
+	stop         context.CancelFunc
+	ctx          context.Context
 }
 
 type Subscription struct {
@@ -149,54 +244,300 @@ type Subscription struct {
 // Subscribe registers a callback for the provided VINs. The MQTT connection is shared across
 // subscriptions and is managed by the client. The returned subscription ID can be used to
 // unsubscribe later on.
+// The event stream must be started with StartEventStream before subscribing, unless the client
+// was created with WithEventStreamAutoStart, in which case the first subscription starts it.
 func (c *Client) Subscribe(ctx context.Context, vin string, callback func(message StreamedMessage)) (*Subscription, error) {
 	if callback == nil {
 		return nil, fmt.Errorf("callback must not be nil")
 	}
-	subscription := Subscription{ID: uuid.New().String(), VIN: vin}
-	c.registerCallback(&subscription, callback)
+	return c.subscribe(ctx, vin, subscriber{callback: callback})
+}
+
+// RawMessageCallback receives the raw payload of a streamed message along with the result of its decoding:
+// either the decoded message, or the decoding error. topic is the MQTT topic, like <GCID>/<VIN>.
+type RawMessageCallback func(topic string, payload []byte, message *StreamedMessage, err error)
+
+// SubscribeRaw registers a callback for the provided VIN, like Subscribe, that also receives
+// the messages failing to be decoded, which never reach the callbacks registered with Subscribe.
+// This allows handling malformed messages inline with the data stream, in order.
+// Malformed messages are routed by the VIN of their topic.
+func (c *Client) SubscribeRaw(ctx context.Context, vin string, callback RawMessageCallback) (*Subscription, error) {
+	if callback == nil {
+		return nil, fmt.Errorf("callback must not be nil")
+	}
+	return c.subscribe(ctx, vin, subscriber{raw: callback})
+}
+
+// validateTopicLevel checks the VIN can be used as the level of the <GCID>/<VIN> topic
+// without matching unintended topics: wildcards are only allowed through AllVINs and AllTopics.
+func validateTopicLevel(vin string) error {
+	if vin == "" {
+		return fmt.Errorf("%w: the VIN must not be empty, use AllVINs to subscribe to all the vehicles", ErrInvalidSubscriptionVIN)
+	}
+	if strings.ContainsAny(vin, "#+/\x00") {
+		return fmt.Errorf("%w: %q must not contain MQTT wildcards, separators or null characters", ErrInvalidSubscriptionVIN, vin)
+	}
+	return nil
+}
 
-	err := c.streaming.Load().updateSubscriptions(ctx, c.subscriptions)
+func (c *Client) subscribe(ctx context.Context, vin string, callback subscriber) (*Subscription, error) {
+	if vin != AllVINs && vin != AllTopics {
+		normalized := ""
+		// The default VIN does not apply to subscriptions, empty VINs are rejected below.
+		if strings.TrimSpace(vin) != "" {
+			var err error
+			normalized, err = c.normalizeVIN(vin)
+			if err != nil {
+				return nil, err
+			}
+		}
+		err := validateTopicLevel(normalized)
+		if err != nil {
+			return nil, err
+		}
+		vin = normalized
+	}
+	if c.streaming.Load() == nil && !c.autoStartEventStream {
+		return nil, ErrEventStreamNotStarted
+	}
+	subscription := Subscription{ID: c.newID(), VIN: vin}
+	unlock, err := c.subscriptions.lockVIN(ctx, vin)
 	if err != nil {
 		return nil, err
 	}
+	defer unlock()
+	first := c.subscriptions.register(&subscription, callback)
+
+	if c.streaming.Load() == nil {
+		// The registered subscriptions are subscribed to once the connection is up.
+		err = c.StartEventStream()
+		if err != nil {
+			c.subscriptions.remove(&subscription)
+			return nil, err
+		}
+	}
+	// Load the manager once: the stream may be stopped concurrently.
+	m := c.streaming.Load()
+	if m == nil {
+		c.subscriptions.remove(&subscription)
+		return nil, ErrEventStreamNotStarted
+	}
+	if first {
+		err = m.subscribe(ctx, vin)
+		if err != nil {
+			c.subscriptions.remove(&subscription)
+			return nil, err
+		}
+	}
 	return &subscription, nil
 }
 
+// SubscribeKeys registers a callback for the provided VIN that is only invoked when the
+// received message contains at least one of the provided telematic keys.
+// The message passed to the callback is filtered to only contain the requested keys.
+func (c *Client) SubscribeKeys(ctx context.Context, vin string, keys []string, callback func(message StreamedMessage)) (*Subscription, error) {
+	if callback == nil {
+		return nil, fmt.Errorf("callback must not be nil")
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("keys must not be empty")
+	}
+	return c.Subscribe(ctx, vin, filterKeys(keys, callback))
+}
+
+// SubscribeEntity registers a callback for the messages of the provided entity, identified by
+// the EntityID of the messages, for vehicles publishing several entities.
+// BMW does not document how entities relate to vehicles, and the broker topics are per VIN only:
+// the messages of all the VINs are received and only the ones of the entity are delivered.
+func (c *Client) SubscribeEntity(ctx context.Context, entityID string, callback func(message StreamedMessage)) (*Subscription, error) {
+	if callback == nil {
+		return nil, fmt.Errorf("callback must not be nil")
+	}
+	if entityID == "" {
+		return nil, fmt.Errorf("entityID must not be empty")
+	}
+	return c.Subscribe(ctx, AllVINs, filterEntity(entityID, callback))
+}
+
+func filterEntity(entityID string, callback func(message StreamedMessage)) func(message StreamedMessage) {
+	return func(message StreamedMessage) {
+		if message.EntityID == entityID {
+			callback(message)
+		}
+	}
+}
+
+func filterKeys(keys []string, callback func(message StreamedMessage)) func(message StreamedMessage) {
+	wanted := make(map[string]struct{}, len(keys))
+	for _, key := range keys {
+		wanted[key] = struct{}{}
+	}
+	return func(message StreamedMessage) {
+		data := map[string]StreamedDataDetails{}
+		for key, value := range message.Data {
+			if _, ok := wanted[key]; ok {
+				data[key] = value
+			}
+		}
+		if len(data) == 0 {
+			return
+		}
+		message.Data = data
+		callback(message)
+	}
+}
+
 func (c *Client) Unsubscribe(ctx context.Context, subscription *Subscription) error {
 	if subscription == nil {
 		return fmt.Errorf("subscription must not be nil")
 	}
-	c.unregisterCallback(subscription)
-	err := c.streaming.Load().updateSubscriptions(ctx, c.subscriptions)
+	unlock, err := c.subscriptions.lockVIN(ctx, subscription.VIN)
 	if err != nil {
 		return err
 	}
+	defer unlock()
+	last := c.subscriptions.remove(subscription)
+	m := c.streaming.Load()
+	if m == nil || !last {
+		// Nothing to unsubscribe on the broker when the stream is not running
+		// or when other subscriptions still need the VIN.
+		return nil
+	}
+	return m.unsubscribe(ctx, subscription.VIN)
+}
+
+// subscriptionRegistry holds the callbacks registered for each VIN.
+// It is owned by the client and shared with the streaming manager so that both
+// always see the same subscriptions, across restarts of the event stream.
+type subscriptionRegistry struct {
+	m         sync.Mutex
+	callbacks map[string]map[string]subscriber
+	// vinLocks serialise, per VIN, the registration changes along with the matching broker
+	// (un)subscriptions, so that the broker subscriptions always end up matching the registry.
+	vinLocks map[string]*vinLock
+}
+
+// vinLock is the lock of a VIN, shared by its users and released once none is left.
+type vinLock struct {
+	m     contextMutex
+	users int
+}
+
+// lockVIN acquires the lock of the VIN, or returns the context error if it is done first.
+// The returned function releases the lock.
+func (r *subscriptionRegistry) lockVIN(ctx context.Context, vin string) (func(), error) {
+	r.m.Lock()
+	if r.vinLocks == nil {
+		r.vinLocks = make(map[string]*vinLock)
+	}
+	l, ok := r.vinLocks[vin]
+	if !ok {
+		l = &vinLock{}
+		r.vinLocks[vin] = l
+	}
+	l.users++
+	r.m.Unlock()
+
+	release := func() {
+		r.m.Lock()
+		defer r.m.Unlock()
+		l.users--
+		if l.users == 0 {
+			delete(r.vinLocks, vin)
+		}
+	}
+	if err := l.m.lock(ctx); err != nil {
+		release()
+		return nil, err
+	}
+	return func() {
+		l.m.unlock()
+		release()
+	}, nil
+}
+
+// subscriber is the callback of a subscription, either of decoded messages or of raw ones.
+type subscriber struct {
+	callback func(message StreamedMessage)
+	raw      RawMessageCallback
+	// enqueue, when set, receives the decoded messages synchronously, in the order they are received.
+	// It must not block the stream.
+	enqueue func(message StreamedMessage)
+	// removed, when not nil, is closed once the subscription is removed.
+	removed chan struct{}
+}
+
+// deliver returns the invocation of the subscriber for a message, nil when it does not handle it.
+func (s subscriber) deliver(topic string, payload []byte, message StreamedMessage, err error) func() {
+	switch {
+	case s.raw != nil && err != nil:
+		return func() { s.raw(topic, payload, nil, err) }
+	case s.raw != nil:
+		return func() { s.raw(topic, payload, &message, nil) }
+	case s.callback != nil && err == nil:
+		return func() { s.callback(message) }
+	}
 	return nil
 }
 
-func (c *Client) registerCallback(subscription *Subscription, callback func(message StreamedMessage)) {
-	c.m.Lock()
-	defer c.m.Unlock()
-	if c.subscriptions == nil {
-		c.subscriptions = make(map[string]map[string]func(message StreamedMessage))
+// register registers the subscriber and reports whether it is the first one for the subscription VIN.
+func (r *subscriptionRegistry) register(subscription *Subscription, callback subscriber) bool {
+	r.m.Lock()
+	defer r.m.Unlock()
+	if r.callbacks == nil {
+		r.callbacks = make(map[string]map[string]subscriber)
 	}
-	if _, ok := c.subscriptions[subscription.VIN]; !ok {
-		c.subscriptions[subscription.VIN] = make(map[string]func(message StreamedMessage))
+	_, ok := r.callbacks[subscription.VIN]
+	if !ok {
+		r.callbacks[subscription.VIN] = make(map[string]subscriber)
 	}
-	c.subscriptions[subscription.VIN][subscription.ID] = callback
+	r.callbacks[subscription.VIN][subscription.ID] = callback
+	return !ok
 }
 
-func (c *Client) unregisterCallback(subscription *Subscription) {
-	c.m.Lock()
-	defer c.m.Unlock()
-	if _, ok := c.subscriptions[subscription.VIN]; !ok {
-		return
+// remove unregisters the callback and reports whether it was the last one for the subscription VIN.
+func (r *subscriptionRegistry) remove(subscription *Subscription) bool {
+	r.m.Lock()
+	defer r.m.Unlock()
+	s, ok := r.callbacks[subscription.VIN][subscription.ID]
+	if !ok {
+		return false
+	}
+	if s.removed != nil {
+		close(s.removed)
+	}
+	delete(r.callbacks[subscription.VIN], subscription.ID)
+	if len(r.callbacks[subscription.VIN]) == 0 {
+		delete(r.callbacks, subscription.VIN)
+		return true
+	}
+	return false
+}
+
+func (r *subscriptionRegistry) vins() []string {
+	r.m.Lock()
+	defer r.m.Unlock()
+	vins := []string{}
+	for vin := range r.callbacks {
+		vins = append(vins, vin)
+	}
+	return vins
+}
+
+func (r *subscriptionRegistry) get(vin string) []subscriber {
+	r.m.Lock()
+	defer r.m.Unlock()
+	callbacks := []subscriber{}
+	for _, callback := range r.callbacks[vin] {
+		callbacks = append(callbacks, callback)
+	}
+	for _, callback := range r.callbacks[AllVINs] {
+		callbacks = append(callbacks, callback)
 	}
-	delete(c.subscriptions[subscription.VIN], subscription.ID)
-	if len(c.subscriptions[subscription.VIN]) == 0 {
-		delete(c.subscriptions, subscription.VIN)
+	for _, callback := range r.callbacks[AllTopics] {
+		callbacks = append(callbacks, callback)
 	}
+	return callbacks
 }
 
 func (c *Client) Done() <-chan struct{} {
@@ -211,27 +552,54 @@ func (c *Client) StartEventStream() error {
 	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, os.Kill)
 
 	candidate := &streamingManager{
-		Authenticator: c.Authenticator,
-		streamingURL:  c.StreamingURL,
-		subscriptions: c.subscriptions,
-		ctx:           ctx,
-		stop:          stop,
-	}
-
-	if c.streaming.CompareAndSwap(nil, candidate) {
-		// the new connection manager was successfully stored,
-		// we can start it.
-		// In case there is a concurrent call to `ensureStreamingManager`,
-		// it may happen that the other call wins and our candidate is not the one
-		// stored. In this case, we won't get here, but the other one will
-		// start the connection.
-		if err := candidate.connect(); err != nil {
-			return err
+		Authenticator:      c.Authenticator,
+		streamingURL:       c.StreamingURL,
+		subscriptions:      &c.subscriptions,
+		messageHandler:     c.messageHandler,
+		insecureSkipVerify: c.streamInsecureSkipVerify,
+		maxReconnects:      c.maxReconnects,
+		subscribeTimeout:   c.subscribeTimeout,
+		connectTimeout:     c.connectTimeout,
+		keepAlive:          c.keepAlive,
+		livenessTimeout:    c.livenessTimeout,
+		streamErrors:       c.streamErrors,
+		dedup:              c.dedup,
+		history:            c.history,
+		clock:              c.clock,
+		mqttClientID:       c.mqttClientID,
+		ctx:                ctx,
+		stop:               stop,
+	}
+
+	// A manager that stopped itself, after too many reconnections for instance, is replaced.
+	existing := c.streaming.Load()
+	for existing == nil || existing.ctx.Err() != nil {
+		if c.streaming.CompareAndSwap(existing, candidate) {
+			// the new connection manager was successfully stored,
+			// we can start it.
+			// In case there is a concurrent call to `ensureStreamingManager`,
+			// it may happen that the other call wins and our candidate is not the one
+			// stored. In this case, we won't get here, but the other one will
+			// start the connection.
+			if err := candidate.connect(); err != nil {
+				// Stop retrying in the background, so that the stream can be started again.
+				c.streaming.CompareAndSwap(candidate, nil)
+				candidate.stop()
+				return err
+			}
+			go func() {
+				// Forget the manager once it stops itself, so that the stream can be started again.
+				<-candidate.ctx.Done()
+				c.streaming.CompareAndSwap(candidate, nil)
+			}()
+			if c.watchdog != nil {
+				go c.watchdog.run(c, candidate)
+			}
+			return nil
 		}
-		return nil
-	} else {
-		candidate.stop()
+		existing = c.streaming.Load()
 	}
+	candidate.stop()
 	return nil
 }
 
@@ -249,18 +617,26 @@ func (c *Client) StopEventStream() error {
 	existing.stop()
 	// Wait for the context to be done, so we can be sure that the connection
 	<-existing.ctx.Done()
+	if c.callbackDrainTimeout > 0 {
+		return existing.waitCallbacks(c.callbackDrainTimeout)
+	}
 	return nil
 }
 
 func (m *streamingManager) connect() error {
+	if m.insecureSkipVerify {
+		slog.Warn("INSECURE: the streaming broker TLS certificate is not verified, never use WithStreamInsecureSkipVerify in production", "url", m.streamingURL)
+	}
 
 	cm, err := autopaho.NewConnection(m.ctx, m.autopahoConfig())
 	if err != nil {
 		return err
 	}
+	m.m.Lock()
 	m.connectionManager = cm
+	m.m.Unlock()
 
-	err = cm.AwaitConnection(m.ctx)
+	err = m.awaitConnection(cm)
 	if err != nil {
 		return err
 	}
@@ -272,13 +648,45 @@ func (m *streamingManager) connect() error {
 	return nil
 }
 
+// awaitConnection waits for the initial connection, up to the connect timeout, if any.
+// It fails with the MQTTError of the broker when it rejects the connection.
+func (m *streamingManager) awaitConnection(cm *autopaho.ConnectionManager) error {
+	ctx := m.ctx
+	if m.connectTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, m.connectTimeout)
+		defer cancel()
+	}
+	ctx, cancelConnect := context.WithCancelCause(ctx)
+	defer cancelConnect(nil)
+	m.m.Lock()
+	m.cancelConnect = cancelConnect
+	m.m.Unlock()
+	defer func() {
+		m.m.Lock()
+		m.cancelConnect = nil
+		m.m.Unlock()
+	}()
+
+	err := cm.AwaitConnection(ctx)
+	if err == nil || m.ctx.Err() != nil {
+		return err
+	}
+	var mqttErr MQTTError
+	if cause := context.Cause(ctx); errors.As(cause, &mqttErr) {
+		return fmt.Errorf("the streaming broker rejected the connection: %w", cause)
+	}
+	return fmt.Errorf("%w %s within %s", ErrStreamConnectTimeout, m.streamingURL, m.connectTimeout)
+}
+
 func (m *streamingManager) autopahoConfig() autopaho.ClientConfig {
 	return autopaho.ClientConfig{
 		ServerUrls: []*url.URL{m.streamingURL},
 		TlsCfg: &tls.Config{
-			MinVersion: tls.VersionTLS12,
+			MinVersion:         tls.VersionTLS12,
+			InsecureSkipVerify: m.insecureSkipVerify,
 		},
-		KeepAlive:                     20,
+		KeepAlive:                     m.keepAliveSeconds(),
 		ReconnectBackoff:              m.handlePahoReconnectBackoff,
 		CleanStartOnInitialConnection: false,
 		SessionExpiryInterval:         60,
@@ -287,7 +695,8 @@ func (m *streamingManager) autopahoConfig() autopaho.ClientConfig {
 		OnConnectError:                m.handlePahoConnectError,
 		ConnectPacketBuilder:          m.buildPahoConnectPacket,
 		ClientConfig: paho.ClientConfig{
-			ClientID:      ClientID,
+			ClientID:      m.mqttClientID,
+			PingHandler:   m.pinger(),
 			OnClientError: m.onPahoClientError,
 			OnPublishReceived: []func(paho.PublishReceived) (bool, error){
 				m.handlePahoPublishReceived,
@@ -298,29 +707,124 @@ func (m *streamingManager) autopahoConfig() autopaho.ClientConfig {
 }
 
 func (m *streamingManager) handlePahoPublishReceived(pr paho.PublishReceived) (bool, error) {
+	m.touch()
+	topic, payload := pr.Packet.Topic, pr.Packet.Payload
 	var msg StreamedMessage
-	if err := json.Unmarshal(pr.Packet.Payload, &msg); err != nil {
-		return true, fmt.Errorf("error unmarshaling message: %w", err)
+	err := json.Unmarshal(payload, &msg)
+	if err != nil {
+		err = fmt.Errorf("error unmarshaling message: %w", err)
+		// Only the raw subscribers handle malformed messages, routed by the VIN of the topic.
+		m.dispatch(m.subscriptions.get(topicVIN(topic)), topic, payload, msg, err)
+		return true, err
+	}
+	if m.dedup != nil && m.dedup.duplicate(msg) {
+		return true, nil
 	}
-	for _, callback := range m.getCallbacks(msg.VIN) {
-		go callback(msg)
+	if m.history != nil {
+		m.history.add(msg)
 	}
+	subscribers := m.subscriptions.get(msg.VIN)
+	if m.messageHandler != nil {
+		subscribers = append(subscribers, subscriber{callback: m.messageHandler})
+	}
+	m.dispatch(subscribers, topic, payload, msg, nil)
 	return true, nil
 }
 
+// dispatch invokes the subscribers handling the message, each in its own goroutine.
+// The subscribers queuing the messages are called in place, to preserve the order of the messages.
+func (m *streamingManager) dispatch(subscribers []subscriber, topic string, payload []byte, msg StreamedMessage, err error) {
+	m.m.Lock()
+	defer m.m.Unlock()
+	if m.draining {
+		return
+	}
+	for _, subscriber := range subscribers {
+		if subscriber.enqueue != nil {
+			if err == nil {
+				subscriber.enqueue(msg)
+			}
+			continue
+		}
+		deliver := subscriber.deliver(topic, payload, msg, err)
+		if deliver == nil {
+			continue
+		}
+		m.callbacks.Add(1)
+		go func() {
+			defer m.callbacks.Done()
+			deliver()
+		}()
+	}
+}
+
+// streamTopic returns the <GCID>/<VIN> topic the messages of the VIN are streamed on.
+func streamTopic(gcid, vin string) string {
+	return gcid + "/" + vin
+}
+
+// topicVIN returns the VIN of a <GCID>/<VIN> topic.
+func topicVIN(topic string) string {
+	return topic[strings.LastIndex(topic, "/")+1:]
+}
+
+// waitCallbacks waits for the running callbacks to return, for at most timeout.
+func (m *streamingManager) waitCallbacks(timeout time.Duration) error {
+	m.m.Lock()
+	m.draining = true
+	m.m.Unlock()
+	done := make(chan struct{})
+	go func() {
+		m.callbacks.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-m.getClock().After(timeout):
+		return ErrCallbacksStillRunning
+	}
+}
+
 func (m *streamingManager) handlePahoServerDisconnect(d *paho.Disconnect) {
 	if d.Properties != nil {
 		fmt.Printf("server requested disconnect: %s\n", d.Properties.ReasonString)
 	} else {
 		fmt.Printf("server requested disconnect; reason code: %d\n", d.ReasonCode)
 	}
+	m.reportError(fmt.Errorf("the streaming broker disconnected: %w", MQTTError(d.ReasonCode)))
 }
 
 func (m *streamingManager) handlePahoConnectError(err error) {
 	if connackErr, ok := err.(*autopaho.ConnackError); ok {
 		err = MQTTError(connackErr.ReasonCode)
+		// The broker rejected the initial connection, make StartEventStream return the reason.
+		m.m.Lock()
+		cancelConnect := m.cancelConnect
+		m.m.Unlock()
+		if cancelConnect != nil {
+			cancelConnect(err)
+		}
 	}
 	fmt.Printf("error whilst attempting connection: %s\n", err)
+	m.reportError(err)
+
+	m.m.Lock()
+	m.connectFailures++
+	giveUp := m.maxReconnects > 0 && m.connectFailures >= m.maxReconnects
+	m.m.Unlock()
+	if giveUp {
+		m.reportError(fmt.Errorf("%w: %d consecutive failures, last one: %w", ErrTooManyReconnects, m.maxReconnects, err))
+		m.stop()
+	}
+}
+
+// reportError sends the error on the StreamErrors channel, dropping it when the channel is full.
+func (m *streamingManager) reportError(err error) {
+	select {
+	case m.streamErrors <- err:
+	default:
+	}
 }
 
 func (m *streamingManager) handlePahoReconnectBackoff(attempt int) time.Duration {
@@ -329,96 +833,181 @@ func (m *streamingManager) handlePahoReconnectBackoff(attempt int) time.Duration
 
 func (m *streamingManager) onPahoClientError(err error) {
 	fmt.Printf("client error: %s\n", err)
+	m.reportError(err)
 }
 
 func (m *streamingManager) handlePahoConnectionDown() bool {
 	return true
 }
 
-func (m *streamingManager) listSubscribedVINs() []string {
+func (m *streamingManager) getConnectionManager() *autopaho.ConnectionManager {
 	m.m.Lock()
 	defer m.m.Unlock()
-	vins := []string{}
-	for vin := range m.subscriptions {
-		vins = append(vins, vin)
-	}
-	return vins
+	return m.connectionManager
 }
 
-func (m *streamingManager) getCallbacks(vin string) []func(message StreamedMessage) {
-	m.m.Lock()
-	defer m.m.Unlock()
-	callbacks := []func(message StreamedMessage){}
-	for _, callback := range m.subscriptions[vin] {
-		callbacks = append(callbacks, callback)
+// subscribe subscribes to the VIN topic when the connection is established.
+// Otherwise, the VIN is subscribed to once the connection is up.
+func (m *streamingManager) subscribe(ctx context.Context, vin string) error {
+	cm := m.getConnectionManager()
+	if cm == nil || m.messageHandler != nil {
+		// With a message handler, all the VINs are already subscribed to.
+		return nil
 	}
-	for _, callback := range m.subscriptions[AllVINs] {
-		callbacks = append(callbacks, callback)
+	gcid, err := sessionGcid(ctx, m.Authenticator)
+	if err != nil {
+		return err
 	}
-	for _, callback := range m.subscriptions[AllTopics] {
-		callbacks = append(callbacks, callback)
+	subscribe := &paho.Subscribe{
+		Subscriptions: []paho.SubscribeOptions{{Topic: streamTopic(gcid, vin), QoS: 1}},
 	}
-	return callbacks
+	ctx, cancel := m.withSubscribeTimeout(ctx)
+	defer cancel()
+	if _, err := cm.Subscribe(ctx, subscribe); err != nil {
+		return fmt.Errorf("failed to subscribe to VIN %s: %w", vin, err)
+	}
+	return nil
 }
 
-func (m *streamingManager) updateSubscriptions(ctx context.Context, newSubscriptions map[string]map[string]func(message StreamedMessage)) error {
-	if m == nil {
+// unsubscribe unsubscribes from the VIN topic when the connection is established.
+func (m *streamingManager) unsubscribe(ctx context.Context, vin string) error {
+	cm := m.getConnectionManager()
+	if cm == nil || m.messageHandler != nil {
 		return nil
 	}
-	m.m.Lock()
-	defer m.m.Unlock()
-	if m.connectionManager != nil {
-		unsubscribe := &paho.Unsubscribe{}
-		session, err := m.Authenticator.GetSession(m.ctx)
-		if err != nil {
-			fmt.Printf("error getting session: %s\n", err)
-			return err
-		}
-		for vin := range m.subscriptions {
-			if _, ok := newSubscriptions[vin]; !ok {
-				unsubscribe.Topics = append(unsubscribe.Topics, fmt.Sprintf("%s/%s", session.Gcid, vin))
-			}
-		}
-		if unsubscribe.Topics != nil {
-			if _, err := m.connectionManager.Unsubscribe(m.ctx, unsubscribe); err != nil {
-				fmt.Printf("failed to unsubscribe from topics: %s\n", err)
-				return err
-			}
-		}
+	gcid, err := sessionGcid(ctx, m.Authenticator)
+	if err != nil {
+		return err
+	}
+	unsubscribe := &paho.Unsubscribe{Topics: []string{streamTopic(gcid, vin)}}
+	ctx, cancel := m.withSubscribeTimeout(ctx)
+	defer cancel()
+	if _, err := cm.Unsubscribe(ctx, unsubscribe); err != nil {
+		return fmt.Errorf("failed to unsubscribe from VIN %s: %w", vin, err)
 	}
-	m.subscriptions = newSubscriptions
 	return nil
 }
 
+// subscribedVINs lists the VINs to subscribe to on the broker.
+// With a message handler, every message must be received, hence all the VINs are subscribed to.
+// The per-VIN topics are then not subscribed to, to avoid receiving duplicated messages
+// through overlapping subscriptions.
+func (m *streamingManager) subscribedVINs() []string {
+	if m.messageHandler != nil {
+		return []string{AllVINs}
+	}
+	return m.subscriptions.vins()
+}
+
 func (m *streamingManager) handlePahoConnectionUp(cm *autopaho.ConnectionManager, connAck *paho.Connack) {
-	session, err := m.Authenticator.GetSession(m.ctx)
+	m.m.Lock()
+	m.connectFailures = 0
+	m.m.Unlock()
+
+	if err := m.subscribeAll(m.ctx, cm); err != nil {
+		m.reportError(err)
+	}
+}
+
+// withSubscribeTimeout returns a context bounding the wait for the broker to acknowledge a (un)subscription.
+func (m *streamingManager) withSubscribeTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	timeout := m.subscribeTimeout
+	if timeout <= 0 {
+		timeout = DefaultStreamSubscribeTimeout
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// subscribeAll subscribes to the topics of all the subscribed VINs.
+func (m *streamingManager) subscribeAll(ctx context.Context, cm *autopaho.ConnectionManager) error {
+	gcid, err := sessionGcid(ctx, m.Authenticator)
 	if err != nil {
-		fmt.Printf("error getting session: %s\n", err)
-		return
+		return fmt.Errorf("error getting session: %w", err)
 	}
 
 	subscribe := &paho.Subscribe{}
-	for _, vin := range m.listSubscribedVINs() {
-		subscribe.Subscriptions = append(subscribe.Subscriptions, paho.SubscribeOptions{Topic: fmt.Sprintf("%s/%s", session.Gcid, vin), QoS: 1})
+	for _, vin := range m.subscribedVINs() {
+		subscribe.Subscriptions = append(subscribe.Subscriptions, paho.SubscribeOptions{Topic: streamTopic(gcid, vin), QoS: 1})
 	}
 	if subscribe.Subscriptions != nil {
-		if _, err := cm.Subscribe(m.ctx, subscribe); err != nil {
-			fmt.Printf("failed to subscribe to topics: %s\n", err)
+		ctx, cancel := m.withSubscribeTimeout(ctx)
+		defer cancel()
+		if _, err := cm.Subscribe(ctx, subscribe); err != nil {
+			return fmt.Errorf("failed to subscribe to topics: %w", err)
 		}
 	}
+	return nil
 }
 
-func (m *streamingManager) buildPahoConnectPacket(connect *paho.Connect, url *url.URL) (*paho.Connect, error) {
+// getClock returns the clock of the stream, the system one by default.
+func (m *streamingManager) getClock() Clock {
+	if m.clock == nil {
+		return systemClock{}
+	}
+	return m.clock
+}
+
+// touch records activity on the stream, postponing the container watchdog checks.
+func (m *streamingManager) touch() {
+	m.activity.Store(m.getClock().Now().UnixNano())
+}
+
+// lastActivity returns the time of the last activity on the stream.
+func (m *streamingManager) lastActivity() time.Time {
+	return time.Unix(0, m.activity.Load())
+}
+
+// sessionRefresher is implemented by authenticators able to force a session refresh, like Authenticator.
+type sessionRefresher interface {
+	RefreshSession(ctx context.Context) (*AuthenticatedSession, error)
+}
+
+// getStreamingSession returns a session with a valid id_token, used as the broker password.
+// When the id_token is missing or expired, typically when reconnecting after the broker
+// disconnected on token expiry, the session refresh is forced to get a fresh one.
+func (m *streamingManager) getStreamingSession() (*AuthenticatedSession, error) {
 	session, err := m.Authenticator.GetSession(m.ctx)
 	if err != nil {
 		return nil, err
 	}
+	err = session.requireScopes(ScopeCardataStreaming)
+	if err != nil {
+		return nil, err
+	}
+	if session.hasValidIDToken() {
+		return session, nil
+	}
+	refresher, ok := m.Authenticator.(sessionRefresher)
+	if !ok {
+		return nil, ErrMissingIDToken
+	}
+	session, err = refresher.RefreshSession(m.ctx)
+	if err != nil {
+		return nil, err
+	}
+	if !session.hasValidIDToken() {
+		return nil, ErrMissingIDToken
+	}
+	return session, nil
+}
+
+func (m *streamingManager) buildPahoConnectPacket(connect *paho.Connect, url *url.URL) (*paho.Connect, error) {
+	session, err := m.getStreamingSession()
+	if errors.Is(err, ErrMissingIDToken) || errors.Is(err, ErrMissingScope) {
+		// Retrying would only fail again with the same token, stop the stream instead.
+		m.reportError(err)
+		m.stop()
+		return nil, err
+	}
+	if err != nil {
+		return nil, err
+	}
 	connect.UsernameFlag = true
 	connect.PasswordFlag = true
 	connect.Username = session.Gcid
 	connect.Password = []byte(*session.IdToken)
 	connect.Properties = &paho.ConnectProperties{
-		SessionExpiryInterval: p(uint32(time.Until(session.ExpiresAt).Seconds())),
+		SessionExpiryInterval: p(uint32(max(session.ExpiresAt.Sub(m.getClock().Now()).Seconds(), 0))),
 	}
 	return connect, nil
 }
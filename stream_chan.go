@@ -0,0 +1,117 @@
+package bmwcardata
+
+import (
+	"context"
+	"sync"
+)
+
+// subscribeChanBuffer is the number of messages the channels returned by SubscribeChan hold
+// before the following ones are queued.
+const subscribeChanBuffer = 64
+
+// SubscribeChan registers a subscription for the provided VIN, like Subscribe, delivering the messages
+// on the returned channel instead of a callback, for select-based consumers.
+// The channel is closed when the subscription is removed with Unsubscribe, or when the event stream stops,
+// in which case the subscription is removed.
+// Messages are delivered in the order they are received. The stream does not wait for the consumer:
+// once the channel buffer is full, the messages are queued, without bound, until they are read.
+// Hence the channel must be read until it is closed, or the subscription removed, not to accumulate messages.
+func (c *Client) SubscribeChan(ctx context.Context, vin string) (<-chan StreamedMessage, *Subscription, error) {
+	messages := newMessageChannel()
+	removed := make(chan struct{})
+	subscription, err := c.subscribe(ctx, vin, subscriber{enqueue: messages.enqueue, removed: removed})
+	if err != nil {
+		messages.close()
+		return nil, nil, err
+	}
+	stopped := make(<-chan struct{})
+	if m := c.streaming.Load(); m != nil {
+		stopped = m.ctx.Done()
+	} else {
+		// The stream was stopped concurrently.
+		c.subscriptions.remove(subscription)
+	}
+	go func() {
+		select {
+		case <-removed:
+		case <-stopped:
+			c.subscriptions.remove(subscription)
+		}
+		messages.close()
+	}()
+	return messages.ch, subscription, nil
+}
+
+// messageChannel delivers the messages of a subscription on a channel, in order, from a single goroutine.
+// The messages are queued without blocking the stream while the consumer is slow.
+type messageChannel struct {
+	m      sync.Mutex
+	queue  []StreamedMessage
+	closed bool
+	// wake signals the delivery goroutine that messages were queued.
+	wake chan struct{}
+	done chan struct{}
+	ch   chan StreamedMessage
+}
+
+// newMessageChannel returns a messageChannel and starts its delivery goroutine, stopped by close.
+func newMessageChannel() *messageChannel {
+	c := &messageChannel{
+		wake: make(chan struct{}, 1),
+		done: make(chan struct{}),
+		ch:   make(chan StreamedMessage, subscribeChanBuffer),
+	}
+	go c.deliver()
+	return c
+}
+
+// enqueue queues the message for delivery. It never blocks.
+func (c *messageChannel) enqueue(message StreamedMessage) {
+	c.m.Lock()
+	defer c.m.Unlock()
+	if c.closed {
+		return
+	}
+	c.queue = append(c.queue, message)
+	select {
+	case c.wake <- struct{}{}:
+	default:
+	}
+}
+
+// deliver sends the queued messages on the channel until close is called, then closes the channel.
+func (c *messageChannel) deliver() {
+	defer close(c.ch)
+	for {
+		c.m.Lock()
+		if len(c.queue) == 0 {
+			c.m.Unlock()
+			select {
+			case <-c.wake:
+				continue
+			case <-c.done:
+				return
+			}
+		}
+		message := c.queue[0]
+		c.queue[0] = StreamedMessage{}
+		c.queue = c.queue[1:]
+		c.m.Unlock()
+		select {
+		case c.ch <- message:
+		case <-c.done:
+			return
+		}
+	}
+}
+
+// close drops the pending messages and closes the channel.
+func (c *messageChannel) close() {
+	c.m.Lock()
+	defer c.m.Unlock()
+	if !c.closed {
+		c.closed = true
+		c.queue = nil
+		close(c.done)
+	}
+}
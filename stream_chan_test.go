@@ -0,0 +1,82 @@
+package bmwcardata
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/eclipse/paho.golang/paho"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSubscribeChan(t *testing.T) {
+	c, err := NewClient(WithCarDataAPI(&mockCardataClient{}), WithAuthenticator(&staticAuthenticator{}))
+	require.NoError(t, err)
+	_, _, err = c.SubscribeChan(context.Background(), "VIN123")
+	require.ErrorIs(t, err, ErrEventStreamNotStarted)
+
+	ctx, stop := context.WithCancel(context.Background())
+	defer stop()
+	m := &streamingManager{subscriptions: &c.subscriptions, ctx: ctx, stop: stop}
+	c.streaming.Store(m)
+
+	messages, subscription, err := c.SubscribeChan(context.Background(), "VIN123")
+	require.NoError(t, err)
+	for i := 0; i < subscribeChanBuffer+10; i++ {
+		_, err = m.handlePahoPublishReceived(paho.PublishReceived{Packet: &paho.Publish{Payload: fmt.Appendf(nil, `{"vin":"VIN123","timestamp":"%d"}`, i)}})
+		require.NoError(t, err)
+	}
+	_, err = m.handlePahoPublishReceived(paho.PublishReceived{Packet: &paho.Publish{Payload: []byte(`{"vin":"OTHER"}`)}})
+	require.NoError(t, err)
+	for i := 0; i < subscribeChanBuffer+10; i++ {
+		select {
+		case message := <-messages:
+			assert.Equal(t, "VIN123", message.VIN)
+			assert.Equal(t, fmt.Sprint(i), message.Timestamp, "the messages must be delivered in order")
+		case <-time.After(time.Second):
+			t.Fatalf("message %d was not delivered, messages must not be dropped when the channel is full", i)
+		}
+	}
+
+	require.NoError(t, c.Unsubscribe(context.Background(), subscription))
+	select {
+	case _, ok := <-messages:
+		assert.False(t, ok, "the channel must be closed once unsubscribed")
+	case <-time.After(time.Second):
+		t.Fatal("the channel must be closed once unsubscribed")
+	}
+}
+
+func TestSubscribeChan_StreamStopped(t *testing.T) {
+	c, err := NewClient(WithCarDataAPI(&mockCardataClient{}), WithAuthenticator(&staticAuthenticator{}))
+	require.NoError(t, err)
+	ctx, stop := context.WithCancel(context.Background())
+	m := &streamingManager{subscriptions: &c.subscriptions, ctx: ctx, stop: stop}
+	c.streaming.Store(m)
+
+	messages, _, err := c.SubscribeChan(context.Background(), AllVINs)
+	require.NoError(t, err)
+	// Deliveries waiting for the consumer must not prevent closing the channel.
+	for i := 0; i < subscribeChanBuffer+10; i++ {
+		_, err = m.handlePahoPublishReceived(paho.PublishReceived{Packet: &paho.Publish{Payload: []byte(`{"vin":"VIN123"}`)}})
+		require.NoError(t, err)
+	}
+	require.Eventually(t, func() bool { return len(messages) == subscribeChanBuffer }, time.Second, time.Millisecond)
+
+	stop()
+	require.Eventually(t, func() bool {
+		for {
+			select {
+			case _, ok := <-messages:
+				if !ok {
+					return true
+				}
+			default:
+				return false
+			}
+		}
+	}, time.Second, time.Millisecond, "the channel must be closed once the stream stops")
+	assert.Empty(t, c.subscriptions.vins(), "the subscription must be removed once the stream stops")
+}
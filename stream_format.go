@@ -0,0 +1,45 @@
+package bmwcardata
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// FormatStreamedMessage renders a streamed message for humans, typically to debug the stream.
+// Each signal is rendered on its own line with its timestamp, falling back to the one of the message,
+// the VIN, the descriptor name resolved from the catalogue, and the value with its unit.
+// Signals missing from the catalogue are rendered with their descriptor ID.
+// Signals are sorted by descriptor ID for a stable output.
+func FormatStreamedMessage(message StreamedMessage) string {
+	keys := make([]string, 0, len(message.Data))
+	for key := range message.Data {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	lines := make([]string, 0, len(keys))
+	for _, key := range keys {
+		details := message.Data[key]
+		timestamp := details.Timestamp
+		if timestamp == "" {
+			timestamp = message.Timestamp
+		}
+		name := key
+		unit := details.Unit
+		if descriptor, ok := DescriptorByID(key); ok {
+			name = descriptor.Name
+			if unit == "" {
+				unit = descriptor.Unit
+			}
+		}
+		value := "null"
+		if v := details.Value.string(); v != nil {
+			value = *v
+		}
+		if unit != "" {
+			value += " " + unit
+		}
+		lines = append(lines, fmt.Sprintf("%s %s %s: %s", timestamp, message.VIN, name, value))
+	}
+	return strings.Join(lines, "\n")
+}
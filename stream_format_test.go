@@ -0,0 +1,30 @@
+package bmwcardata
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFormatStreamedMessage(t *testing.T) {
+	message := StreamedMessage{}
+	require.NoError(t, json.Unmarshal([]byte(`{
+		"vin": "WBA00000000000000",
+		"timestamp": "2025-01-01T12:00:00Z",
+		"data": {
+			"vehicle.drivetrain.batteryManagement.header": {"value": 81.5, "unit": "%", "timestamp": "2025-01-01T11:59:00Z"},
+			"vehicle.powertrain.tractionBattery.charging.port.anyPosition.isPlugged": {"value": true},
+			"vehicle.unknown.signal": {"value": "on"}
+		}
+	}`), &message))
+
+	assert.Equal(t,
+		"2025-01-01T11:59:00Z WBA00000000000000 Charging status of high-voltage battery: 81.5 %\n"+
+			"2025-01-01T12:00:00Z WBA00000000000000 Battery charging port any position plugged: true\n"+
+			"2025-01-01T12:00:00Z WBA00000000000000 vehicle.unknown.signal: on",
+		FormatStreamedMessage(message),
+	)
+	assert.Equal(t, "", FormatStreamedMessage(StreamedMessage{}))
+}
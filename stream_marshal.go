@@ -0,0 +1,75 @@
+package bmwcardata
+
+import (
+	"encoding/json"
+	"math"
+	"strconv"
+)
+
+// StreamedMarshalOption customises the JSON rendering of the streamed data,
+// see MarshalStreamedMessage and StreamedDataValue.MarshalJSONWith.
+type StreamedMarshalOption func(*streamedMarshalOptions)
+
+type streamedMarshalOptions struct {
+	fixedFloats bool
+}
+
+// WithFixedFloatNotation renders the float values in fixed notation, 0.0000001 rather than 1e-7,
+// with the fewest digits representing the value exactly, for an output stable across values
+// and accepted by the strict parsers rejecting exponents.
+// Integer-valued floats are rendered as integers, 10000000 or 1000000000000000000000 rather than 1e+21.
+// By default, floats are rendered as by encoding/json, which uses exponents for very small or very large values.
+func WithFixedFloatNotation() StreamedMarshalOption {
+	return func(o *streamedMarshalOptions) {
+		o.fixedFloats = true
+	}
+}
+
+// MarshalJSONWith renders the value as JSON like MarshalJSON, customised by the options.
+func (v StreamedDataValue) MarshalJSONWith(options ...StreamedMarshalOption) ([]byte, error) {
+	o := streamedMarshalOptions{}
+	for _, option := range options {
+		option(&o)
+	}
+	if v.String != nil || v.Bool != nil || v.Int != nil || v.Float == nil || !o.fixedFloats {
+		return v.MarshalJSON()
+	}
+	f := *v.Float
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		// Not representable in JSON, let encoding/json report it.
+		return json.Marshal(f)
+	}
+	return strconv.AppendFloat(nil, f, 'f', -1, 64), nil
+}
+
+// marshalledDetails mirrors StreamedDataDetails with the value already rendered.
+type marshalledDetails struct {
+	Timestamp string          `json:"timestamp,omitempty"`
+	Value     json.RawMessage `json:"value"`
+	Unit      string          `json:"unit,omitempty"`
+}
+
+// MarshalStreamedMessage renders the message as JSON like json.Marshal, with the values customised by the options.
+func MarshalStreamedMessage(message StreamedMessage, options ...StreamedMarshalOption) ([]byte, error) {
+	if len(options) == 0 {
+		return json.Marshal(message)
+	}
+	var data map[string]marshalledDetails
+	if message.Data != nil {
+		data = make(map[string]marshalledDetails, len(message.Data))
+	}
+	for id, details := range message.Data {
+		value, err := details.Value.MarshalJSONWith(options...)
+		if err != nil {
+			return nil, err
+		}
+		data[id] = marshalledDetails{Timestamp: details.Timestamp, Value: value, Unit: details.Unit}
+	}
+	return json.Marshal(struct {
+		VIN       string                       `json:"vin"`
+		EntityID  string                       `json:"entityId"`
+		Topic     string                       `json:"topic"`
+		Timestamp string                       `json:"timestamp"`
+		Data      map[string]marshalledDetails `json:"data"`
+	}{message.VIN, message.EntityID, message.Topic, message.Timestamp, data})
+}
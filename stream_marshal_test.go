@@ -0,0 +1,86 @@
+package bmwcardata
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStreamedDataValueMarshalJSONWith(t *testing.T) {
+	for _, tc := range []struct {
+		value         float64
+		byDefault     string
+		fixedNotation string
+	}{
+		{1e-7, "1e-7", "0.0000001"},
+		{10000000, "10000000", "10000000"},
+		{1e21, "1e+21", "1000000000000000000000"},
+		{3.0, "3", "3"},
+		{-12.5, "-12.5", "-12.5"},
+		{0.1, "0.1", "0.1"},
+	} {
+		value := StreamedDataValue{Float: p(tc.value)}
+		data, err := value.MarshalJSONWith()
+		require.NoError(t, err)
+		assert.Equal(t, tc.byDefault, string(data))
+		data, err = value.MarshalJSONWith(WithFixedFloatNotation())
+		require.NoError(t, err)
+		assert.Equal(t, tc.fixedNotation, string(data))
+
+		var parsed float64
+		require.NoError(t, json.Unmarshal(data, &parsed))
+		assert.Equal(t, tc.value, parsed, "the fixed notation must not lose precision")
+	}
+
+	data, err := StreamedDataValue{Int: p(int64(42))}.MarshalJSONWith(WithFixedFloatNotation())
+	require.NoError(t, err)
+	assert.Equal(t, "42", string(data))
+	data, err = StreamedDataValue{String: p("1e-7")}.MarshalJSONWith(WithFixedFloatNotation())
+	require.NoError(t, err)
+	assert.Equal(t, `"1e-7"`, string(data))
+	_, err = StreamedDataValue{Float: p(math.NaN())}.MarshalJSONWith(WithFixedFloatNotation())
+	assert.Error(t, err)
+}
+
+func TestMarshalStreamedMessage(t *testing.T) {
+	message := StreamedMessage{
+		VIN:       "VIN123",
+		EntityID:  "VIN123",
+		Topic:     "gcid/VIN123",
+		Timestamp: "2025-06-01T00:00:00Z",
+		Data: map[string]StreamedDataDetails{
+			"small": {Value: StreamedDataValue{Float: p(1e-7)}, Unit: "km"},
+			"large": {Value: StreamedDataValue{Float: p(1e21)}, Timestamp: "2025-06-01T00:00:00Z"},
+			"open":  {Value: StreamedDataValue{Bool: p(true)}},
+		},
+	}
+	expected, err := json.Marshal(message)
+	require.NoError(t, err)
+	data, err := MarshalStreamedMessage(message)
+	require.NoError(t, err)
+	assert.Equal(t, string(expected), string(data))
+
+	data, err = MarshalStreamedMessage(message, WithFixedFloatNotation())
+	require.NoError(t, err)
+	assert.JSONEq(t, `{
+		"vin": "VIN123", "entityId": "VIN123", "topic": "gcid/VIN123", "timestamp": "2025-06-01T00:00:00Z",
+		"data": {
+			"small": {"value": 0.0000001, "unit": "km"},
+			"large": {"value": 1000000000000000000000, "timestamp": "2025-06-01T00:00:00Z"},
+			"open": {"value": true}
+		}
+	}`, string(data))
+	assert.Contains(t, string(data), `"value":0.0000001`)
+	assert.NotContains(t, string(data), "e+")
+
+	buf := &bytes.Buffer{}
+	writer := &lineWriter{w: bufio.NewWriter(buf), failed: make(chan struct{}), options: []StreamedMarshalOption{WithFixedFloatNotation()}}
+	writer.write(message)
+	require.NoError(t, writer.close())
+	assert.Equal(t, string(data)+"\n", buf.String())
+}
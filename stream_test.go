@@ -0,0 +1,774 @@
+package bmwcardata
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/eclipse/paho.golang/autopaho"
+	"github.com/eclipse/paho.golang/packets"
+	"github.com/eclipse/paho.golang/paho"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSubscribeKeys(t *testing.T) {
+	c := &Client{}
+	c.streaming.Store(&streamingManager{})
+	received := []StreamedMessage{}
+	subscription, err := c.SubscribeKeys(context.Background(), "VIN123", []string{"vehicle.drivetrain.batteryManagement.header"}, func(message StreamedMessage) {
+		received = append(received, message)
+	})
+	require.NoError(t, err)
+	require.NotNil(t, subscription)
+	callback := c.subscriptions.callbacks["VIN123"][subscription.ID].callback
+	require.NotNil(t, callback)
+
+	callback(StreamedMessage{VIN: "VIN123", Data: map[string]StreamedDataDetails{
+		"vehicle.cabin.door.status": {Unit: "x"},
+	}})
+	assert.Empty(t, received, "messages without any requested key must be dropped")
+
+	callback(StreamedMessage{VIN: "VIN123", Data: map[string]StreamedDataDetails{
+		"vehicle.cabin.door.status":                   {Unit: "x"},
+		"vehicle.drivetrain.batteryManagement.header": {Unit: "%"},
+	}})
+	require.Len(t, received, 1)
+	assert.Equal(t, "VIN123", received[0].VIN)
+	assert.Equal(t, map[string]StreamedDataDetails{
+		"vehicle.drivetrain.batteryManagement.header": {Unit: "%"},
+	}, received[0].Data)
+}
+
+func TestSubscribeEntity(t *testing.T) {
+	c := &Client{}
+	ctx, stop := context.WithCancel(context.Background())
+	defer stop()
+	m := &streamingManager{subscriptions: &c.subscriptions, ctx: ctx, stop: stop}
+	c.streaming.Store(m)
+
+	_, err := c.SubscribeEntity(context.Background(), "", func(message StreamedMessage) {})
+	require.Error(t, err)
+	_, err = c.SubscribeEntity(context.Background(), "trailer", nil)
+	require.Error(t, err)
+
+	received := make(chan StreamedMessage, 2)
+	subscription, err := c.SubscribeEntity(context.Background(), "trailer", func(message StreamedMessage) { received <- message })
+	require.NoError(t, err)
+	assert.Equal(t, AllVINs, subscription.VIN, "entities may be published on any VIN topic")
+
+	for _, payload := range []string{`{"vin":"VIN1","entityId":"vehicle"}`, `{"vin":"VIN2","entityId":"trailer"}`} {
+		_, err = m.handlePahoPublishReceived(paho.PublishReceived{Packet: &paho.Publish{Payload: []byte(payload)}})
+		require.NoError(t, err)
+	}
+	require.NoError(t, m.waitCallbacks(time.Second))
+	require.Len(t, received, 1)
+	message := <-received
+	assert.Equal(t, "trailer", message.EntityID)
+	assert.Equal(t, "VIN2", message.VIN)
+}
+
+func TestSubscribeKeys_Validation(t *testing.T) {
+	c := &Client{}
+	_, err := c.SubscribeKeys(context.Background(), "VIN123", nil, func(message StreamedMessage) {})
+	assert.Error(t, err)
+	_, err = c.SubscribeKeys(context.Background(), "VIN123", []string{"key"}, nil)
+	assert.Error(t, err)
+}
+
+func TestSubscribe_EventStreamNotStarted(t *testing.T) {
+	c := &Client{}
+	_, err := c.Subscribe(context.Background(), "VIN123", func(message StreamedMessage) {})
+	require.ErrorIs(t, err, ErrEventStreamNotStarted)
+	assert.Empty(t, c.subscriptions.callbacks, "failed subscriptions must not be registered")
+}
+
+func TestSubscribe_AfterStopEventStream(t *testing.T) {
+	c := &Client{}
+	ctx, stop := context.WithCancel(context.Background())
+	c.streaming.Store(&streamingManager{ctx: ctx, stop: stop})
+	subscription, err := c.Subscribe(context.Background(), "VIN123", func(message StreamedMessage) {})
+	require.NoError(t, err)
+
+	require.NoError(t, c.StopEventStream())
+	assert.NotPanics(t, func() {
+		_, err = c.Subscribe(context.Background(), "VIN123", func(message StreamedMessage) {})
+	})
+	require.ErrorIs(t, err, ErrEventStreamNotStarted)
+	assert.NotPanics(t, func() {
+		err = c.Unsubscribe(context.Background(), subscription)
+	})
+	require.NoError(t, err)
+	assert.Empty(t, c.subscriptions.callbacks)
+}
+
+func TestUnsubscribe_EventStreamNotStarted(t *testing.T) {
+	c := &Client{}
+	assert.NotPanics(t, func() {
+		require.NoError(t, c.Unsubscribe(context.Background(), &Subscription{ID: "id", VIN: "VIN123"}))
+	})
+	assert.Nil(t, c.Done())
+}
+
+func TestSubscribeRaw(t *testing.T) {
+	c := &Client{}
+	ctx, stop := context.WithCancel(context.Background())
+	defer stop()
+	m := &streamingManager{subscriptions: &c.subscriptions, ctx: ctx, stop: stop}
+	c.streaming.Store(m)
+
+	_, err := c.SubscribeRaw(context.Background(), "VIN123", nil)
+	require.Error(t, err)
+
+	type delivery struct {
+		topic   string
+		payload string
+		message *StreamedMessage
+		err     error
+	}
+	raw := make(chan delivery, 2)
+	decoded := make(chan StreamedMessage, 2)
+	_, err = c.SubscribeRaw(context.Background(), "VIN123", func(topic string, payload []byte, message *StreamedMessage, err error) {
+		raw <- delivery{topic, string(payload), message, err}
+	})
+	require.NoError(t, err)
+	_, err = c.Subscribe(context.Background(), "VIN123", func(message StreamedMessage) { decoded <- message })
+	require.NoError(t, err)
+
+	_, err = m.handlePahoPublishReceived(paho.PublishReceived{Packet: &paho.Publish{Topic: "gcid/VIN123", Payload: []byte(`{"vin":"VIN123"}`)}})
+	require.NoError(t, err)
+	got := <-raw
+	assert.Equal(t, "gcid/VIN123", got.topic)
+	assert.Equal(t, `{"vin":"VIN123"}`, got.payload)
+	require.NoError(t, got.err)
+	require.NotNil(t, got.message)
+	assert.Equal(t, "VIN123", got.message.VIN)
+	assert.Equal(t, "VIN123", (<-decoded).VIN)
+
+	_, err = m.handlePahoPublishReceived(paho.PublishReceived{Packet: &paho.Publish{Topic: "gcid/VIN123", Payload: []byte(`{not-json`)}})
+	require.Error(t, err)
+	got = <-raw
+	assert.Equal(t, `{not-json`, got.payload)
+	assert.Nil(t, got.message)
+	assert.Error(t, got.err, "the decoding error must be delivered to raw subscribers")
+	require.NoError(t, m.waitCallbacks(time.Second))
+	assert.Empty(t, decoded, "malformed messages must not reach the decoded message subscribers")
+}
+
+func TestSubscriptionRegistry(t *testing.T) {
+	r := &subscriptionRegistry{}
+	first := &Subscription{ID: "1", VIN: "VIN123"}
+	second := &Subscription{ID: "2", VIN: "VIN123"}
+	callback := subscriber{callback: func(message StreamedMessage) {}}
+	assert.True(t, r.register(first, callback), "the first subscription for a VIN must be reported")
+	assert.False(t, r.register(second, callback))
+	r.register(&Subscription{ID: "3", VIN: AllVINs}, callback)
+	assert.ElementsMatch(t, []string{"VIN123", AllVINs}, r.vins())
+	assert.Len(t, r.get("VIN123"), 3)
+	assert.Len(t, r.get("OTHER"), 1)
+
+	assert.False(t, r.remove(first))
+	assert.False(t, r.remove(first), "removing twice must be a no-op")
+	assert.True(t, r.remove(second), "the last subscription for a VIN must be reported")
+	assert.Equal(t, []string{AllVINs}, r.vins())
+}
+
+func TestSubscribe_Concurrent(t *testing.T) {
+	c := &Client{}
+	c.streaming.Store(&streamingManager{subscriptions: &c.subscriptions})
+	vins := []string{"VIN1", "VIN2", "VIN3"}
+	wg := sync.WaitGroup{}
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			subscription, err := c.Subscribe(context.Background(), vins[i%len(vins)], func(message StreamedMessage) {})
+			assert.NoError(t, err)
+			assert.NotEmpty(t, c.streaming.Load().subscriptions.get(subscription.VIN))
+			assert.NoError(t, c.Unsubscribe(context.Background(), subscription))
+		}()
+	}
+	wg.Wait()
+	assert.Empty(t, c.subscriptions.callbacks)
+	assert.Empty(t, c.streaming.Load().subscriptions.vins(), "the manager must share the client subscriptions")
+}
+
+func TestWithMessageHandler(t *testing.T) {
+	handled := make(chan StreamedMessage, 1)
+	subscribed := make(chan StreamedMessage, 1)
+	c, err := NewClient(
+		WithCarDataAPI(&mockCardataClient{}),
+		WithAuthenticator(&staticAuthenticator{}),
+		WithMessageHandler(func(message StreamedMessage) { handled <- message }),
+	)
+	require.NoError(t, err)
+	m := &streamingManager{subscriptions: &c.subscriptions, messageHandler: c.messageHandler}
+	c.streaming.Store(m)
+	_, err = c.Subscribe(context.Background(), "VIN123", func(message StreamedMessage) { subscribed <- message })
+	require.NoError(t, err)
+	assert.Equal(t, []string{AllVINs}, m.subscribedVINs(), "a message handler must receive the messages of all the VINs")
+
+	_, err = m.handlePahoPublishReceived(paho.PublishReceived{Packet: &paho.Publish{Payload: []byte(`{"vin":"VIN123"}`)}})
+	require.NoError(t, err)
+	assert.Equal(t, "VIN123", (<-handled).VIN)
+	assert.Equal(t, "VIN123", (<-subscribed).VIN)
+
+	_, err = m.handlePahoPublishReceived(paho.PublishReceived{Packet: &paho.Publish{Payload: []byte(`{"vin":"OTHER"}`)}})
+	require.NoError(t, err)
+	assert.Equal(t, "OTHER", (<-handled).VIN)
+	assert.Empty(t, subscribed)
+}
+
+type refreshingAuthenticator struct {
+	session   *AuthenticatedSession
+	refreshed *AuthenticatedSession
+	refreshes int
+}
+
+func (a *refreshingAuthenticator) GetSession(ctx context.Context) (*AuthenticatedSession, error) {
+	return a.session, nil
+}
+
+func (a *refreshingAuthenticator) RefreshSession(ctx context.Context) (*AuthenticatedSession, error) {
+	a.refreshes++
+	return a.refreshed, nil
+}
+
+func TestBuildPahoConnectPacket(t *testing.T) {
+	valid := &AuthenticatedSession{Gcid: "gcid", IdToken: p("id-token"), ExpiresAt: time.Now().Add(time.Hour)}
+	expired := &AuthenticatedSession{Gcid: "gcid", IdToken: p("stale"), ExpiresAt: time.Now().Add(-time.Hour)}
+	withoutIDToken := &AuthenticatedSession{Gcid: "gcid", ExpiresAt: time.Now().Add(time.Hour)}
+
+	t.Run("uses the id_token as password", func(t *testing.T) {
+		authenticator := &refreshingAuthenticator{session: valid}
+		m := &streamingManager{Authenticator: authenticator, ctx: context.Background()}
+		connect, err := m.buildPahoConnectPacket(&paho.Connect{}, nil)
+		require.NoError(t, err)
+		assert.Equal(t, "gcid", connect.Username)
+		assert.Equal(t, []byte("id-token"), connect.Password)
+		assert.Equal(t, 0, authenticator.refreshes)
+	})
+
+	t.Run("computes the session expiry with the stream clock", func(t *testing.T) {
+		// The stream clock is an hour ahead of the system one.
+		clock := newFakeClock()
+		clock.now = time.Now().Add(time.Hour)
+		session := &AuthenticatedSession{Gcid: "gcid", IdToken: p("id-token"), ExpiresAt: clock.Now().Add(time.Hour)}
+		m := &streamingManager{Authenticator: &refreshingAuthenticator{session: session}, ctx: context.Background(), clock: clock}
+		connect, err := m.buildPahoConnectPacket(&paho.Connect{}, nil)
+		require.NoError(t, err)
+		assert.Equal(t, uint32(3600), *connect.Properties.SessionExpiryInterval)
+	})
+
+	for name, session := range map[string]*AuthenticatedSession{"expired": expired, "missing": withoutIDToken} {
+		t.Run("refreshes "+name+" id_tokens", func(t *testing.T) {
+			authenticator := &refreshingAuthenticator{session: session, refreshed: valid}
+			m := &streamingManager{Authenticator: authenticator, ctx: context.Background()}
+			connect, err := m.buildPahoConnectPacket(&paho.Connect{}, nil)
+			require.NoError(t, err)
+			assert.Equal(t, []byte("id-token"), connect.Password)
+			assert.Equal(t, 1, authenticator.refreshes)
+		})
+	}
+
+	t.Run("stops the stream when no id_token is returned", func(t *testing.T) {
+		ctx, stop := context.WithCancel(context.Background())
+		defer stop()
+		authenticator := &refreshingAuthenticator{session: withoutIDToken, refreshed: withoutIDToken}
+		m := &streamingManager{Authenticator: authenticator, ctx: ctx, stop: stop}
+		_, err := m.buildPahoConnectPacket(&paho.Connect{}, nil)
+		require.ErrorIs(t, err, ErrMissingIDToken)
+		assert.Error(t, ctx.Err(), "the stream must be stopped rather than retrying with a stale token")
+	})
+}
+
+func TestWithStreamInsecureSkipVerify(t *testing.T) {
+	c, err := NewClient(WithCarDataAPI(&mockCardataClient{}), WithAuthenticator(&staticAuthenticator{}))
+	require.NoError(t, err)
+	assert.False(t, c.streamInsecureSkipVerify)
+	assert.False(t, (&streamingManager{}).autopahoConfig().TlsCfg.InsecureSkipVerify, "the broker certificate must be verified by default")
+
+	c, err = NewClient(WithCarDataAPI(&mockCardataClient{}), WithAuthenticator(&staticAuthenticator{}), WithStreamInsecureSkipVerify(true))
+	require.NoError(t, err)
+	assert.True(t, c.streamInsecureSkipVerify)
+	assert.True(t, (&streamingManager{insecureSkipVerify: true}).autopahoConfig().TlsCfg.InsecureSkipVerify)
+}
+
+func TestWithMQTTClientID(t *testing.T) {
+	_, err := NewClient(WithCarDataAPI(&mockCardataClient{}), WithAuthenticator(&staticAuthenticator{}), WithMQTTClientID(""))
+	require.Error(t, err)
+
+	first, err := NewClient(WithCarDataAPI(&mockCardataClient{}), WithAuthenticator(&staticAuthenticator{}))
+	require.NoError(t, err)
+	second, err := NewClient(WithCarDataAPI(&mockCardataClient{}), WithAuthenticator(&staticAuthenticator{}))
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(first.mqttClientID, ClientID+"-"))
+	assert.NotEqual(t, first.mqttClientID, second.mqttClientID, "distinct instances must not take over each other session")
+
+	c, err := NewClient(WithCarDataAPI(&mockCardataClient{}), WithAuthenticator(&staticAuthenticator{}), WithMQTTClientID("instance-1"))
+	require.NoError(t, err)
+	assert.Equal(t, "instance-1", c.mqttClientID)
+	assert.Equal(t, "instance-1", (&streamingManager{mqttClientID: c.mqttClientID}).autopahoConfig().ClientID)
+}
+
+func TestWithIDGenerator(t *testing.T) {
+	_, err := NewClient(WithCarDataAPI(&mockCardataClient{}), WithAuthenticator(&staticAuthenticator{}), WithIDGenerator(nil))
+	require.Error(t, err)
+
+	next := 0
+	c, err := NewClient(WithCarDataAPI(&mockCardataClient{}), WithAuthenticator(&staticAuthenticator{}), WithIDGenerator(func() string {
+		next++
+		return fmt.Sprintf("id-%d", next)
+	}))
+	require.NoError(t, err)
+	assert.Equal(t, ClientID+"-id-1", c.mqttClientID)
+	c.streaming.Store(&streamingManager{subscriptions: &c.subscriptions, ctx: context.Background()})
+
+	subscription, err := c.Subscribe(context.Background(), AllVINs, func(StreamedMessage) {})
+	require.NoError(t, err)
+	assert.Equal(t, "id-2", subscription.ID)
+	subscription, err = c.Subscribe(context.Background(), AllVINs, func(StreamedMessage) {})
+	require.NoError(t, err)
+	assert.Equal(t, "id-3", subscription.ID)
+
+	c, err = NewClient(WithCarDataAPI(&mockCardataClient{}), WithAuthenticator(&staticAuthenticator{}), WithIDGenerator(func() string { return "generated" }), WithMQTTClientID("instance-1"))
+	require.NoError(t, err)
+	assert.Equal(t, "instance-1", c.mqttClientID, "the MQTT client ID option must take precedence")
+}
+
+func TestSubscribeInvalidVIN(t *testing.T) {
+	c, err := NewClient(WithCarDataAPI(&mockCardataClient{}), WithAuthenticator(&staticAuthenticator{}))
+	require.NoError(t, err)
+	c.streaming.Store(&streamingManager{subscriptions: &c.subscriptions, ctx: context.Background()})
+
+	for _, vin := range []string{"", "  ", "WBA0000000000000#", "WBA+0000000000000", "GCID/WBA00000000000000"} {
+		_, err := c.Subscribe(context.Background(), vin, func(StreamedMessage) {})
+		assert.ErrorIs(t, err, ErrInvalidSubscriptionVIN, "%q must be rejected", vin)
+	}
+	assert.Empty(t, c.subscriptions.callbacks)
+
+	for _, vin := range []string{AllVINs, AllTopics, "wba00000000000000"} {
+		_, err := c.Subscribe(context.Background(), vin, func(StreamedMessage) {})
+		assert.NoError(t, err, "%q must be accepted", vin)
+	}
+}
+
+// stallingBroker accepts MQTT connections but never acknowledges the subscriptions.
+func stallingBroker(t *testing.T) *url.URL {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				for {
+					packet, err := packets.ReadPacket(conn)
+					if err != nil {
+						return
+					}
+					if packet.Type == packets.CONNECT {
+						_, err = packets.NewControlPacket(packets.CONNACK).WriteTo(conn)
+						if err != nil {
+							return
+						}
+					}
+				}
+			}()
+		}
+	}()
+	return &url.URL{Scheme: "tcp", Host: listener.Addr().String()}
+}
+
+// acknowledgingBroker accepts MQTT connections and acknowledges the (un)subscriptions.
+// It returns the (un)subscribed topics, in the order they were received, prefixed with + or -.
+func acknowledgingBroker(t *testing.T) (*url.URL, func() []string) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+	var m sync.Mutex
+	received := []string{}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				for {
+					packet, err := packets.ReadPacket(conn)
+					if err != nil {
+						return
+					}
+					var ack *packets.ControlPacket
+					switch content := packet.Content.(type) {
+					case *packets.Connect:
+						ack = packets.NewControlPacket(packets.CONNACK)
+					case *packets.Subscribe:
+						m.Lock()
+						for _, subscription := range content.Subscriptions {
+							received = append(received, "+"+subscription.Topic)
+						}
+						m.Unlock()
+						ack = packets.NewControlPacket(packets.SUBACK)
+						ack.Content.(*packets.Suback).PacketID = content.PacketID
+						ack.Content.(*packets.Suback).Reasons = make([]byte, len(content.Subscriptions))
+					case *packets.Unsubscribe:
+						m.Lock()
+						for _, topic := range content.Topics {
+							received = append(received, "-"+topic)
+						}
+						m.Unlock()
+						ack = packets.NewControlPacket(packets.UNSUBACK)
+						ack.Content.(*packets.Unsuback).PacketID = content.PacketID
+						ack.Content.(*packets.Unsuback).Reasons = make([]byte, len(content.Topics))
+					default:
+						continue
+					}
+					if _, err := ack.WriteTo(conn); err != nil {
+						return
+					}
+				}
+			}()
+		}
+	}()
+	return &url.URL{Scheme: "tcp", Host: listener.Addr().String()}, func() []string {
+		m.Lock()
+		defer m.Unlock()
+		return slices.Clone(received)
+	}
+}
+
+// gatedAuthenticator returns its session, holding the GetSession call following holdNext until it is released.
+type gatedAuthenticator struct {
+	session *AuthenticatedSession
+	m       sync.Mutex
+	gate    bool
+	held    chan struct{}
+	release chan struct{}
+}
+
+// holdNext makes the next GetSession call wait for release to be called. held is closed once the call waits.
+func (a *gatedAuthenticator) holdNext() (held <-chan struct{}, release func()) {
+	a.m.Lock()
+	defer a.m.Unlock()
+	a.gate = true
+	a.held = make(chan struct{})
+	a.release = make(chan struct{})
+	return a.held, sync.OnceFunc(func() { close(a.release) })
+}
+
+func (a *gatedAuthenticator) GetSession(ctx context.Context) (*AuthenticatedSession, error) {
+	a.m.Lock()
+	gate, held, release := a.gate, a.held, a.release
+	a.gate = false
+	a.m.Unlock()
+	if gate {
+		close(held)
+		<-release
+	}
+	return a.session, nil
+}
+
+func TestSubscribe_ConcurrentUnsubscribe(t *testing.T) {
+	brokerURL, received := acknowledgingBroker(t)
+	authenticator := &gatedAuthenticator{session: &AuthenticatedSession{Gcid: "gcid"}}
+	c, err := NewClient(WithCarDataAPI(&mockCardataClient{}), WithAuthenticator(authenticator))
+	require.NoError(t, err)
+	ctx, stop := context.WithCancel(context.Background())
+	defer stop()
+	cm, err := autopaho.NewConnection(ctx, autopaho.ClientConfig{
+		ServerUrls:     []*url.URL{brokerURL},
+		KeepAlive:      20,
+		ConnectTimeout: time.Second,
+		ClientConfig:   paho.ClientConfig{ClientID: "test"},
+	})
+	require.NoError(t, err)
+	require.NoError(t, cm.AwaitConnection(ctx))
+	c.streaming.Store(&streamingManager{
+		Authenticator:     c.Authenticator,
+		subscriptions:     &c.subscriptions,
+		connectionManager: cm,
+		streamErrors:      c.streamErrors,
+		ctx:               ctx,
+		stop:              stop,
+	})
+
+	first, err := c.Subscribe(context.Background(), "VIN123", func(StreamedMessage) {})
+	require.NoError(t, err)
+
+	// Hold the last unsubscription of the VIN between its removal from the registry and the broker call.
+	held, release := authenticator.holdNext()
+	defer release()
+	unsubscribed := make(chan error)
+	go func() {
+		unsubscribed <- c.Unsubscribe(context.Background(), first)
+	}()
+	<-held
+	subscribed := make(chan error)
+	go func() {
+		_, err := c.Subscribe(context.Background(), "VIN123", func(StreamedMessage) {})
+		subscribed <- err
+	}()
+	select {
+	case err := <-subscribed:
+		t.Fatalf("the subscription must wait for the pending unsubscription of the VIN, returned %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	release()
+	require.NoError(t, <-unsubscribed)
+	require.NoError(t, <-subscribed)
+
+	assert.Equal(t, []string{"+gcid/VIN123", "-gcid/VIN123", "+gcid/VIN123"}, received(), "the broker subscriptions must follow the registrations")
+	assert.Empty(t, c.subscriptions.vinLocks, "the VIN locks must be released")
+}
+
+func TestWithStreamSubscribeTimeout(t *testing.T) {
+	_, err := NewClient(WithCarDataAPI(&mockCardataClient{}), WithAuthenticator(&staticAuthenticator{}), WithStreamSubscribeTimeout(0))
+	require.Error(t, err)
+
+	c, err := NewClient(
+		WithCarDataAPI(&mockCardataClient{}),
+		WithAuthenticator(&staticAuthenticator{session: &AuthenticatedSession{Gcid: "gcid"}}),
+		WithStreamSubscribeTimeout(50*time.Millisecond),
+	)
+	require.NoError(t, err)
+	ctx, stop := context.WithCancel(context.Background())
+	defer stop()
+	cm, err := autopaho.NewConnection(ctx, autopaho.ClientConfig{
+		ServerUrls:     []*url.URL{stallingBroker(t)},
+		KeepAlive:      20,
+		ConnectTimeout: time.Second,
+		ClientConfig:   paho.ClientConfig{ClientID: "test"},
+	})
+	require.NoError(t, err)
+	require.NoError(t, cm.AwaitConnection(ctx))
+	m := &streamingManager{
+		Authenticator:     c.Authenticator,
+		subscriptions:     &c.subscriptions,
+		subscribeTimeout:  c.subscribeTimeout,
+		connectionManager: cm,
+		streamErrors:      c.streamErrors,
+		ctx:               ctx,
+		stop:              stop,
+	}
+	c.streaming.Store(m)
+
+	start := time.Now()
+	_, err = c.Subscribe(context.Background(), "VIN123", func(StreamedMessage) {})
+	require.ErrorIs(t, err, context.DeadlineExceeded, "stalled subscriptions must time out")
+	assert.Less(t, time.Since(start), 5*time.Second)
+
+	c.subscriptions.register(&Subscription{ID: "id", VIN: "VIN123"}, subscriber{callback: func(StreamedMessage) {}})
+	m.handlePahoConnectionUp(cm, nil)
+	select {
+	case err := <-c.StreamErrors():
+		assert.ErrorIs(t, err, context.DeadlineExceeded, "subscription timeouts on connection must be reported")
+	case <-time.After(time.Second):
+		t.Fatal("the subscription timeout must be reported on StreamErrors")
+	}
+}
+
+func TestWithStreamConnectTimeout(t *testing.T) {
+	_, err := NewClient(WithCarDataAPI(&mockCardataClient{}), WithAuthenticator(&staticAuthenticator{}), WithStreamConnectTimeout(0))
+	require.Error(t, err)
+
+	// The broker accepts the TCP connections, but never acknowledges the MQTT connections.
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go io.Copy(io.Discard, conn)
+		}
+	}()
+	c, err := NewClient(
+		WithCarDataAPI(&mockCardataClient{}),
+		WithAuthenticator(&staticAuthenticator{session: &AuthenticatedSession{Gcid: "gcid", IdToken: p("id-token"), ExpiresAt: time.Now().Add(time.Hour)}}),
+		WithStreamingURL(&url.URL{Scheme: "tcp", Host: listener.Addr().String()}),
+		WithStreamConnectTimeout(100*time.Millisecond),
+	)
+	require.NoError(t, err)
+
+	start := time.Now()
+	err = c.StartEventStream()
+	require.ErrorIs(t, err, ErrStreamConnectTimeout)
+	assert.Less(t, time.Since(start), 5*time.Second)
+	assert.Nil(t, c.streaming.Load(), "the stream must be stopped to be started again")
+}
+
+func TestStartEventStream_RestartAfterSelfStop(t *testing.T) {
+	c, err := NewClient(
+		WithCarDataAPI(&mockCardataClient{}),
+		WithAuthenticator(&staticAuthenticator{session: &AuthenticatedSession{Gcid: "gcid", IdToken: p("id-token"), ExpiresAt: time.Now().Add(time.Hour)}}),
+		WithStreamingURL(stallingBroker(t)),
+		WithMaxReconnects(1),
+	)
+	require.NoError(t, err)
+	require.NoError(t, c.StartEventStream())
+	defer c.StopEventStream()
+	stopped := c.streaming.Load()
+	require.NotNil(t, stopped)
+
+	stopped.handlePahoConnectError(errors.New("broker down"))
+	<-stopped.ctx.Done()
+	require.NoError(t, c.StartEventStream(), "the stream must be started again once it stopped itself")
+	restarted := c.streaming.Load()
+	require.NotNil(t, restarted)
+	assert.NotSame(t, stopped, restarted, "the stopped manager must be replaced")
+	assert.NoError(t, restarted.ctx.Err())
+
+	restarted.handlePahoConnectError(errors.New("broker down"))
+	require.Eventually(t, func() bool { return c.streaming.Load() == nil }, time.Second, time.Millisecond, "a manager stopping itself must be forgotten")
+}
+
+func TestMQTTError(t *testing.T) {
+	assert.Equal(t, "Not authorized", MQTTError(ReasonCodeNotAuthorized).Name())
+	assert.Equal(t, "Server unavailable", MQTTError(ReasonCodeServerUnavailable).Name())
+	assert.Equal(t, ReasonCodeBanned, MQTTError(0x8A).ReasonCode())
+	assert.Equal(t, "Unknown", MQTTError(0xFF).Description())
+
+	c, err := NewClient(WithCarDataAPI(&mockCardataClient{}), WithAuthenticator(&staticAuthenticator{}))
+	require.NoError(t, err)
+	m := &streamingManager{streamErrors: c.streamErrors}
+	m.handlePahoServerDisconnect(&paho.Disconnect{ReasonCode: byte(ReasonCodeServerBusy)})
+	assert.ErrorIs(t, <-c.StreamErrors(), MQTTError(ReasonCodeServerBusy))
+}
+
+func TestStartEventStream_ConnectionRejected(t *testing.T) {
+	// The broker rejects the MQTT connections as not authorized.
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				packet, err := packets.ReadPacket(conn)
+				if err != nil || packet.Type != packets.CONNECT {
+					return
+				}
+				connack := packets.NewControlPacket(packets.CONNACK)
+				connack.Content.(*packets.Connack).ReasonCode = byte(ReasonCodeNotAuthorized)
+				_, _ = connack.WriteTo(conn)
+			}()
+		}
+	}()
+	c, err := NewClient(
+		WithCarDataAPI(&mockCardataClient{}),
+		WithAuthenticator(&staticAuthenticator{session: &AuthenticatedSession{Gcid: "gcid", IdToken: p("id-token"), ExpiresAt: time.Now().Add(time.Hour)}}),
+		WithStreamingURL(&url.URL{Scheme: "tcp", Host: listener.Addr().String()}),
+	)
+	require.NoError(t, err)
+
+	err = c.StartEventStream()
+	require.ErrorIs(t, err, MQTTError(ReasonCodeNotAuthorized))
+	var mqttErr MQTTError
+	require.ErrorAs(t, err, &mqttErr)
+	assert.Equal(t, "Not authorized", mqttErr.Name())
+	assert.Nil(t, c.streaming.Load(), "the stream must be stopped to be started again")
+	assert.ErrorIs(t, <-c.StreamErrors(), MQTTError(ReasonCodeNotAuthorized), "the rejection must be reported on StreamErrors")
+}
+
+func TestWithCallbackDrainTimeout(t *testing.T) {
+	newStream := func(timeout time.Duration) (*Client, *streamingManager) {
+		c, err := NewClient(WithCarDataAPI(&mockCardataClient{}), WithAuthenticator(&staticAuthenticator{}), WithCallbackDrainTimeout(timeout))
+		require.NoError(t, err)
+		ctx, stop := context.WithCancel(context.Background())
+		m := &streamingManager{subscriptions: &c.subscriptions, ctx: ctx, stop: stop}
+		c.streaming.Store(m)
+		return c, m
+	}
+
+	t.Run("waits for running callbacks", func(t *testing.T) {
+		c, m := newStream(time.Second)
+		started := make(chan struct{})
+		release := make(chan struct{})
+		_, err := c.Subscribe(context.Background(), "VIN123", func(message StreamedMessage) {
+			close(started)
+			<-release
+		})
+		require.NoError(t, err)
+		_, err = m.handlePahoPublishReceived(paho.PublishReceived{Packet: &paho.Publish{Payload: []byte(`{"vin":"VIN123"}`)}})
+		require.NoError(t, err)
+		<-started
+
+		stopped := make(chan error)
+		go func() { stopped <- c.StopEventStream() }()
+		select {
+		case <-stopped:
+			t.Fatal("StopEventStream must wait for the running callbacks")
+		case <-time.After(20 * time.Millisecond):
+		}
+		close(release)
+		require.NoError(t, <-stopped)
+	})
+
+	t.Run("times out", func(t *testing.T) {
+		c, m := newStream(10 * time.Millisecond)
+		release := make(chan struct{})
+		defer close(release)
+		_, err := c.Subscribe(context.Background(), "VIN123", func(message StreamedMessage) { <-release })
+		require.NoError(t, err)
+		_, err = m.handlePahoPublishReceived(paho.PublishReceived{Packet: &paho.Publish{Payload: []byte(`{"vin":"VIN123"}`)}})
+		require.NoError(t, err)
+		require.ErrorIs(t, c.StopEventStream(), ErrCallbacksStillRunning)
+	})
+}
+
+func TestWithMaxReconnects(t *testing.T) {
+	_, err := NewClient(WithCarDataAPI(&mockCardataClient{}), WithAuthenticator(&staticAuthenticator{}), WithMaxReconnects(-1))
+	require.Error(t, err)
+
+	c, err := NewClient(WithCarDataAPI(&mockCardataClient{}), WithAuthenticator(&staticAuthenticator{session: &AuthenticatedSession{Gcid: "gcid"}}), WithMaxReconnects(2))
+	require.NoError(t, err)
+	ctx, stop := context.WithCancel(context.Background())
+	defer stop()
+	m := &streamingManager{
+		Authenticator: c.Authenticator,
+		subscriptions: &c.subscriptions,
+		maxReconnects: c.maxReconnects,
+		streamErrors:  c.streamErrors,
+		ctx:           ctx,
+		stop:          stop,
+	}
+
+	m.handlePahoConnectError(errors.New("first"))
+	m.handlePahoConnectionUp(nil, nil)
+	m.handlePahoConnectError(errors.New("second"))
+	require.NoError(t, ctx.Err(), "the failures count must be reset once connected")
+
+	m.handlePahoConnectError(errors.New("third"))
+	require.Error(t, ctx.Err(), "the stream must be stopped after the maximum number of consecutive failures")
+	reported := []error{}
+	for len(c.StreamErrors()) > 0 {
+		reported = append(reported, <-c.StreamErrors())
+	}
+	require.Len(t, reported, 4)
+	assert.EqualError(t, reported[0], "first")
+	assert.ErrorIs(t, reported[3], ErrTooManyReconnects)
+	assert.ErrorContains(t, reported[3], "third")
+}
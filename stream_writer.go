@@ -0,0 +1,138 @@
+package bmwcardata
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// defaultStreamFlushInterval is the interval at which StreamToWriter flushes the buffered messages by default.
+const defaultStreamFlushInterval = time.Second
+
+// WithStreamWriterFlushInterval is a client option setting the interval at which StreamToWriter
+// flushes the buffered messages to the writer. It defaults to one second.
+func WithStreamWriterFlushInterval(interval time.Duration) ClientOption {
+	return func(c *Client) error {
+		if interval <= 0 {
+			return fmt.Errorf("the stream writer flush interval must be positive, got %s", interval)
+		}
+		c.streamWriterFlushInterval = interval
+		return nil
+	}
+}
+
+// WithStreamWriterMarshalOptions is a client option customising the JSON rendering of the messages
+// written by StreamToWriter, for instance WithFixedFloatNotation for consumers rejecting exponents.
+func WithStreamWriterMarshalOptions(options ...StreamedMarshalOption) ClientOption {
+	return func(c *Client) error {
+		c.streamWriterMarshalOptions = options
+		return nil
+	}
+}
+
+// StreamToWriter writes every message streamed for the given VINs to w, as one JSON object per line.
+// When no VIN is provided, the messages of all the VINs are written.
+// The event stream is started if needed, and stopped on return if it was started by StreamToWriter.
+// The messages are written in the order they are received, writes are buffered and flushed periodically.
+// It returns when ctx is cancelled, the event stream ends or writing to w fails.
+func (c *Client) StreamToWriter(ctx context.Context, w io.Writer, vins ...string) (err error) {
+	if len(vins) == 0 {
+		vins = []string{AllVINs}
+	}
+	if c.streaming.Load() == nil {
+		err := c.StartEventStream()
+		if err != nil {
+			return err
+		}
+		defer c.StopEventStream()
+	}
+	done := c.Done()
+
+	writer := &lineWriter{w: bufio.NewWriter(w), failed: make(chan struct{}), options: c.streamWriterMarshalOptions}
+	defer func() {
+		err = errors.Join(err, writer.close())
+	}()
+
+	// The messages of all the VINs are written from this goroutine, in order, through a single queue.
+	messages := newMessageChannel()
+	defer messages.close()
+	for _, vin := range vins {
+		subscription, err := c.subscribe(ctx, vin, subscriber{enqueue: messages.enqueue})
+		if err != nil {
+			return err
+		}
+		defer c.Unsubscribe(context.WithoutCancel(ctx), subscription)
+	}
+
+	interval := c.streamWriterFlushInterval
+	if interval <= 0 {
+		interval = defaultStreamFlushInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-done:
+			return nil
+		case <-writer.failed:
+			return nil
+		case message := <-messages.ch:
+			writer.write(message)
+		case <-ticker.C:
+			writer.flush()
+		}
+	}
+}
+
+// lineWriter serialises the streamed messages as JSON lines.
+// It stops writing after the first error, which is reported by close.
+type lineWriter struct {
+	w      *bufio.Writer
+	err    error
+	closed bool
+	failed chan struct{}
+	// options customise the rendering of the messages.
+	options []StreamedMarshalOption
+}
+
+func (l *lineWriter) write(message StreamedMessage) {
+	if l.closed || l.err != nil {
+		return
+	}
+	data, err := MarshalStreamedMessage(message, l.options...)
+	if err == nil {
+		data = append(data, '\n')
+		_, err = l.w.Write(data)
+	}
+	l.fail(err)
+}
+
+func (l *lineWriter) flush() {
+	if l.closed || l.err != nil {
+		return
+	}
+	l.fail(l.w.Flush())
+}
+
+// fail records the first error and signals it.
+func (l *lineWriter) fail(err error) {
+	if err == nil || l.err != nil {
+		return
+	}
+	l.err = err
+	close(l.failed)
+}
+
+// close flushes the pending messages and prevents further writes.
+func (l *lineWriter) close() error {
+	if !l.closed && l.err == nil {
+		l.fail(l.w.Flush())
+	}
+	l.closed = true
+	return l.err
+}
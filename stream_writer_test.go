@@ -0,0 +1,92 @@
+package bmwcardata
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/eclipse/paho.golang/paho"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStreamToWriter(t *testing.T) {
+	c := &Client{}
+	streamCtx, stop := context.WithCancel(context.Background())
+	defer stop()
+	m := &streamingManager{subscriptions: &c.subscriptions, ctx: streamCtx, stop: stop}
+	c.streaming.Store(m)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	buf := &bytes.Buffer{}
+	result := make(chan error)
+	go func() {
+		result <- c.StreamToWriter(ctx, buf, "VIN123")
+	}()
+	require.Eventually(t, func() bool { return len(c.subscriptions.get("VIN123")) == 1 }, time.Second, time.Millisecond)
+
+	for i := 0; i < 10; i++ {
+		_, err := m.handlePahoPublishReceived(paho.PublishReceived{Packet: &paho.Publish{Payload: fmt.Appendf(nil, `{"vin":"VIN123","timestamp":"%d"}`, i)}})
+		require.NoError(t, err)
+	}
+	_, err := m.handlePahoPublishReceived(paho.PublishReceived{Packet: &paho.Publish{Payload: []byte(`{"vin":"OTHER"}`)}})
+	require.NoError(t, err)
+	// Let the queued messages be written before stopping.
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+	require.NoError(t, <-result)
+
+	scanner := bufio.NewScanner(buf)
+	for i := 0; i < 10; i++ {
+		require.True(t, scanner.Scan())
+		message := StreamedMessage{}
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &message))
+		assert.Equal(t, "VIN123", message.VIN)
+		assert.Equal(t, fmt.Sprint(i), message.Timestamp, "the messages must be written in order")
+	}
+	assert.False(t, scanner.Scan(), "only the messages of the requested VINs must be written")
+	assert.Empty(t, c.subscriptions.vins(), "subscriptions must be removed on return")
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write(data []byte) (int, error) {
+	return 0, errors.New("disk full")
+}
+
+func TestStreamToWriter_WriteError(t *testing.T) {
+	c := &Client{streamWriterFlushInterval: time.Millisecond}
+	streamCtx, stop := context.WithCancel(context.Background())
+	defer stop()
+	m := &streamingManager{subscriptions: &c.subscriptions, ctx: streamCtx, stop: stop}
+	c.streaming.Store(m)
+
+	result := make(chan error)
+	go func() {
+		result <- c.StreamToWriter(context.Background(), failingWriter{})
+	}()
+	require.Eventually(t, func() bool { return len(c.subscriptions.get("VIN123")) == 1 }, time.Second, time.Millisecond)
+
+	_, err := m.handlePahoPublishReceived(paho.PublishReceived{Packet: &paho.Publish{Payload: []byte(`{"vin":"VIN123"}`)}})
+	require.NoError(t, err)
+	select {
+	case err := <-result:
+		assert.ErrorContains(t, err, "disk full")
+	case <-time.After(time.Second):
+		t.Fatal("StreamToWriter did not return on write errors")
+	}
+}
+
+func TestWithStreamWriterFlushInterval(t *testing.T) {
+	_, err := NewClient(WithCarDataAPI(&mockCardataClient{}), WithAuthenticator(&staticAuthenticator{}), WithStreamWriterFlushInterval(0))
+	require.Error(t, err)
+
+	c, err := NewClient(WithCarDataAPI(&mockCardataClient{}), WithAuthenticator(&staticAuthenticator{}), WithStreamWriterFlushInterval(time.Minute))
+	require.NoError(t, err)
+	assert.Equal(t, time.Minute, c.streamWriterFlushInterval)
+}
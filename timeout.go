@@ -0,0 +1,55 @@
+package bmwcardata
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/tjamet/bmw-cardata/cardataapi"
+)
+
+// WithPerRequestTimeout is a client option that bounds each CarData API call to the given duration,
+// independently of the deadline of the context provided by the caller.
+// The deadline is derived from the caller context, so cancelling it still cancels the requests.
+// It covers the whole call, including reading the response body. A zero timeout disables it.
+func WithPerRequestTimeout(timeout time.Duration) ClientOption {
+	return func(c *Client) error {
+		c.useHTTPOption("WithPerRequestTimeout")
+		if timeout < 0 {
+			return fmt.Errorf("the per request timeout must not be negative, got %s", timeout)
+		}
+		c.perRequestTimeout = timeout
+		return nil
+	}
+}
+
+// timeoutDoer runs each request with a child context bounded by timeout.
+type timeoutDoer struct {
+	doer    cardataapi.HttpRequestDoer
+	timeout time.Duration
+}
+
+func (d *timeoutDoer) Do(req *http.Request) (*http.Response, error) {
+	ctx, cancel := context.WithTimeout(req.Context(), d.timeout)
+	resp, err := d.doer.Do(req.WithContext(ctx))
+	if err != nil {
+		cancel()
+		return resp, err
+	}
+	// The body is read after Do returns, release the context once it is closed.
+	resp.Body = &cancelOnClose{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
+
+// cancelOnClose cancels the request context when the response body is closed.
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (c *cancelOnClose) Close() error {
+	defer c.cancel()
+	return c.ReadCloser.Close()
+}
@@ -0,0 +1,47 @@
+package bmwcardata
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWithPerRequestTimeout(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/customers/vehicles/mappings" {
+			select {
+			case <-release:
+			case <-r.Context().Done():
+			}
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"vin":"WBA00000000000000","modelName":"X7"}`))
+	}))
+	defer server.Close()
+	defer close(release)
+	client, err := NewClient(
+		WithCarDataServer(server.URL),
+		WithAuthenticator(&staticAuthenticator{session: &AuthenticatedSession{AccessToken: "acc"}}),
+		WithPerRequestTimeout(50*time.Millisecond),
+	)
+	require.NoError(t, err)
+
+	vehicle, err := client.GetBasicData(context.Background(), "WBA00000000000000")
+	require.NoError(t, err, "fast requests must not be affected")
+	assert.Equal(t, "X7", *vehicle.ModelName)
+
+	start := time.Now()
+	_, err = client.GetMappings(context.Background())
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), time.Second)
+
+	_, err = NewClient(WithCarDataServer(server.URL), WithPerRequestTimeout(-time.Second))
+	require.Error(t, err, "negative timeouts must be rejected")
+}
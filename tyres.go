@@ -0,0 +1,192 @@
+package bmwcardata
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/tjamet/bmw-cardata/cardataapi"
+)
+
+// TyresFromDiagnosis converts the tyre diagnosis returned by GetSmartMaintenanceTyreDiagnosis
+// to the tyre types of the archive, so that tyres are handled the same way regardless of their source.
+// It returns nil when the diagnosis has no passenger car tyres.
+func TyresFromDiagnosis(diagnosis *cardataapi.SmartMaintenanceTyreDiagnosisDto) (*TyresPassengerCar, error) {
+	if diagnosis == nil || diagnosis.PassengerCar == nil {
+		return nil, nil
+	}
+	mounted, err := tyreSetFromDto(diagnosis.PassengerCar.MountedTyres)
+	if err != nil {
+		return nil, fmt.Errorf("invalid mounted tyres: %w", err)
+	}
+	unmounted, err := tyreSetFromDto(diagnosis.PassengerCar.UnmountedTyres)
+	if err != nil {
+		return nil, fmt.Errorf("invalid unmounted tyres: %w", err)
+	}
+	return &TyresPassengerCar{MountedTyres: mounted, UnmountedTyres: unmounted}, nil
+}
+
+func tyreSetFromDto(set *cardataapi.PassengerCarTyreSetDto) (*TyreSet, error) {
+	if set == nil {
+		return nil, nil
+	}
+	r := &TyreSet{Label: value(set.Label)}
+	if status := set.AggregatedQualityStatus; status != nil {
+		r.AggregatedQualityStatus = &QualityStatus{Label: value(status.Label), QualityStatus: value(status.QualityStatus), Value: value(status.Value)}
+	}
+	for _, corner := range []struct {
+		name string
+		dto  *cardataapi.TyreDataDto
+		tyre **Tyre
+	}{
+		{"front left", set.FrontLeft, &r.FrontLeft},
+		{"front right", set.FrontRight, &r.FrontRight},
+		{"rear left", set.RearLeft, &r.RearLeft},
+		{"rear right", set.RearRight, &r.RearRight},
+	} {
+		tyre, err := tyreFromDto(corner.dto)
+		if err != nil {
+			return nil, fmt.Errorf("%s tyre: %w", corner.name, err)
+		}
+		*corner.tyre = tyre
+	}
+	return r, nil
+}
+
+func tyreFromDto(dto *cardataapi.TyreDataDto) (*Tyre, error) {
+	if dto == nil {
+		return nil, nil
+	}
+	tyre := &Tyre{Label: value(dto.Label)}
+	if d := dto.Dimension; d != nil {
+		tyre.Dimension = &TyreDimension{
+			AspectRatio:      int(value(d.AspectRatio)),
+			ConstructionType: value(d.ConstructionType),
+			Label:            value(d.Label),
+			LoadIndex:        int(value(d.LoadIndex)),
+			RimDiameter:      int(value(d.RimDiameter)),
+			SectionWidth:     int(value(d.SectionWidth)),
+			SpeedRating:      value(d.SpeedRating),
+			Value:            value(d.Value),
+		}
+	}
+	if d := dto.MountingDate; d != nil {
+		tyre.MountingDate = &TyreMountingDate{Label: value(d.Label), Value: value(d.Value)}
+		if d.MountingDate != nil && *d.MountingDate != "" {
+			err := tyre.MountingDate.MountingDate.parseAndDetectFormat(*d.MountingDate)
+			if err != nil {
+				return nil, fmt.Errorf("invalid mounting date: %w", err)
+			}
+		}
+	}
+	if d := dto.OptimizedForOem; d != nil {
+		tyre.OptimizedForOem = &TyreOptimizedForOem{Label: value(d.Label), OptimizedForOem: value(d.OptimizedForOem), Value: value(d.Value)}
+	}
+	if d := dto.PartNumber; d != nil {
+		tyre.PartNumber = &TyrePartNumber{Label: value(d.Label), PartNumber: value(d.PartNumber), Value: value(d.Value)}
+	}
+	if d := dto.QualityStatus; d != nil {
+		tyre.QualityStatus = &QualityStatus{Label: value(d.Label), QualityStatus: value(d.QualityStatus), Value: value(d.Value)}
+	}
+	if d := dto.RunFlat; d != nil {
+		tyre.RunFlat = &TyreRunFlat{Label: value(d.Label), RunFlat: value(d.RunFlat), Value: value(d.Value)}
+	}
+	if d := dto.Season; d != nil {
+		tyre.Season = &TyreSeason{Label: value(d.Label), Season: value(d.Season), Value: value(d.Value)}
+	}
+	if d := dto.Tread; d != nil {
+		tyre.Tread = &TyreTread{Carcass: value(d.Carcass), Label: value(d.Label), Manufacturer: value(d.Manufacturer), TreadDesign: value(d.TreadDesign), Value: value(d.Value)}
+	}
+	if d := dto.TyreDefect; d != nil {
+		tyre.TyreDefect = &TyreDefect{Label: value(d.Label)}
+	}
+	if d := dto.TyreProductionDate; d != nil {
+		tyre.TyreProductionDate = &TyreProductionDate{Label: value(d.Label), StatusColor: string(value(d.StatusColor)), Value: value(d.Value)}
+	}
+	if d := dto.TyreWear; d != nil {
+		tyre.TyreWear = &TyreWear{Label: value(d.Label), StatusColor: string(value(d.StatusColor)), Unit: string(value(d.Unit))}
+	}
+	return tyre, nil
+}
+
+// value returns the pointed value, or the zero value for nil pointers.
+func value[T any](v *T) T {
+	if v == nil {
+		var zero T
+		return zero
+	}
+	return *v
+}
+
+// Tyre seasons, as returned by TyreSet.Season.
+const (
+	TyreSeasonSummer = "SUMMER"
+	TyreSeasonWinter = "WINTER"
+)
+
+// Season returns the season of the tyre set, TyreSeasonSummer or TyreSeasonWinter, according to its tyres.
+// It returns an empty string when the season is unknown, either because the tyres have no season data,
+// because they are all-season tyres, or because the tyres of the set have different seasons.
+func (s *TyreSet) Season() string {
+	if s == nil {
+		return ""
+	}
+	season := ""
+	for _, tyre := range []*Tyre{s.FrontLeft, s.FrontRight, s.RearLeft, s.RearRight} {
+		tyreSeason := tyre.season()
+		switch {
+		case tyreSeason == "":
+			continue
+		case season == "":
+			season = tyreSeason
+		case season != tyreSeason:
+			return ""
+		}
+	}
+	return season
+}
+
+// season returns the season of the tyre, from its season code or, when missing, its value.
+func (t *Tyre) season() string {
+	if t == nil || t.Season == nil {
+		return ""
+	}
+	raw := t.Season.Season
+	if raw == "" {
+		raw = t.Season.Value
+	}
+	raw = strings.ToUpper(raw)
+	switch {
+	case strings.Contains(raw, "ALL"):
+		// All-season tyres are neither summer nor winter ones.
+		return ""
+	case strings.Contains(raw, TyreSeasonWinter):
+		return TyreSeasonWinter
+	case strings.Contains(raw, TyreSeasonSummer):
+		return TyreSeasonSummer
+	}
+	return ""
+}
+
+// WinterTyres returns the winter tyre sets of the archive, mounted or not.
+func (a *Archive) WinterTyres() []*TyreSet {
+	return a.tyreSets(TyreSeasonWinter)
+}
+
+// SummerTyres returns the summer tyre sets of the archive, mounted or not.
+func (a *Archive) SummerTyres() []*TyreSet {
+	return a.tyreSets(TyreSeasonSummer)
+}
+
+func (a *Archive) tyreSets(season string) []*TyreSet {
+	sets := []*TyreSet{}
+	tyres := a.SmartMaintenance.PassengerCar
+	if tyres == nil {
+		return sets
+	}
+	for _, set := range []*TyreSet{tyres.MountedTyres, tyres.UnmountedTyres} {
+		if set.Season() == season {
+			sets = append(sets, set)
+		}
+	}
+	return sets
+}
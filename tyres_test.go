@@ -0,0 +1,111 @@
+package bmwcardata
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tjamet/bmw-cardata/cardataapi"
+)
+
+func TestTyresFromDiagnosis(t *testing.T) {
+	wear := cardataapi.YELLOW
+	diagnosis := &cardataapi.SmartMaintenanceTyreDiagnosisDto{
+		PassengerCar: &cardataapi.PassengerCarDto{
+			MountedTyres: &cardataapi.PassengerCarTyreSetDto{
+				Label: p("Summer tyres"),
+				AggregatedQualityStatus: &cardataapi.AggregatedQualityStatusDto{
+					QualityStatus: p("OK"),
+				},
+				FrontLeft: &cardataapi.TyreDataDto{
+					Label: p("Front left"),
+					Dimension: &cardataapi.DimensionDto{
+						SectionWidth: p(int32(225)),
+						AspectRatio:  p(int32(45)),
+						RimDiameter:  p(int32(18)),
+						Value:        p("225/45 R18"),
+					},
+					MountingDate: &cardataapi.MountingDateDto{MountingDate: p("2024-04-01T00:00:00Z")},
+					TyreWear:     &cardataapi.TyreWearDto{StatusColor: &wear},
+				},
+			},
+		},
+	}
+
+	tyres, err := TyresFromDiagnosis(diagnosis)
+	require.NoError(t, err)
+	require.NotNil(t, tyres)
+	assert.Nil(t, tyres.UnmountedTyres)
+	mounted := tyres.MountedTyres
+	require.NotNil(t, mounted)
+	assert.Equal(t, "Summer tyres", mounted.Label)
+	require.NotNil(t, mounted.AggregatedQualityStatus)
+	assert.Equal(t, "OK", mounted.AggregatedQualityStatus.QualityStatus)
+	assert.Nil(t, mounted.FrontRight)
+	assert.Nil(t, mounted.RearLeft)
+	assert.Nil(t, mounted.RearRight)
+
+	tyre := mounted.FrontLeft
+	require.NotNil(t, tyre)
+	assert.Equal(t, "Front left", tyre.Label)
+	require.NotNil(t, tyre.Dimension)
+	assert.Equal(t, 225, tyre.Dimension.SectionWidth)
+	assert.Equal(t, 45, tyre.Dimension.AspectRatio)
+	assert.Equal(t, 18, tyre.Dimension.RimDiameter)
+	assert.Equal(t, "225/45 R18", tyre.Dimension.Value)
+	require.NotNil(t, tyre.MountingDate)
+	assert.True(t, time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC).Equal(tyre.MountingDate.MountingDate.Time))
+	require.NotNil(t, tyre.TyreWear)
+	assert.Equal(t, "YELLOW", tyre.TyreWear.StatusColor)
+	assert.Nil(t, tyre.Season)
+	assert.Nil(t, tyre.TyreDefect)
+}
+
+func TestTyresFromDiagnosis_Nil(t *testing.T) {
+	tyres, err := TyresFromDiagnosis(nil)
+	require.NoError(t, err)
+	assert.Nil(t, tyres)
+
+	tyres, err = TyresFromDiagnosis(&cardataapi.SmartMaintenanceTyreDiagnosisDto{})
+	require.NoError(t, err)
+	assert.Nil(t, tyres)
+
+	tyres, err = TyresFromDiagnosis(&cardataapi.SmartMaintenanceTyreDiagnosisDto{PassengerCar: &cardataapi.PassengerCarDto{}})
+	require.NoError(t, err)
+	require.NotNil(t, tyres)
+	assert.Nil(t, tyres.MountedTyres)
+	assert.Nil(t, tyres.UnmountedTyres)
+}
+
+func TestTyresFromDiagnosis_InvalidMountingDate(t *testing.T) {
+	_, err := TyresFromDiagnosis(&cardataapi.SmartMaintenanceTyreDiagnosisDto{
+		PassengerCar: &cardataapi.PassengerCarDto{
+			UnmountedTyres: &cardataapi.PassengerCarTyreSetDto{
+				RearRight: &cardataapi.TyreDataDto{MountingDate: &cardataapi.MountingDateDto{MountingDate: p("yesterday")}},
+			},
+		},
+	})
+	assert.ErrorContains(t, err, "unmounted tyres")
+	assert.ErrorContains(t, err, "rear right tyre")
+}
+
+func TestArchiveTyresBySeason(t *testing.T) {
+	tyre := func(season, value string) *Tyre {
+		return &Tyre{Season: &TyreSeason{Season: season, Value: value}}
+	}
+	winter := &TyreSet{Label: "winter", FrontLeft: tyre("WINTER", ""), RearRight: tyre("", "Winter tyre"), FrontRight: &Tyre{}}
+	summer := &TyreSet{Label: "summer", FrontLeft: tyre("summer", "")}
+	archive := &Archive{SmartMaintenance: SmartMaintenanceArchive{PassengerCar: &TyresPassengerCar{MountedTyres: summer, UnmountedTyres: winter}}}
+
+	assert.Equal(t, []*TyreSet{winter}, archive.WinterTyres())
+	assert.Equal(t, []*TyreSet{summer}, archive.SummerTyres())
+
+	assert.Equal(t, "", (&TyreSet{FrontLeft: tyre("WINTER", ""), RearLeft: tyre("SUMMER", "")}).Season(), "mixed sets have no season")
+	assert.Equal(t, "", (&TyreSet{FrontLeft: tyre("ALL_SEASON", "")}).Season(), "all-season tyres are neither summer nor winter ones")
+	assert.Equal(t, "", (&TyreSet{FrontLeft: &Tyre{}}).Season())
+	assert.Equal(t, "", (*TyreSet)(nil).Season())
+
+	assert.Empty(t, (&Archive{}).WinterTyres(), "archives without tyre data must be handled")
+	assert.Empty(t, (&Archive{SmartMaintenance: SmartMaintenanceArchive{PassengerCar: &TyresPassengerCar{}}}).SummerTyres())
+}
@@ -0,0 +1,67 @@
+package bmwcardata
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// LengthUnit is a unit of length, as used for the mileage of the vehicles.
+type LengthUnit string
+
+const (
+	LengthUnitKilometers LengthUnit = "km"
+	LengthUnitMiles      LengthUnit = "mi"
+)
+
+// kilometersPerMile is the length of an international mile in kilometers.
+const kilometersPerMile = 1.609344
+
+// ErrUnknownLengthUnit is returned when a unit of length is not known.
+var ErrUnknownLengthUnit = errors.New("unknown unit of length")
+
+// ParseLengthUnit parses the units of length BMW uses, like the archive UnitOfLength ("km", "miles")
+// or the charging session MileageUnits ("MileageUnits.KM", "MileageUnits.MI").
+// It returns an error wrapping ErrUnknownLengthUnit for other values, including empty ones,
+// so that lengths are never converted with a wrong unit.
+func ParseLengthUnit(unit string) (LengthUnit, error) {
+	normalized := strings.ToLower(strings.TrimSpace(unit))
+	normalized = strings.TrimPrefix(normalized, "mileageunits.")
+	switch normalized {
+	case "km", "kilometer", "kilometers", "kilometre", "kilometres":
+		return LengthUnitKilometers, nil
+	case "mi", "mile", "miles":
+		return LengthUnitMiles, nil
+	}
+	return "", fmt.Errorf("%w: %q", ErrUnknownLengthUnit, unit)
+}
+
+// ConvertLength converts a length from the unit u to the unit to.
+func (u LengthUnit) ConvertLength(value float64, to LengthUnit) (float64, error) {
+	kilometers := 0.0
+	switch u {
+	case LengthUnitKilometers:
+		kilometers = value
+	case LengthUnitMiles:
+		kilometers = value * kilometersPerMile
+	default:
+		return 0, fmt.Errorf("%w: %q", ErrUnknownLengthUnit, u)
+	}
+	switch to {
+	case LengthUnitKilometers:
+		return kilometers, nil
+	case LengthUnitMiles:
+		return kilometers / kilometersPerMile, nil
+	}
+	return 0, fmt.Errorf("%w: %q", ErrUnknownLengthUnit, to)
+}
+
+// LengthUnit returns the unit of length of the archive, see ParseLengthUnit.
+func (a *Archive) LengthUnit() (LengthUnit, error) {
+	return ParseLengthUnit(a.UnitOfLength)
+}
+
+// MileageLengthUnit returns the unit of the mileage of the charging session, see ParseLengthUnit.
+func (s ChargingSessionArchive) MileageLengthUnit() (LengthUnit, error) {
+	return ParseLengthUnit(s.MileageUnits)
+}
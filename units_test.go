@@ -0,0 +1,51 @@
+package bmwcardata
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tjamet/bmw-cardata/cardataapi"
+)
+
+func TestParseLengthUnit(t *testing.T) {
+	for unit, expected := range map[string]LengthUnit{
+		"km":                              LengthUnitKilometers,
+		" KM ":                            LengthUnitKilometers,
+		"miles":                           LengthUnitMiles,
+		string(cardataapi.MileageUnitsKM): LengthUnitKilometers,
+		string(cardataapi.MileageUnitsMI): LengthUnitMiles,
+	} {
+		parsed, err := ParseLengthUnit(unit)
+		require.NoError(t, err, unit)
+		assert.Equal(t, expected, parsed, unit)
+	}
+	for _, unit := range []string{"", "furlongs"} {
+		_, err := ParseLengthUnit(unit)
+		assert.ErrorIs(t, err, ErrUnknownLengthUnit, unit)
+	}
+
+	unit, err := (&Archive{UnitOfLength: "miles"}).LengthUnit()
+	require.NoError(t, err)
+	assert.Equal(t, LengthUnitMiles, unit)
+	unit, err = ChargingSessionArchive{MileageUnits: "MileageUnits.KM"}.MileageLengthUnit()
+	require.NoError(t, err)
+	assert.Equal(t, LengthUnitKilometers, unit)
+}
+
+func TestConvertLength(t *testing.T) {
+	km, err := LengthUnitMiles.ConvertLength(100, LengthUnitKilometers)
+	require.NoError(t, err)
+	assert.InDelta(t, 160.9344, km, 1e-9)
+	mi, err := LengthUnitKilometers.ConvertLength(km, LengthUnitMiles)
+	require.NoError(t, err)
+	assert.InDelta(t, 100, mi, 1e-9)
+	same, err := LengthUnitKilometers.ConvertLength(42, LengthUnitKilometers)
+	require.NoError(t, err)
+	assert.Equal(t, 42.0, same)
+
+	_, err = LengthUnit("furlongs").ConvertLength(1, LengthUnitKilometers)
+	assert.ErrorIs(t, err, ErrUnknownLengthUnit)
+	_, err = LengthUnitKilometers.ConvertLength(1, "")
+	assert.ErrorIs(t, err, ErrUnknownLengthUnit)
+}
@@ -0,0 +1,71 @@
+package bmwcardata
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// VINLength is the length of a Vehicle Identification Number.
+const VINLength = 17
+
+// NormalizeVIN trims and upper-cases a VIN, and checks it is a valid 17 characters VIN.
+// VINs only contain digits and capital letters, except I, O and Q.
+func NormalizeVIN(vin string) (string, error) {
+	normalized := strings.ToUpper(strings.TrimSpace(vin))
+	if len(normalized) != VINLength {
+		return "", fmt.Errorf("invalid VIN %q: expected %d characters, got %d", vin, VINLength, len(normalized))
+	}
+	for _, r := range normalized {
+		if !isVINCharacter(r) {
+			return "", fmt.Errorf("invalid VIN %q: unexpected character %q", vin, r)
+		}
+	}
+	return normalized, nil
+}
+
+func isVINCharacter(r rune) bool {
+	switch {
+	case r >= '0' && r <= '9':
+		return true
+	case r == 'I' || r == 'O' || r == 'Q':
+		return false
+	case r >= 'A' && r <= 'Z':
+		return true
+	default:
+		return false
+	}
+}
+
+// ErrVINRequired is returned when a VIN is required but none is provided and no default is set with WithDefaultVIN.
+var ErrVINRequired = errors.New("a VIN is required, pass one or set a default with WithDefaultVIN")
+
+// WithDefaultVIN is a client option that sets the VIN used by the CarData API calls, like GetBasicData,
+// when they are passed an empty VIN. This avoids repeating the VIN for single-vehicle users.
+// Subscriptions to the event stream always require an explicit VIN, or AllVINs.
+func WithDefaultVIN(vin string) ClientOption {
+	return func(c *Client) error {
+		vin = strings.TrimSpace(vin)
+		if vin == "" {
+			return errors.New("the default VIN must not be empty")
+		}
+		c.defaultVIN = vin
+		return nil
+	}
+}
+
+// normalizeVIN trims and upper-cases the VIN before it is sent to BMW.
+// An empty VIN is replaced by the default one, see WithDefaultVIN.
+// When the client is created with WithStrictVIN, the VIN is also validated.
+func (c *Client) normalizeVIN(vin string) (string, error) {
+	if strings.TrimSpace(vin) == "" {
+		if c.defaultVIN == "" {
+			return "", ErrVINRequired
+		}
+		vin = c.defaultVIN
+	}
+	if c.strictVIN {
+		return NormalizeVIN(vin)
+	}
+	return strings.ToUpper(strings.TrimSpace(vin)), nil
+}
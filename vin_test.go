@@ -0,0 +1,90 @@
+package bmwcardata
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tjamet/bmw-cardata/cardataapi"
+)
+
+func TestNormalizeVIN(t *testing.T) {
+	tests := []struct {
+		name     string
+		vin      string
+		expected string
+		wantErr  bool
+	}{
+		{name: "valid", vin: "WBA12345678901234", expected: "WBA12345678901234"},
+		{name: "lowercase", vin: "wba12345678901234", expected: "WBA12345678901234"},
+		{name: "padded", vin: "  WBA12345678901234\n", expected: "WBA12345678901234"},
+		{name: "too short", vin: "WBA123", wantErr: true},
+		{name: "too long", vin: "WBA123456789012345", wantErr: true},
+		{name: "forbidden letter", vin: "WBA1234567890123O", wantErr: true},
+		{name: "invalid character", vin: "WBA1234567890123-", wantErr: true},
+		{name: "empty", vin: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			vin, err := NormalizeVIN(tt.vin)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, vin)
+		})
+	}
+}
+
+func TestClientNormalizesVIN(t *testing.T) {
+	received := ""
+	mock := &mockCardataClient{
+		GetBasicDataFunc: func(ctx context.Context, vin string, params *cardataapi.GetBasicDataParams, _ ...cardataapi.RequestEditorFn) (*http.Response, error) {
+			received = vin
+			return jsonResponse(http.StatusOK, cardataapi.VehicleDto{}, nil), nil
+		},
+	}
+	c := &Client{carDataAPI: mock}
+	_, err := c.GetBasicData(context.Background(), " vin123 ")
+	require.NoError(t, err)
+	assert.Equal(t, "VIN123", received, "VINs must always be trimmed and upper-cased")
+
+	c = &Client{carDataAPI: mock, strictVIN: true}
+	received = ""
+	_, err = c.GetBasicData(context.Background(), "vin123")
+	assert.Error(t, err)
+	assert.Empty(t, received, "invalid VINs must not be sent with WithStrictVIN")
+	_, err = c.GetBasicData(context.Background(), "wba12345678901234")
+	require.NoError(t, err)
+	assert.Equal(t, "WBA12345678901234", received)
+}
+
+func TestWithDefaultVIN(t *testing.T) {
+	_, err := NewClient(WithCarDataAPI(&mockCardataClient{}), WithAuthenticator(&staticAuthenticator{}), WithDefaultVIN(" "))
+	require.Error(t, err)
+
+	received := ""
+	mock := &mockCardataClient{
+		GetBasicDataFunc: func(ctx context.Context, vin string, params *cardataapi.GetBasicDataParams, _ ...cardataapi.RequestEditorFn) (*http.Response, error) {
+			received = vin
+			return jsonResponse(http.StatusOK, cardataapi.VehicleDto{}, nil), nil
+		},
+	}
+	c, err := NewClient(WithCarDataAPI(mock), WithAuthenticator(&staticAuthenticator{}))
+	require.NoError(t, err)
+	_, err = c.GetBasicData(context.Background(), "")
+	require.ErrorIs(t, err, ErrVINRequired)
+	assert.Empty(t, received, "requests must not be sent without VIN")
+
+	c, err = NewClient(WithCarDataAPI(mock), WithAuthenticator(&staticAuthenticator{}), WithDefaultVIN("wba12345678901234"), WithStrictVIN())
+	require.NoError(t, err)
+	_, err = c.GetBasicData(context.Background(), " ")
+	require.NoError(t, err)
+	assert.Equal(t, "WBA12345678901234", received, "the default VIN must be used and normalized")
+	_, err = c.GetBasicData(context.Background(), "WBA00000000000000")
+	require.NoError(t, err)
+	assert.Equal(t, "WBA00000000000000", received, "explicit VINs must take precedence")
+}
@@ -0,0 +1,139 @@
+package bmwcardata
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/tjamet/bmw-cardata/cardataapi"
+)
+
+// WithContainerWatchdog is a client option making the event stream self-healing when its container
+// is deleted or expires server-side, which silently stops the delivery of messages.
+// When no message is streamed for the silence duration, the container is checked with GetContainerDetails.
+// When it is missing, it is recreated with the same name, purpose and descriptors, read when the stream
+// starts, and the VINs are subscribed to again. onRecreated, when not nil, is called with the ID of the new container.
+// As it creates containers, the watchdog is only enabled with this option.
+// Failures are reported on StreamErrors. Reading the container when the stream starts is retried until it succeeds.
+func WithContainerWatchdog(containerID string, silence time.Duration, onRecreated func(containerID string)) ClientOption {
+	return func(c *Client) error {
+		if containerID == "" {
+			return errors.New("the watched container ID must not be empty")
+		}
+		if silence <= 0 {
+			return fmt.Errorf("the watchdog silence must be positive, got %s", silence)
+		}
+		c.watchdog = &containerWatchdog{containerID: containerID, silence: silence, onRecreated: onRecreated}
+		return nil
+	}
+}
+
+// containerWatchdog recreates the container of the event stream when it goes missing.
+type containerWatchdog struct {
+	m           sync.Mutex
+	containerID string
+	silence     time.Duration
+	onRecreated func(containerID string)
+}
+
+// Delays between the attempts to read the watched container when the stream starts.
+const (
+	watchdogRetryDelay    = time.Second
+	watchdogMaxRetryDelay = time.Minute
+)
+
+// run watches the stream until it is stopped.
+func (w *containerWatchdog) run(c *Client, m *streamingManager) {
+	w.m.Lock()
+	defer w.m.Unlock()
+	details := w.containerDetails(c, m)
+	if details == nil {
+		return
+	}
+	m.touch()
+	clock := m.getClock()
+	for {
+		select {
+		case <-m.ctx.Done():
+			return
+		case <-clock.After(m.lastActivity().Add(w.silence).Sub(clock.Now())):
+		}
+		if clock.Now().Sub(m.lastActivity()) < w.silence {
+			continue
+		}
+		err := w.check(c, m, details)
+		if err != nil {
+			m.reportError(fmt.Errorf("container watchdog: %w", err))
+		}
+		// Wait for another silence period before checking again.
+		m.touch()
+	}
+}
+
+// containerDetails reads the details of the watched container, needed to recreate it.
+// Failures, like a transient server or authentication error when the stream starts, are reported
+// and retried with an exponential backoff until the stream is stopped, in which case it returns nil.
+func (w *containerWatchdog) containerDetails(c *Client, m *streamingManager) *cardataapi.ContainerDetailsDto {
+	clock := m.getClock()
+	delay := watchdogRetryDelay
+	for {
+		details, err := c.GetContainerDetails(m.ctx, w.containerID)
+		if err == nil {
+			return details
+		}
+		m.reportError(fmt.Errorf("container watchdog: failed to get the details of container %s, retrying in %s: %w", w.containerID, delay, err))
+		select {
+		case <-m.ctx.Done():
+			return nil
+		case <-clock.After(delay):
+		}
+		delay = min(2*delay, watchdogMaxRetryDelay)
+	}
+}
+
+// check recreates the container when it no longer exists, and subscribes again.
+func (w *containerWatchdog) check(c *Client, m *streamingManager, details *cardataapi.ContainerDetailsDto) error {
+	resp, err := c.carDataAPI.GetContainerDetails(m.ctx, w.containerID, &cardataapi.GetContainerDetailsParams{XVersion: "v1"})
+	if err != nil {
+		return fmt.Errorf("failed to check container %s: %w", w.containerID, err)
+	}
+	resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return nil
+	case http.StatusNotFound:
+	default:
+		return fmt.Errorf("failed to check container %s: unexpected status %d", w.containerID, resp.StatusCode)
+	}
+	name, purpose := "", ""
+	if details.Name != nil {
+		name = *details.Name
+	}
+	if details.Purpose != nil {
+		purpose = *details.Purpose
+	}
+	created, err := c.CreateContainer(m.ctx, name, purpose, ContainerDescriptors(details))
+	if err != nil {
+		return fmt.Errorf("failed to recreate container %s: %w", w.containerID, err)
+	}
+	if created.JSON201 == nil || created.JSON201.ContainerId == nil {
+		return fmt.Errorf("the container recreated for %s has no ID", w.containerID)
+	}
+	w.containerID = *created.JSON201.ContainerId
+	err = c.waitForContainer(m.ctx, m.getClock(), w.containerID)
+	if err != nil {
+		return err
+	}
+	if cm := m.getConnectionManager(); cm != nil {
+		err = m.subscribeAll(m.ctx, cm)
+		if err != nil {
+			return err
+		}
+	}
+	if w.onRecreated != nil {
+		w.onRecreated(w.containerID)
+	}
+	return nil
+}
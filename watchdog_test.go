@@ -0,0 +1,204 @@
+package bmwcardata
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/eclipse/paho.golang/paho"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tjamet/bmw-cardata/cardataapi"
+)
+
+func TestWithContainerWatchdog(t *testing.T) {
+	_, err := NewClient(WithCarDataAPI(&mockCardataClient{}), WithContainerWatchdog("", time.Second, nil))
+	require.Error(t, err)
+	_, err = NewClient(WithCarDataAPI(&mockCardataClient{}), WithContainerWatchdog("CID", 0, nil))
+	require.Error(t, err)
+
+	var m sync.Mutex
+	existing := map[string]bool{"CID": true}
+	created := []cardataapi.CreateContainerJSONRequestBody{}
+	mock := &mockCardataClient{
+		GetContainerDetailsFunc: func(ctx context.Context, containerId string, params *cardataapi.GetContainerDetailsParams, _ ...cardataapi.RequestEditorFn) (*http.Response, error) {
+			m.Lock()
+			defer m.Unlock()
+			if !existing[containerId] {
+				return jsonResponse(http.StatusNotFound, cardataapi.CarDataError{ExveErrorMsg: p("container not found")}, nil), nil
+			}
+			return jsonResponse(http.StatusOK, cardataapi.ContainerDetailsDto{
+				ContainerId:          p(containerId),
+				Name:                 p("stream"),
+				Purpose:              p("streaming"),
+				State:                p(cardataapi.ContainerDetailsDtoStateACTIVE),
+				TechnicalDescriptors: &[]string{ChargingSoCDescriptorID},
+			}, nil), nil
+		},
+		CreateContainerFunc: func(ctx context.Context, body cardataapi.CreateContainerJSONRequestBody, _ ...cardataapi.RequestEditorFn) (*http.Response, error) {
+			m.Lock()
+			defer m.Unlock()
+			created = append(created, body)
+			existing["NEW"] = true
+			return jsonResponse(http.StatusCreated, cardataapi.ContainerDetailsDto{ContainerId: p("NEW")}, nil), nil
+		},
+	}
+	recreated := make(chan string, 1)
+	c, err := NewClient(
+		WithCarDataAPI(mock),
+		WithAuthenticator(&staticAuthenticator{session: &AuthenticatedSession{}}),
+		WithContainerWatchdog("CID", 50*time.Millisecond, func(containerID string) { recreated <- containerID }),
+	)
+	require.NoError(t, err)
+	ctx, stop := context.WithCancel(context.Background())
+	defer stop()
+	stream := &streamingManager{
+		Authenticator: c.Authenticator,
+		subscriptions: &c.subscriptions,
+		streamErrors:  c.streamErrors,
+		ctx:           ctx,
+		stop:          stop,
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		c.watchdog.run(c, stream)
+	}()
+
+	// Streamed messages postpone the checks.
+	for i := 0; i < 4; i++ {
+		time.Sleep(20 * time.Millisecond)
+		_, err := stream.handlePahoPublishReceived(paho.PublishReceived{Packet: &paho.Publish{Payload: []byte(`{"vin":"VIN123"}`)}})
+		require.NoError(t, err)
+	}
+	m.Lock()
+	delete(existing, "CID")
+	m.Unlock()
+
+	select {
+	case id := <-recreated:
+		assert.Equal(t, "NEW", id)
+	case err := <-c.StreamErrors():
+		t.Fatalf("unexpected watchdog error: %s", err)
+	case <-time.After(time.Second):
+		t.Fatal("the missing container must be recreated")
+	}
+	m.Lock()
+	require.Len(t, created, 1)
+	assert.Equal(t, "stream", *created[0].Name)
+	assert.Equal(t, "streaming", *created[0].Purpose)
+	assert.Equal(t, []string{ChargingSoCDescriptorID}, *created[0].TechnicalDescriptors)
+	m.Unlock()
+
+	stop()
+	<-done
+}
+
+func TestWithContainerWatchdog_Silence(t *testing.T) {
+	var m sync.Mutex
+	checks := 0
+	mock := &mockCardataClient{
+		GetContainerDetailsFunc: func(ctx context.Context, containerId string, params *cardataapi.GetContainerDetailsParams, _ ...cardataapi.RequestEditorFn) (*http.Response, error) {
+			m.Lock()
+			defer m.Unlock()
+			checks++
+			return jsonResponse(http.StatusOK, cardataapi.ContainerDetailsDto{ContainerId: p(containerId)}, nil), nil
+		},
+	}
+	clock := newFakeClock()
+	c, err := NewClient(
+		WithCarDataAPI(mock),
+		WithAuthenticator(&staticAuthenticator{session: &AuthenticatedSession{}}),
+		WithContainerWatchdog("CID", time.Minute, nil),
+		WithClock(clock),
+	)
+	require.NoError(t, err)
+	ctx, stop := context.WithCancel(context.Background())
+	defer stop()
+	stream := &streamingManager{subscriptions: &c.subscriptions, streamErrors: c.streamErrors, clock: c.clock, ctx: ctx, stop: stop}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		c.watchdog.run(c, stream)
+	}()
+	getChecks := func() int {
+		m.Lock()
+		defer m.Unlock()
+		return checks
+	}
+
+	clock.waitTimers(t, 1)
+	require.Equal(t, 1, getChecks(), "the container details must be read when the stream starts")
+
+	clock.Advance(30 * time.Second)
+	_, err = stream.handlePahoPublishReceived(paho.PublishReceived{Packet: &paho.Publish{Payload: []byte(`{"vin":"VIN123"}`)}})
+	require.NoError(t, err)
+	clock.Advance(31 * time.Second)
+	clock.waitTimers(t, 1)
+	require.Equal(t, 1, getChecks(), "the container must not be checked while messages are streamed")
+
+	clock.Advance(30 * time.Second)
+	clock.waitTimers(t, 1)
+	require.Equal(t, 2, getChecks(), "the container must be checked after the silence")
+
+	stop()
+	<-done
+}
+
+func TestWithContainerWatchdog_InitialFailure(t *testing.T) {
+	var m sync.Mutex
+	calls := 0
+	mock := &mockCardataClient{
+		GetContainerDetailsFunc: func(ctx context.Context, containerId string, params *cardataapi.GetContainerDetailsParams, _ ...cardataapi.RequestEditorFn) (*http.Response, error) {
+			m.Lock()
+			defer m.Unlock()
+			calls++
+			if calls <= 2 {
+				return jsonResponse(http.StatusServiceUnavailable, cardataapi.CarDataError{ExveErrorMsg: p("unavailable")}, nil), nil
+			}
+			return jsonResponse(http.StatusOK, cardataapi.ContainerDetailsDto{ContainerId: p(containerId)}, nil), nil
+		},
+	}
+	clock := newFakeClock()
+	c, err := NewClient(
+		WithCarDataAPI(mock),
+		WithAuthenticator(&staticAuthenticator{session: &AuthenticatedSession{}}),
+		WithContainerWatchdog("CID", time.Minute, nil),
+		WithClock(clock),
+	)
+	require.NoError(t, err)
+	ctx, stop := context.WithCancel(context.Background())
+	defer stop()
+	stream := &streamingManager{subscriptions: &c.subscriptions, streamErrors: c.streamErrors, clock: c.clock, ctx: ctx, stop: stop}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		c.watchdog.run(c, stream)
+	}()
+	getCalls := func() int {
+		m.Lock()
+		defer m.Unlock()
+		return calls
+	}
+
+	clock.waitTimers(t, 1)
+	require.Equal(t, 1, getCalls())
+	assert.ErrorContains(t, <-c.StreamErrors(), "retrying in 1s")
+	clock.Advance(time.Second)
+	clock.waitTimers(t, 1)
+	require.Equal(t, 2, getCalls())
+	assert.ErrorContains(t, <-c.StreamErrors(), "retrying in 2s", "the retries must back off")
+	clock.Advance(2 * time.Second)
+	clock.waitTimers(t, 1)
+	require.Equal(t, 3, getCalls(), "the details must be read again until they are available")
+
+	// The watchdog now waits for the silence to check the container.
+	clock.Advance(time.Minute)
+	clock.waitTimers(t, 1)
+	require.Equal(t, 4, getCalls())
+
+	stop()
+	<-done
+}